@@ -2,26 +2,73 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
+	"os"
 	"time"
 
+	"grpc-crud-proj/internal/config"
+	"grpc-crud-proj/middleware"
 	pb "grpc-crud-proj/proto/google/userpb"
+	"grpc-crud-proj/sdk"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 func main() {
-	conn, err := grpc.Dial(
-		"localhost:50051",
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML config file; only the client section is used")
+	flag.String("target", "", `server address, e.g. "dns:///users.internal:50051" (default client.target)`)
+	flag.String("region", "", "prefer servers in this region when -target lists region=host:port entries")
+	flag.Bool("tls", false, "connect with TLS")
+	flag.String("ca-file", "", "PEM CA bundle used to verify the server (default: system roots)")
+	flag.String("server-name", "", "override the TLS server name")
+	flag.String("cert", "", "PEM client certificate for servers that require mutual TLS (implies -tls)")
+	flag.String("key", "", "PEM key for -cert")
+	email := flag.String("email", os.Getenv("USER_SERVICE_EMAIL"), "log in as this (admin) user")
+	password := flag.String("password", os.Getenv("USER_SERVICE_PASSWORD"), "password for -email")
+	flag.Parse()
+
+	cfg, problems, err := config.Resolve(*configPath)
 	if err != nil {
-		log.Fatal("failed to connect:", err)
+		log.Fatal("failed to load config: ", err)
+	}
+	problems = append(problems, cfg.ApplyFlags(flag.CommandLine, config.ClientFlags)...)
+	problems = append(problems, cfg.Client.Validate()...)
+	if len(problems) > 0 {
+		log.Fatal("invalid client config: ", problems)
 	}
-	defer conn.Close()
+	cc := cfg.Client
 
-	client := pb.NewUserServiceClient(conn)
+	opts := []sdk.Option{
+		// Log message sizes for each call; handy when sizing message limits
+		sdk.WithDialOptions(grpc.WithStatsHandler(
+			middleware.NewWireMetrics(prometheus.NewRegistry()).
+				StatsHandler("client", cfg.Server.LogPayloadSizes),
+		)),
+	}
+	if *email != "" {
+		// Logs in lazily and again whenever the token expires
+		opts = append(opts, sdk.WithLogin(*email, *password))
+	}
+	if cc.TLS || cc.CertFile != "" {
+		opts = append(opts, sdk.WithCAFile(cc.CAFile), sdk.WithServerName(cc.ServerName))
+		if cc.CertFile != "" {
+			opts = append(opts, sdk.WithClientCertificate(cc.CertFile, cc.KeyFile))
+		}
+	} else {
+		opts = append(opts, sdk.WithInsecure())
+	}
+	if cc.Region != "" {
+		opts = append(opts, sdk.WithRegion(cc.Region))
+	}
+
+	// HTTPS_PROXY / NO_PROXY from the environment are honoured
+	client, err := sdk.New(cc.Target, opts...)
+	if err != nil {
+		log.Fatal("failed to connect:", err)
+	}
+	defer client.Close()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -55,9 +102,10 @@ func main() {
 	log.Println("Fetched User:", getRes.User)
 
 	updateRes, err := client.UpdateUser(ctx, &pb.UpdateUserRequest{
-		Id:    userID,
-		Name:  "Divyam Sinha",
-		Email: "divyam.sinha@test.com",
+		Id:              userID,
+		Name:            "Divyam Sinha",
+		Email:           "divyam.sinha@test.com",
+		ExpectedVersion: getRes.User.Version,
 	})
 	if err != nil {
 		log.Fatal("UpdateUser error:", err)
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/golang-migrate/migrate/v4"
+	pgxmigrate "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// Migrate applies the embedded migrations the database at connStr hasn't
+// seen yet, recording the version reached in schema_migrations. An advisory
+// lock keeps servers starting together from running them twice. A database
+// set up by hand from an older schema.sql is fine: the first migration only
+// creates what is missing.
+func Migrate(connStr string) error {
+	// The migrate driver closes the *sql.DB it is given, so it gets its own
+	conn, err := sql.Open("pgx", connStr)
+	if err != nil {
+		return err
+	}
+	driver, err := pgxmigrate.WithInstance(conn, &pgxmigrate.Config{})
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("prepare migrations: %w", err)
+	}
+	src, err := iofs.New(migrations, "migrations")
+	if err != nil {
+		driver.Close()
+		return fmt.Errorf("read migrations: %w", err)
+	}
+	m, err := migrate.NewWithInstance("iofs", src, "pgx5", driver)
+	if err != nil {
+		src.Close()
+		driver.Close()
+		return fmt.Errorf("prepare migrations: %w", err)
+	}
+	defer m.Close()
+
+	before, _, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return err
+	}
+	if err := m.Up(); errors.Is(err, migrate.ErrNoChange) {
+		slog.Info("schema is up to date", "version", before)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("apply migrations: %w", err)
+	}
+	after, _, err := m.Version()
+	if err != nil {
+		return err
+	}
+	slog.Info("applied schema migrations", "from_version", before, "to_version", after)
+	return nil
+}
@@ -0,0 +1,12 @@
+-- Emails are unique regardless of case, so "Foo@x.com" and "foo@x.com"
+-- can't both register. The application stores emails lowercased; rows from
+-- before it did are lowercased here unless another row has the same email
+-- in a different case.
+UPDATE users SET email = lower(email)
+WHERE email <> lower(email)
+  AND NOT EXISTS (SELECT 1 FROM users o WHERE lower(o.email) = lower(users.email) AND o.id <> users.id);
+
+-- Fails while such pairs remain. Find them with
+--   SELECT lower(email), array_agg(id) FROM users GROUP BY 1 HAVING count(*) > 1;
+-- then merge or rename one of each pair and rerun the migration.
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_idx ON users (lower(email));
@@ -0,0 +1,10 @@
+-- Every user gets a UUID, the id of the v2 API. The serial id stays the
+-- primary key every other table references, so both APIs see the same
+-- accounts while clients move to v2. The repository generates UUIDs for new
+-- users (time-ordered v7, which keeps the index compact); the default
+-- covers existing rows and any other insert.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS uuid UUID;
+UPDATE users SET uuid = gen_random_uuid() WHERE uuid IS NULL;
+ALTER TABLE users ALTER COLUMN uuid SET DEFAULT gen_random_uuid();
+ALTER TABLE users ALTER COLUMN uuid SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_uuid_idx ON users (uuid);
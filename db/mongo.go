@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// defaultMongoDatabase is used when the URI names none.
+const defaultMongoDatabase = "users"
+
+// ConnectMongo connects to the MongoDB deployment at uri, such as
+// mongodb://localhost:27017/users, pings it and returns the database the
+// URI's path names ("users" if it names none). Disconnect the client with
+// Client().Disconnect when done.
+func ConnectMongo(ctx context.Context, uri string) (*mongo.Database, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimPrefix(u.Path, "/")
+	if name == "" {
+		name = defaultMongoDatabase
+	}
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+
+	slog.Info("connected to MongoDB", "database", name)
+	return client.Database(name), nil
+}
+
+// EnsureMongoIndexes creates the indexes repository.Mongo relies on, the
+// MongoDB counterpart of a schema: unique emails and numeric ids, and the
+// status listing.
+func EnsureMongoIndexes(ctx context.Context, database *mongo.Database) error {
+	_, err := database.Collection("users").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = database.Collection("audit_log").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "occurred_at", Value: 1}},
+	})
+	return err
+}
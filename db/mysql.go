@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"log/slog"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQLSchema is the DDL in mysql.sql: the users and audit_log tables that
+// repository.MySQL reads and writes.
+//
+//go:embed mysql.sql
+var MySQLSchema string
+
+// ConnectMySQL opens and pings the MySQL database at dsn, in the driver's
+// user:password@tcp(host:3306)/dbname form. It turns on the settings
+// repository.MySQL relies on: affected-row counts that include rows left
+// unchanged, so updating a value to itself still finds the row, and
+// TIMESTAMP columns scanned as time.Time.
+func ConnectMySQL(dsn string) (*sql.DB, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientFoundRows = true
+	cfg.ParseTime = true
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	conn := sql.OpenDB(connector)
+
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	slog.Info("connected to MySQL")
+	return conn, nil
+}
+
+// ApplyMySQLSchema runs MySQLSchema one statement at a time; the driver
+// refuses several in one call unless multiStatements is on, which is better
+// left off.
+func ApplyMySQLSchema(ctx context.Context, conn *sql.DB) error {
+	for _, stmt := range strings.Split(MySQLSchema, ";") {
+		if !hasSQL(stmt) {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasSQL reports whether stmt is more than blank lines and comments.
+func hasSQL(stmt string) bool {
+	for _, line := range strings.Split(stmt, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return true
+		}
+	}
+	return false
+}
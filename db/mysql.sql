@@ -0,0 +1,29 @@
+-- The tables repository.MySQL needs, for deployments on MySQL 8. The rest
+-- of the server's tables are Postgres-only. Every statement is idempotent.
+CREATE TABLE IF NOT EXISTS users (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    -- Case-insensitive, so the unique key treats Foo@x.com as foo@x.com
+    email VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_as_ci NOT NULL UNIQUE,
+    password VARCHAR(255),
+    role VARCHAR(50) NOT NULL DEFAULT 'user',
+    status VARCHAR(20) NOT NULL DEFAULT 'ACTIVE',
+    deleted_at TIMESTAMP(6) NULL,
+    merged_into INT NULL,
+    version INT NOT NULL DEFAULT 1,
+    created_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    email_verified_at TIMESTAMP(6) NULL,
+    INDEX users_status_id_idx (status, id),
+    INDEX users_created_at_idx (created_at),
+    FOREIGN KEY (merged_into) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    occurred_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    actor VARCHAR(255) NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    user_id INT NOT NULL,
+    detail TEXT NOT NULL,
+    INDEX audit_log_user_idx (user_id, occurred_at)
+);
@@ -1,28 +1,132 @@
 package db
 
 import (
+	"context"
 	"database/sql"
-	"log"
-	"os"
+	"fmt"
+	"log/slog"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
-func Connect() *sql.DB {
-	connStr := os.Getenv("DB_URL")
-	if connStr == "" {
-		connStr = "postgres://divyam.sinha@localhost:5432/postgres?sslmode=disable"
-	}
+// DB is a pgx connection pool with a database/sql handle on it, which the
+// repositories and the server query through. Pool is there for its
+// statistics (Pool.Stat) and for code wanting pgx directly.
+type DB struct {
+	*sql.DB
+	Pool *pgxpool.Pool
+}
+
+// Close closes the database/sql handle and then the pool under it.
+func (d *DB) Close() error {
+	err := d.DB.Close()
+	d.Pool.Close()
+	return err
+}
+
+// Option configures Connect.
+type Option func(*connectOptions)
+
+type connectOptions struct {
+	tracers []pgx.QueryTracer
+}
 
-	db, err := sql.Open("postgres", connStr)
+// WithTracer has t trace every query on the pool. A tracer may also
+// implement pgx.PrepareTracer and the other pgx tracer interfaces.
+func WithTracer(t pgx.QueryTracer) Option {
+	return func(o *connectOptions) { o.tracers = append(o.tracers, t) }
+}
+
+// Connect opens a pool on the Postgres database at connStr (database.url in
+// the config) and pings it. Besides the usual libpq settings, connStr may
+// size the pool with pool_max_conns (default the larger of 4 and the CPU
+// count), pool_min_conns, pool_max_conn_lifetime and
+// pool_max_conn_idle_time.
+func Connect(connStr string, opts ...Option) (*DB, error) {
+	db, err := open(connStr, opts)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	if err := db.Pool.Ping(context.Background()); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
-		log.Fatal(err)
+	slog.Info("connected to Postgres", "max_conns", db.Pool.Config().MaxConns)
+	return db, nil
+}
+
+// open is Connect without the ping; the pool connects on first use.
+func open(connStr string, opts []Option) (*DB, error) {
+	cfg, err := pgxpool.ParseConfig(connStr)
+	if err != nil {
+		return nil, err
+	}
+	var o connectOptions
+	for _, opt := range opts {
+		opt(&o)
 	}
+	switch len(o.tracers) {
+	case 0:
+	case 1:
+		cfg.ConnConfig.Tracer = o.tracers[0]
+	default:
+		cfg.ConnConfig.Tracer = multitracer.New(o.tracers...)
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{DB: stdlib.OpenDBFromPool(pool), Pool: pool}, nil
+}
 
-	log.Println("Connected to Postgres")
-	return db
+// RetryConfig is how long ConnectWithRetry keeps trying.
+type RetryConfig struct {
+	MaxWait      time.Duration // give up once this much time has passed; 0 tries once
+	RetryBackoff time.Duration // before the second attempt, doubling after; 500ms
+	MaxBackoff   time.Duration // cap on the wait between attempts; 10s
+}
+
+// ConnectWithRetry is Connect for a database that may still be starting,
+// as under docker-compose: failed attempts are retried with exponential
+// backoff until cfg.MaxWait has passed, and the last error is returned.
+func ConnectWithRetry(connStr string, cfg RetryConfig, opts ...Option) (*DB, error) {
+	return Retry(cfg, "Postgres", func() (*DB, error) { return Connect(connStr, opts...) })
+}
+
+// Retry is ConnectWithRetry for any database: connect, such as a call to
+// ConnectMySQL or ConnectSQLite, is tried until it succeeds or cfg.MaxWait
+// has passed. name is the database in the log lines.
+func Retry[T any](cfg RetryConfig, name string, connect func() (T, error)) (T, error) {
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 10 * time.Second
+	}
+	deadline := time.Now().Add(cfg.MaxWait)
+	wait := cfg.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		conn, err := connect()
+		if err == nil {
+			return conn, nil
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			var zero T
+			if attempt == 1 {
+				return zero, err
+			}
+			return zero, fmt.Errorf("gave up after %d attempts in %s: %w", attempt, cfg.MaxWait, err)
+		}
+		wait = min(wait, cfg.MaxBackoff, left)
+		slog.Warn(name+" not reachable yet, retrying", "attempt", attempt, "retry_in", wait, "error", err)
+		time.Sleep(wait)
+		wait *= 2
+	}
 }
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Replicas spreads reads over read replicas of the primary database. A
+// replica that fails a health check is skipped until it passes one again,
+// and reads go to the primary while none is healthy. Replicas lag behind
+// the primary, so only reads that may be slightly stale belong here.
+type Replicas struct {
+	primary *sql.DB
+	pools   []*DB
+	healthy []atomic.Bool
+	next    atomic.Uint32
+}
+
+// OpenReplicas opens a pool on each of connStrs, taking the same options
+// as Connect. Unlike Connect it doesn't fail when a replica is down: every
+// replica starts unhealthy until Check reaches it.
+func OpenReplicas(primary *sql.DB, connStrs []string, opts ...Option) (*Replicas, error) {
+	r := &Replicas{primary: primary, healthy: make([]atomic.Bool, len(connStrs))}
+	for _, connStr := range connStrs {
+		db, err := open(connStr, opts)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.pools = append(r.pools, db)
+	}
+	return r, nil
+}
+
+// Reader returns the next healthy replica in turn, or the primary.
+func (r *Replicas) Reader() *sql.DB {
+	n := uint32(len(r.pools))
+	start := r.next.Add(1)
+	for i := uint32(0); i < n; i++ {
+		if j := (start + i) % n; r.healthy[j].Load() {
+			return r.pools[j].DB
+		}
+	}
+	return r.primary
+}
+
+// Check pings every replica, with timeout each, and logs the ones whose
+// health changed.
+func (r *Replicas) Check(ctx context.Context, timeout time.Duration) {
+	for i, db := range r.pools {
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := db.PingContext(pingCtx)
+		cancel()
+		if was := r.healthy[i].Swap(err == nil); was != (err == nil) {
+			if err != nil {
+				slog.Warn("read replica is down; reading elsewhere", "replica", i, "error", err)
+			} else {
+				slog.Info("read replica is healthy", "replica", i)
+			}
+		}
+	}
+}
+
+// Watch runs Check every interval until ctx ends, starting now.
+func (r *Replicas) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r.Check(ctx, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Pools returns the replica pools, in the order of the connection strings.
+func (r *Replicas) Pools() []*sql.DB {
+	pools := make([]*sql.DB, len(r.pools))
+	for i, db := range r.pools {
+		pools[i] = db.DB
+	}
+	return pools
+}
+
+// Close closes the replica pools; the primary is the caller's.
+func (r *Replicas) Close() {
+	for _, db := range r.pools {
+		db.Close()
+	}
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"embed"
+	"io/fs"
+	"strings"
+)
+
+// migrations holds the numbered migration files Migrate applies, named
+// NNNNNN_description.up.sql. Add a new file for every schema change; never
+// edit one that has shipped.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Schema is every up migration in order. Every statement is idempotent, so
+// it can be applied to a fresh or an existing database, as the doctor and
+// test schemas do.
+var Schema = upMigrations()
+
+func upMigrations() string {
+	// Glob sorts, and the zero-padded versions sort numerically
+	names, err := fs.Glob(migrations, "migrations/*.up.sql")
+	if err != nil {
+		panic(err)
+	}
+	var b strings.Builder
+	for _, name := range names {
+		sql, err := migrations.ReadFile(name)
+		if err != nil {
+			panic(err)
+		}
+		b.Write(sql)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
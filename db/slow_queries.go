@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// maxLoggedSQL bounds the SQL text of a slow query log line.
+const maxLoggedSQL = 1000
+
+// SlowQueryLog logs every query taking at least its threshold as a "slow
+// query" warning, with a name for it, how long it took, its SQL and its
+// arguments. Arguments are sanitized: numbers, booleans and times are
+// logged as they are, since they are what an index is chosen on, while
+// strings and bytes, which hold emails, names and password hashes, are
+// reduced to their length. Pass it to Connect with WithTracer.
+type SlowQueryLog struct {
+	threshold time.Duration
+}
+
+func NewSlowQueryLog(threshold time.Duration) *SlowQueryLog {
+	return &SlowQueryLog{threshold: threshold}
+}
+
+type slowQueryKey struct{}
+
+type startedQuery struct {
+	sql   string
+	args  []interface{}
+	start time.Time
+}
+
+func (l *SlowQueryLog) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, slowQueryKey{}, &startedQuery{sql: data.SQL, args: data.Args, start: time.Now()})
+}
+
+func (l *SlowQueryLog) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	q, ok := ctx.Value(slowQueryKey{}).(*startedQuery)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(q.start)
+	if elapsed < l.threshold {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("query", queryName(q.sql)),
+		slog.Duration("duration", elapsed),
+		slog.String("sql", truncate(strings.Join(strings.Fields(q.sql), " "), maxLoggedSQL)),
+		slog.Any("args", sanitizeArgs(q.args)),
+	}
+	if data.Err != nil {
+		attrs = append(attrs, slog.String("error", data.Err.Error()))
+	}
+	slog.LogAttrs(ctx, slog.LevelWarn, "slow query", attrs...)
+}
+
+// queryName names a query by its statement and the first table it names,
+// such as "SELECT users" or "INSERT audit_log"; queries starting with a CTE
+// are named by the table of their first FROM, INTO or UPDATE.
+func queryName(sql string) string {
+	words := strings.Fields(sql)
+	if len(words) == 0 {
+		return ""
+	}
+	verb := strings.ToUpper(words[0])
+	for i, w := range words[:len(words)-1] {
+		switch strings.ToUpper(w) {
+		case "FROM", "INTO", "UPDATE", "JOIN":
+			table, _, _ := strings.Cut(words[i+1], "(")
+			table = strings.Trim(table, `"),;`)
+			if table != "" && !strings.EqualFold(table, "ONLY") {
+				return verb + " " + table
+			}
+		}
+	}
+	return verb
+}
+
+// sanitizeArgs describes args without the values that may be personal or
+// secret.
+func sanitizeArgs(args []interface{}) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case nil:
+			out[i] = "NULL"
+		case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			out[i] = fmt.Sprint(v)
+		case time.Time:
+			out[i] = v.Format(time.RFC3339Nano)
+		case time.Duration:
+			out[i] = v.String()
+		case string:
+			out[i] = fmt.Sprintf("<string len=%d>", len(v))
+		case []byte:
+			out[i] = fmt.Sprintf("<bytes len=%d>", len(v))
+		default:
+			out[i] = fmt.Sprintf("<%T>", v)
+		}
+	}
+	return out
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"log/slog"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteSchema is the DDL in sqlite.sql: the users and audit_log tables
+// that repository.SQLite reads and writes.
+//
+//go:embed sqlite.sql
+var SQLiteSchema string
+
+// ConnectSQLite opens the SQLite database in the file at path (created if
+// missing; ":memory:" keeps it in memory) with foreign keys enforced. It is
+// for demos and tests: the database allows one connection, which every
+// query waits for, so a transaction can't deadlock against another and an
+// in-memory database is the same one for every query.
+func ConnectSQLite(path string) (*sql.DB, error) {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	conn, err := sql.Open("sqlite", path+sep+"_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	conn.SetMaxOpenConns(1)
+
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	slog.Info("opened SQLite database", "path", path)
+	return conn, nil
+}
+
+// ApplySQLiteSchema runs SQLiteSchema.
+func ApplySQLiteSchema(ctx context.Context, conn *sql.DB) error {
+	_, err := conn.ExecContext(ctx, SQLiteSchema)
+	return err
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StatementMetrics counts how often queries find their statement already
+// prepared on the connection. pgx prepares each distinct query the first
+// time a connection runs it and keeps it in a per-connection cache (512
+// statements), so the hot queries are parsed and planned once per
+// connection rather than on every call. Pass it to Connect with
+// WithTracer.
+type StatementMetrics struct {
+	lookups *prometheus.CounterVec
+}
+
+// NewStatementMetrics registers db_statement_cache_lookups_total on reg.
+func NewStatementMetrics(reg prometheus.Registerer) *StatementMetrics {
+	m := &StatementMetrics{
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_statement_cache_lookups_total",
+			Help: "Queries run from a statement already prepared on their connection (hit) or prepared for them (miss).",
+		}, []string{"result"}),
+	}
+	reg.MustRegister(m.lookups)
+	return m
+}
+
+type preparedKey struct{}
+
+// TraceQueryStart marks queries that go through the statement cache. An
+// Exec without arguments uses the simple protocol and isn't counted.
+func (m *StatementMetrics) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if len(data.Args) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, preparedKey{}, new(bool))
+}
+
+func (m *StatementMetrics) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryEndData) {
+	prepared, ok := ctx.Value(preparedKey{}).(*bool)
+	if !ok {
+		return
+	}
+	result := "hit"
+	if *prepared {
+		result = "miss"
+	}
+	m.lookups.WithLabelValues(result).Inc()
+}
+
+func (m *StatementMetrics) TracePrepareStart(ctx context.Context, _ *pgx.Conn, _ pgx.TracePrepareStartData) context.Context {
+	return ctx
+}
+
+// TracePrepareEnd runs inside the query that needed the statement.
+func (m *StatementMetrics) TracePrepareEnd(ctx context.Context, _ *pgx.Conn, data pgx.TracePrepareEndData) {
+	if prepared, ok := ctx.Value(preparedKey{}).(*bool); ok && !data.AlreadyPrepared {
+		*prepared = true
+	}
+}
@@ -0,0 +1,267 @@
+// Command embed shows another Go service hosting the user service's core
+// account RPCs on its own gRPC server and HTTP mux, next to its own
+// services, with the shared middleware and a single database pool:
+//
+//	DB_URL=postgres://... JWT_SECRET=... go run ./examples/embed
+//
+// With DB_DRIVER=mysql the accounts live in MySQL instead, DB_URL then
+// being a DSN such as user:password@tcp(localhost:3306)/users, and with
+// DB_DRIVER=sqlite in the SQLite file DB_URL names, so nothing but the
+// binary is needed. DB_DRIVER=mongo keeps them in MongoDB, at a URI such
+// as mongodb://localhost:27017/users.
+//
+// Only the importable packages are used: db for the pool, repository and
+// service for the accounts logic, middleware for the interceptors and
+// userpb for the generated gRPC and gateway code. The full UserService
+// (logins, avatars, webhooks and the rest) is still assembled in ./server,
+// so the host implements the RPCs it wants over service.Users and leaves
+// the others unimplemented. Tokens come from the real service's Login,
+// signed with the same JWT_SECRET.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+
+	"grpc-crud-proj/db"
+	"grpc-crud-proj/middleware"
+	pb "grpc-crud-proj/proto/google/userpb"
+	"grpc-crud-proj/repository"
+	"grpc-crud-proj/service"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// users serves the account RPCs of pb.UserServiceServer from service.Users.
+type users struct {
+	pb.UnimplementedUserServiceServer
+	svc *service.Users
+}
+
+func (u *users) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.UserResponse, error) {
+	user, err := u.svc.Create(ctx, req.Name, req.Email, req.Role)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.UserResponse{User: user}, nil
+}
+
+func (u *users) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.UserResponse, error) {
+	user, err := u.svc.Get(ctx, req.Id)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.UserResponse{User: user}, nil
+}
+
+func (u *users) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.UserResponse, error) {
+	user, err := u.svc.Update(ctx, req.Id, req.Name, req.Email, req.ExpectedVersion)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.UserResponse{User: user}, nil
+}
+
+func (u *users) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
+	if err := u.svc.Delete(ctx, req.Id); err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.DeleteUserResponse{Message: "User deleted successfully"}, nil
+}
+
+func (u *users) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	list, err := u.svc.List(ctx, repository.ListOptions{Status: req.Status, Limit: req.PageSize, Offset: req.Offset})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.ListUsersResponse{Users: list}, nil
+}
+
+var serviceCodes = map[service.Kind]codes.Code{
+	service.Internal:         codes.Internal,
+	service.Invalid:          codes.InvalidArgument,
+	service.NotFound:         codes.NotFound,
+	service.Conflict:         codes.AlreadyExists,
+	service.PermissionDenied: codes.PermissionDenied,
+	service.VersionMismatch:  codes.Aborted,
+}
+
+func grpcError(err error) error {
+	var se *service.Error
+	if errors.As(err, &se) {
+		return status.Error(serviceCodes[se.Kind], se.Message)
+	}
+	return status.Errorf(codes.Internal, "%v", err)
+}
+
+// actor hands the token's caller to the service layer, as ./server does.
+func actor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if claims, ok := middleware.ClaimsFromContext(ctx); ok {
+		ctx = service.WithActor(ctx, service.Actor{Email: claims.EffectiveEmail(), Role: claims.Role})
+	}
+	return handler(ctx, req)
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	return string(hash), err
+}
+
+func main() {
+	grpcAddr := flag.String("grpc-addr", "localhost:50061", "gRPC listen address")
+	httpAddr := flag.String("http-addr", "localhost:8091", "HTTP listen address")
+	flag.Parse()
+
+	// One pool for the host's own queries and the user service's
+	store, err := openDatabase(context.Background(), os.Getenv("DB_DRIVER"), os.Getenv("DB_URL"))
+	if err != nil {
+		slog.Error("failed to open the database", "error", err)
+		os.Exit(1)
+	}
+	defer store.close()
+
+	// The host's policy covers its own services as well as the user RPCs
+	policy, err := middleware.NewPolicy(map[string]middleware.MethodPolicy{
+		"/grpc.health.v1.Health/*": {Roles: []string{middleware.RolePublic}},
+		"/user.UserService/*":      {Roles: []string{service.AdminRole}},
+	}, nil)
+	if err != nil {
+		slog.Error("bad policy", "error", err)
+		os.Exit(1)
+	}
+	opts := []middleware.Option{
+		middleware.WithRequestIDs(),
+		middleware.WithLogging(slog.Default()),
+		middleware.WithAuth(middleware.AuthConfig{Key: []byte(os.Getenv("JWT_SECRET"))}),
+		middleware.WithPolicy(func() *middleware.Policy { return policy }),
+	}
+	grpcServer := grpc.NewServer(
+		middleware.ServerOption(append(opts, middleware.WithUnaryInterceptors(actor))...),
+		middleware.StreamServerOption(opts...),
+	)
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+	accounts := service.NewUsers(store.repo, nil, hashPassword)
+	pb.RegisterUserServiceServer(grpcServer, &users{svc: accounts})
+
+	lis, err := net.Listen("tcp", *grpcAddr)
+	if err != nil {
+		slog.Error("failed to listen", "addr", *grpcAddr, "error", err)
+		os.Exit(1)
+	}
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			slog.Error("gRPC server stopped", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	// The gateway goes through the gRPC server, so REST calls get the same
+	// interceptors as gRPC ones
+	ctx := context.Background()
+	gateway := runtime.NewServeMux()
+	err = pb.RegisterUserServiceHandlerFromEndpoint(ctx, gateway, *grpcAddr,
+		[]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())})
+	if err != nil {
+		slog.Error("failed to register gateway", "error", err)
+		os.Exit(1)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", gateway)
+	mux.HandleFunc("/ready", ready(store.ping))
+
+	slog.Info("host service running", "grpc", *grpcAddr, "http", *httpAddr)
+	if err := http.ListenAndServe(*httpAddr, mux); err != nil {
+		slog.Error("HTTP server stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// database is the account storage openDatabase opened.
+type database struct {
+	repo  repository.UserRepository
+	ping  func(ctx context.Context) error
+	close func() error
+}
+
+// openDatabase connects to the database DB_DRIVER names, postgres (the
+// default), mysql, sqlite or mongo, and creates the account tables (or
+// indexes) on first run.
+func openDatabase(ctx context.Context, driver, url string) (*database, error) {
+	var conn *sql.DB
+	var repo repository.UserRepository
+	var closer io.Closer
+	var err error
+	switch driver {
+	case "", "postgres":
+		var pool *db.DB
+		if pool, err = db.Connect(url); err != nil {
+			return nil, err
+		}
+		// As the main server does at startup
+		if err := db.Migrate(url); err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("migrate the schema: %w", err)
+		}
+		conn, repo, closer = pool.DB, repository.NewPostgres(pool.DB, nil), pool
+	case "mysql":
+		if conn, err = db.ConnectMySQL(url); err != nil {
+			return nil, err
+		}
+		repo, closer = repository.NewMySQL(conn, nil), conn
+		err = db.ApplyMySQLSchema(ctx, conn)
+	case "sqlite":
+		if conn, err = db.ConnectSQLite(url); err != nil {
+			return nil, err
+		}
+		repo, closer = repository.NewSQLite(conn, nil), conn
+		err = db.ApplySQLiteSchema(ctx, conn)
+	case "mongo":
+		mdb, err := db.ConnectMongo(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		client := mdb.Client()
+		if err := db.EnsureMongoIndexes(ctx, mdb); err != nil {
+			client.Disconnect(ctx)
+			return nil, fmt.Errorf("create the indexes: %w", err)
+		}
+		return &database{
+			repo:  repository.NewMongo(mdb),
+			ping:  func(ctx context.Context) error { return client.Ping(ctx, nil) },
+			close: func() error { return client.Disconnect(context.Background()) },
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q; use postgres, mysql, sqlite or mongo", driver)
+	}
+	if err != nil {
+		closer.Close()
+		return nil, fmt.Errorf("create the schema: %w", err)
+	}
+	return &database{repo: repo, ping: conn.PingContext, close: closer.Close}, nil
+}
+
+// ready is one of the host's own handlers, on the shared connection.
+func ready(ping func(ctx context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := ping(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
@@ -0,0 +1,125 @@
+// Package avatar validates and processes uploaded avatar images. Processing is
+// a Pipeline of named steps; the built-in ones sniff the type, bound the
+// dimensions, re-encode (which drops EXIF and every other metadata block) and
+// render square thumbnails. Deployments pick and order steps by name in the
+// config, and can add their own with RegisterStep.
+package avatar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+	"sync"
+
+	// Decoders for the accepted formats
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// ErrInvalid (wrapped) means the upload was rejected; the message says why and
+// is safe to show the uploader.
+var ErrInvalid = errors.New("invalid avatar")
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+}
+
+// Image is an upload on its way through a Pipeline. Steps read and replace
+// whichever fields they need.
+type Image struct {
+	Data        []byte // encoded image, as uploaded until a step re-encodes it
+	ContentType string // set by the sniff step
+	Decoded     image.Image
+	Thumbnails  []Thumbnail
+}
+
+// Thumbnail is a square rendition of the image.
+type Thumbnail struct {
+	Size        int
+	ContentType string
+	Data        []byte
+}
+
+// Options are the settings the built-in steps read.
+type Options struct {
+	MaxBytes       int
+	MaxWidth       int
+	MaxHeight      int
+	ContentTypes   []string // accepted types, e.g. "image/png"
+	ThumbnailSizes []int
+}
+
+// Step is one stage of processing. Returning an error wrapping ErrInvalid
+// rejects the upload; any other error is a server failure.
+type Step func(ctx context.Context, img *Image) error
+
+// Pipeline runs steps in order.
+type Pipeline struct {
+	names []string
+	steps []Step
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]func(Options) Step{
+		"sniff":      Sniff,
+		"decode":     Decode,
+		"reencode":   func(Options) Step { return Reencode },
+		"thumbnails": Thumbnails,
+	}
+)
+
+// DefaultSteps is the pipeline used unless the config lists others.
+var DefaultSteps = []string{"sniff", "decode", "reencode", "thumbnails"}
+
+// RegisterStep makes a custom step available to New under name. Call it from
+// an init function, before the server builds its pipeline.
+func RegisterStep(name string, build func(Options) Step) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = build
+}
+
+// New builds the pipeline of the named steps.
+func New(names []string, opts Options) (*Pipeline, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	p := &Pipeline{}
+	for _, name := range names {
+		build, ok := registry[name]
+		if !ok {
+			known := make([]string, 0, len(registry))
+			for k := range registry {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("avatar: unknown step %q (known: %v)", name, known)
+		}
+		p.names = append(p.names, name)
+		p.steps = append(p.steps, build(opts))
+	}
+	return p, nil
+}
+
+// Run processes an upload. The result's Data is what should be stored.
+func (p *Pipeline) Run(ctx context.Context, data []byte) (*Image, error) {
+	img := &Image{Data: data}
+	for i, step := range p.steps {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := step(ctx, img); err != nil {
+			if errors.Is(err, ErrInvalid) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("avatar: step %s: %w", p.names[i], err)
+		}
+	}
+	if img.ContentType == "" {
+		return nil, fmt.Errorf("avatar: pipeline %v never set a content type", p.names)
+	}
+	return img, nil
+}
@@ -0,0 +1,143 @@
+package avatar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// Sniff checks the size and detects the type from the bytes themselves; what
+// the client claims is ignored.
+func Sniff(opts Options) Step {
+	return func(ctx context.Context, img *Image) error {
+		if len(img.Data) == 0 {
+			return invalid("the image is empty")
+		}
+		if opts.MaxBytes > 0 && len(img.Data) > opts.MaxBytes {
+			return invalid("the image is %d bytes; the limit is %d", len(img.Data), opts.MaxBytes)
+		}
+		ct := http.DetectContentType(img.Data)
+		for _, allowed := range opts.ContentTypes {
+			if ct == allowed {
+				img.ContentType = ct
+				return nil
+			}
+		}
+		return invalid("%s is not an accepted image type (accepted: %v)", ct, opts.ContentTypes)
+	}
+}
+
+// Decode checks the dimensions from the header, before decoding, so an image
+// that would expand to gigabytes is rejected cheaply.
+func Decode(opts Options) Step {
+	return func(ctx context.Context, img *Image) error {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(img.Data))
+		if err != nil {
+			return invalid("the image can't be read: %v", err)
+		}
+		if (opts.MaxWidth > 0 && cfg.Width > opts.MaxWidth) || (opts.MaxHeight > 0 && cfg.Height > opts.MaxHeight) {
+			return invalid("the image is %dx%d; the limit is %dx%d", cfg.Width, cfg.Height, opts.MaxWidth, opts.MaxHeight)
+		}
+		decoded, _, err := image.Decode(bytes.NewReader(img.Data))
+		if err != nil {
+			return invalid("the image can't be read: %v", err)
+		}
+		img.Decoded = decoded
+		return nil
+	}
+}
+
+// Reencode replaces Data with a fresh encoding of the decoded pixels. The
+// encoders write no metadata, so EXIF (camera, GPS position) is gone. Note
+// the EXIF orientation is lost with it. JPEG stays JPEG; anything else, GIF
+// included (first frame), becomes PNG.
+func Reencode(ctx context.Context, img *Image) error {
+	if img.Decoded == nil {
+		return errors.New("reencode needs the decode step first")
+	}
+	data, ct, err := encode(img.Decoded, img.ContentType)
+	if err != nil {
+		return err
+	}
+	img.Data, img.ContentType = data, ct
+	return nil
+}
+
+func encode(m image.Image, contentType string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if contentType == "image/jpeg" {
+		if err := jpeg.Encode(&buf, m, &jpeg.Options{Quality: 90}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), contentType, nil
+	}
+	if err := png.Encode(&buf, m); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
+
+// Thumbnails renders a center-cropped square for each configured size. Images
+// smaller than a size are not scaled up.
+func Thumbnails(opts Options) Step {
+	return func(ctx context.Context, img *Image) error {
+		if img.Decoded == nil {
+			return errors.New("thumbnails needs the decode step first")
+		}
+		square := cropSquare(img.Decoded)
+		img.Thumbnails = img.Thumbnails[:0]
+		for _, size := range opts.ThumbnailSizes {
+			data, ct, err := encode(shrink(square, size), img.ContentType)
+			if err != nil {
+				return err
+			}
+			img.Thumbnails = append(img.Thumbnails, Thumbnail{Size: size, ContentType: ct, Data: data})
+		}
+		return nil
+	}
+}
+
+// cropSquare copies the centered square of m into an RGBA image.
+func cropSquare(m image.Image) *image.RGBA {
+	b := m.Bounds()
+	side := min(b.Dx(), b.Dy())
+	origin := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	out := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(out, out.Bounds(), m, origin, draw.Src)
+	return out
+}
+
+// shrink box-filters the square src down to size x size.
+func shrink(src *image.RGBA, size int) *image.RGBA {
+	side := src.Bounds().Dx()
+	if size >= side || size <= 0 {
+		return src
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := y*side/size, (y+1)*side/size
+		for x := 0; x < size; x++ {
+			x0, x1 := x*side/size, (x+1)*side/size
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,813 @@
+// Package config is the user service's configuration file: its schema,
+// defaults and validation.
+package config
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Template is an annotated config file containing every default.
+//
+//go:embed template.yaml
+var Template string
+
+// Config mirrors template.yaml.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Log       LogConfig       `yaml:"log"`
+	GRPC      GRPCConfig      `yaml:"grpc"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Password  PasswordConfig  `yaml:"password"`
+	Timeouts  TimeoutsConfig  `yaml:"timeouts"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Mail      MailConfig      `yaml:"mail"`
+	Consent   ConsentConfig   `yaml:"consent"`
+	OAuth     OAuthConfig     `yaml:"oauth"`
+	Outbound  OutboundConfig  `yaml:"outbound_http"`
+	Webhooks  WebhooksConfig  `yaml:"webhooks"`
+	Avatars   AvatarsConfig   `yaml:"avatars"`
+	Capacity  CapacityConfig  `yaml:"capacity"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Audit     AuditConfig     `yaml:"audit"`
+	Client    ClientConfig    `yaml:"client"`
+}
+
+type ServerConfig struct {
+	GRPCAddr        string `yaml:"grpc_addr"`
+	HTTPAddr        string `yaml:"http_addr"`
+	LogPayloadSizes bool   `yaml:"log_payload_sizes"`
+	ReadOnly        bool   `yaml:"read_only"`
+	Region          string `yaml:"region"`
+	// TrustedProxies are the addresses (CIDRs or IPs) whose
+	// X-Forwarded-For and Forwarded headers are believed.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// DebugAddr serves pprof and expvar; empty disables them. Must be a
+	// loopback address.
+	DebugAddr string `yaml:"debug_addr"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+type GRPCConfig struct {
+	MaxRecvMsgSize    int      `yaml:"max_recv_msg_size"`
+	MaxSendMsgSize    int      `yaml:"max_send_msg_size"`
+	ConnectionTimeout Duration `yaml:"connection_timeout"`
+	// GatewayConnections is how many connections the REST gateway opens
+	// to the gRPC server.
+	GatewayConnections int             `yaml:"gateway_connections"`
+	Keepalive          KeepaliveConfig `yaml:"keepalive"`
+	TLS                TLSConfig       `yaml:"tls"`
+}
+
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+type KeepaliveConfig struct {
+	Time                  Duration `yaml:"time"`
+	Timeout               Duration `yaml:"timeout"`
+	MaxConnectionIdle     Duration `yaml:"max_connection_idle"`
+	MaxConnectionAge      Duration `yaml:"max_connection_age"`
+	MaxConnectionAgeGrace Duration `yaml:"max_connection_age_grace"`
+	MinTime               Duration `yaml:"min_time"`
+	PermitWithoutStream   bool     `yaml:"permit_without_stream"`
+}
+
+type DatabaseConfig struct {
+	// Driver is where users are kept: "postgres", which every RPC works
+	// with, or "mysql", "sqlite" or "mongo", which serve the account RPCs
+	// alone.
+	Driver string `yaml:"driver"`
+	URL    string `yaml:"url"`
+	// ConnectMaxWait is how long startup keeps retrying an unreachable
+	// database; 0 tries once.
+	ConnectMaxWait      Duration `yaml:"connect_max_wait"`
+	ConnectRetryBackoff Duration `yaml:"connect_retry_backoff"`
+	ConnectMaxBackoff   Duration `yaml:"connect_max_backoff"`
+	// AutoMigrate applies pending schema migrations at startup.
+	AutoMigrate bool `yaml:"auto_migrate"`
+	// UserIDs is "serial", serving only the int32 ids of the v1 API, or
+	// "uuid", also serving user.v2.UserService, which names users by UUID.
+	UserIDs string `yaml:"user_ids"`
+	// ReplicaURLs are read replicas of URL that GetUser and ListUsers
+	// read from, each checked every ReplicaCheckInterval.
+	ReplicaURLs          []string `yaml:"replica_urls"`
+	ReplicaCheckInterval Duration `yaml:"replica_check_interval"`
+	// HealthCheckInterval is how often the primary is pinged for the gRPC
+	// health service; HealthFailureThreshold failed pings in a row mark
+	// UserService NOT_SERVING until one succeeds.
+	HealthCheckInterval    Duration `yaml:"health_check_interval"`
+	HealthFailureThreshold int      `yaml:"health_failure_threshold"`
+	// RetryMaxAttempts is how often user queries failing for a transient
+	// reason (serialization failure, deadlock, dropped connection) are
+	// tried, with jittered backoff from RetryBaseDelay to RetryMaxDelay;
+	// RetryBudget caps retries at that share of calls.
+	RetryMaxAttempts int      `yaml:"retry_max_attempts"`
+	RetryBaseDelay   Duration `yaml:"retry_base_delay"`
+	RetryMaxDelay    Duration `yaml:"retry_max_delay"`
+	RetryBudget      float64  `yaml:"retry_budget"`
+	// SlowRequestThreshold logs calls at least this slow with their
+	// queries; 0 disables it.
+	SlowRequestThreshold Duration `yaml:"slow_request_threshold"`
+	ExplainSlowRequests  bool     `yaml:"explain_slow_requests"`
+	// SlowQueryThreshold logs each query at least this slow with its
+	// sanitized arguments; 0 disables it.
+	SlowQueryThreshold Duration `yaml:"slow_query_threshold"`
+}
+
+type AuthConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	JWTSecret string `yaml:"jwt_secret"`
+	// JWTKeys are "kid:secret" pairs. The first one signs new tokens and
+	// every one verifies tokens naming it in their kid header, so keys can
+	// be rotated. JWTSecret then only verifies tokens without a kid.
+	JWTKeys []string `yaml:"jwt_keys"`
+	// JWTAlgorithm is HS256, signing with the secrets above, or RS256 or
+	// EdDSA, signing with the first of JWTPrivateKeys so other services can
+	// verify tokens with the public keys served at /.well-known/jwks.json.
+	JWTAlgorithm string `yaml:"jwt_algorithm"`
+	// JWTPrivateKeys are "kid:file" pairs naming PEM private keys.
+	JWTPrivateKeys []string `yaml:"jwt_private_keys"`
+	// JWTIssuer and JWTAudience, if set, go in the iss and aud claims of
+	// new tokens and must be in every token presented.
+	JWTIssuer        string   `yaml:"jwt_issuer"`
+	JWTAudience      string   `yaml:"jwt_audience"`
+	TokenTTL         Duration `yaml:"token_ttl"`
+	ImpersonationTTL Duration `yaml:"impersonation_ttl"`
+	RefreshTokenTTL  Duration `yaml:"refresh_token_ttl"`
+	LoginMaxFailures int      `yaml:"login_max_failures"`
+	LoginLockout     Duration `yaml:"login_lockout"`
+	// RequireVerifiedEmail refuses Login until the account's email is
+	// verified.
+	RequireVerifiedEmail bool `yaml:"require_verified_email"`
+	BcryptCost           int  `yaml:"bcrypt_cost"`
+	// CookieAuth lets gateway clients keep their tokens in HttpOnly
+	// cookies, with CSRF checks on mutating requests.
+	CookieAuth   bool `yaml:"cookie_auth"`
+	CookieSecure bool `yaml:"cookie_secure"`
+	// PolicyFile is the API policy; empty means the built-in one.
+	PolicyFile           string   `yaml:"policy_file"`
+	PolicyReloadInterval Duration `yaml:"policy_reload_interval"`
+}
+
+// JWTKey is an auth.jwt_keys entry.
+type JWTKey struct {
+	ID     string
+	Secret string
+}
+
+// JWTKeyFile is an auth.jwt_private_keys entry.
+type JWTKeyFile struct {
+	ID   string
+	Path string
+}
+
+// Keys parses JWTKeys in order. Malformed entries are skipped; Validate
+// reports them.
+func (c AuthConfig) Keys() []JWTKey {
+	var keys []JWTKey
+	for _, raw := range c.JWTKeys {
+		if id, secret, ok := cutKID(raw); ok {
+			keys = append(keys, JWTKey{ID: id, Secret: secret})
+		}
+	}
+	return keys
+}
+
+// PrivateKeyFiles parses JWTPrivateKeys like Keys.
+func (c AuthConfig) PrivateKeyFiles() []JWTKeyFile {
+	var files []JWTKeyFile
+	for _, raw := range c.JWTPrivateKeys {
+		if id, path, ok := cutKID(raw); ok {
+			files = append(files, JWTKeyFile{ID: id, Path: path})
+		}
+	}
+	return files
+}
+
+// cutKID splits a "kid:value" entry.
+func cutKID(raw string) (kid, value string, ok bool) {
+	kid, value, ok = strings.Cut(raw, ":")
+	return kid, value, ok && kid != ""
+}
+
+type TimeoutsConfig struct {
+	DefaultRPC Duration `yaml:"default_rpc"`
+}
+
+type RateLimitConfig struct {
+	RPS   float64 `yaml:"rps"`
+	Burst int     `yaml:"burst"`
+}
+
+// StorageConfig selects where state that should be shared between instances
+// is kept.
+type StorageConfig struct {
+	Backend         string   `yaml:"backend"`
+	CleanupInterval Duration `yaml:"cleanup_interval"`
+}
+
+type MailConfig struct {
+	SMTPAddr     string `yaml:"smtp_addr"`
+	SMTPFrom     string `yaml:"smtp_from"`
+	SMTPUser     string `yaml:"smtp_user"`
+	SMTPPassword string `yaml:"smtp_password"`
+	AppBaseURL   string `yaml:"app_base_url"`
+}
+
+type ConsentConfig struct {
+	TermsVersion   string `yaml:"terms_version"`
+	PrivacyVersion string `yaml:"privacy_version"`
+}
+
+// PasswordConfig is the policy new passwords must meet in Register and
+// ChangePassword.
+type PasswordConfig struct {
+	MinLength     int  `yaml:"min_length"`
+	RequireUpper  bool `yaml:"require_upper"`
+	RequireLower  bool `yaml:"require_lower"`
+	RequireDigit  bool `yaml:"require_digit"`
+	RequireSymbol bool `yaml:"require_symbol"`
+	// BreachCheckURL is a Pwned Passwords range API; empty skips the check.
+	BreachCheckURL string `yaml:"breach_check_url"`
+}
+
+// OAuthConfig sets up LoginWithProvider. Each provider is enabled by
+// setting its client id and secret.
+type OAuthConfig struct {
+	// RedirectBaseURL is the gateway's public URL; providers send users
+	// back to <RedirectBaseURL>/v1/oauth/<provider>/callback.
+	RedirectBaseURL    string `yaml:"redirect_base_url"`
+	GoogleClientID     string `yaml:"google_client_id"`
+	GoogleClientSecret string `yaml:"google_client_secret"`
+	GitHubClientID     string `yaml:"github_client_id"`
+	GitHubClientSecret string `yaml:"github_client_secret"`
+}
+
+// Enabled reports whether any provider is configured.
+func (c OAuthConfig) Enabled() bool {
+	return c.GoogleClientID != "" || c.GitHubClientID != ""
+}
+
+// OutboundConfig is the HTTP client used for webhooks and other outgoing
+// notifications.
+type OutboundConfig struct {
+	Timeout             Duration `yaml:"timeout"`
+	DialTimeout         Duration `yaml:"dial_timeout"`
+	MaxRetries          int      `yaml:"max_retries"`
+	RetryBackoff        Duration `yaml:"retry_backoff"`
+	MaxBackoff          Duration `yaml:"max_backoff"`
+	MaxIdleConnsPerHost int      `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int      `yaml:"max_conns_per_host"`
+	IdleConnTimeout     Duration `yaml:"idle_conn_timeout"`
+	ProxyURL            string   `yaml:"proxy_url"`
+	AllowedNetworks     []string `yaml:"allowed_networks"`
+}
+
+type WebhooksConfig struct {
+	URLs        []string `yaml:"urls"`
+	Secret      string   `yaml:"secret"`
+	MaxAttempts int      `yaml:"max_attempts"`
+}
+
+type AvatarsConfig struct {
+	MaxBytes       int      `yaml:"max_bytes"`
+	MaxWidth       int      `yaml:"max_width"`
+	MaxHeight      int      `yaml:"max_height"`
+	ContentTypes   []string `yaml:"content_types"`
+	ThumbnailSizes []int    `yaml:"thumbnail_sizes"`
+	Steps          []string `yaml:"steps"`
+}
+
+// CapacityConfig holds the soft limits on the users table. A limit of 0
+// turns that check off.
+type CapacityConfig struct {
+	CheckInterval Duration `yaml:"check_interval"`
+	MaxRows       int      `yaml:"max_rows"`
+	MaxBytes      int      `yaml:"max_bytes"`
+	WarnRatio     float64  `yaml:"warn_ratio"`
+}
+
+// TracingConfig controls which calls are recorded as spans.
+type TracingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Sampler is "always" or "ratio".
+	Sampler string  `yaml:"sampler"`
+	Ratio   float64 `yaml:"ratio"`
+	// Methods overrides Ratio per full method name.
+	Methods       map[string]float64 `yaml:"methods"`
+	KeepErrors    bool               `yaml:"keep_errors"`
+	SlowThreshold Duration           `yaml:"slow_threshold"`
+}
+
+// AuditConfig controls the audit_events trail of mutating calls.
+type AuditConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// ClientConfig is how the example client and usersctl reach the server.
+type ClientConfig struct {
+	Target     string `yaml:"target"`
+	Region     string `yaml:"region"`
+	TLS        bool   `yaml:"tls"`
+	CAFile     string `yaml:"ca_file"`
+	ServerName string `yaml:"server_name"`
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+}
+
+// Duration accepts Go duration strings. A value that doesn't parse is kept
+// and reported by Validate, so one bad duration doesn't hide other problems.
+type Duration struct {
+	time.Duration
+	raw string
+	err error
+}
+
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	d.raw = n.Value
+	d.Duration, d.err = time.ParseDuration(n.Value)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+// Default returns the configuration described by Template.
+func Default() *Config {
+	cfg := &Config{}
+	if err := decode(strings.NewReader(Template), cfg); err != nil {
+		panic("config: bad template: " + err.Error())
+	}
+	return cfg
+}
+
+// Load reads the file at path on top of the defaults. Unknown keys are an
+// error; call Validate for everything else.
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := Default()
+	if err := decode(f, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Check loads the file at path and returns everything wrong with it: unknown
+// keys, values of the wrong type and the findings of Validate. The error is
+// only set when the file can't be read or isn't YAML at all.
+func Check(path string) ([]Problem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var problems []Problem
+	cfg := Default()
+	err = decode(f, cfg)
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		// The decoder still fills in every field it understood
+		for _, msg := range typeErr.Errors {
+			problems = append(problems, typeProblem(msg))
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return append(problems, cfg.Validate()...), nil
+}
+
+var unknownField = regexp.MustCompile(`^(line \d+): field (\S+) not found in type`)
+
+// typeProblem rewords yaml.v3's "line 4: field foo not found in type
+// config.ServerConfig" as an unknown-key problem.
+func typeProblem(msg string) Problem {
+	if m := unknownField.FindStringSubmatch(msg); m != nil {
+		return Problem{Key: m[1], Message: fmt.Sprintf("unknown key %q", m[2])}
+	}
+	return Problem{Key: "", Message: msg}
+}
+
+// decode overlays the YAML in r onto cfg. An empty file leaves cfg as is.
+func decode(r io.Reader, cfg *Config) error {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// Problem is one thing wrong with a config file.
+type Problem struct {
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Key == "" {
+		return p.Message
+	}
+	return p.Key + ": " + p.Message
+}
+
+// isLoopbackHost reports whether host is "localhost" or a loopback IP.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
+
+// Validate reports every problem that would stop the server from starting or
+// make it insecure. It returns nil for a usable config.
+func (c *Config) Validate() []Problem {
+	var problems []Problem
+	add := func(key, format string, args ...interface{}) {
+		problems = append(problems, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.GRPCAddr); err != nil {
+		add("server.grpc_addr", "must be host:port, got %q", c.Server.GRPCAddr)
+	}
+	if _, _, err := net.SplitHostPort(c.Server.HTTPAddr); err != nil {
+		add("server.http_addr", "must be host:port, got %q", c.Server.HTTPAddr)
+	}
+	if c.Server.DebugAddr != "" {
+		if host, _, err := net.SplitHostPort(c.Server.DebugAddr); err != nil || !isLoopbackHost(host) {
+			add("server.debug_addr", "must be a loopback host:port such as localhost:6060, got %q", c.Server.DebugAddr)
+		}
+	}
+	for i, proxy := range c.Server.TrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err != nil {
+			if _, err := netip.ParseAddr(proxy); err != nil {
+				add(fmt.Sprintf("server.trusted_proxies[%d]", i), "must be a CIDR or IP address, got %q", proxy)
+			}
+		}
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		add("log.level", "must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		add("log.format", "must be text or json, got %q", c.Log.Format)
+	}
+	if c.Database.URL == "" {
+		add("database.url", "must be set")
+	}
+	if c.Database.UserIDs != "serial" && c.Database.UserIDs != "uuid" {
+		add("database.user_ids", "must be serial or uuid, got %q", c.Database.UserIDs)
+	}
+	switch c.Database.Driver {
+	case "postgres":
+	case "mysql", "sqlite", "mongo":
+		// Only the users are kept there; everything else the server stores
+		// is in Postgres tables
+		needsPostgres := func(key string) {
+			add(key, "needs database.driver postgres, got %s", c.Database.Driver)
+		}
+		if c.Database.UserIDs == "uuid" {
+			needsPostgres("database.user_ids")
+		}
+		if len(c.Database.ReplicaURLs) > 0 {
+			needsPostgres("database.replica_urls")
+		}
+		if c.Database.SlowQueryThreshold.Duration > 0 {
+			needsPostgres("database.slow_query_threshold")
+		}
+		if c.Database.ExplainSlowRequests {
+			needsPostgres("database.explain_slow_requests")
+		}
+		if c.Storage.Backend == "postgres" {
+			needsPostgres("storage.backend")
+		}
+		if len(c.Webhooks.URLs) > 0 {
+			needsPostgres("webhooks.urls")
+		}
+		if c.Audit.Enabled {
+			needsPostgres("audit.enabled")
+		}
+	default:
+		add("database.driver", "must be postgres, mysql, sqlite or mongo, got %q", c.Database.Driver)
+	}
+
+	switch c.Auth.JWTAlgorithm {
+	case "HS256":
+		if c.Auth.JWTSecret == "" && len(c.Auth.JWTKeys) == 0 {
+			add("auth.jwt_secret", "must be set, or auth.jwt_keys")
+		}
+	case "RS256", "EdDSA":
+		if len(c.Auth.JWTPrivateKeys) == 0 {
+			add("auth.jwt_private_keys", "must be set when auth.jwt_algorithm is %s", c.Auth.JWTAlgorithm)
+		}
+	default:
+		add("auth.jwt_algorithm", "must be HS256, RS256 or EdDSA, got %q", c.Auth.JWTAlgorithm)
+	}
+	if c.Auth.JWTSecret != "" && len(c.Auth.JWTSecret) < 32 {
+		add("auth.jwt_secret", "must be at least 32 characters")
+	}
+	// A kid names one key across both lists
+	kids := map[string]bool{}
+	checkKeys := func(name, format string, entries []string, check func(kid, value string) string) {
+		for i, raw := range entries {
+			key := fmt.Sprintf("%s[%d]", name, i)
+			id, value, ok := cutKID(raw)
+			switch {
+			case !ok:
+				add(key, "must be %s", format)
+			case kids[id]:
+				add(key, "duplicate kid %q", id)
+			default:
+				if problem := check(id, value); problem != "" {
+					add(key, "%s", problem)
+				}
+			}
+			kids[id] = true
+		}
+	}
+	checkKeys("auth.jwt_keys", "kid:secret", c.Auth.JWTKeys, func(kid, secret string) string {
+		if len(secret) < 32 {
+			return fmt.Sprintf("secret for kid %q must be at least 32 characters", kid)
+		}
+		return ""
+	})
+	checkKeys("auth.jwt_private_keys", "kid:file", c.Auth.JWTPrivateKeys, func(kid, path string) string {
+		if _, err := os.Stat(path); err != nil {
+			return err.Error()
+		}
+		return ""
+	})
+
+	problems = append(problems, c.GRPC.Validate()...)
+	problems = append(problems, c.Outbound.validate()...)
+	for i, raw := range c.Webhooks.URLs {
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(fmt.Sprintf("webhooks.urls[%d]", i), "must be an http or https URL, got %q", raw)
+		}
+	}
+	if c.Avatars.MaxBytes < 1 || c.Avatars.MaxWidth < 1 || c.Avatars.MaxHeight < 1 {
+		add("avatars", "max_bytes, max_width and max_height must be positive")
+	}
+	for i, size := range c.Avatars.ThumbnailSizes {
+		if size < 1 {
+			add(fmt.Sprintf("avatars.thumbnail_sizes[%d]", i), "must be positive")
+		}
+	}
+	if c.Capacity.MaxRows < 0 || c.Capacity.MaxBytes < 0 {
+		add("capacity", "max_rows and max_bytes must not be negative")
+	}
+	if c.Capacity.WarnRatio <= 0 || c.Capacity.WarnRatio > 1 {
+		add("capacity.warn_ratio", "must be greater than 0 and at most 1, got %v", c.Capacity.WarnRatio)
+	}
+	if c.Tracing.Sampler != "always" && c.Tracing.Sampler != "ratio" {
+		add("tracing.sampler", "must be always or ratio, got %q", c.Tracing.Sampler)
+	}
+	if c.Tracing.Ratio < 0 || c.Tracing.Ratio > 1 {
+		add("tracing.ratio", "must be between 0 and 1, got %v", c.Tracing.Ratio)
+	}
+	methods := make([]string, 0, len(c.Tracing.Methods))
+	for method := range c.Tracing.Methods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		key := fmt.Sprintf("tracing.methods[%q]", method)
+		if !strings.HasPrefix(method, "/") || strings.Count(method, "/") != 2 {
+			add(key, "must be a full method name such as \"/user.UserService/Login\"")
+		}
+		if r := c.Tracing.Methods[method]; r < 0 || r > 1 {
+			add(key, "must be between 0 and 1, got %v", r)
+		}
+	}
+	if c.Webhooks.MaxAttempts < 1 {
+		add("webhooks.max_attempts", "must be at least 1")
+	}
+	if len(c.Webhooks.URLs) > 0 && len(c.Webhooks.Secret) < 32 {
+		add("webhooks.secret", "must be at least 32 characters when webhooks.urls is set")
+	}
+	problems = append(problems, checkDurations([]durationField{
+		{"auth.token_ttl", c.Auth.TokenTTL, false},
+		{"auth.impersonation_ttl", c.Auth.ImpersonationTTL, false},
+		{"auth.refresh_token_ttl", c.Auth.RefreshTokenTTL, false},
+		{"timeouts.default_rpc", c.Timeouts.DefaultRPC, false},
+		{"auth.login_lockout", c.Auth.LoginLockout, false},
+		{"auth.policy_reload_interval", c.Auth.PolicyReloadInterval, false},
+		{"storage.cleanup_interval", c.Storage.CleanupInterval, false},
+		{"capacity.check_interval", c.Capacity.CheckInterval, false},
+		{"tracing.slow_threshold", c.Tracing.SlowThreshold, true},
+		{"database.slow_request_threshold", c.Database.SlowRequestThreshold, true},
+		{"database.slow_query_threshold", c.Database.SlowQueryThreshold, true},
+		{"database.connect_max_wait", c.Database.ConnectMaxWait, true},
+		{"database.connect_retry_backoff", c.Database.ConnectRetryBackoff, false},
+		{"database.connect_max_backoff", c.Database.ConnectMaxBackoff, false},
+		{"database.replica_check_interval", c.Database.ReplicaCheckInterval, false},
+		{"database.health_check_interval", c.Database.HealthCheckInterval, false},
+		{"database.retry_base_delay", c.Database.RetryBaseDelay, false},
+		{"database.retry_max_delay", c.Database.RetryMaxDelay, false},
+	})...)
+
+	// bcrypt.MinCost and bcrypt.MaxCost
+	if c.Auth.BcryptCost < 4 || c.Auth.BcryptCost > 31 {
+		add("auth.bcrypt_cost", "must be between 4 and 31, got %d", c.Auth.BcryptCost)
+	}
+	// bcrypt refuses passwords over 72 bytes
+	if c.Password.MinLength < 1 || c.Password.MinLength > 72 {
+		add("password.min_length", "must be between 1 and 72, got %d", c.Password.MinLength)
+	}
+	if raw := c.Password.BreachCheckURL; raw != "" {
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("password.breach_check_url", "must be an http or https URL, got %q", raw)
+		}
+	}
+	if c.Auth.LoginMaxFailures < 0 {
+		add("auth.login_max_failures", "must not be negative")
+	}
+	if c.Database.RetryMaxAttempts < 1 {
+		add("database.retry_max_attempts", "must be at least 1, got %d", c.Database.RetryMaxAttempts)
+	}
+	if c.Database.HealthFailureThreshold < 1 {
+		add("database.health_failure_threshold", "must be at least 1, got %d", c.Database.HealthFailureThreshold)
+	}
+	if c.Database.RetryBudget < 0 || c.Database.RetryBudget > 1 {
+		add("database.retry_budget", "must be between 0 and 1, got %v", c.Database.RetryBudget)
+	}
+	if c.Storage.Backend != "memory" && c.Storage.Backend != "postgres" {
+		add("storage.backend", "must be memory or postgres, got %q", c.Storage.Backend)
+	}
+	if c.RateLimit.RPS < 0 {
+		add("rate_limit.rps", "must not be negative")
+	}
+	if c.RateLimit.RPS > 0 && c.RateLimit.Burst < 1 {
+		add("rate_limit.burst", "must be at least 1 when rate_limit.rps is set")
+	}
+
+	if c.Mail.SMTPUser != "" && c.Mail.SMTPPassword == "" {
+		add("mail.smtp_password", "must be set when mail.smtp_user is set")
+	}
+	if c.Mail.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(c.Mail.SMTPAddr); err != nil {
+			add("mail.smtp_addr", "must be host:port, got %q", c.Mail.SMTPAddr)
+		}
+	}
+	if c.Consent.TermsVersion == "" {
+		add("consent.terms_version", "must be set")
+	}
+	if c.Consent.PrivacyVersion == "" {
+		add("consent.privacy_version", "must be set")
+	}
+	for _, p := range []struct{ name, id, secret string }{
+		{"google", c.OAuth.GoogleClientID, c.OAuth.GoogleClientSecret},
+		{"github", c.OAuth.GitHubClientID, c.OAuth.GitHubClientSecret},
+	} {
+		if (p.id == "") != (p.secret == "") {
+			add("oauth."+p.name+"_client_secret", "oauth.%[1]s_client_id and oauth.%[1]s_client_secret must be set together", p.name)
+		}
+	}
+	if c.OAuth.Enabled() {
+		if u, err := url.Parse(c.OAuth.RedirectBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("oauth.redirect_base_url", "must be the gateway's http or https URL when a provider is set, got %q", c.OAuth.RedirectBaseURL)
+		}
+	}
+	return problems
+}
+
+// Validate checks the client section on its own; clients don't need the
+// server settings to be complete.
+func (cc *ClientConfig) Validate() []Problem {
+	var problems []Problem
+	if cc.Target == "" {
+		problems = append(problems, Problem{Key: "client.target", Message: "must be set"})
+	}
+	if (cc.CertFile == "") != (cc.KeyFile == "") {
+		problems = append(problems, Problem{Key: "client", Message: "cert_file and key_file must be set together"})
+	}
+	return problems
+}
+
+// Validate checks the grpc section on its own.
+func (g *GRPCConfig) Validate() []Problem {
+	problems := checkDurations([]durationField{
+		{"grpc.connection_timeout", g.ConnectionTimeout, false},
+		{"grpc.keepalive.time", g.Keepalive.Time, false},
+		{"grpc.keepalive.timeout", g.Keepalive.Timeout, false},
+		{"grpc.keepalive.max_connection_idle", g.Keepalive.MaxConnectionIdle, true},
+		{"grpc.keepalive.max_connection_age", g.Keepalive.MaxConnectionAge, true},
+		{"grpc.keepalive.max_connection_age_grace", g.Keepalive.MaxConnectionAgeGrace, true},
+		{"grpc.keepalive.min_time", g.Keepalive.MinTime, true},
+	})
+	if g.MaxRecvMsgSize <= 0 {
+		problems = append(problems, Problem{Key: "grpc.max_recv_msg_size", Message: "must be positive"})
+	}
+	if g.MaxSendMsgSize <= 0 {
+		problems = append(problems, Problem{Key: "grpc.max_send_msg_size", Message: "must be positive"})
+	}
+	if g.GatewayConnections < 1 {
+		problems = append(problems, Problem{Key: "grpc.gateway_connections", Message: "must be at least 1"})
+	}
+	return append(problems, g.TLS.validate()...)
+}
+
+func (t *TLSConfig) validate() []Problem {
+	var problems []Problem
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		problems = append(problems, Problem{Key: "grpc.tls", Message: "cert_file and key_file must be set together"})
+	}
+	if t.ClientCAFile != "" && t.CertFile == "" {
+		problems = append(problems, Problem{Key: "grpc.tls.client_ca_file", Message: "requires cert_file and key_file"})
+	}
+	for _, f := range []struct{ key, path string }{
+		{"grpc.tls.cert_file", t.CertFile},
+		{"grpc.tls.key_file", t.KeyFile},
+		{"grpc.tls.client_ca_file", t.ClientCAFile},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			problems = append(problems, Problem{Key: f.key, Message: err.Error()})
+		}
+	}
+	return problems
+}
+
+func (o *OutboundConfig) validate() []Problem {
+	problems := checkDurations([]durationField{
+		{"outbound_http.timeout", o.Timeout, false},
+		{"outbound_http.dial_timeout", o.DialTimeout, false},
+		{"outbound_http.retry_backoff", o.RetryBackoff, false},
+		{"outbound_http.max_backoff", o.MaxBackoff, false},
+		{"outbound_http.idle_conn_timeout", o.IdleConnTimeout, false},
+	})
+	add := func(key, format string, args ...interface{}) {
+		problems = append(problems, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+	if o.MaxRetries < 0 {
+		add("outbound_http.max_retries", "must not be negative")
+	}
+	if o.MaxIdleConnsPerHost < 1 {
+		add("outbound_http.max_idle_conns_per_host", "must be at least 1")
+	}
+	if o.MaxConnsPerHost < 0 {
+		add("outbound_http.max_conns_per_host", "must not be negative")
+	}
+	if o.ProxyURL != "" {
+		if u, err := url.Parse(o.ProxyURL); err != nil || u.Host == "" {
+			add("outbound_http.proxy_url", "must be a URL such as \"http://proxy:3128\", got %q", o.ProxyURL)
+		}
+	}
+	for i, cidr := range o.AllowedNetworks {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			add(fmt.Sprintf("outbound_http.allowed_networks[%d]", i), "must be a CIDR such as \"10.0.0.0/8\", got %q", cidr)
+		}
+	}
+	return problems
+}
+
+type durationField struct {
+	key    string
+	d      Duration
+	zeroOK bool // 0 means "disabled"
+}
+
+func checkDurations(fields []durationField) []Problem {
+	var problems []Problem
+	for _, f := range fields {
+		switch {
+		case f.d.err != nil:
+			problems = append(problems, Problem{Key: f.key,
+				Message: fmt.Sprintf("invalid duration %q (use e.g. \"30s\" or \"15m\")", f.d.raw)})
+		case f.d.Duration < 0 || (f.d.Duration == 0 && !f.zeroOK):
+			problems = append(problems, Problem{Key: f.key, Message: "must be positive"})
+		}
+	}
+	return problems
+}
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is a key that can be overridden outside the file: by its
+// environment variable, or by a command-line flag mapped to it.
+type setting struct {
+	key string
+	env string
+	set func(c *Config, v string) error
+}
+
+// settings lists every overridable key. Env names predate the config file
+// and are kept so existing deployments keep working.
+var settings = []setting{
+	{"server.grpc_addr", "GRPC_ADDR", str(func(c *Config) *string { return &c.Server.GRPCAddr })},
+	{"server.http_addr", "HTTP_ADDR", str(func(c *Config) *string { return &c.Server.HTTPAddr })},
+	{"server.log_payload_sizes", "GRPC_LOG_PAYLOAD_SIZES", boolean(func(c *Config) *bool { return &c.Server.LogPayloadSizes })},
+	{"server.read_only", "READ_ONLY", boolean(func(c *Config) *bool { return &c.Server.ReadOnly })},
+	{"server.region", "REGION", str(func(c *Config) *string { return &c.Server.Region })},
+	{"server.trusted_proxies", "TRUSTED_PROXIES", list(func(c *Config) *[]string { return &c.Server.TrustedProxies })},
+	{"server.debug_addr", "DEBUG_ADDR", str(func(c *Config) *string { return &c.Server.DebugAddr })},
+	{"log.level", "LOG_LEVEL", str(func(c *Config) *string { return &c.Log.Level })},
+	{"log.format", "LOG_FORMAT", str(func(c *Config) *string { return &c.Log.Format })},
+	{"grpc.gateway_connections", "GATEWAY_CONNECTIONS", integer(func(c *Config) *int { return &c.GRPC.GatewayConnections })},
+	{"grpc.tls.cert_file", "TLS_CERT_FILE", str(func(c *Config) *string { return &c.GRPC.TLS.CertFile })},
+	{"grpc.tls.key_file", "TLS_KEY_FILE", str(func(c *Config) *string { return &c.GRPC.TLS.KeyFile })},
+	{"grpc.tls.client_ca_file", "TLS_CLIENT_CA_FILE", str(func(c *Config) *string { return &c.GRPC.TLS.ClientCAFile })},
+	{"database.driver", "DB_DRIVER", str(func(c *Config) *string { return &c.Database.Driver })},
+	{"database.url", "DB_URL", str(func(c *Config) *string { return &c.Database.URL })},
+	{"database.connect_max_wait", "DB_CONNECT_MAX_WAIT", duration(func(c *Config) *Duration { return &c.Database.ConnectMaxWait })},
+	{"database.connect_retry_backoff", "DB_CONNECT_RETRY_BACKOFF", duration(func(c *Config) *Duration { return &c.Database.ConnectRetryBackoff })},
+	{"database.connect_max_backoff", "DB_CONNECT_MAX_BACKOFF", duration(func(c *Config) *Duration { return &c.Database.ConnectMaxBackoff })},
+	{"database.auto_migrate", "DB_AUTO_MIGRATE", boolean(func(c *Config) *bool { return &c.Database.AutoMigrate })},
+	{"database.user_ids", "DB_USER_IDS", str(func(c *Config) *string { return &c.Database.UserIDs })},
+	{"database.replica_urls", "DB_REPLICA_URLS", list(func(c *Config) *[]string { return &c.Database.ReplicaURLs })},
+	{"database.replica_check_interval", "DB_REPLICA_CHECK_INTERVAL", duration(func(c *Config) *Duration { return &c.Database.ReplicaCheckInterval })},
+	{"database.health_check_interval", "DB_HEALTH_CHECK_INTERVAL", duration(func(c *Config) *Duration { return &c.Database.HealthCheckInterval })},
+	{"database.health_failure_threshold", "DB_HEALTH_FAILURE_THRESHOLD", integer(func(c *Config) *int { return &c.Database.HealthFailureThreshold })},
+	{"database.retry_max_attempts", "DB_RETRY_MAX_ATTEMPTS", integer(func(c *Config) *int { return &c.Database.RetryMaxAttempts })},
+	{"database.retry_base_delay", "DB_RETRY_BASE_DELAY", duration(func(c *Config) *Duration { return &c.Database.RetryBaseDelay })},
+	{"database.retry_max_delay", "DB_RETRY_MAX_DELAY", duration(func(c *Config) *Duration { return &c.Database.RetryMaxDelay })},
+	{"database.retry_budget", "DB_RETRY_BUDGET", float(func(c *Config) *float64 { return &c.Database.RetryBudget })},
+	{"database.slow_request_threshold", "SLOW_REQUEST_THRESHOLD", duration(func(c *Config) *Duration { return &c.Database.SlowRequestThreshold })},
+	{"database.explain_slow_requests", "EXPLAIN_SLOW_REQUESTS", boolean(func(c *Config) *bool { return &c.Database.ExplainSlowRequests })},
+	{"database.slow_query_threshold", "SLOW_QUERY_THRESHOLD", duration(func(c *Config) *Duration { return &c.Database.SlowQueryThreshold })},
+	{"auth.enabled", "AUTH_ENABLED", boolean(func(c *Config) *bool { return &c.Auth.Enabled })},
+	{"auth.jwt_secret", "JWT_SECRET", str(func(c *Config) *string { return &c.Auth.JWTSecret })},
+	{"auth.jwt_keys", "JWT_KEYS", list(func(c *Config) *[]string { return &c.Auth.JWTKeys })},
+	{"auth.jwt_algorithm", "JWT_ALGORITHM", str(func(c *Config) *string { return &c.Auth.JWTAlgorithm })},
+	{"auth.jwt_private_keys", "JWT_PRIVATE_KEYS", list(func(c *Config) *[]string { return &c.Auth.JWTPrivateKeys })},
+	{"auth.jwt_issuer", "JWT_ISSUER", str(func(c *Config) *string { return &c.Auth.JWTIssuer })},
+	{"auth.jwt_audience", "JWT_AUDIENCE", str(func(c *Config) *string { return &c.Auth.JWTAudience })},
+	{"auth.token_ttl", "TOKEN_TTL", duration(func(c *Config) *Duration { return &c.Auth.TokenTTL })},
+	{"auth.impersonation_ttl", "IMPERSONATION_TTL", duration(func(c *Config) *Duration { return &c.Auth.ImpersonationTTL })},
+	{"auth.refresh_token_ttl", "REFRESH_TOKEN_TTL", duration(func(c *Config) *Duration { return &c.Auth.RefreshTokenTTL })},
+	{"auth.login_max_failures", "LOGIN_MAX_FAILURES", integer(func(c *Config) *int { return &c.Auth.LoginMaxFailures })},
+	{"auth.login_lockout", "LOGIN_LOCKOUT", duration(func(c *Config) *Duration { return &c.Auth.LoginLockout })},
+	{"auth.require_verified_email", "REQUIRE_VERIFIED_EMAIL", boolean(func(c *Config) *bool { return &c.Auth.RequireVerifiedEmail })},
+	{"auth.bcrypt_cost", "BCRYPT_COST", integer(func(c *Config) *int { return &c.Auth.BcryptCost })},
+	{"auth.cookie_auth", "AUTH_COOKIE", boolean(func(c *Config) *bool { return &c.Auth.CookieAuth })},
+	{"auth.cookie_secure", "AUTH_COOKIE_SECURE", boolean(func(c *Config) *bool { return &c.Auth.CookieSecure })},
+	{"auth.policy_file", "AUTH_POLICY_FILE", str(func(c *Config) *string { return &c.Auth.PolicyFile })},
+	{"auth.policy_reload_interval", "AUTH_POLICY_RELOAD_INTERVAL", duration(func(c *Config) *Duration { return &c.Auth.PolicyReloadInterval })},
+	{"password.min_length", "PASSWORD_MIN_LENGTH", integer(func(c *Config) *int { return &c.Password.MinLength })},
+	{"password.require_upper", "PASSWORD_REQUIRE_UPPER", boolean(func(c *Config) *bool { return &c.Password.RequireUpper })},
+	{"password.require_lower", "PASSWORD_REQUIRE_LOWER", boolean(func(c *Config) *bool { return &c.Password.RequireLower })},
+	{"password.require_digit", "PASSWORD_REQUIRE_DIGIT", boolean(func(c *Config) *bool { return &c.Password.RequireDigit })},
+	{"password.require_symbol", "PASSWORD_REQUIRE_SYMBOL", boolean(func(c *Config) *bool { return &c.Password.RequireSymbol })},
+	{"password.breach_check_url", "PASSWORD_BREACH_CHECK_URL", str(func(c *Config) *string { return &c.Password.BreachCheckURL })},
+	{"timeouts.default_rpc", "RPC_TIMEOUT", duration(func(c *Config) *Duration { return &c.Timeouts.DefaultRPC })},
+	{"rate_limit.rps", "RATE_LIMIT_RPS", float(func(c *Config) *float64 { return &c.RateLimit.RPS })},
+	{"rate_limit.burst", "RATE_LIMIT_BURST", integer(func(c *Config) *int { return &c.RateLimit.Burst })},
+	{"storage.backend", "STORAGE_BACKEND", str(func(c *Config) *string { return &c.Storage.Backend })},
+	{"storage.cleanup_interval", "STORAGE_CLEANUP_INTERVAL", duration(func(c *Config) *Duration { return &c.Storage.CleanupInterval })},
+	{"mail.smtp_addr", "SMTP_ADDR", str(func(c *Config) *string { return &c.Mail.SMTPAddr })},
+	{"mail.smtp_from", "SMTP_FROM", str(func(c *Config) *string { return &c.Mail.SMTPFrom })},
+	{"mail.smtp_user", "SMTP_USER", str(func(c *Config) *string { return &c.Mail.SMTPUser })},
+	{"mail.smtp_password", "SMTP_PASSWORD", str(func(c *Config) *string { return &c.Mail.SMTPPassword })},
+	{"mail.app_base_url", "APP_BASE_URL", str(func(c *Config) *string { return &c.Mail.AppBaseURL })},
+	{"consent.terms_version", "TERMS_VERSION", str(func(c *Config) *string { return &c.Consent.TermsVersion })},
+	{"consent.privacy_version", "PRIVACY_VERSION", str(func(c *Config) *string { return &c.Consent.PrivacyVersion })},
+	{"oauth.redirect_base_url", "OAUTH_REDIRECT_BASE_URL", str(func(c *Config) *string { return &c.OAuth.RedirectBaseURL })},
+	{"oauth.google_client_id", "GOOGLE_CLIENT_ID", str(func(c *Config) *string { return &c.OAuth.GoogleClientID })},
+	{"oauth.google_client_secret", "GOOGLE_CLIENT_SECRET", str(func(c *Config) *string { return &c.OAuth.GoogleClientSecret })},
+	{"oauth.github_client_id", "GITHUB_CLIENT_ID", str(func(c *Config) *string { return &c.OAuth.GitHubClientID })},
+	{"oauth.github_client_secret", "GITHUB_CLIENT_SECRET", str(func(c *Config) *string { return &c.OAuth.GitHubClientSecret })},
+	{"outbound_http.timeout", "OUTBOUND_HTTP_TIMEOUT", duration(func(c *Config) *Duration { return &c.Outbound.Timeout })},
+	{"outbound_http.max_retries", "OUTBOUND_HTTP_MAX_RETRIES", integer(func(c *Config) *int { return &c.Outbound.MaxRetries })},
+	{"outbound_http.proxy_url", "OUTBOUND_HTTP_PROXY", str(func(c *Config) *string { return &c.Outbound.ProxyURL })},
+	{"outbound_http.allowed_networks", "OUTBOUND_HTTP_ALLOWED_NETWORKS", list(func(c *Config) *[]string { return &c.Outbound.AllowedNetworks })},
+	{"webhooks.urls", "WEBHOOK_URLS", list(func(c *Config) *[]string { return &c.Webhooks.URLs })},
+	{"webhooks.secret", "WEBHOOK_SECRET", str(func(c *Config) *string { return &c.Webhooks.Secret })},
+	{"webhooks.max_attempts", "WEBHOOK_MAX_ATTEMPTS", integer(func(c *Config) *int { return &c.Webhooks.MaxAttempts })},
+	{"capacity.check_interval", "CAPACITY_CHECK_INTERVAL", duration(func(c *Config) *Duration { return &c.Capacity.CheckInterval })},
+	{"capacity.max_rows", "CAPACITY_MAX_ROWS", integer(func(c *Config) *int { return &c.Capacity.MaxRows })},
+	{"capacity.max_bytes", "CAPACITY_MAX_BYTES", integer(func(c *Config) *int { return &c.Capacity.MaxBytes })},
+	{"capacity.warn_ratio", "CAPACITY_WARN_RATIO", float(func(c *Config) *float64 { return &c.Capacity.WarnRatio })},
+	{"audit.enabled", "AUDIT_ENABLED", boolean(func(c *Config) *bool { return &c.Audit.Enabled })},
+	{"tracing.enabled", "TRACING_ENABLED", boolean(func(c *Config) *bool { return &c.Tracing.Enabled })},
+	{"tracing.sampler", "TRACING_SAMPLER", str(func(c *Config) *string { return &c.Tracing.Sampler })},
+	{"tracing.ratio", "TRACING_RATIO", float(func(c *Config) *float64 { return &c.Tracing.Ratio })},
+	{"tracing.keep_errors", "TRACING_KEEP_ERRORS", boolean(func(c *Config) *bool { return &c.Tracing.KeepErrors })},
+	{"tracing.slow_threshold", "TRACING_SLOW_THRESHOLD", duration(func(c *Config) *Duration { return &c.Tracing.SlowThreshold })},
+	{"client.target", "USER_SERVICE_TARGET", str(func(c *Config) *string { return &c.Client.Target })},
+	{"client.region", "USER_SERVICE_REGION", str(func(c *Config) *string { return &c.Client.Region })},
+	{"client.tls", "USER_SERVICE_TLS", boolean(func(c *Config) *bool { return &c.Client.TLS })},
+	{"client.ca_file", "USER_SERVICE_CA_FILE", str(func(c *Config) *string { return &c.Client.CAFile })},
+	{"client.server_name", "USER_SERVICE_SERVER_NAME", str(func(c *Config) *string { return &c.Client.ServerName })},
+	{"client.cert_file", "USER_SERVICE_CERT_FILE", str(func(c *Config) *string { return &c.Client.CertFile })},
+	{"client.key_file", "USER_SERVICE_KEY_FILE", str(func(c *Config) *string { return &c.Client.KeyFile })},
+}
+
+// ClientFlags maps the connection flags of the example client and usersctl
+// to the client section, for ApplyFlags.
+var ClientFlags = map[string]string{
+	"target":      "client.target",
+	"region":      "client.region",
+	"tls":         "client.tls",
+	"ca-file":     "client.ca_file",
+	"server-name": "client.server_name",
+	"cert":        "client.cert_file",
+	"key":         "client.key_file",
+}
+
+// Resolve builds the effective configuration: the defaults, then the file at
+// path (skipped when path is empty), then environment variables. Env values
+// that don't parse are returned as problems; call Validate for the rest.
+func Resolve(path string) (*Config, []Problem, error) {
+	cfg := Default()
+	if path != "" {
+		var err error
+		if cfg, err = Load(path); err != nil {
+			return nil, nil, err
+		}
+	}
+	return cfg, cfg.ApplyEnv(), nil
+}
+
+// ApplyEnv overrides c with every variable in settings that is set.
+func (c *Config) ApplyEnv() []Problem {
+	var problems []Problem
+	for _, s := range settings {
+		v, ok := os.LookupEnv(s.env)
+		if !ok || v == "" {
+			continue
+		}
+		if err := s.set(c, v); err != nil {
+			problems = append(problems, Problem{Key: s.key, Message: fmt.Sprintf("%s: %v", s.env, err)})
+		}
+	}
+	return problems
+}
+
+// ApplyFlags overrides c with the flags in keys (flag name -> config key)
+// that were given on the command line. Flags take precedence over the file
+// and the environment.
+func (c *Config) ApplyFlags(fs *flag.FlagSet, keys map[string]string) []Problem {
+	var problems []Problem
+	fs.Visit(func(f *flag.Flag) {
+		key, ok := keys[f.Name]
+		if !ok {
+			return
+		}
+		if err := c.Set(key, f.Value.String()); err != nil {
+			problems = append(problems, Problem{Key: key, Message: fmt.Sprintf("-%s: %v", f.Name, err)})
+		}
+	})
+	return problems
+}
+
+// Set assigns one overridable key from its string form.
+func (c *Config) Set(key, value string) error {
+	for _, s := range settings {
+		if s.key == key {
+			return s.set(c, value)
+		}
+	}
+	return fmt.Errorf("%q can't be overridden", key)
+}
+
+func str(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func boolean(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func integer(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", v)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func float(field func(*Config) *float64) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", v)
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+// list takes comma-separated values.
+func list(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field(c) = items
+		return nil
+	}
+}
+
+// duration never fails; like a bad value in the file, a bad duration is
+// reported by Validate.
+func duration(field func(*Config) *Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d := field(c)
+		d.raw = v
+		d.Duration, d.err = time.ParseDuration(v)
+		return nil
+	}
+}
@@ -0,0 +1,263 @@
+// Package doctor inspects a user service database and reports problems an
+// operator can act on.
+package doctor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"grpc-crud-proj/db"
+)
+
+// Severity of a finding.
+type Severity string
+
+const (
+	OK    Severity = "ok"
+	Warn  Severity = "warn"
+	Error Severity = "error"
+)
+
+// Finding is the result of one check.
+type Finding struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	Fix      string   `json:"fix,omitempty"`
+}
+
+// Extensions the search and case-insensitive email features rely on.
+var recommendedExtensions = map[string]string{
+	"pg_trgm": "trigram indexes for name/email search",
+	"citext":  "case-insensitive email columns",
+}
+
+// Bloat thresholds for the users table.
+const (
+	maxDeadTupleRatio = 0.2
+	minRowsForBloat   = 1000
+)
+
+// Run executes every check. A failed connection stops the run, since no other
+// check can work without it.
+func Run(ctx context.Context, conn *sql.DB) []Finding {
+	if err := conn.PingContext(ctx); err != nil {
+		return []Finding{{Check: "connectivity", Severity: Error,
+			Message: fmt.Sprintf("cannot reach the database: %v", err),
+			Fix:     "check DB_URL, network access and credentials"}}
+	}
+	findings := []Finding{{Check: "connectivity", Severity: OK, Message: "connected"}}
+
+	findings = append(findings, checkExtensions(ctx, conn)...)
+	findings = append(findings, checkSchemaDrift(ctx, conn)...)
+	findings = append(findings, checkUsersBloat(ctx, conn)...)
+	return findings
+}
+
+func checkExtensions(ctx context.Context, conn *sql.DB) []Finding {
+	installed := map[string]bool{}
+	rows, err := conn.QueryContext(ctx, "SELECT extname FROM pg_extension")
+	if err != nil {
+		return []Finding{{Check: "extensions", Severity: Error, Message: fmt.Sprintf("cannot list extensions: %v", err)}}
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return []Finding{{Check: "extensions", Severity: Error, Message: err.Error()}}
+		}
+		installed[name] = true
+	}
+
+	var findings []Finding
+	for _, name := range sortedKeys(recommendedExtensions) {
+		if installed[name] {
+			findings = append(findings, Finding{Check: "extensions", Severity: OK, Message: name + " installed"})
+			continue
+		}
+		findings = append(findings, Finding{Check: "extensions", Severity: Warn,
+			Message: fmt.Sprintf("%s is not installed (needed for %s)", name, recommendedExtensions[name]),
+			Fix:     fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s;", name)})
+	}
+	return findings
+}
+
+// checkSchemaDrift applies db.Schema to a scratch schema inside a transaction
+// that is rolled back, then compares its columns and indexes with the live
+// schema. This catches both missing indexes and hand-made changes without
+// keeping a second copy of the expected schema.
+func checkSchemaDrift(ctx context.Context, conn *sql.DB) []Finding {
+	fail := func(err error) []Finding {
+		return []Finding{{Check: "schema", Severity: Warn,
+			Message: fmt.Sprintf("cannot compare with db/migrations: %v", err),
+			Fix:     "run doctor as a role that may CREATE SCHEMA"}}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fail(err)
+	}
+	defer tx.Rollback()
+
+	var live string
+	if err := tx.QueryRowContext(ctx, "SELECT current_schema()").Scan(&live); err != nil {
+		return fail(err)
+	}
+	const scratch = "usersvc_doctor_expected"
+	if _, err := tx.ExecContext(ctx, "CREATE SCHEMA "+scratch); err != nil {
+		return fail(err)
+	}
+	if _, err := tx.ExecContext(ctx, "SET LOCAL search_path TO "+scratch); err != nil {
+		return fail(err)
+	}
+	if _, err := tx.ExecContext(ctx, db.Schema); err != nil {
+		return fail(err)
+	}
+
+	expectedCols, err := columns(ctx, tx, scratch)
+	if err != nil {
+		return fail(err)
+	}
+	liveCols, err := columns(ctx, tx, live)
+	if err != nil {
+		return fail(err)
+	}
+	expectedIdx, err := indexes(ctx, tx, scratch)
+	if err != nil {
+		return fail(err)
+	}
+	liveIdx, err := indexes(ctx, tx, live)
+	if err != nil {
+		return fail(err)
+	}
+
+	var findings []Finding
+	for _, key := range sortedKeys(expectedCols) {
+		got, ok := liveCols[key]
+		switch {
+		case !ok:
+			findings = append(findings, Finding{Check: "schema", Severity: Error,
+				Message: "missing column " + key, Fix: "run the server with -migrate"})
+		case got != expectedCols[key]:
+			findings = append(findings, Finding{Check: "schema", Severity: Warn,
+				Message: fmt.Sprintf("column %s is %s, expected %s", key, got, expectedCols[key])})
+		}
+	}
+	for _, name := range sortedKeys(expectedIdx) {
+		if _, ok := liveIdx[name]; !ok {
+			findings = append(findings, Finding{Check: "indexes", Severity: Error,
+				Message: "missing index " + name, Fix: expectedIdx[name] + ";"})
+		}
+	}
+	for _, name := range sortedKeys(liveIdx) {
+		table := strings.SplitN(name, ".", 2)[0]
+		if _, ok := expectedIdx[name]; !ok && knownTable(expectedCols, table) {
+			findings = append(findings, Finding{Check: "indexes", Severity: Warn,
+				Message: "index " + name + " is not in db/migrations",
+				Fix:     "add it in a migration if it is needed, otherwise drop it"})
+		}
+	}
+	if len(findings) == 0 {
+		findings = append(findings, Finding{Check: "schema", Severity: OK, Message: "tables, columns and indexes match db/migrations"})
+	}
+	return findings
+}
+
+// columns maps "table.column" to its type and nullability.
+func columns(ctx context.Context, tx *sql.Tx, schema string) (map[string]string, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT table_name, column_name, data_type, is_nullable
+		 FROM information_schema.columns WHERE table_schema=$1`,
+		schema,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]string{}
+	for rows.Next() {
+		var table, column, dataType, nullable string
+		if err := rows.Scan(&table, &column, &dataType, &nullable); err != nil {
+			return nil, err
+		}
+		desc := dataType
+		if nullable == "NO" {
+			desc += " NOT NULL"
+		}
+		out[table+"."+column] = desc
+	}
+	return out, rows.Err()
+}
+
+// indexes maps "table.index" to its definition with the schema name removed.
+func indexes(ctx context.Context, tx *sql.Tx, schema string) (map[string]string, error) {
+	rows, err := tx.QueryContext(ctx,
+		"SELECT tablename, indexname, indexdef FROM pg_indexes WHERE schemaname=$1",
+		schema,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]string{}
+	for rows.Next() {
+		var table, name, def string
+		if err := rows.Scan(&table, &name, &def); err != nil {
+			return nil, err
+		}
+		out[table+"."+name] = strings.ReplaceAll(def, schema+".", "")
+	}
+	return out, rows.Err()
+}
+
+func knownTable(cols map[string]string, table string) bool {
+	for key := range cols {
+		if strings.HasPrefix(key, table+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func checkUsersBloat(ctx context.Context, conn *sql.DB) []Finding {
+	var live, dead int64
+	var size string
+	var lastVacuum sql.NullTime
+	err := conn.QueryRowContext(ctx,
+		`SELECT n_live_tup, n_dead_tup, pg_size_pretty(pg_total_relation_size(relid)),
+		        GREATEST(last_vacuum, last_autovacuum)
+		 FROM pg_stat_user_tables WHERE relname='users' AND schemaname=current_schema()`,
+	).Scan(&live, &dead, &size, &lastVacuum)
+	if err == sql.ErrNoRows {
+		return []Finding{{Check: "bloat", Severity: Error, Message: "users table not found", Fix: "run the server with -migrate"}}
+	}
+	if err != nil {
+		return []Finding{{Check: "bloat", Severity: Warn, Message: fmt.Sprintf("cannot read table statistics: %v", err)}}
+	}
+
+	vacuumed := "never vacuumed"
+	if lastVacuum.Valid {
+		vacuumed = "last vacuumed " + lastVacuum.Time.Format("2006-01-02 15:04 MST")
+	}
+	summary := fmt.Sprintf("users: %d live rows, %d dead, %s, %s", live, dead, size, vacuumed)
+	if live+dead >= minRowsForBloat && float64(dead) > maxDeadTupleRatio*float64(live+dead) {
+		return []Finding{{Check: "bloat", Severity: Warn,
+			Message: summary + fmt.Sprintf(" (over %.0f%% dead rows slows scans)", maxDeadTupleRatio*100),
+			Fix:     "VACUUM (ANALYZE) users; and check that autovacuum keeps up"}}
+	}
+	return []Finding{{Check: "bloat", Severity: OK, Message: summary}}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,281 @@
+// Package httpclient is the outbound HTTP client shared by webhooks and other
+// notifiers: bounded timeouts, retries with backoff, pooled connections,
+// proxy support and protection against being pointed at internal addresses
+// (SSRF).
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrBlockedAddress is returned (wrapped) when a request would reach an
+// address in a private, loopback or otherwise internal range that isn't
+// allow-listed.
+var ErrBlockedAddress = errors.New("httpclient: destination address is not allowed")
+
+// Config tunes a Client. Zero values fall back to the defaults noted.
+type Config struct {
+	Timeout             time.Duration // per attempt, including the body; 10s
+	DialTimeout         time.Duration // 5s
+	MaxRetries          int           // retries after the first attempt
+	RetryBackoff        time.Duration // before the first retry, doubling after; 500ms
+	MaxBackoff          time.Duration // cap on backoff and on Retry-After; 30s
+	MaxIdleConnsPerHost int           // 4
+	MaxConnsPerHost     int           // 0 means unlimited
+	IdleConnTimeout     time.Duration // 90s
+	// ProxyURL routes every request through this proxy. When empty,
+	// HTTPS_PROXY / HTTP_PROXY / NO_PROXY from the environment apply.
+	ProxyURL string
+	// AllowedNetworks are CIDRs that may be reached even though they are
+	// internal, e.g. "10.20.0.0/16" for an in-cluster receiver.
+	AllowedNetworks []string
+}
+
+// Client sends requests with the policy in Config. It is safe for concurrent
+// use.
+type Client struct {
+	hc      *http.Client
+	cfg     Config
+	allowed []netip.Prefix
+
+	mu      sync.Mutex
+	proxies map[string]bool // proxy host:ports, exempt from the address check
+}
+
+// New builds a Client.
+func New(cfg Config) (*Client, error) {
+	setDefault(&cfg.Timeout, 10*time.Second)
+	setDefault(&cfg.DialTimeout, 5*time.Second)
+	setDefault(&cfg.RetryBackoff, 500*time.Millisecond)
+	setDefault(&cfg.MaxBackoff, 30*time.Second)
+	setDefault(&cfg.IdleConnTimeout, 90*time.Second)
+	if cfg.MaxIdleConnsPerHost == 0 {
+		cfg.MaxIdleConnsPerHost = 4
+	}
+
+	c := &Client{cfg: cfg, proxies: map[string]bool{}}
+	for _, cidr := range cfg.AllowedNetworks {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("httpclient: bad allowed network %q: %w", cidr, err)
+		}
+		c.allowed = append(c.allowed, p.Masked())
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.ProxyURL != "" {
+		u, err := url.Parse(cfg.ProxyURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("httpclient: bad proxy URL %q", cfg.ProxyURL)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: 30 * time.Second, Control: c.checkDial}
+	direct := &net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy: func(r *http.Request) (*url.URL, error) {
+			u, err := proxy(r)
+			if u != nil {
+				c.trustProxy(u)
+			}
+			return u, err
+		},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if c.isProxy(addr) {
+				return direct.DialContext(ctx, network, addr)
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   cfg.DialTimeout,
+		ResponseHeaderTimeout: cfg.Timeout,
+	}
+	c.hc = &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+		// A redirect could point anywhere; receivers must answer directly
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	return c, nil
+}
+
+func setDefault(d *time.Duration, def time.Duration) {
+	if *d <= 0 {
+		*d = def
+	}
+}
+
+// Do sends req, retrying connection errors, 429 and 5xx responses with
+// exponential backoff (or the server's Retry-After). A request with a body
+// is only retried if it can be replayed, i.e. req.GetBody is set, as it is
+// for bodies from bytes.Reader, bytes.Buffer and strings.Reader. The last
+// response is returned even if it is an error status; the caller closes it.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("httpclient: unsupported scheme %q", req.URL.Scheme)
+	}
+	// The dialer checks direct connections; this also covers proxied ones,
+	// where only the proxy sees the destination's address.
+	if err := c.checkHost(req.Context(), req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := c.hc.Do(req)
+		retry := attempt < c.cfg.MaxRetries && (req.Body == nil || req.GetBody != nil) && retryable(resp, err)
+		if !retry {
+			return resp, err
+		}
+
+		wait := c.backoff(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp); ok {
+				wait = min(after, c.cfg.MaxBackoff)
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, ErrBlockedAddress) && !errors.Is(err, context.Canceled)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// backoff doubles from RetryBackoff up to MaxBackoff, with jitter so a burst
+// of failed deliveries doesn't retry in lockstep.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.cfg.RetryBackoff << attempt
+	if d <= 0 || d > c.cfg.MaxBackoff {
+		d = c.cfg.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+func (c *Client) checkHost(ctx context.Context, host string) error {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return c.checkAddr(addr)
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		// Let the dial report it (and retry) like any other network error
+		return nil
+	}
+	for _, addr := range addrs {
+		if err := c.checkAddr(addr); err != nil {
+			return fmt.Errorf("%w: %s resolves to %s", ErrBlockedAddress, host, addr)
+		}
+	}
+	return nil
+}
+
+// checkDial vets the address actually being connected to, after DNS, so a
+// name that re-resolves to an internal address is still caught.
+func (c *Client) checkDial(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, address)
+	}
+	return c.checkAddr(ap.Addr())
+}
+
+func (c *Client) checkAddr(addr netip.Addr) error {
+	addr = addr.Unmap()
+	for _, p := range c.allowed {
+		if p.Contains(addr) {
+			return nil
+		}
+	}
+	if internal(addr) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, addr)
+	}
+	return nil
+}
+
+// internalRanges are special-purpose ranges not covered by netip's methods.
+var internalRanges = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64 maps onto IPv4, internal or not
+}
+
+func internal(addr netip.Addr) bool {
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return true
+	}
+	for _, p := range internalRanges {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) trustProxy(u *url.URL) {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	c.mu.Lock()
+	c.proxies[net.JoinHostPort(u.Hostname(), port)] = true
+	c.mu.Unlock()
+}
+
+func (c *Client) isProxy(addr string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.proxies[addr]
+}
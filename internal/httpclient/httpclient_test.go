@@ -0,0 +1,190 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr    string
+		allowed []string
+		blocked bool
+	}{
+		{"127.0.0.1", nil, true},
+		{"::1", nil, true},
+		{"0.0.0.0", nil, true},
+		{"::", nil, true},
+		{"10.1.2.3", nil, true},
+		{"172.16.0.1", nil, true},
+		{"172.31.255.255", nil, true},
+		{"192.168.1.1", nil, true},
+		{"fd00::1", nil, true},
+		{"169.254.169.254", nil, true},
+		{"fe80::1", nil, true},
+		{"100.64.0.1", nil, true},
+		{"100.127.255.255", nil, true},
+		{"198.18.0.1", nil, true},
+		{"224.0.0.1", nil, true},
+		{"64:ff9b::a00:1", nil, true},
+		{"64:ff9b::808:808", nil, true},
+		{"::ffff:127.0.0.1", nil, true},
+		{"::ffff:10.0.0.1", nil, true},
+		{"::ffff:169.254.169.254", nil, true},
+		{"8.8.8.8", nil, false},
+		{"172.32.0.1", nil, false},
+		{"100.128.0.1", nil, false},
+		{"2001:4860:4860::8888", nil, false},
+		{"::ffff:8.8.8.8", nil, false},
+		{"10.20.1.1", []string{"10.20.0.0/16"}, false},
+		{"::ffff:10.20.1.1", []string{"10.20.0.0/16"}, false},
+		{"10.21.1.1", []string{"10.20.0.0/16"}, true},
+		{"127.0.0.1", []string{"127.0.0.0/8"}, false},
+		{"169.254.169.254", []string{"10.20.1.7/16"}, true},
+	} {
+		t.Run(fmt.Sprintf("%s allowing %v", tc.addr, tc.allowed), func(t *testing.T) {
+			c, err := New(Config{AllowedNetworks: tc.allowed})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.checkAddr(netip.MustParseAddr(tc.addr))
+			if blocked := errors.Is(err, ErrBlockedAddress); blocked != tc.blocked {
+				t.Errorf("checkAddr = %v, want blocked %v", err, tc.blocked)
+			}
+		})
+	}
+}
+
+func TestCheckDial(t *testing.T) {
+	c, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for address, blocked := range map[string]bool{
+		"93.184.216.34:443":     false,
+		"169.254.169.254:80":    true,
+		"[::ffff:10.0.0.1]:443": true,
+		"[64:ff9b::a00:1]:443":  true,
+		"not-an-address":        true,
+	} {
+		if err := c.checkDial("tcp", address, nil); errors.Is(err, ErrBlockedAddress) != blocked {
+			t.Errorf("checkDial(%s) = %v, want blocked %v", address, err, blocked)
+		}
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	c, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for host, blocked := range map[string]bool{
+		"127.0.0.1":       true,
+		"::1":             true,
+		"169.254.169.254": true,
+		"localhost":       true,
+		"8.8.8.8":         false,
+	} {
+		if err := c.checkHost(ctx, host); errors.Is(err, ErrBlockedAddress) != blocked {
+			t.Errorf("checkHost(%s) = %v, want blocked %v", host, err, blocked)
+		}
+	}
+}
+
+func TestNewBadAllowedNetwork(t *testing.T) {
+	if _, err := New(Config{AllowedNetworks: []string{"10.0.0.0"}}); err == nil {
+		t.Error("New accepted an allowed network without a prefix length")
+	}
+}
+
+func TestDoDoesNotFollowRedirects(t *testing.T) {
+	var internalHits atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		internalHits.Add(1)
+	}))
+	defer target.Close()
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusFound)
+	}))
+	defer receiver.Close()
+
+	c, err := New(Config{AllowedNetworks: []string{"127.0.0.0/8", "::1/128"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, receiver.URL, nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("status %d, want the receiver's own 302", resp.StatusCode)
+	}
+	if n := internalHits.Load(); n != 0 {
+		t.Errorf("redirect target was requested %d times, want 0", n)
+	}
+}
+
+func TestDoRetries(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{MaxRetries: 3, RetryBackoff: time.Millisecond, AllowedNetworks: []string{"127.0.0.0/8", "::1/128"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || hits.Load() != 3 {
+		t.Errorf("got %d after %d requests, want 200 after 3", resp.StatusCode, hits.Load())
+	}
+}
+
+func TestDoBlockedAddressIsNotRetried(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer srv.Close()
+
+	// A retry would wait out the hour-long backoff and hit the deadline
+	c, err := New(Config{MaxRetries: 3, RetryBackoff: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if _, err := c.Do(req); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("Do to %s = %v, want ErrBlockedAddress", srv.URL, err)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("blocked server was requested %d times, want 0", n)
+	}
+
+	// Blocked at dial time, after the host check, is not retried either
+	if retryable(nil, fmt.Errorf("dial tcp: %w", ErrBlockedAddress)) {
+		t.Error("retryable reports a blocked dial as retryable")
+	}
+	if !retryable(nil, errors.New("connection refused")) {
+		t.Error("retryable reports a refused connection as not retryable")
+	}
+}
@@ -0,0 +1,238 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Claims is the JWT payload issued by the user service.
+type Claims struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+	// ActAs is set on impersonation tokens: Email is the admin who requested
+	// the token and ActAs is the user being impersonated.
+	ActAs string `json:"act_as,omitempty"`
+	// SessionID is the refresh token family the token was issued from, if
+	// any; Logout revokes it.
+	SessionID string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// EffectiveEmail is the identity the caller is acting as.
+func (c *Claims) EffectiveEmail() string {
+	if c.ActAs != "" {
+		return c.ActAs
+	}
+	return c.Email
+}
+
+// AuthConfig configures the Auth interceptor.
+type AuthConfig struct {
+	// Key is the HMAC secret tokens without a kid header are signed with.
+	Key []byte
+	// Keys are the HMAC secrets for tokens with a kid header, by kid, so
+	// signing keys can be rotated without invalidating issued tokens.
+	Keys map[string][]byte
+	// PublicKeys verify RS256 and EdDSA tokens by kid: *rsa.PublicKey or
+	// ed25519.PublicKey.
+	PublicKeys map[string]crypto.PublicKey
+	// Issuer and Audience, if set, must match the token's iss claim and be
+	// one of its aud claim.
+	Issuer   string
+	Audience string
+	// PublicMethods need no token at all.
+	PublicMethods map[string]bool
+	// AdminMethods additionally require AdminRole.
+	AdminMethods map[string]bool
+	// AdminRole defaults to "admin".
+	AdminRole string
+	// Policy, if set, replaces PublicMethods and AdminMethods. It is called
+	// on every call so the policy can be swapped while serving.
+	Policy func() *Policy
+	// Revoked, if set, is asked about every token that is otherwise valid;
+	// it rejects tokens that were logged out. An error fails the call.
+	Revoked func(ctx context.Context, claims *Claims) (bool, error)
+}
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the validated token claims stored by Auth.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// Auth validates the bearer token on every non-public method and enforces the
+// admin role where required.
+func Auth(cfg AuthConfig) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := cfg.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuth is Auth for streaming RPCs. The token is checked once, when the
+// stream is opened.
+func StreamAuth(cfg AuthConfig) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := cfg.authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, wrapStream(ss, ctx))
+	}
+}
+
+// Verify checks token as calls are checked, signature, expiry, issuer,
+// audience and revocation, and returns its claims. Invalid and revoked
+// tokens are Unauthenticated; Unavailable means revocation couldn't be
+// checked.
+func (cfg AuthConfig) Verify(ctx context.Context, token string) (*Claims, error) {
+	claims := &Claims{}
+	var opts []jwt.ParserOption
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	tkn, err := jwt.ParseWithClaims(token, claims, cfg.key, opts...)
+	if err != nil || !tkn.Valid {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid token")
+	}
+	if cfg.Revoked != nil {
+		revoked, err := cfg.Revoked(ctx, claims)
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "cannot check token: %v", err)
+		}
+		if revoked {
+			return nil, status.Errorf(codes.Unauthenticated, "token has been revoked")
+		}
+	}
+	return claims, nil
+}
+
+// authenticate returns ctx with the caller's claims attached, or the status
+// error the call must fail with.
+func (cfg AuthConfig) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if cfg.Policy != nil {
+		p := cfg.Policy()
+		m, _ := p.Method(method)
+		return cfg.authorize(ctx, method, m, p)
+	}
+	return cfg.authorize(ctx, method, MethodPolicy{Roles: cfg.roles(method)}, nil)
+}
+
+// authorize is authenticate for a method with policy entry m. p resolves
+// role inheritance and permissions; nil means plain role names.
+func (cfg AuthConfig) authorize(ctx context.Context, method string, m MethodPolicy, p *Policy) (context.Context, error) {
+	adminRole := cfg.adminRole()
+	roles := m.Roles
+
+	// A. Allow Public Methods
+	if hasRole(roles, RolePublic) {
+		return ctx, nil
+	}
+
+	// B. Get Metadata
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "metadata missing")
+	}
+
+	// C. Get Token
+	values := md["authorization"]
+	if len(values) == 0 {
+		return nil, status.Errorf(codes.Unauthenticated, "token missing")
+	}
+
+	tokenString := values[0]
+	if len(tokenString) > 7 && strings.ToUpper(tokenString[0:7]) == "BEARER " {
+		tokenString = tokenString[7:]
+	}
+
+	// D. Validate Token & Parse Claims
+	claims, err := cfg.Verify(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	// E. If method requires a role, check it
+	if len(roles) > 0 && !hasRole(roles, RoleAuthenticated) && !p.Satisfies(claims.Role, roles) {
+		if len(roles) == 1 && strings.EqualFold(roles[0], adminRole) {
+			return nil, status.Errorf(codes.PermissionDenied, "Access Denied: You are not an admin")
+		}
+		return nil, status.Errorf(codes.PermissionDenied, "Access Denied: requires role %s", strings.Join(roles, " or "))
+	}
+	// allow_self methods check the permission themselves, with the target
+	if m.Permission != "" && !m.AllowSelf && !p.Grants(claims.Role, m.Permission) {
+		return nil, status.Errorf(codes.PermissionDenied, "Access Denied: requires permission %s", m.Permission)
+	}
+
+	// F. Record both identities for every call made with an impersonation token
+	if claims.ActAs != "" {
+		slog.InfoContext(ctx, "AUDIT impersonated call",
+			"method", method, "real", claims.Email, "effective", claims.ActAs)
+	}
+
+	// G. Success
+	return context.WithValue(ctx, claimsKey{}, claims), nil
+}
+
+// key picks the secret or public key to verify token with by its algorithm
+// and kid header. The jwt package refuses a key of the wrong type, so an
+// HMAC token can't be checked against a public key.
+func (cfg AuthConfig) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodEd25519:
+		key, ok := cfg.PublicKeys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		return key, nil
+	}
+	if kid == "" {
+		if len(cfg.Key) == 0 {
+			return nil, errors.New("token has no kid")
+		}
+		return cfg.Key, nil
+	}
+	key, ok := cfg.Keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	return key, nil
+}
+
+// roles looks method up in PublicMethods and AdminMethods, for configs
+// without a Policy.
+func (cfg AuthConfig) roles(method string) []string {
+	switch {
+	case cfg.PublicMethods[method]:
+		return []string{RolePublic}
+	case cfg.AdminMethods[method]:
+		return []string{cfg.adminRole()}
+	}
+	return nil
+}
+
+func (cfg AuthConfig) adminRole() string {
+	if cfg.AdminRole == "" {
+		return "admin"
+	}
+	return cfg.AdminRole
+}
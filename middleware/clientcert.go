@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"crypto/x509"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientIdentity describes the verified certificate a caller presented over
+// mutual TLS.
+type ClientIdentity struct {
+	CommonName string
+	DNSNames   []string
+	URIs       []string // e.g. SPIFFE ids
+	Serial     string
+}
+
+// String is the most specific name in the certificate: the first URI SAN,
+// then the first DNS SAN, then the common name.
+func (id ClientIdentity) String() string {
+	switch {
+	case len(id.URIs) > 0:
+		return id.URIs[0]
+	case len(id.DNSNames) > 0:
+		return id.DNSNames[0]
+	}
+	return id.CommonName
+}
+
+// ClientIdentityFromContext returns the identity of the caller's client
+// certificate. It is only set when the server verified the certificate
+// against its client CA, i.e. when mutual TLS is enabled.
+func ClientIdentityFromContext(ctx context.Context) (ClientIdentity, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ClientIdentity{}, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return ClientIdentity{}, false
+	}
+	return identityOf(info.State.VerifiedChains[0][0]), true
+}
+
+func identityOf(cert *x509.Certificate) ClientIdentity {
+	id := ClientIdentity{
+		CommonName: cert.Subject.CommonName,
+		DNSNames:   cert.DNSNames,
+		Serial:     cert.SerialNumber.String(),
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+	return id
+}
@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// TrustedProxies are the load balancers and proxies whose forwarding headers
+// are believed. The client IP is the rightmost address in the chain of hops
+// (X-Forwarded-For or Forwarded entries, then the connection's own peer)
+// that is not a trusted proxy, so entries a client adds itself are ignored.
+type TrustedProxies struct {
+	nets []*net.IPNet
+}
+
+// DefaultTrustedProxies trusts loopback only, which covers the built-in REST
+// gateway dialing the gRPC port on localhost.
+var DefaultTrustedProxies = MustParseTrustedProxies([]string{"127.0.0.0/8", "::1/128"})
+
+// ParseTrustedProxies accepts CIDRs ("10.0.0.0/8") and single addresses.
+func ParseTrustedProxies(entries []string) (*TrustedProxies, error) {
+	t := &TrustedProxies{}
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			t.nets = append(t.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		t.nets = append(t.nets, n)
+	}
+	return t, nil
+}
+
+// MustParseTrustedProxies is ParseTrustedProxies for constant lists.
+func MustParseTrustedProxies(entries []string) *TrustedProxies {
+	t, err := ParseTrustedProxies(entries)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func (t *TrustedProxies) trusts(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range t.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve picks the client from hops, ordered from the original client to
+// the nearest proxy. If every hop is trusted the leftmost one is returned.
+func (t *TrustedProxies) Resolve(hops []string) string {
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !t.trusts(hops[i]) {
+			return hops[i]
+		}
+	}
+	if len(hops) == 0 {
+		return ""
+	}
+	return hops[0]
+}
+
+// ForwardedFor returns the hops listed in the request's Forwarded header
+// (RFC 7239 for= parameters) or, without one, in X-Forwarded-For. Ports and
+// IPv6 brackets are stripped.
+func ForwardedFor(h http.Header) []string {
+	var hops []string
+	for _, value := range h.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					hops = append(hops, hostOnly(strings.Trim(v, `"`)))
+				}
+			}
+		}
+	}
+	if len(hops) > 0 {
+		return hops
+	}
+	return splitForwardedFor(h.Values("X-Forwarded-For"))
+}
+
+func splitForwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hostOnly(hop))
+			}
+		}
+	}
+	return hops
+}
+
+// hostOnly strips a port and IPv6 brackets: "[::1]:80" -> "::1".
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// Handler resolves the client of each HTTP request and forwards it as the
+// only X-Forwarded-For entry. grpc-gateway appends the request's RemoteAddr,
+// so the gRPC side sees "client, remote" and resolves the same client.
+func (t *TrustedProxies) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hops := append(ForwardedFor(r.Header), hostOnly(r.RemoteAddr))
+		r = r.Clone(r.Context())
+		r.Header.Del("Forwarded")
+		r.Header.Set("X-Forwarded-For", t.Resolve(hops))
+		next.ServeHTTP(w, r)
+	})
+}
+
+type clientIPKey struct{}
+
+// fromContext resolves the client of a gRPC call from its x-forwarded-for
+// metadata and its peer. A peer without an IP is the in-process gateway
+// listener and is trusted like a proxy.
+func (t *TrustedProxies) fromContext(ctx context.Context) string {
+	var hops []string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		hops = splitForwardedFor(md["x-forwarded-for"])
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host := hostOnly(p.Addr.String()); net.ParseIP(host) != nil {
+			hops = append(hops, host)
+		}
+	}
+	return t.Resolve(hops)
+}
+
+// ClientIP is the caller's address as resolved by the ClientIPs interceptor,
+// or with DefaultTrustedProxies when it isn't installed.
+func ClientIP(ctx context.Context) string {
+	if ip, ok := ctx.Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return DefaultTrustedProxies.fromContext(ctx)
+}
+
+// ClientIPs resolves the client address once per call, for ClientIP and
+// log lines.
+func ClientIPs(t *TrustedProxies) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(context.WithValue(ctx, clientIPKey{}, t.fromContext(ctx)), req)
+	}
+}
+
+// StreamClientIPs is ClientIPs for streaming RPCs.
+func StreamClientIPs(t *TrustedProxies) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := context.WithValue(ss.Context(), clientIPKey{}, t.fromContext(ss.Context()))
+		return handler(srv, wrapStream(ss, ctx))
+	}
+}
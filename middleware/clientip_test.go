@@ -0,0 +1,126 @@
+package middleware_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"grpc-crud-proj/middleware"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	if _, err := middleware.ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.7", "2001:db8::1", "fd00::/8"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"10.0.0.0/33", "proxy.internal", "10.0.0", ""} {
+		if _, err := middleware.ParseTrustedProxies([]string{bad}); err == nil {
+			t.Errorf("ParseTrustedProxies accepted %q", bad)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	proxies := middleware.MustParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.7", "2001:db8::1"})
+	for _, tc := range []struct {
+		name string
+		hops []string
+		want string
+	}{
+		{"direct", []string{"203.0.113.5"}, "203.0.113.5"},
+		{"through a proxy", []string{"203.0.113.5", "10.1.2.3"}, "203.0.113.5"},
+		{"through two proxies", []string{"203.0.113.5", "192.0.2.7", "10.1.2.3"}, "203.0.113.5"},
+		{"spoofed entry ignored", []string{"1.1.1.1", "203.0.113.5", "10.1.2.3"}, "203.0.113.5"},
+		{"untrusted peer", []string{"203.0.113.5", "198.51.100.9"}, "198.51.100.9"},
+		{"single address is exact", []string{"203.0.113.5", "192.0.2.8"}, "192.0.2.8"},
+		{"IPv6 proxy", []string{"2001:db8::5", "2001:db8::1"}, "2001:db8::5"},
+		{"garbage is not trusted", []string{"203.0.113.5", "unknown", "10.1.2.3"}, "unknown"},
+		{"all trusted", []string{"10.0.0.1", "10.0.0.2"}, "10.0.0.1"},
+		{"no hops", nil, ""},
+	} {
+		if got := proxies.Resolve(tc.hops); got != tc.want {
+			t.Errorf("%s: Resolve(%v) = %q, want %q", tc.name, tc.hops, got, tc.want)
+		}
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		want   []string
+	}{
+		{"X-Forwarded-For", http.Header{"X-Forwarded-For": {"203.0.113.5, 10.1.2.3"}}, []string{"203.0.113.5", "10.1.2.3"}},
+		{"repeated X-Forwarded-For", http.Header{"X-Forwarded-For": {"203.0.113.5", "10.1.2.3,"}}, []string{"203.0.113.5", "10.1.2.3"}},
+		{"ports stripped", http.Header{"X-Forwarded-For": {"203.0.113.5:4711, [2001:db8::5]:443"}}, []string{"203.0.113.5", "2001:db8::5"}},
+		{"Forwarded", http.Header{"Forwarded": {`for=203.0.113.5;proto=https, For="[2001:db8::5]:4711";by=10.1.2.3`}}, []string{"203.0.113.5", "2001:db8::5"}},
+		{"Forwarded wins", http.Header{"Forwarded": {"for=203.0.113.5"}, "X-Forwarded-For": {"1.1.1.1"}}, []string{"203.0.113.5"}},
+		{"Forwarded without for", http.Header{"Forwarded": {"proto=https"}, "X-Forwarded-For": {"1.1.1.1"}}, []string{"1.1.1.1"}},
+		{"none", http.Header{}, nil},
+	} {
+		if got := middleware.ForwardedFor(tc.header); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: ForwardedFor = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestHandlerForwardsResolvedClient(t *testing.T) {
+	proxies := middleware.MustParseTrustedProxies([]string{"10.0.0.0/8"})
+	var got http.Header
+	h := proxies.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r.Header }))
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/users", nil)
+	r.RemoteAddr = "10.1.2.3:5555"
+	r.Header.Set("Forwarded", "for=1.1.1.1, for=203.0.113.5")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if xff := got.Values("X-Forwarded-For"); !slices.Equal(xff, []string{"203.0.113.5"}) {
+		t.Errorf("forwarded X-Forwarded-For = %v, want [203.0.113.5]", xff)
+	}
+	if f := got.Get("Forwarded"); f != "" {
+		t.Errorf("Forwarded = %q, want it dropped", f)
+	}
+	if r.Header.Get("X-Forwarded-For") != "" {
+		t.Error("Handler changed the caller's request headers")
+	}
+}
+
+func TestClientIPs(t *testing.T) {
+	proxies := middleware.MustParseTrustedProxies([]string{"127.0.0.0/8", "10.0.0.0/8"})
+	interceptor := middleware.ClientIPs(proxies)
+	call := func(ctx context.Context) string {
+		var ip string
+		interceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			ip = middleware.ClientIP(ctx)
+			return nil, nil
+		})
+		return ip
+	}
+	withPeer := func(ctx context.Context, addr net.Addr) context.Context {
+		return peer.NewContext(ctx, &peer.Peer{Addr: addr})
+	}
+	forwarded := func(xff ...string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.MD{"x-forwarded-for": xff})
+	}
+
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"direct caller", withPeer(context.Background(), &net.TCPAddr{IP: net.ParseIP("203.0.113.5"), Port: 4711}), "203.0.113.5"},
+		{"through the gateway", withPeer(forwarded("203.0.113.5, 127.0.0.1"), &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 4711}), "203.0.113.5"},
+		{"spoofed by an untrusted peer", withPeer(forwarded("1.1.1.1"), &net.TCPAddr{IP: net.ParseIP("198.51.100.9"), Port: 4711}), "198.51.100.9"},
+		{"in-process listener", withPeer(forwarded("203.0.113.5"), &net.UnixAddr{Name: "bufconn", Net: "unix"}), "203.0.113.5"},
+		{"no peer", context.Background(), ""},
+	} {
+		if got := call(tc.ctx); got != tc.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// DeadlineConfig configures the Deadlines interceptor.
+type DeadlineConfig struct {
+	// Default applies to methods not listed in Methods; 0 means none.
+	Default time.Duration
+	// Methods overrides Default for individual full method names.
+	Methods map[string]time.Duration
+}
+
+// Deadlines gives calls that arrive without a deadline the configured one, so
+// a client that never gives up can't keep a query running forever. Deadlines
+// set by the client are left alone, even when they are longer.
+func Deadlines(cfg DeadlineConfig) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if _, ok := ctx.Deadline(); ok {
+			return handler(ctx, req)
+		}
+		timeout := cfg.Default
+		if d, ok := cfg.Methods[info.FullMethod]; ok {
+			timeout = d
+		}
+		if timeout <= 0 {
+			return handler(ctx, req)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GatewayHeader is the metadata key the REST gateway sets on every call it
+// forwards, so methods can be hidden from it.
+const GatewayHeader = "x-via-gateway"
+
+// DeprecationHeader is the response header sent by deprecated methods.
+const DeprecationHeader = "deprecation"
+
+// EnforceConfig configures the Enforce interceptor. The per-method settings
+// come from Policy; the rest are the defaults for methods whose entry
+// leaves them unset.
+type EnforceConfig struct {
+	// Policy is called on every call so the policy can be swapped while
+	// serving.
+	Policy func() *Policy
+	// Auth checks tokens and roles; nil lets every call through. Its own
+	// Policy, PublicMethods and AdminMethods are ignored.
+	Auth *AuthConfig
+	// RateLimit throttles clients and holds the role quotas' buckets; nil
+	// means no limits or quotas. Its Methods are ignored.
+	RateLimit *RateLimitConfig
+	// DefaultTimeout is the deadline for unary calls that arrive without
+	// one; 0 means none.
+	DefaultTimeout time.Duration
+}
+
+type enforcer struct {
+	cfg    EnforceConfig
+	limits *rateLimiter
+	warned sync.Map // deprecated methods already logged
+}
+
+// Enforce applies the API policy to every call in one place: it refuses
+// methods hidden from the gateway, flags deprecated ones, gives calls
+// without a deadline the method's timeout, then checks the token and roles
+// and finally the rate limit, in the same order as Deadlines, Auth and
+// RateLimit, which it replaces.
+func Enforce(cfg EnforceConfig) grpc.UnaryServerInterceptor {
+	e := newEnforcer(cfg)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		p := e.cfg.Policy()
+		m, _ := p.Method(info.FullMethod)
+		if _, ok := ctx.Deadline(); !ok {
+			timeout := e.cfg.DefaultTimeout
+			if m.Timeout > 0 {
+				timeout = m.Timeout
+			}
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+		}
+		ctx, err := e.admit(ctx, info.FullMethod, p, m, func(md metadata.MD) { _ = grpc.SetHeader(ctx, md) })
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamEnforce is Enforce for streaming RPCs. Streams get no deadline;
+// everything else is checked once, when the stream is opened.
+func StreamEnforce(cfg EnforceConfig) grpc.StreamServerInterceptor {
+	e := newEnforcer(cfg)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		p := e.cfg.Policy()
+		m, _ := p.Method(info.FullMethod)
+		ctx, err := e.admit(ss.Context(), info.FullMethod, p, m, func(md metadata.MD) { _ = ss.SetHeader(md) })
+		if err != nil {
+			return err
+		}
+		return handler(srv, wrapStream(ss, ctx))
+	}
+}
+
+func newEnforcer(cfg EnforceConfig) *enforcer {
+	e := &enforcer{cfg: cfg}
+	if cfg.RateLimit != nil {
+		e.limits = newRateLimiter(*cfg.RateLimit)
+	}
+	return e
+}
+
+// admit returns ctx with the caller's claims attached, or the status error
+// the call must fail with. setHeader sends response headers.
+func (e *enforcer) admit(ctx context.Context, method string, p *Policy, m MethodPolicy, setHeader func(metadata.MD)) (context.Context, error) {
+	if m.HideFromGateway && viaGateway(ctx) {
+		return nil, status.Errorf(codes.Unimplemented, "%s is not available through the REST gateway", method)
+	}
+	if m.Deprecated {
+		setHeader(metadata.Pairs(DeprecationHeader, "true"))
+		if _, seen := e.warned.LoadOrStore(method, true); !seen {
+			slog.WarnContext(ctx, "deprecated method called", "method", method)
+		}
+	}
+	if e.cfg.Auth != nil {
+		var err error
+		if ctx, err = e.cfg.Auth.authorize(ctx, method, m, p); err != nil {
+			return nil, err
+		}
+	}
+	if e.limits != nil {
+		limit, scope := e.limits.cfg.Default, "*"
+		if m.RateLimit != nil {
+			limit, scope = *m.RateLimit, method
+		}
+		if err := e.limits.take(ctx, method, scope, limit); err != nil {
+			return nil, err
+		}
+		if err := e.quota(ctx, method, p, m); err != nil {
+			return nil, err
+		}
+	}
+	return ctx, nil
+}
+
+// quota spends a token from the caller's quota for the kind of method this
+// is, if their role has one. Callers without a token have no role and so
+// no quota; the per-method limits cover them.
+func (e *enforcer) quota(ctx context.Context, method string, p *Policy, m MethodPolicy) error {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	kind := QuotaWrites
+	if m.ReadOnly {
+		kind = QuotaReads
+	}
+	q, ok := p.Quota(claims.Role, kind)
+	if !ok {
+		return nil
+	}
+	limit := q.limit()
+	if !e.limits.allow(ctx, method, "quota:"+kind, limit) {
+		return exhausted(limit, "%s quota of %d per %s exceeded, retry later", strings.TrimSuffix(kind, "s"), q.Requests, q.Per)
+	}
+	return nil
+}
+
+func viaGateway(ctx context.Context) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return len(md.Get(GatewayHeader)) > 0
+}
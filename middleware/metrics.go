@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// RPCMetrics counts and times unary RPCs per method.
+type RPCMetrics struct {
+	started  *prometheus.CounterVec
+	handled  *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewRPCMetrics registers the RPC collectors on reg.
+func NewRPCMetrics(reg prometheus.Registerer) *RPCMetrics {
+	m := &RPCMetrics{
+		started: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_started_total",
+			Help: "RPCs started on the server.",
+		}, []string{"method"}),
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_handled_total",
+			Help: "RPCs completed on the server, by status code.",
+		}, []string{"method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "Time taken to handle RPCs.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+	}
+	reg.MustRegister(m.started, m.handled, m.duration)
+	return m
+}
+
+// Interceptor records every call made through it.
+func (m *RPCMetrics) Interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	m.started.WithLabelValues(info.FullMethod).Inc()
+	start := time.Now()
+
+	resp, err := handler(ctx, req)
+
+	m.observe(info.FullMethod, start, err)
+	return resp, err
+}
+
+// StreamInterceptor records streams; the latency is the stream's lifetime.
+func (m *RPCMetrics) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	m.started.WithLabelValues(info.FullMethod).Inc()
+	start := time.Now()
+
+	err := handler(srv, ss)
+
+	m.observe(info.FullMethod, start, err)
+	return err
+}
+
+func (m *RPCMetrics) observe(method string, start time.Time, err error) {
+	m.duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	m.handled.WithLabelValues(method, status.Code(err).String()).Inc()
+}
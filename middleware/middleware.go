@@ -0,0 +1,164 @@
+// Package middleware bundles the cross-cutting gRPC interceptors used by the
+// user service so other services can get identical behaviour by importing it.
+package middleware
+
+import (
+	"log/slog"
+
+	"google.golang.org/grpc"
+)
+
+type options struct {
+	logger   *slog.Logger
+	metrics  *RPCMetrics
+	recovery bool
+	reqIDs   bool
+	region   string
+	proxies  *TrustedProxies
+	tracing  *TraceConfig
+	auth     *AuthConfig
+	limits   *RateLimitConfig
+	timeouts *DeadlineConfig
+	policy   func() *Policy
+	extra    []grpc.UnaryServerInterceptor
+	streams  []grpc.StreamServerInterceptor
+}
+
+// Option configures the interceptor chain built by UnaryInterceptors.
+type Option func(*options)
+
+// WithAuth enables JWT authentication and role checks.
+func WithAuth(cfg AuthConfig) Option {
+	return func(o *options) { o.auth = &cfg }
+}
+
+// WithRateLimit throttles each client according to cfg.
+func WithRateLimit(cfg RateLimitConfig) Option {
+	return func(o *options) { o.limits = &cfg }
+}
+
+// WithDeadlines applies default per-method deadlines to unary calls.
+func WithDeadlines(cfg DeadlineConfig) Option {
+	return func(o *options) { o.timeouts = &cfg }
+}
+
+// WithPolicy enforces policy with a single Enforce interceptor in place of
+// the separate deadline, auth and rate limit ones; the configs passed to
+// WithDeadlines, WithAuth and WithRateLimit supply its defaults and their
+// per-method tables are ignored.
+func WithPolicy(policy func() *Policy) Option {
+	return func(o *options) { o.policy = policy }
+}
+
+// enforcement is the Enforce config built from o.
+func (o *options) enforcement() EnforceConfig {
+	cfg := EnforceConfig{Policy: o.policy, Auth: o.auth, RateLimit: o.limits}
+	if o.timeouts != nil {
+		cfg.DefaultTimeout = o.timeouts.Default
+	}
+	return cfg
+}
+
+// WithLogging logs every RPC to logger.
+func WithLogging(logger *slog.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithMetrics records per-method counts and latencies in m.
+func WithMetrics(m *RPCMetrics) Option {
+	return func(o *options) { o.metrics = m }
+}
+
+// WithRequestIDs assigns every call a request id (see RequestID).
+func WithRequestIDs() Option {
+	return func(o *options) { o.reqIDs = true }
+}
+
+// WithTrustedProxies resolves ClientIP behind the proxies in t (see
+// TrustedProxies). Without it, only loopback proxies are trusted.
+func WithTrustedProxies(t *TrustedProxies) Option {
+	return func(o *options) { o.proxies = t }
+}
+
+// WithTracing records a span per call, sampled as cfg says (see Tracing).
+func WithTracing(cfg TraceConfig) Option {
+	return func(o *options) { o.tracing = &cfg }
+}
+
+// WithRegion tags every response with the instance's region (see Region).
+func WithRegion(region string) Option {
+	return func(o *options) { o.region = region }
+}
+
+// WithoutRecovery disables the panic recovery interceptor (on by default).
+func WithoutRecovery() Option {
+	return func(o *options) { o.recovery = false }
+}
+
+// WithUnaryInterceptors appends service-specific interceptors. They run after
+// the built-in ones, so they can rely on ClaimsFromContext.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(o *options) { o.extra = append(o.extra, interceptors...) }
+}
+
+// WithStreamInterceptors is WithUnaryInterceptors for StreamInterceptors.
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(o *options) { o.streams = append(o.streams, interceptors...) }
+}
+
+// UnaryInterceptors returns the interceptor chain in the order it should run:
+// request ids first so every later interceptor can see them, the client
+// IP and the region header with them, tracing so the trace id is on every
+// log line, logging and metrics next so they see the final status of
+// every call, recovery next so it also catches panics in later interceptors, then
+// deadlines (covering the DB work of later interceptors too), then auth,
+// then rate limiting (so it can key on the caller's identity), then any extra
+// interceptors. With WithPolicy, Enforce takes the place of the deadline,
+// auth and rate limit interceptors.
+func UnaryInterceptors(opts ...Option) []grpc.UnaryServerInterceptor {
+	o := options{recovery: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var chain []grpc.UnaryServerInterceptor
+	if o.reqIDs {
+		chain = append(chain, RequestID)
+	}
+	if o.proxies != nil {
+		chain = append(chain, ClientIPs(o.proxies))
+	}
+	if o.region != "" {
+		chain = append(chain, Region(o.region))
+	}
+	if o.tracing != nil {
+		chain = append(chain, Tracing(*o.tracing))
+	}
+	if o.logger != nil {
+		chain = append(chain, Logging(o.logger))
+	}
+	if o.metrics != nil {
+		chain = append(chain, o.metrics.Interceptor)
+	}
+	if o.recovery {
+		chain = append(chain, Recovery)
+	}
+	if o.policy != nil {
+		return append(append(chain, Enforce(o.enforcement())), o.extra...)
+	}
+	if o.timeouts != nil {
+		chain = append(chain, Deadlines(*o.timeouts))
+	}
+	if o.auth != nil {
+		chain = append(chain, Auth(*o.auth))
+	}
+	if o.limits != nil {
+		chain = append(chain, RateLimit(*o.limits))
+	}
+	return append(chain, o.extra...)
+}
+
+// ServerOption is a convenience wrapper for grpc.NewServer.
+func ServerOption(opts ...Option) grpc.ServerOption {
+	return grpc.ChainUnaryInterceptor(UnaryInterceptors(opts...)...)
+}
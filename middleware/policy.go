@@ -0,0 +1,530 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+)
+
+// Roles with a special meaning in a Policy.
+const (
+	// RolePublic methods need no token at all.
+	RolePublic = "public"
+	// RoleAuthenticated methods accept any valid token, whatever its role.
+	RoleAuthenticated = "authenticated"
+)
+
+// Policy is the per-method API policy: who may call each method, how often,
+// for how long, and whether it is deprecated, read-only or hidden from the
+// REST gateway. It also holds the role table mapping each role to the
+// permissions it grants, directly or through the roles it inherits. Keys
+// are full method names and may end in "*" to cover every method with that
+// prefix ("/user.UserService/*", or "*" for everything); an exact entry
+// beats a wildcard and a longer wildcard beats a shorter one. The most
+// specific entry applies whole: settings are not merged from the wildcards
+// it overrides. Methods matching nothing accept any valid token and get the
+// defaults.
+type Policy struct {
+	exact    map[string]MethodPolicy
+	prefixes []policyPrefix // longest first
+	roles    map[string]resolvedRole
+}
+
+// resolvedRole is a role with its inheritance flattened.
+type resolvedRole struct {
+	is          map[string]bool // the role itself and every role it inherits
+	permissions []string
+	quotas      map[string]Quota
+}
+
+type policyPrefix struct {
+	prefix string
+	method MethodPolicy
+}
+
+// MethodPolicy is a Policy entry. Zero fields mean the default.
+type MethodPolicy struct {
+	// Roles may call the method, as may roles inheriting them; empty accepts
+	// any valid token.
+	Roles []string
+	// Permission, if set, must be granted to the caller's role as well.
+	Permission string
+	// AllowSelf lets callers without Permission through to act on their own
+	// account; the handler must check that the target is the caller.
+	AllowSelf bool
+	// RateLimit, if set, replaces the default limit with a bucket of its own.
+	RateLimit *Limit
+	// Timeout, if set, replaces the default deadline for calls that arrive
+	// without one. Streams never get one.
+	Timeout time.Duration
+	// ReadOnly methods don't write and keep working in read-only mode.
+	ReadOnly bool
+	// Deprecated methods still work but answer with DeprecationHeader.
+	Deprecated bool
+	// HideFromGateway refuses calls that came through the REST gateway.
+	HideFromGateway bool
+}
+
+// RolePolicy is an entry of the role table.
+type RolePolicy struct {
+	// Inherits are roles whose permissions this role has too. A method open
+	// to an inherited role is open to this one.
+	Inherits []string
+	// Permissions such as "user.read". "*" grants every permission and
+	// "user.*" every one starting with "user.".
+	Permissions []string
+	// Quotas cap each caller with the role across all methods of a kind,
+	// QuotaWrites or QuotaReads, on top of the per-method rate limits.
+	// Inherited quotas apply unless the role sets its own.
+	Quotas map[string]Quota
+}
+
+// Kinds of Quota: methods not marked ReadOnly, and those that are.
+const (
+	QuotaWrites = "writes"
+	QuotaReads  = "reads"
+)
+
+// Quota allows Requests calls per period Per, in bursts of up to Requests.
+type Quota struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (q Quota) limit() Limit {
+	return Limit{Rate: rate.Limit(float64(q.Requests) / q.Per.Seconds()), Burst: q.Requests}
+}
+
+// DefaultRoles is the role table of a policy that doesn't define one: admin
+// holds every permission.
+var DefaultRoles = map[string]RolePolicy{"admin": {Permissions: []string{"*"}}}
+
+// policyFile is the file format read by ParsePolicy, YAML or JSON:
+//
+//	roles:
+//	  user:
+//	    quotas:
+//	      writes: {requests: 100, per: 1m}
+//	  support: [user.read]
+//	  admin:
+//	    inherits: [support]
+//	    permissions: ["*"]
+//	methods:
+//	  /user.UserService/Login:
+//	    roles: [public]
+//	    rate_limit: {rps: 1, burst: 5}
+//	    timeout: 15s
+//	    read_only: true
+//	  /user.UserService/*: [admin]
+//	  /user.UserService/GetUser:
+//	    permission: user.read
+//	  /user.UserService/OldGetUser:
+//	    roles: [admin, support]
+//	    deprecated: true
+//	    gateway: false
+//
+// A bare list of roles is short for a method entry with only roles, and a
+// bare list of permissions for a role with only permissions. Without a roles
+// section DefaultRoles applies.
+type policyFile struct {
+	Roles   map[string]roleEntry   `yaml:"roles"`
+	Methods map[string]policyEntry `yaml:"methods"`
+}
+
+type roleEntry struct {
+	Inherits    []string `yaml:"inherits"`
+	Permissions []string `yaml:"permissions"`
+	Quotas      map[string]struct {
+		Requests int           `yaml:"requests"`
+		Per      time.Duration `yaml:"per"`
+	} `yaml:"quotas"`
+}
+
+func (e *roleEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode(&e.Permissions)
+	}
+	type plain roleEntry
+	return decodeStrict(node, (*plain)(e))
+}
+
+type policyEntry struct {
+	Roles      []string `yaml:"roles"`
+	Permission string   `yaml:"permission"`
+	AllowSelf  bool     `yaml:"allow_self"`
+	RateLimit  *struct {
+		RPS   float64 `yaml:"rps"`
+		Burst int     `yaml:"burst"`
+	} `yaml:"rate_limit"`
+	Timeout    time.Duration `yaml:"timeout"`
+	ReadOnly   bool          `yaml:"read_only"`
+	Deprecated bool          `yaml:"deprecated"`
+	Gateway    *bool         `yaml:"gateway"`
+}
+
+func (e *policyEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode(&e.Roles)
+	}
+	type plain policyEntry
+	return decodeStrict(node, (*plain)(e))
+}
+
+// decodeStrict decodes node into v rejecting unknown keys, which
+// node.Decode alone doesn't: the KnownFields of the outer decoder stops at
+// a custom UnmarshalYAML.
+func decodeStrict(node *yaml.Node, v interface{}) error {
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("entry at line %d: %w", node.Line, err)
+	}
+	return nil
+}
+
+func (e policyEntry) method() MethodPolicy {
+	m := MethodPolicy{
+		Roles:           e.Roles,
+		Permission:      e.Permission,
+		AllowSelf:       e.AllowSelf,
+		Timeout:         e.Timeout,
+		ReadOnly:        e.ReadOnly,
+		Deprecated:      e.Deprecated,
+		HideFromGateway: e.Gateway != nil && !*e.Gateway,
+	}
+	if e.RateLimit != nil {
+		m.RateLimit = &Limit{Rate: rate.Limit(e.RateLimit.RPS), Burst: e.RateLimit.Burst}
+	}
+	return m
+}
+
+// NewPolicy builds a policy from a method→entry table and a role table; nil
+// roles means DefaultRoles.
+func NewPolicy(methods map[string]MethodPolicy, roles map[string]RolePolicy) (*Policy, error) {
+	if roles == nil {
+		roles = DefaultRoles
+	}
+	resolved, err := resolveRoles(roles)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{exact: map[string]MethodPolicy{}, roles: resolved}
+	for method, m := range methods {
+		for _, role := range m.Roles {
+			if strings.TrimSpace(role) == "" {
+				return nil, fmt.Errorf("%s: empty role", method)
+			}
+			if strings.EqualFold(role, RolePublic) && len(m.Roles) > 1 {
+				return nil, fmt.Errorf("%s: %s can't be combined with other roles", method, RolePublic)
+			}
+		}
+		if l := m.RateLimit; l != nil && (l.Rate < 0 || l.Burst < 1) {
+			return nil, fmt.Errorf("%s: rate_limit needs rps >= 0 and burst >= 1", method)
+		}
+		if m.Timeout < 0 {
+			return nil, fmt.Errorf("%s: negative timeout", method)
+		}
+		if m.Permission != "" && strings.TrimSpace(m.Permission) != m.Permission {
+			return nil, fmt.Errorf("%s: permission %q has surrounding spaces", method, m.Permission)
+		}
+		if m.Permission != "" && hasRole(m.Roles, RolePublic) {
+			return nil, fmt.Errorf("%s: %s methods can't require a permission", method, RolePublic)
+		}
+		if m.AllowSelf && m.Permission == "" {
+			return nil, fmt.Errorf("%s: allow_self needs a permission", method)
+		}
+		if prefix, ok := strings.CutSuffix(method, "*"); ok {
+			if strings.Contains(prefix, "*") {
+				return nil, fmt.Errorf("%s: only a trailing * is supported", method)
+			}
+			p.prefixes = append(p.prefixes, policyPrefix{prefix, m})
+			continue
+		}
+		if !strings.HasPrefix(method, "/") || strings.Count(method, "/") != 2 {
+			return nil, fmt.Errorf("%s: not a full method name such as /user.UserService/Login", method)
+		}
+		p.exact[method] = m
+	}
+	sort.Slice(p.prefixes, func(i, j int) bool { return len(p.prefixes[i].prefix) > len(p.prefixes[j].prefix) })
+	return p, nil
+}
+
+// ParsePolicy reads a policy file (see policyFile). Unknown keys are an error.
+func ParsePolicy(data []byte) (*Policy, error) {
+	var f policyFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		return nil, err
+	}
+	methods := make(map[string]MethodPolicy, len(f.Methods))
+	for method, e := range f.Methods {
+		methods[method] = e.method()
+	}
+	var roles map[string]RolePolicy
+	if f.Roles != nil {
+		roles = make(map[string]RolePolicy, len(f.Roles))
+		for role, e := range f.Roles {
+			r := RolePolicy{Inherits: e.Inherits, Permissions: e.Permissions}
+			for kind, q := range e.Quotas {
+				if r.Quotas == nil {
+					r.Quotas = map[string]Quota{}
+				}
+				r.Quotas[kind] = Quota{Requests: q.Requests, Per: q.Per}
+			}
+			roles[role] = r
+		}
+	}
+	return NewPolicy(methods, roles)
+}
+
+// resolveRoles flattens the inheritance in roles, rejecting unknown roles
+// and cycles. Role names are case-insensitive.
+func resolveRoles(roles map[string]RolePolicy) (map[string]resolvedRole, error) {
+	byName := make(map[string]RolePolicy, len(roles))
+	for name, r := range roles {
+		key := strings.ToLower(name)
+		if strings.TrimSpace(name) == "" {
+			return nil, errors.New("roles: empty role name")
+		}
+		if key == RolePublic || key == RoleAuthenticated {
+			return nil, fmt.Errorf("roles: %s is built in and can't be defined", name)
+		}
+		if _, dup := byName[key]; dup {
+			return nil, fmt.Errorf("roles: %s is defined twice", name)
+		}
+		for _, perm := range r.Permissions {
+			if perm == "" || strings.TrimSpace(perm) != perm {
+				return nil, fmt.Errorf("roles: %s: invalid permission %q", name, perm)
+			}
+		}
+		for kind, q := range r.Quotas {
+			if kind != QuotaWrites && kind != QuotaReads {
+				return nil, fmt.Errorf("roles: %s: quota for %q, want %s or %s", name, kind, QuotaWrites, QuotaReads)
+			}
+			if q.Requests < 1 || q.Per <= 0 {
+				return nil, fmt.Errorf("roles: %s: %s quota needs requests >= 1 and a positive per", name, kind)
+			}
+		}
+		byName[key] = r
+	}
+
+	resolved := make(map[string]resolvedRole, len(byName))
+	var resolve func(name string, path []string) (resolvedRole, error)
+	resolve = func(name string, path []string) (resolvedRole, error) {
+		if r, ok := resolved[name]; ok {
+			return r, nil
+		}
+		for _, seen := range path {
+			if seen == name {
+				return resolvedRole{}, fmt.Errorf("roles: %s inherits itself (%s)", name, strings.Join(append(path, name), " -> "))
+			}
+		}
+		def := byName[name]
+		r := resolvedRole{is: map[string]bool{name: true}, permissions: def.Permissions, quotas: map[string]Quota{}}
+		for kind, q := range def.Quotas {
+			r.quotas[kind] = q
+		}
+		for _, parent := range def.Inherits {
+			key := strings.ToLower(parent)
+			if _, ok := byName[key]; !ok {
+				return resolvedRole{}, fmt.Errorf("roles: %s inherits unknown role %s", name, parent)
+			}
+			pr, err := resolve(key, append(path, name))
+			if err != nil {
+				return resolvedRole{}, err
+			}
+			for is := range pr.is {
+				r.is[is] = true
+			}
+			r.permissions = append(r.permissions[:len(r.permissions):len(r.permissions)], pr.permissions...)
+			for kind, q := range pr.quotas {
+				if _, ok := r.quotas[kind]; !ok {
+					r.quotas[kind] = q
+				}
+			}
+		}
+		resolved[name] = r
+		return r, nil
+	}
+	for name := range byName {
+		if _, err := resolve(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
+// Method returns the entry that applies to method, and false when none
+// matches it.
+func (p *Policy) Method(method string) (MethodPolicy, bool) {
+	if m, ok := p.exact[method]; ok {
+		return m, true
+	}
+	for _, r := range p.prefixes {
+		if strings.HasPrefix(method, r.prefix) {
+			return r.method, true
+		}
+	}
+	return MethodPolicy{}, false
+}
+
+// Roles returns the roles allowed to call method; none means any valid
+// token.
+func (p *Policy) Roles(method string) []string {
+	m, _ := p.Method(method)
+	return m.Roles
+}
+
+// Grants reports whether role holds permission, directly or through a role
+// it inherits. A nil policy grants nothing.
+func (p *Policy) Grants(role, permission string) bool {
+	if p == nil {
+		return false
+	}
+	for _, perm := range p.roles[strings.ToLower(role)].permissions {
+		if perm == "*" || perm == permission {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(perm, "*"); ok && strings.HasPrefix(permission, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether the policy defines role. A nil policy defines
+// none.
+func (p *Policy) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	_, ok := p.roles[strings.ToLower(role)]
+	return ok
+}
+
+// Quota returns role's quota for a kind of method, QuotaWrites or
+// QuotaReads, and false if it has none.
+func (p *Policy) Quota(role, kind string) (Quota, bool) {
+	if p == nil {
+		return Quota{}, false
+	}
+	q, ok := p.roles[strings.ToLower(role)].quotas[kind]
+	return q, ok
+}
+
+// Satisfies reports whether role is one of roles or inherits one of them.
+// A nil policy knows no inheritance.
+func (p *Policy) Satisfies(role string, roles []string) bool {
+	if hasRole(roles, role) {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	is := p.roles[strings.ToLower(role)].is
+	for _, r := range roles {
+		if is[strings.ToLower(r)] {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadOnly reports whether method is marked read_only.
+func (p *Policy) ReadOnly(method string) bool {
+	m, _ := p.Method(method)
+	return m.ReadOnly
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if strings.EqualFold(r, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyFile is a policy loaded from disk that can be reloaded while the
+// server runs.
+type PolicyFile struct {
+	path    string
+	current atomic.Pointer[Policy]
+	modTime time.Time
+}
+
+// LoadPolicyFile reads the policy at path.
+func LoadPolicyFile(path string) (*PolicyFile, error) {
+	f := &PolicyFile{path: path}
+	if _, err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Policy is the most recently loaded policy.
+func (f *PolicyFile) Policy() *Policy {
+	return f.current.Load()
+}
+
+// reload rereads the file if it changed and reports whether it did. A file
+// that doesn't parse leaves the current policy in place.
+func (f *PolicyFile) reload() (bool, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(f.modTime) {
+		return false, nil
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return false, err
+	}
+	p, err := ParsePolicy(data)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", f.path, err)
+	}
+	f.current.Store(p)
+	f.modTime = info.ModTime()
+	return true, nil
+}
+
+// Watch checks the file every interval until ctx ends and swaps in the new
+// policy when it changes. report, if set, is told the outcome of every
+// check.
+func (f *PolicyFile) Watch(ctx context.Context, interval time.Duration, report func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := f.reload()
+		if report != nil {
+			report(err)
+		}
+		if err != nil {
+			slog.Error("failed to reload API policy, keeping the current one", "path", f.path, "error", err)
+		} else if changed {
+			slog.Info("reloaded API policy", "path", f.path)
+		}
+	}
+}
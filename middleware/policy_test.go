@@ -0,0 +1,172 @@
+package middleware_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-crud-proj/middleware"
+)
+
+const testPolicy = `
+roles:
+  viewer: [user.read]
+  support:
+    inherits: [viewer]
+    permissions: [audit.read]
+    quotas:
+      writes: {requests: 10, per: 1m}
+  manager:
+    inherits: [support]
+    permissions: ["user.*"]
+  Lead:
+    inherits: [manager]
+    quotas:
+      writes: {requests: 100, per: 1m}
+  admin: ["*"]
+methods:
+  "*": [admin]
+  /user.UserService/*: [support]
+  /user.UserService/Get*:
+    permission: user.read
+    read_only: true
+  /user.UserService/GetUser:
+    permission: user.read
+    allow_self: true
+  /user.UserService/Login: [public]
+  /user.UserService/DeleteUser:
+    roles: [manager]
+    permission: user.delete
+    timeout: 2s
+`
+
+func TestPolicyMethod(t *testing.T) {
+	p, err := middleware.ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		method     string
+		roles      []string
+		permission string
+		readOnly   bool
+	}{
+		// The exact entry applies whole, without the Get* read_only
+		{"/user.UserService/GetUser", nil, "user.read", false},
+		{"/user.UserService/GetConsents", nil, "user.read", true},
+		{"/user.UserService/DeleteUser", []string{"manager"}, "user.delete", false},
+		{"/user.UserService/Login", []string{"public"}, "", false},
+		{"/user.UserService/MergeUsers", []string{"support"}, "", false},
+		{"/grpc.health.v1.Health/Check", []string{"admin"}, "", false},
+	} {
+		m, ok := p.Method(tc.method)
+		if !ok {
+			t.Errorf("%s matches no entry", tc.method)
+			continue
+		}
+		if strings.Join(m.Roles, ",") != strings.Join(tc.roles, ",") || m.Permission != tc.permission || p.ReadOnly(tc.method) != tc.readOnly {
+			t.Errorf("%s = roles %v, permission %q, read-only %v; want %v, %q, %v",
+				tc.method, m.Roles, m.Permission, p.ReadOnly(tc.method), tc.roles, tc.permission, tc.readOnly)
+		}
+	}
+	if m, _ := p.Method("/user.UserService/DeleteUser"); m.Timeout != 2*time.Second {
+		t.Errorf("DeleteUser timeout = %s, want 2s", m.Timeout)
+	}
+
+	empty, err := middleware.NewPolicy(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := empty.Method("/user.UserService/GetUser"); ok {
+		t.Error("an empty policy matched GetUser")
+	}
+}
+
+func TestPolicyRoles(t *testing.T) {
+	p, err := middleware.ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		role, permission string
+		want             bool
+	}{
+		{"viewer", "user.read", true},
+		{"viewer", "user.write", false},
+		{"support", "user.read", true},
+		{"support", "audit.read", true},
+		{"support", "user.delete", false},
+		{"manager", "user.delete", true},
+		{"manager", "audit.read", true},
+		{"manager", "webhook.write", false},
+		{"lead", "user.write", true},
+		{"LEAD", "audit.read", true},
+		{"admin", "webhook.write", true},
+		{"nobody", "user.read", false},
+	} {
+		if got := p.Grants(tc.role, tc.permission); got != tc.want {
+			t.Errorf("Grants(%s, %s) = %v, want %v", tc.role, tc.permission, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		role  string
+		roles []string
+		want  bool
+	}{
+		{"support", []string{"support"}, true},
+		{"manager", []string{"support"}, true},
+		{"lead", []string{"viewer"}, true},
+		{"viewer", []string{"support"}, false},
+		{"admin", []string{"support"}, false},
+		{"nobody", []string{"nobody"}, true},
+	} {
+		if got := p.Satisfies(tc.role, tc.roles); got != tc.want {
+			t.Errorf("Satisfies(%s, %v) = %v, want %v", tc.role, tc.roles, got, tc.want)
+		}
+	}
+
+	for role, want := range map[string]bool{"viewer": true, "Lead": true, "lead": true, "nobody": false, "authenticated": false} {
+		if got := p.HasRole(role); got != want {
+			t.Errorf("HasRole(%s) = %v, want %v", role, got, want)
+		}
+	}
+
+	// Inherited quotas apply unless the role sets its own
+	for role, want := range map[string]int{"support": 10, "manager": 10, "lead": 100} {
+		if q, ok := p.Quota(role, middleware.QuotaWrites); !ok || q.Requests != want {
+			t.Errorf("%s writes quota = %v, %v; want %d requests", role, q, ok, want)
+		}
+	}
+	if _, ok := p.Quota("viewer", middleware.QuotaWrites); ok {
+		t.Error("viewer has a writes quota, want none")
+	}
+
+	var none *middleware.Policy
+	if none.Grants("admin", "user.read") || none.HasRole("admin") {
+		t.Error("a nil policy grants or defines roles")
+	}
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	for name, policy := range map[string]string{
+		"inheritance cycle":        "roles:\n  a: {inherits: [b]}\n  b: {inherits: [a]}\n",
+		"unknown inherited role":   "roles:\n  a: {inherits: [missing]}\n",
+		"built-in role defined":    "roles:\n  public: [user.read]\n",
+		"role defined twice":       "roles:\n  admin: ['*']\n  Admin: ['*']\n",
+		"unknown quota kind":       "roles:\n  a: {quotas: {deletes: {requests: 1, per: 1m}}}\n",
+		"inner wildcard":           "methods:\n  /user.*/Get*: [admin]\n",
+		"short method name":        "methods:\n  GetUser: [admin]\n",
+		"public with a role":       "methods:\n  /user.UserService/Login: [public, admin]\n",
+		"public with permission":   "methods:\n  /user.UserService/Login: {roles: [public], permission: user.read}\n",
+		"allow_self alone":         "methods:\n  /user.UserService/GetUser: {allow_self: true}\n",
+		"unknown method key":       "methods:\n  /user.UserService/GetUser: {permision: user.read}\n",
+		"unknown role key":         "roles:\n  a: {inherit: [b]}\n  b: [user.read]\n",
+		"unknown rate_limit key":   "methods:\n  /user.UserService/Login: {rate_limit: {rate: 1, burst: 5}}\n",
+		"spaces around permission": "methods:\n  /user.UserService/GetUser: {permission: ' user.read'}\n",
+	} {
+		if _, err := middleware.ParsePolicy([]byte(policy)); err == nil {
+			t.Errorf("%s: ParsePolicy accepted\n%s", name, policy)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Limit is a token bucket: Rate requests per second on average, with bursts of
+// up to Burst. The zero Limit means unlimited.
+type Limit struct {
+	Rate  rate.Limit
+	Burst int
+}
+
+// RateLimitConfig configures the RateLimit interceptor.
+type RateLimitConfig struct {
+	// Default applies to every method not listed in Methods.
+	Default Limit
+	// Methods overrides Default for individual full method names. Each listed
+	// method gets its own bucket per client.
+	Methods map[string]Limit
+	// IdleTTL is how long an unused in-memory bucket is kept; defaults to 10
+	// minutes.
+	IdleTTL time.Duration
+	// Store keeps the buckets. Nil means in memory, per process.
+	Store RateLimitStore
+}
+
+// RateLimitStore holds the token buckets, so they can be shared between
+// instances and survive restarts.
+type RateLimitStore interface {
+	// Allow takes a token from the bucket named key, creating it full with
+	// limit if needed, and reports whether there was one.
+	Allow(ctx context.Context, key string, limit Limit) (bool, error)
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type rateLimiter struct {
+	cfg RateLimitConfig
+}
+
+// memoryBuckets is the default RateLimitStore.
+type memoryBuckets struct {
+	idleTTL   time.Duration
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// RateLimit rejects calls with ResourceExhausted once a client has used up its
+// bucket. Clients are identified by their JWT email when Auth ran earlier in
+// the chain, then by their client certificate (mutual TLS), otherwise by
+// ClientIP.
+func RateLimit(cfg RateLimitConfig) grpc.UnaryServerInterceptor {
+	rl := newRateLimiter(cfg)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := rl.check(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamRateLimit is RateLimit for streaming RPCs. Opening a stream costs one
+// token; messages on an open stream are not limited.
+func StreamRateLimit(cfg RateLimitConfig) grpc.StreamServerInterceptor {
+	rl := newRateLimiter(cfg)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := rl.check(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func newRateLimiter(cfg RateLimitConfig) *rateLimiter {
+	if cfg.IdleTTL == 0 {
+		cfg.IdleTTL = 10 * time.Minute
+	}
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryRateLimitStore(cfg.IdleTTL)
+	}
+	return &rateLimiter{cfg: cfg}
+}
+
+func (rl *rateLimiter) check(ctx context.Context, method string) error {
+	limit, scope := rl.cfg.Default, "*"
+	if l, ok := rl.cfg.Methods[method]; ok {
+		limit, scope = l, method
+	}
+	return rl.take(ctx, method, scope, limit)
+}
+
+// take spends a token from the caller's bucket for scope, which is either a
+// method with a limit of its own or "*" for the shared default bucket.
+func (rl *rateLimiter) take(ctx context.Context, method, scope string, limit Limit) error {
+	if !rl.allow(ctx, method, scope, limit) {
+		return exhausted(limit, "rate limit exceeded for %s, retry later", method)
+	}
+	return nil
+}
+
+// allow spends a token from the caller's bucket for scope and reports
+// whether there was one.
+func (rl *rateLimiter) allow(ctx context.Context, method, scope string, limit Limit) bool {
+	if limit.Rate == 0 && limit.Burst == 0 {
+		return true
+	}
+	ok, err := rl.cfg.Store.Allow(ctx, rateLimitKey(ctx)+" "+scope, limit)
+	if err != nil {
+		// Failing open: an outage of the store shouldn't take the API down
+		slog.WarnContext(ctx, "rate limit store failed; allowing call", "method", method, "error", err)
+		return true
+	}
+	return ok
+}
+
+// exhausted is ResourceExhausted with a RetryInfo detail saying how long
+// limit's bucket takes to hold another token.
+func exhausted(limit Limit, format string, args ...interface{}) error {
+	st := status.Newf(codes.ResourceExhausted, format, args...)
+	if limit.Rate > 0 {
+		delay := time.Duration(float64(time.Second) / float64(limit.Rate))
+		if withDetails, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)}); err == nil {
+			st = withDetails
+		}
+	}
+	return st.Err()
+}
+
+// NewMemoryRateLimitStore keeps buckets in this process, dropping those
+// unused for idleTTL.
+func NewMemoryRateLimitStore(idleTTL time.Duration) RateLimitStore {
+	return &memoryBuckets{idleTTL: idleTTL, buckets: map[string]*bucket{}, lastSweep: time.Now()}
+}
+
+func (m *memoryBuckets) Allow(ctx context.Context, key string, limit Limit) (bool, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.lastSweep) > m.idleTTL {
+		for k, b := range m.buckets {
+			if now.Sub(b.lastSeen) > m.idleTTL {
+				delete(m.buckets, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(limit.Rate, limit.Burst)}
+		m.buckets[key] = b
+	}
+	b.lastSeen = now
+	return b.limiter.AllowN(now, 1), nil
+}
+
+func rateLimitKey(ctx context.Context) string {
+	if claims, ok := ClaimsFromContext(ctx); ok && claims.Email != "" {
+		return "user:" + claims.Email
+	}
+	if id, ok := ClientIdentityFromContext(ctx); ok {
+		return "cert:" + id.String()
+	}
+	return "ip:" + ClientIP(ctx)
+}
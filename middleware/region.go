@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RegionHeader is the response header naming the region of the instance that
+// served the call.
+const RegionHeader = "x-region"
+
+// Region adds RegionHeader to every response.
+func Region(region string) grpc.UnaryServerInterceptor {
+	md := metadata.Pairs(RegionHeader, region)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		_ = grpc.SetHeader(ctx, md)
+		return handler(ctx, req)
+	}
+}
+
+// StreamRegion is Region for streaming RPCs.
+func StreamRegion(region string) grpc.StreamServerInterceptor {
+	md := metadata.Pairs(RegionHeader, region)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		_ = ss.SetHeader(md)
+		return handler(srv, ss)
+	}
+}
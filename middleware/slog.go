@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+
+	"google.golang.org/grpc"
+)
+
+// ContextHandler wraps h so that records logged with a call's context
+// (slog.InfoContext and friends) carry its method, request id, trace id,
+// client IP and, after Auth, the caller's email (and acting_as for
+// impersonation tokens). Attributes the record already has are not
+// repeated.
+func ContextHandler(h slog.Handler) slog.Handler {
+	return contextHandler{h}
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx == nil {
+		return h.Handler.Handle(ctx, r)
+	}
+	have := map[string]bool{}
+	r.Attrs(func(a slog.Attr) bool {
+		have[a.Key] = true
+		return true
+	})
+	add := func(key, value string) {
+		if value != "" && !have[key] {
+			r.AddAttrs(slog.String(key, value))
+		}
+	}
+
+	if method, ok := grpc.Method(ctx); ok {
+		add("method", method)
+	}
+	add("request_id", requestID(ctx))
+	if id, ok := TraceIDFromContext(ctx); ok {
+		add("trace_id", id)
+	}
+	if ip, ok := ctx.Value(clientIPKey{}).(string); ok {
+		add("client_ip", ip)
+	}
+	if claims, ok := ClaimsFromContext(ctx); ok {
+		add("caller", claims.Email)
+		add("acting_as", claims.ActAs)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/stats"
+)
+
+// WireMetrics holds the Prometheus collectors shared by the server and client
+// stats handlers. Create it once per registry.
+type WireMetrics struct {
+	messageBytes     *prometheus.HistogramVec
+	compressionRatio *prometheus.HistogramVec
+	connsOpened      *prometheus.CounterVec
+	connsClosed      *prometheus.CounterVec
+	connsActive      *prometheus.GaugeVec
+}
+
+// NewWireMetrics registers the wire-level collectors on reg.
+func NewWireMetrics(reg prometheus.Registerer) *WireMetrics {
+	m := &WireMetrics{
+		messageBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_wire_message_bytes",
+			Help:    "Uncompressed size of gRPC messages.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 10), // 64B .. 16MiB
+		}, []string{"side", "direction", "method"}),
+		compressionRatio: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_wire_compression_ratio",
+			Help:    "Compressed size divided by uncompressed size of gRPC messages.",
+			Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+		}, []string{"side", "direction"}),
+		connsOpened: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_wire_connections_opened_total",
+			Help: "Transport connections opened.",
+		}, []string{"side"}),
+		connsClosed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_wire_connections_closed_total",
+			Help: "Transport connections closed.",
+		}, []string{"side"}),
+		connsActive: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "grpc_wire_connections_active",
+			Help: "Transport connections currently open.",
+		}, []string{"side"}),
+	}
+	reg.MustRegister(m.messageBytes, m.compressionRatio, m.connsOpened, m.connsClosed, m.connsActive)
+	return m
+}
+
+// StatsHandler returns a grpc stats.Handler for one side ("server" or
+// "client"). With logPayloads set, every message size is also logged, which is
+// handy when sizing message limits but too noisy to leave on.
+func (m *WireMetrics) StatsHandler(side string, logPayloads bool) stats.Handler {
+	return &wireStats{metrics: m, side: side, logPayloads: logPayloads}
+}
+
+type wireStats struct {
+	metrics     *WireMetrics
+	side        string
+	logPayloads bool
+}
+
+type methodKey struct{}
+
+func (w *wireStats) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, methodKey{}, info.FullMethodName)
+}
+
+func (w *wireStats) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	method, _ := ctx.Value(methodKey{}).(string)
+
+	switch p := s.(type) {
+	case *stats.InPayload:
+		w.recordPayload(method, "received", p.Length, p.CompressedLength, p.WireLength)
+	case *stats.OutPayload:
+		w.recordPayload(method, "sent", p.Length, p.CompressedLength, p.WireLength)
+	}
+}
+
+func (w *wireStats) recordPayload(method, direction string, length, compressed, wire int) {
+	w.metrics.messageBytes.WithLabelValues(w.side, direction, method).Observe(float64(length))
+	if length > 0 && compressed > 0 {
+		w.metrics.compressionRatio.WithLabelValues(w.side, direction).Observe(float64(compressed) / float64(length))
+	}
+	if w.logPayloads {
+		slog.Info("grpc payload", "side", w.side, "direction", direction, "method", method,
+			"bytes", length, "compressed", compressed, "wire", wire)
+	}
+}
+
+func (w *wireStats) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (w *wireStats) HandleConn(ctx context.Context, s stats.ConnStats) {
+	switch s.(type) {
+	case *stats.ConnBegin:
+		w.metrics.connsOpened.WithLabelValues(w.side).Inc()
+		w.metrics.connsActive.WithLabelValues(w.side).Inc()
+	case *stats.ConnEnd:
+		w.metrics.connsClosed.WithLabelValues(w.side).Inc()
+		w.metrics.connsActive.WithLabelValues(w.side).Dec()
+	}
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// wrappedStream overrides the context of a grpc.ServerStream so stream
+// interceptors can pass values (claims, request id) down to the handler.
+type wrappedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (w *wrappedStream) Context() context.Context {
+	return w.ctx
+}
+
+func wrapStream(ss grpc.ServerStream, ctx context.Context) grpc.ServerStream {
+	return &wrappedStream{ServerStream: ss, ctx: ctx}
+}
+
+// StreamInterceptors is the streaming counterpart of UnaryInterceptors, built
+// from the same options and run in the same order. Extra interceptors come
+// from WithStreamInterceptors; those given with WithUnaryInterceptors are not
+// included.
+func StreamInterceptors(opts ...Option) []grpc.StreamServerInterceptor {
+	o := options{recovery: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var chain []grpc.StreamServerInterceptor
+	if o.reqIDs {
+		chain = append(chain, StreamRequestID)
+	}
+	if o.proxies != nil {
+		chain = append(chain, StreamClientIPs(o.proxies))
+	}
+	if o.region != "" {
+		chain = append(chain, StreamRegion(o.region))
+	}
+	if o.tracing != nil {
+		chain = append(chain, StreamTracing(*o.tracing))
+	}
+	if o.logger != nil {
+		chain = append(chain, StreamLogging(o.logger))
+	}
+	if o.metrics != nil {
+		chain = append(chain, o.metrics.StreamInterceptor)
+	}
+	if o.recovery {
+		chain = append(chain, StreamRecovery)
+	}
+	if o.policy != nil {
+		return append(append(chain, StreamEnforce(o.enforcement())), o.streams...)
+	}
+	if o.auth != nil {
+		chain = append(chain, StreamAuth(*o.auth))
+	}
+	if o.limits != nil {
+		chain = append(chain, StreamRateLimit(*o.limits))
+	}
+	return append(chain, o.streams...)
+}
+
+// StreamServerOption is ServerOption for streaming RPCs; pass both to
+// grpc.NewServer.
+func StreamServerOption(opts ...Option) grpc.ServerOption {
+	return grpc.ChainStreamInterceptor(StreamInterceptors(opts...)...)
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TraceparentHeader is the W3C Trace Context header carrying the caller's
+// trace id, span id and sampled flag.
+const TraceparentHeader = "traceparent"
+
+// TraceConfig configures the Tracing interceptor.
+type TraceConfig struct {
+	// Logger receives one "span" record per kept call.
+	Logger *slog.Logger
+	// Ratio is the fraction of calls kept up front, 0 to 1. Callers that
+	// send a sampled traceparent are always kept.
+	Ratio float64
+	// Methods overrides Ratio for individual full method names.
+	Methods map[string]float64
+	// KeepErrors keeps calls that fail with a server-side error (those
+	// logged at error level) whatever the ratio.
+	KeepErrors bool
+	// SlowThreshold keeps calls that take at least this long; 0 disables.
+	SlowThreshold time.Duration
+}
+
+type traceKey struct{}
+
+// spanContext identifies a call's span within its trace.
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	parent  string
+	sampled bool
+}
+
+// TraceIDFromContext returns the trace id assigned by Tracing.
+func TraceIDFromContext(ctx context.Context) (string, bool) {
+	sc, ok := ctx.Value(traceKey{}).(*spanContext)
+	if !ok {
+		return "", false
+	}
+	return hex.EncodeToString(sc.traceID[:]), true
+}
+
+// Tracing joins the caller's trace (from traceparent) or starts one, and
+// records a span for the call. Which spans are kept is decided twice: up
+// front by the ratio, consistently for every call in a trace, and again
+// when the call ends, so failed and slow calls are kept even at a low ratio.
+func Tracing(cfg TraceConfig) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		sc := cfg.start(ctx, info.FullMethod)
+		start := time.Now()
+		resp, err := handler(context.WithValue(ctx, traceKey{}, sc), req)
+		cfg.finish(ctx, sc, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// StreamTracing is Tracing for streaming RPCs; the span covers the whole
+// stream.
+func StreamTracing(cfg TraceConfig) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		sc := cfg.start(ss.Context(), info.FullMethod)
+		start := time.Now()
+		err := handler(srv, wrapStream(ss, context.WithValue(ss.Context(), traceKey{}, sc)))
+		cfg.finish(ss.Context(), sc, info.FullMethod, start, err)
+		return err
+	}
+}
+
+func (cfg TraceConfig) start(ctx context.Context, method string) *spanContext {
+	sc := &spanContext{}
+	rand.Read(sc.spanID[:])
+	if parseTraceparent(incomingTraceparent(ctx), sc) {
+		if sc.sampled {
+			return sc
+		}
+	} else {
+		rand.Read(sc.traceID[:])
+	}
+	ratio := cfg.Ratio
+	if r, ok := cfg.Methods[method]; ok {
+		ratio = r
+	}
+	// Deciding on the trace id rather than at random gives every service
+	// the same answer for the same trace
+	sc.sampled = float64(binary.BigEndian.Uint64(sc.traceID[8:])>>11) < ratio*(1<<53)
+	return sc
+}
+
+func (cfg TraceConfig) finish(ctx context.Context, sc *spanContext, method string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	code := status.Code(err)
+	var reason string
+	switch {
+	case sc.sampled:
+		reason = "sampled"
+	case cfg.KeepErrors && levelForCode(code) == slog.LevelError:
+		reason = "error"
+	case cfg.SlowThreshold > 0 && elapsed >= cfg.SlowThreshold:
+		reason = "slow"
+	default:
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("trace_id", hex.EncodeToString(sc.traceID[:])),
+		slog.String("span_id", hex.EncodeToString(sc.spanID[:])),
+		slog.String("method", method),
+		slog.Duration("duration", elapsed),
+		slog.String("code", code.String()),
+		slog.String("kept", reason),
+	}
+	if sc.parent != "" {
+		attrs = append(attrs, slog.String("parent_span_id", sc.parent))
+	}
+	if code != codes.OK {
+		attrs = append(attrs, slog.String("error", status.Convert(err).Message()))
+	}
+	cfg.Logger.LogAttrs(ctx, slog.LevelInfo, "span", attrs...)
+}
+
+func incomingTraceparent(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(TraceparentHeader); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// parseTraceparent fills sc's trace id, parent and sampled flag from a
+// version-00 traceparent such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func parseTraceparent(value string, sc *spanContext) bool {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return false
+	}
+	var traceID [16]byte
+	var parentID [8]byte
+	flags := make([]byte, 1)
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == [16]byte{} {
+		return false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || parentID == [8]byte{} {
+		return false
+	}
+	if _, err := hex.Decode(flags, []byte(parts[3])); err != nil {
+		return false
+	}
+	sc.traceID, sc.parent, sc.sampled = traceID, parts[2], flags[0]&1 == 1
+	return true
+}
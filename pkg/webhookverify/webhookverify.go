@@ -0,0 +1,116 @@
+// Package webhookverify checks that a webhook delivery really comes from the
+// user service and is recent. Receivers import it; the service uses Sign.
+//
+// Each delivery carries two headers:
+//
+//	X-Webhook-Timestamp: 1760428800
+//	X-Webhook-Signature: v1=<64 hex digits>
+//
+// The signature is hex HMAC-SHA256, keyed with the shared webhook secret (the
+// service's webhooks.secret), over the timestamp, a ".", and the raw request
+// body. The header may hold several comma-separated signatures while a secret
+// is being rotated; one matching is enough.
+//
+// A receiver:
+//
+//	func handle(w http.ResponseWriter, r *http.Request) {
+//		body, err := webhookverify.VerifyRequest(r, secret, webhookverify.DefaultTolerance)
+//		if err != nil {
+//			http.Error(w, err.Error(), http.StatusUnauthorized)
+//			return
+//		}
+//		// body is the verified JSON event
+//	}
+package webhookverify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header names set on every signed delivery.
+const (
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// DefaultTolerance is how far a delivery's timestamp may be from the
+// receiver's clock. It bounds how long a captured delivery can be replayed.
+const DefaultTolerance = 5 * time.Minute
+
+// MaxBodySize is the largest body VerifyRequest reads.
+const MaxBodySize = 1 << 20
+
+var (
+	ErrMissingSignature = errors.New("webhookverify: missing timestamp or signature header")
+	ErrInvalidSignature = errors.New("webhookverify: signature does not match")
+	ErrStale            = errors.New("webhookverify: timestamp outside the tolerance window")
+)
+
+const version = "v1"
+
+// Sign returns the SignatureHeader value for body sent at ts.
+func Sign(secret []byte, ts time.Time, body []byte) string {
+	return version + "=" + hex.EncodeToString(mac(secret, strconv.FormatInt(ts.Unix(), 10), body))
+}
+
+func mac(secret []byte, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Verify checks the header values of a delivery against body. A tolerance of
+// 0 means DefaultTolerance.
+func Verify(secret []byte, timestamp, signature string, body []byte, tolerance time.Duration) error {
+	if timestamp == "" || signature == "" {
+		return ErrMissingSignature
+	}
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: bad timestamp %q", ErrInvalidSignature, timestamp)
+	}
+	if skew := time.Since(time.Unix(secs, 0)); skew > tolerance || skew < -tolerance {
+		return ErrStale
+	}
+
+	want := mac(secret, timestamp, body)
+	for _, sig := range strings.Split(signature, ",") {
+		v, value, ok := strings.Cut(strings.TrimSpace(sig), "=")
+		if !ok || v != version {
+			continue
+		}
+		got, err := hex.DecodeString(value)
+		if err == nil && hmac.Equal(got, want) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// VerifyRequest reads r's body (up to MaxBodySize) and verifies it with the
+// request's headers. It returns the body, which the caller should only trust
+// when the error is nil.
+func VerifyRequest(r *http.Request, secret []byte, tolerance time.Duration) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxBodySize {
+		return nil, fmt.Errorf("webhookverify: body larger than %d bytes", MaxBodySize)
+	}
+	return body, Verify(secret, r.Header.Get(TimestampHeader), r.Header.Get(SignatureHeader), body, tolerance)
+}
@@ -0,0 +1,84 @@
+package webhookverify_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-crud-proj/pkg/webhookverify"
+)
+
+func TestVerify(t *testing.T) {
+	secret := []byte("current-secret")
+	old := []byte("previous-secret")
+	body := []byte(`{"type":"user.created","user_id":7}`)
+	now := time.Now()
+	unix := func(ts time.Time) string { return strconv.FormatInt(ts.Unix(), 10) }
+
+	for _, tc := range []struct {
+		name      string
+		timestamp string
+		signature string
+		body      []byte
+		want      error
+	}{
+		{"round trip", unix(now), webhookverify.Sign(secret, now, body), body, nil},
+		{"tampered body", unix(now), webhookverify.Sign(secret, now, body), []byte(`{"type":"user.created","user_id":8}`), webhookverify.ErrInvalidSignature},
+		{"wrong secret", unix(now), webhookverify.Sign([]byte("other"), now, body), body, webhookverify.ErrInvalidSignature},
+		{"signed for another timestamp", unix(now), webhookverify.Sign(secret, now.Add(-time.Second), body), body, webhookverify.ErrInvalidSignature},
+		{"stale", unix(now.Add(-10 * time.Minute)), webhookverify.Sign(secret, now.Add(-10*time.Minute), body), body, webhookverify.ErrStale},
+		{"future", unix(now.Add(10 * time.Minute)), webhookverify.Sign(secret, now.Add(10*time.Minute), body), body, webhookverify.ErrStale},
+		{"inside the tolerance", unix(now.Add(-4 * time.Minute)), webhookverify.Sign(secret, now.Add(-4*time.Minute), body), body, nil},
+		{"rotation, new secret second", unix(now), webhookverify.Sign(old, now, body) + "," + webhookverify.Sign(secret, now, body), body, nil},
+		{"rotation, spaces after commas", unix(now), webhookverify.Sign(secret, now, body) + ", " + webhookverify.Sign(old, now, body), body, nil},
+		{"rotation, no secret matches", unix(now), webhookverify.Sign(old, now, body) + "," + webhookverify.Sign([]byte("other"), now, body), body, webhookverify.ErrInvalidSignature},
+		{"wrong version prefix", unix(now), "v0=" + strings.TrimPrefix(webhookverify.Sign(secret, now, body), "v1="), body, webhookverify.ErrInvalidSignature},
+		{"no version prefix", unix(now), strings.TrimPrefix(webhookverify.Sign(secret, now, body), "v1="), body, webhookverify.ErrInvalidSignature},
+		{"bad hex", unix(now), "v1=not-hex", body, webhookverify.ErrInvalidSignature},
+		{"bad timestamp", "yesterday", webhookverify.Sign(secret, now, body), body, webhookverify.ErrInvalidSignature},
+		{"missing timestamp", "", webhookverify.Sign(secret, now, body), body, webhookverify.ErrMissingSignature},
+		{"missing signature", unix(now), "", body, webhookverify.ErrMissingSignature},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := webhookverify.Verify(secret, tc.timestamp, tc.signature, tc.body, 0)
+			if !errors.Is(err, tc.want) || (tc.want == nil && err != nil) {
+				t.Errorf("Verify = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	secret := []byte("current-secret")
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name    string
+		body    []byte
+		wantErr string
+	}{
+		{"signed body", []byte(`{"type":"user.deleted"}`), ""},
+		{"body at the limit", bytes.Repeat([]byte("a"), webhookverify.MaxBodySize), ""},
+		{"body over the limit", bytes.Repeat([]byte("a"), webhookverify.MaxBodySize+1), "body larger than"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/hooks", bytes.NewReader(tc.body))
+			r.Header.Set(webhookverify.TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+			r.Header.Set(webhookverify.SignatureHeader, webhookverify.Sign(secret, now, tc.body))
+
+			got, err := webhookverify.VerifyRequest(r, secret, webhookverify.DefaultTolerance)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Fatalf("VerifyRequest: %v", err)
+			case tc.wantErr == "" && !bytes.Equal(got, tc.body):
+				t.Errorf("VerifyRequest returned %d bytes, want the %d sent", len(got), len(tc.body))
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("VerifyRequest = %v, want an error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
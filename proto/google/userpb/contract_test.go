@@ -0,0 +1,320 @@
+package userpb_test
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+	pbv2 "grpc-crud-proj/proto/v2/userpb"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var update = flag.Bool("update", false, "rewrite the testdata/contract*.golden.json snapshots from the current protos")
+
+// snapshots pairs each published proto file with the golden file of its
+// contract.
+var snapshots = []struct {
+	file   protoreflect.FileDescriptor
+	golden string
+}{
+	{pb.File_user_proto, "testdata/contract.golden.json"},
+	{pbv2.File_v2_user_proto, "testdata/contract_v2.golden.json"},
+}
+
+// contract is the externally visible surface of a proto file: the wire format
+// (field numbers and types), the RPC signatures and HTTP bindings, and the
+// JSON shape of each REST response.
+type contract struct {
+	Messages      map[string]map[string]field  `json:"messages"`
+	Enums         map[string]map[string]int32  `json:"enums"`
+	Methods       map[string]method            `json:"methods"`
+	RESTResponses map[string]map[string]string `json:"rest_responses"`
+}
+
+type field struct {
+	Number   int32  `json:"number"`
+	Type     string `json:"type"`
+	JSONName string `json:"json_name"`
+}
+
+type method struct {
+	Input  string `json:"input"`
+	Output string `json:"output"`
+	HTTP   string `json:"http,omitempty"`
+}
+
+// TestContractCompatibility fails on backward-incompatible changes (removed or
+// renumbered fields, changed types, removed RPCs or routes) to user.proto or
+// v2/user.proto. Additive changes only require refreshing the snapshots:
+//
+//	go test ./proto/google/userpb -run TestContract -update
+func TestContractCompatibility(t *testing.T) {
+	for _, s := range snapshots {
+		t.Run(s.file.Path(), func(t *testing.T) {
+			current := buildContract(s.file)
+
+			if *update {
+				writeGolden(t, s.golden, current)
+				return
+			}
+
+			raw, err := os.ReadFile(s.golden)
+			if err != nil {
+				t.Fatalf("read golden file (run with -update to create it): %v", err)
+			}
+			var golden contract
+			if err := json.Unmarshal(raw, &golden); err != nil {
+				t.Fatalf("parse golden file: %v", err)
+			}
+
+			for _, problem := range breakingChanges(golden, current) {
+				t.Errorf("breaking change: %s", problem)
+			}
+			if t.Failed() {
+				return
+			}
+			if !reflect.DeepEqual(golden, current) {
+				t.Errorf("contract changed in a backward-compatible way; refresh the snapshot with -update")
+			}
+		})
+	}
+}
+
+func writeGolden(t *testing.T, path string, c contract) {
+	t.Helper()
+	out, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(out, '\n'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func breakingChanges(golden, current contract) []string {
+	var problems []string
+
+	for _, name := range sortedKeys(golden.Messages) {
+		fields, ok := current.Messages[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("message %s was removed", name))
+			continue
+		}
+		for _, fname := range sortedKeys(golden.Messages[name]) {
+			old := golden.Messages[name][fname]
+			now, ok := fields[fname]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("field %s.%s was removed", name, fname))
+			case now != old:
+				problems = append(problems, fmt.Sprintf("field %s.%s changed from %+v to %+v", name, fname, old, now))
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(golden.Enums) {
+		values, ok := current.Enums[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("enum %s was removed", name))
+			continue
+		}
+		for _, vname := range sortedKeys(golden.Enums[name]) {
+			if n, ok := values[vname]; !ok || n != golden.Enums[name][vname] {
+				problems = append(problems, fmt.Sprintf("enum value %s.%s was removed or renumbered", name, vname))
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(golden.Methods) {
+		now, ok := current.Methods[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("rpc %s was removed", name))
+		case now != golden.Methods[name]:
+			problems = append(problems, fmt.Sprintf("rpc %s changed from %+v to %+v", name, golden.Methods[name], now))
+		}
+	}
+
+	for _, route := range sortedKeys(golden.RESTResponses) {
+		shape, ok := current.RESTResponses[route]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("REST route %s was removed", route))
+			continue
+		}
+		for _, key := range sortedKeys(golden.RESTResponses[route]) {
+			if shape[key] != golden.RESTResponses[route][key] {
+				problems = append(problems, fmt.Sprintf("REST response of %s: %q changed from %q to %q",
+					route, key, golden.RESTResponses[route][key], shape[key]))
+			}
+		}
+	}
+
+	return problems
+}
+
+func buildContract(fd protoreflect.FileDescriptor) contract {
+	c := contract{
+		Messages:      map[string]map[string]field{},
+		Enums:         map[string]map[string]int32{},
+		Methods:       map[string]method{},
+		RESTResponses: map[string]map[string]string{},
+	}
+
+	var addMessages func(msgs protoreflect.MessageDescriptors)
+	addMessages = func(msgs protoreflect.MessageDescriptors) {
+		for i := 0; i < msgs.Len(); i++ {
+			md := msgs.Get(i)
+			if md.IsMapEntry() {
+				continue
+			}
+			fields := map[string]field{}
+			for j := 0; j < md.Fields().Len(); j++ {
+				fdesc := md.Fields().Get(j)
+				fields[string(fdesc.Name())] = field{
+					Number:   int32(fdesc.Number()),
+					Type:     fieldType(fdesc),
+					JSONName: fdesc.JSONName(),
+				}
+			}
+			c.Messages[string(md.FullName())] = fields
+			addMessages(md.Messages())
+		}
+	}
+	addMessages(fd.Messages())
+
+	for i := 0; i < fd.Enums().Len(); i++ {
+		ed := fd.Enums().Get(i)
+		values := map[string]int32{}
+		for j := 0; j < ed.Values().Len(); j++ {
+			v := ed.Values().Get(j)
+			values[string(v.Name())] = int32(v.Number())
+		}
+		c.Enums[string(ed.FullName())] = values
+	}
+
+	for i := 0; i < fd.Services().Len(); i++ {
+		sd := fd.Services().Get(i)
+		for j := 0; j < sd.Methods().Len(); j++ {
+			m := sd.Methods().Get(j)
+			route := httpRoute(m)
+			c.Methods[string(sd.Name())+"/"+string(m.Name())] = method{
+				Input:  string(m.Input().FullName()),
+				Output: string(m.Output().FullName()),
+				HTTP:   route,
+			}
+			if route != "" {
+				shape := map[string]string{}
+				jsonShape(m.Output(), "", shape, map[protoreflect.FullName]bool{})
+				c.RESTResponses[route] = shape
+			}
+		}
+	}
+
+	return c
+}
+
+func fieldType(fd protoreflect.FieldDescriptor) string {
+	if fd.IsMap() {
+		return "map<" + fieldType(fd.MapKey()) + "," + fieldType(fd.MapValue()) + ">"
+	}
+	t := fd.Kind().String()
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		t = string(fd.Message().FullName())
+	case protoreflect.EnumKind:
+		t = string(fd.Enum().FullName())
+	}
+	if fd.IsList() {
+		t = "repeated " + t
+	}
+	if od := fd.ContainingOneof(); od != nil && !od.IsSynthetic() {
+		t += " (oneof " + string(od.Name()) + ")"
+	}
+	return t
+}
+
+func httpRoute(m protoreflect.MethodDescriptor) string {
+	rule, ok := proto.GetExtension(m.Options(), annotations.E_Http).(*annotations.HttpRule)
+	if !ok || rule == nil {
+		return ""
+	}
+	switch p := rule.Pattern.(type) {
+	case *annotations.HttpRule_Get:
+		return "GET " + p.Get
+	case *annotations.HttpRule_Post:
+		return "POST " + p.Post
+	case *annotations.HttpRule_Put:
+		return "PUT " + p.Put
+	case *annotations.HttpRule_Delete:
+		return "DELETE " + p.Delete
+	case *annotations.HttpRule_Patch:
+		return "PATCH " + p.Patch
+	}
+	return ""
+}
+
+// jsonShape flattens the protojson representation of md into dotted JSON keys
+// mapped to JSON value kinds, e.g. "user.id" -> "number".
+func jsonShape(md protoreflect.MessageDescriptor, prefix string, out map[string]string, seen map[protoreflect.FullName]bool) {
+	if seen[md.FullName()] {
+		return
+	}
+	seen[md.FullName()] = true
+	defer delete(seen, md.FullName())
+
+	for i := 0; i < md.Fields().Len(); i++ {
+		fd := md.Fields().Get(i)
+		key := prefix + fd.JSONName()
+		switch {
+		case fd.IsMap():
+			out[key] = "object<" + jsonKind(fd.MapValue()) + ">"
+		case fd.IsList():
+			out[key] = "array<" + jsonKind(fd) + ">"
+			if fd.Kind() == protoreflect.MessageKind {
+				jsonShape(fd.Message(), key+"[].", out, seen)
+			}
+		default:
+			out[key] = jsonKind(fd)
+			if fd.Kind() == protoreflect.MessageKind {
+				jsonShape(fd.Message(), key+".", out, seen)
+			}
+		}
+	}
+}
+
+func jsonKind(fd protoreflect.FieldDescriptor) string {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return "boolean"
+	case protoreflect.StringKind, protoreflect.BytesKind, protoreflect.EnumKind,
+		// protojson encodes 64-bit integers as strings
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return "string"
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return "object"
+	default:
+		return "number"
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
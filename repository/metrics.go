@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// QueryMetrics times repository calls by name, retries included, in the
+// db_query_duration_seconds histogram.
+type QueryMetrics struct {
+	duration *prometheus.HistogramVec
+}
+
+// NewQueryMetrics registers db_query_duration_seconds on reg.
+func NewQueryMetrics(reg prometheus.Registerer) *QueryMetrics {
+	m := &QueryMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "Time taken by user repository calls, by call.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14), // 0.5ms .. 4s
+		}, []string{"query"}),
+	}
+	reg.MustRegister(m.duration)
+	return m
+}
+
+func (m *QueryMetrics) observe(query string, start time.Time) {
+	if m != nil {
+		m.duration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Instrument records how long each of p's calls takes in m. A whole
+// transaction is recorded as "transaction", besides the calls inside it.
+func (p *Postgres) Instrument(m *QueryMetrics) *Postgres {
+	p.metrics = m
+	return p
+}
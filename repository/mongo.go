@@ -0,0 +1,281 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Mongo is the UserRepository backed by the users collection of a MongoDB
+// database, for deployments without SQL. Documents are keyed by an
+// ObjectID; the int32 id the API uses is a separate uniquely indexed field,
+// handed out in order from the counters collection, so ids look the same
+// as on the SQL backends. Create the indexes with db.EnsureMongoIndexes.
+// WithTx needs a replica set, as MongoDB transactions do.
+type Mongo struct {
+	db      *mongo.Database
+	session *mongo.Session // set inside WithTx
+}
+
+// NewMongo returns a repository using db.
+func NewMongo(db *mongo.Database) *Mongo {
+	return &Mongo{db: db}
+}
+
+var _ UserRepository = (*Mongo)(nil)
+
+// mongoUser is a users document.
+type mongoUser struct {
+	ObjectID   bson.ObjectID `bson:"_id,omitempty"`
+	ID         int32         `bson:"id"`
+	Name       string        `bson:"name"`
+	Email      string        `bson:"email"`
+	Password   string        `bson:"password,omitempty"`
+	Role       string        `bson:"role"`
+	Status     string        `bson:"status"`
+	Version    int32         `bson:"version"`
+	MergedInto int32         `bson:"merged_into,omitempty"`
+	CreatedAt  time.Time     `bson:"created_at"`
+	DeletedAt  *time.Time    `bson:"deleted_at,omitempty"`
+}
+
+func (u *mongoUser) proto() *pb.User {
+	return &pb.User{
+		Id:      u.ID,
+		Name:    u.Name,
+		Email:   u.Email,
+		Role:    u.Role,
+		Status:  StatusFromDB(u.Status),
+		Version: u.Version,
+	}
+}
+
+// notDeleted matches users that aren't deleted, with the conditions in filter.
+func notDeleted(filter bson.D) bson.D {
+	return append(filter, bson.E{Key: "deleted_at", Value: nil})
+}
+
+// ctx joins the transaction of WithTx, if any.
+func (m *Mongo) ctx(ctx context.Context) context.Context {
+	if m.session == nil {
+		return ctx
+	}
+	return mongo.NewSessionContext(ctx, m.session)
+}
+
+func (m *Mongo) users() *mongo.Collection { return m.db.Collection("users") }
+
+// nextID takes the next user id from the counters collection.
+func (m *Mongo) nextID(ctx context.Context) (int32, error) {
+	var counter struct {
+		Seq int32 `bson:"seq"`
+	}
+	err := m.db.Collection("counters").FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: "users"}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "seq", Value: 1}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	return counter.Seq, err
+}
+
+// ObjectIDOf returns the ObjectID of the document behind user id, for
+// tools working with the collection directly.
+func (m *Mongo) ObjectIDOf(ctx context.Context, id int32) (string, error) {
+	var u mongoUser
+	err := m.users().FindOne(m.ctx(ctx), bson.D{{Key: "id", Value: id}}).Decode(&u)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return u.ObjectID.Hex(), nil
+}
+
+// UserIDOf is the reverse of ObjectIDOf.
+func (m *Mongo) UserIDOf(ctx context.Context, objectID string) (int32, error) {
+	oid, err := bson.ObjectIDFromHex(objectID)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	var u mongoUser
+	err = m.users().FindOne(m.ctx(ctx), bson.D{{Key: "_id", Value: oid}}).Decode(&u)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return u.ID, nil
+}
+
+func (m *Mongo) Create(ctx context.Context, u NewUser) (*pb.User, error) {
+	ctx = m.ctx(ctx)
+	u.Email = normalizeEmail(u.Email)
+	id, err := m.nextID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	doc := mongoUser{
+		ID:        id,
+		Name:      u.Name,
+		Email:     u.Email,
+		Role:      u.Role,
+		Status:    StatusToDB(pb.UserStatus_USER_STATUS_ACTIVE),
+		Version:   1,
+		CreatedAt: time.Now().UTC(),
+	}
+	if _, err := m.users().InsertOne(ctx, doc); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrDuplicateEmail
+		}
+		return nil, err
+	}
+	return doc.proto(), nil
+}
+
+func (m *Mongo) SetPassword(ctx context.Context, id int32, hash string) error {
+	result, err := m.users().UpdateOne(m.ctx(ctx),
+		notDeleted(bson.D{{Key: "id", Value: id}}),
+		bson.D{{Key: "$set", Value: bson.D{{Key: "password", Value: hash}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) Get(ctx context.Context, id int32) (*pb.User, error) {
+	ctx = m.ctx(ctx)
+	var src mongoUser
+	err := m.users().FindOne(ctx, bson.D{{Key: "id", Value: id}}).Decode(&src)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if src.MergedInto == 0 {
+		if src.DeletedAt != nil {
+			return nil, ErrNotFound
+		}
+		return src.proto(), nil
+	}
+
+	var u mongoUser
+	err = m.users().FindOne(ctx, notDeleted(bson.D{{Key: "id", Value: src.MergedInto}})).Decode(&u)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u.proto(), nil
+}
+
+func (m *Mongo) Update(ctx context.Context, id int32, name, email string, expectedVersion int32) (*pb.User, error) {
+	ctx = m.ctx(ctx)
+	email = normalizeEmail(email)
+	var u mongoUser
+	err := m.users().FindOneAndUpdate(ctx,
+		notDeleted(bson.D{{Key: "id", Value: id}, {Key: "version", Value: expectedVersion}}),
+		bson.D{
+			{Key: "$set", Value: bson.D{{Key: "name", Value: name}, {Key: "email", Value: email}}},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&u)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		// Either the user is gone or someone else got there first
+		n, err := m.users().CountDocuments(ctx, notDeleted(bson.D{{Key: "id", Value: id}}))
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			return nil, ErrVersionMismatch
+		}
+		return nil, ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return nil, ErrDuplicateEmail
+	case err != nil:
+		return nil, err
+	}
+	return u.proto(), nil
+}
+
+func (m *Mongo) Delete(ctx context.Context, id int32) error {
+	result, err := m.users().DeleteOne(m.ctx(ctx), bson.D{{Key: "id", Value: id}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) List(ctx context.Context, opts ListOptions) ([]*pb.User, error) {
+	ctx = m.ctx(ctx)
+	filter := notDeleted(bson.D{})
+	if opts.Status != pb.UserStatus_USER_STATUS_UNSPECIFIED {
+		filter = notDeleted(bson.D{{Key: "status", Value: StatusToDB(opts.Status)}})
+	}
+	cursor, err := m.users().Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "id", Value: 1}}).
+			SetLimit(int64(opts.Limit)).SetSkip(int64(opts.Offset)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []*pb.User
+	for cursor.Next(ctx) {
+		var u mongoUser
+		if err := cursor.Decode(&u); err != nil {
+			return nil, fmt.Errorf("reading user: %w", err)
+		}
+		users = append(users, u.proto())
+	}
+	return users, cursor.Err()
+}
+
+func (m *Mongo) Audit(ctx context.Context, e AuditEntry) error {
+	_, err := m.db.Collection("audit_log").InsertOne(m.ctx(ctx), bson.D{
+		{Key: "occurred_at", Value: time.Now().UTC()},
+		{Key: "actor", Value: e.Actor},
+		{Key: "action", Value: e.Action},
+		{Key: "user_id", Value: e.UserID},
+		{Key: "detail", Value: e.Detail},
+	})
+	return err
+}
+
+// WithTx runs fn in a MongoDB transaction, which the driver retries as a
+// whole on transient errors, so fn may run more than once.
+func (m *Mongo) WithTx(ctx context.Context, fn func(repo UserRepository) error) error {
+	if m.session != nil {
+		return fn(m)
+	}
+	session, err := m.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, fn(&Mongo{db: m.db, session: session})
+	})
+	return err
+}
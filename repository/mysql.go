@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQL is the UserRepository backed by the users table of db.MySQLSchema,
+// for deployments on MySQL 8. Open its database with db.ConnectMySQL: it
+// relies on affected-row counts that include unchanged rows.
+type MySQL struct {
+	db      *sql.DB
+	q       querier // db, or the transaction inside WithTx
+	inTx    bool
+	observe QueryObserver
+}
+
+// NewMySQL returns a repository using db. observe may be nil.
+func NewMySQL(db *sql.DB, observe QueryObserver) *MySQL {
+	return &MySQL{db: db, q: db, observe: observe}
+}
+
+var _ UserRepository = (*MySQL)(nil)
+
+// mysqlDuplicateEntry is MySQL's ER_DUP_ENTRY.
+const mysqlDuplicateEntry = 1062
+
+func isDuplicateEntry(err error) bool {
+	var myErr *mysql.MySQLError
+	return errors.As(err, &myErr) && myErr.Number == mysqlDuplicateEntry
+}
+
+func (m *MySQL) Create(ctx context.Context, u NewUser) (*pb.User, error) {
+	u.Email = normalizeEmail(u.Email)
+	// No RETURNING; the driver reports LAST_INSERT_ID() instead
+	result, err := m.q.ExecContext(ctx,
+		"INSERT INTO users(name, email, role) VALUES(?, ?, ?)",
+		u.Name, u.Email, u.Role,
+	)
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return nil, ErrDuplicateEmail
+		}
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.User{
+		Id:      int32(id),
+		Name:    u.Name,
+		Email:   u.Email,
+		Role:    u.Role,
+		Status:  pb.UserStatus_USER_STATUS_ACTIVE,
+		Version: 1,
+	}, nil
+}
+
+func (m *MySQL) SetPassword(ctx context.Context, id int32, hash string) error {
+	return m.execOne(ctx, "UPDATE users SET password=? WHERE id=? AND deleted_at IS NULL", hash, id)
+}
+
+func (m *MySQL) Get(ctx context.Context, id int32) (*pb.User, error) {
+	user, err := scanUser(m.q.QueryRowContext(ctx,
+		`SELECT u.id, u.name, u.email, u.role, u.status, u.version
+		 FROM users src JOIN users u ON u.id = COALESCE(src.merged_into, src.id)
+		 WHERE src.id=? AND u.deleted_at IS NULL`,
+		id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return user, err
+}
+
+// Update reads the row back in the same transaction, since MySQL has no
+// UPDATE ... RETURNING.
+func (m *MySQL) Update(ctx context.Context, id int32, name, email string, expectedVersion int32) (*pb.User, error) {
+	email = normalizeEmail(email)
+	var user *pb.User
+	err := m.WithTx(ctx, func(repo UserRepository) error {
+		tx := repo.(*MySQL)
+		result, err := tx.q.ExecContext(ctx,
+			"UPDATE users SET name=?, email=?, version=version+1 WHERE id=? AND version=? AND deleted_at IS NULL",
+			name, email, id, expectedVersion,
+		)
+		if isDuplicateEntry(err) {
+			return ErrDuplicateEmail
+		}
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			// Either the user is gone or someone else got there first
+			var exists bool
+			if err := tx.q.QueryRowContext(ctx,
+				"SELECT EXISTS(SELECT 1 FROM users WHERE id=? AND deleted_at IS NULL)", id,
+			).Scan(&exists); err != nil {
+				return err
+			}
+			if exists {
+				return ErrVersionMismatch
+			}
+			return ErrNotFound
+		}
+		user, err = scanUser(tx.q.QueryRowContext(ctx,
+			"SELECT id, name, email, role, status, version FROM users WHERE id=?", id,
+		))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (m *MySQL) Delete(ctx context.Context, id int32) error {
+	return m.execOne(ctx, "DELETE FROM users WHERE id=?", id)
+}
+
+// execOne runs a statement that should touch exactly one user, returning
+// ErrNotFound if it touched none.
+func (m *MySQL) execOne(ctx context.Context, query string, args ...interface{}) error {
+	result, err := m.q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *MySQL) List(ctx context.Context, opts ListOptions) ([]*pb.User, error) {
+	var rows *sql.Rows
+	var err error
+	if opts.Status == pb.UserStatus_USER_STATUS_UNSPECIFIED {
+		rows, err = m.query(ctx, "list", "",
+			"SELECT id, name, email, role, status, version FROM users WHERE deleted_at IS NULL ORDER BY id LIMIT ? OFFSET ?",
+			opts.Limit, opts.Offset,
+		)
+	} else {
+		rows, err = m.query(ctx, "list_by_status", "",
+			"SELECT id, name, email, role, status, version FROM users WHERE deleted_at IS NULL AND status=? ORDER BY id LIMIT ? OFFSET ?",
+			StatusToDB(opts.Status), opts.Limit, opts.Offset,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*pb.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("reading user: %w", err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (m *MySQL) query(ctx context.Context, shape, hint, query string, args ...interface{}) (*sql.Rows, error) {
+	if m.observe != nil {
+		m.observe(ctx, shape, hint, query, args)
+	}
+	return m.q.QueryContext(ctx, query, args...)
+}
+
+func (m *MySQL) Audit(ctx context.Context, e AuditEntry) error {
+	_, err := m.q.ExecContext(ctx,
+		"INSERT INTO audit_log(actor, action, user_id, detail) VALUES(?, ?, ?, ?)",
+		e.Actor, e.Action, e.UserID, e.Detail,
+	)
+	return err
+}
+
+func (m *MySQL) WithTx(ctx context.Context, fn func(repo UserRepository) error) error {
+	if m.inTx {
+		return fn(m)
+	}
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Also rolls back when fn panics; after Commit it is a no-op
+	defer tx.Rollback()
+
+	if err := fn(&MySQL{db: m.db, q: tx, inTx: true, observe: m.observe}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// QueryObserver is told about every list-style query before it runs: a short
+// name for its shape, the index that would serve it (empty if the primary key
+// does) and the SQL with its arguments. The index advisor and the slow
+// request log use it.
+type QueryObserver func(ctx context.Context, shape, hint, query string, args []interface{})
+
+// querier is what *sql.DB and *sql.Tx have in common.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Postgres is the UserRepository backed by the users table.
+type Postgres struct {
+	db      *sql.DB
+	q       querier // db, or the transaction inside WithTx
+	inTx    bool
+	observe QueryObserver
+	read    func() *sql.DB // set by ReadFrom
+	retries *retrier       // set by Retry
+	metrics *QueryMetrics  // set by Instrument
+}
+
+// NewPostgres returns a repository using db. observe may be nil.
+func NewPostgres(db *sql.DB, observe QueryObserver) *Postgres {
+	return &Postgres{db: db, q: db, observe: observe}
+}
+
+// ReadFrom sends Get and List to the database read returns, such as a read
+// replica, instead of db. Inside WithTx they keep using the transaction, so
+// they see its writes.
+func (p *Postgres) ReadFrom(read func() *sql.DB) *Postgres {
+	p.read = read
+	return p
+}
+
+// reader is where Get and List run.
+func (p *Postgres) reader() querier {
+	if p.read == nil || p.inTx {
+		return p.q
+	}
+	return p.read()
+}
+
+var _ UserRepository = (*Postgres)(nil)
+
+// pgUniqueViolation is the Postgres SQLSTATE for unique_violation.
+const pgUniqueViolation = "23505"
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+// scanUser reads the id, name, email, role, status, version columns.
+func scanUser(row interface{ Scan(...interface{}) error }) (*pb.User, error) {
+	var user pb.User
+	var userStatus string
+	if err := row.Scan(&user.Id, &user.Name, &user.Email, &user.Role, &userStatus, &user.Version); err != nil {
+		return nil, err
+	}
+	user.Status = StatusFromDB(userStatus)
+	return &user, nil
+}
+
+func (p *Postgres) create(ctx context.Context, u NewUser) (*pb.User, error) {
+	u.Email = normalizeEmail(u.Email)
+	uid, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	var id int32
+	err = p.q.QueryRowContext(ctx,
+		"INSERT INTO users(name, email, role, uuid) VALUES($1, $2, $3, $4) RETURNING id",
+		u.Name, u.Email, u.Role, uid,
+	).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateEmail
+		}
+		return nil, err
+	}
+	return &pb.User{
+		Id:      id,
+		Name:    u.Name,
+		Email:   u.Email,
+		Role:    u.Role,
+		Status:  pb.UserStatus_USER_STATUS_ACTIVE,
+		Version: 1,
+	}, nil
+}
+
+func (p *Postgres) setPassword(ctx context.Context, id int32, hash string) error {
+	return p.execOne(ctx, "UPDATE users SET password=$1 WHERE id=$2 AND deleted_at IS NULL", hash, id)
+}
+
+func (p *Postgres) get(ctx context.Context, id int32) (*pb.User, error) {
+	user, err := scanUser(p.reader().QueryRowContext(ctx,
+		`SELECT u.id, u.name, u.email, u.role, u.status, u.version
+		 FROM users src JOIN users u ON u.id = COALESCE(src.merged_into, src.id)
+		 WHERE src.id=$1 AND u.deleted_at IS NULL`,
+		id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return user, err
+}
+
+func (p *Postgres) update(ctx context.Context, id int32, name, email string, expectedVersion int32) (*pb.User, error) {
+	email = normalizeEmail(email)
+	user, err := scanUser(p.q.QueryRowContext(ctx,
+		`UPDATE users SET name=$1, email=$2, version=version+1 WHERE id=$3 AND version=$4 AND deleted_at IS NULL
+		 RETURNING id, name, email, role, status, version`,
+		name, email, id, expectedVersion,
+	))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// Either the user is gone or someone else got there first
+		var exists bool
+		if err := p.q.QueryRowContext(ctx,
+			"SELECT EXISTS(SELECT 1 FROM users WHERE id=$1 AND deleted_at IS NULL)", id,
+		).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, ErrVersionMismatch
+		}
+		return nil, ErrNotFound
+	case isUniqueViolation(err):
+		return nil, ErrDuplicateEmail
+	}
+	return user, err
+}
+
+func (p *Postgres) delete(ctx context.Context, id int32) error {
+	return p.execOne(ctx, "DELETE FROM users WHERE id=$1", id)
+}
+
+// execOne runs a statement that should touch exactly one user, returning
+// ErrNotFound if it touched none.
+func (p *Postgres) execOne(ctx context.Context, query string, args ...interface{}) error {
+	result, err := p.q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) list(ctx context.Context, opts ListOptions) ([]*pb.User, error) {
+	var rows *sql.Rows
+	var err error
+	if opts.Status == pb.UserStatus_USER_STATUS_UNSPECIFIED {
+		rows, err = p.query(ctx, "list", "",
+			"SELECT id, name, email, role, status, version FROM users WHERE deleted_at IS NULL ORDER BY id LIMIT $1 OFFSET $2",
+			opts.Limit, opts.Offset,
+		)
+	} else {
+		rows, err = p.query(ctx, "list_by_status",
+			"CREATE INDEX CONCURRENTLY users_status_id_idx ON users (status, id) WHERE deleted_at IS NULL",
+			"SELECT id, name, email, role, status, version FROM users WHERE deleted_at IS NULL AND status=$1 ORDER BY id LIMIT $2 OFFSET $3",
+			StatusToDB(opts.Status), opts.Limit, opts.Offset,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*pb.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("reading user: %w", err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (p *Postgres) query(ctx context.Context, shape, hint, query string, args ...interface{}) (*sql.Rows, error) {
+	if p.observe != nil {
+		p.observe(ctx, shape, hint, query, args)
+	}
+	return p.reader().QueryContext(ctx, query, args...)
+}
+
+func (p *Postgres) audit(ctx context.Context, e AuditEntry) error {
+	_, err := p.q.ExecContext(ctx,
+		"INSERT INTO audit_log(actor, action, user_id, detail) VALUES($1, $2, $3, $4)",
+		e.Actor, e.Action, e.UserID, e.Detail,
+	)
+	return err
+}
+
+// WithTx retries the whole transaction when it fails for a transient
+// reason, so fn may run more than once.
+func (p *Postgres) WithTx(ctx context.Context, fn func(repo UserRepository) error) error {
+	if p.inTx {
+		return fn(p)
+	}
+	return p.call(ctx, "transaction", func() error { return p.withTx(ctx, fn) })
+}
+
+func (p *Postgres) withTx(ctx context.Context, fn func(repo UserRepository) error) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Also rolls back when fn panics; after Commit it is a no-op
+	defer tx.Rollback()
+
+	if err := fn(p.InTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// InTx is the repository inside tx, a transaction begun on the same
+// database by code running statements of its own next to repository calls;
+// that code commits or rolls back tx itself. WithTx on the result joins tx.
+func (p *Postgres) InTx(tx *sql.Tx) *Postgres {
+	return &Postgres{db: p.db, q: tx, inTx: true, observe: p.observe, read: p.read, retries: p.retries, metrics: p.metrics}
+}
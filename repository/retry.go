@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// RetryPolicy is how Postgres retries calls that fail for a transient
+// reason: a serialization failure or deadlock, which Postgres rolled back,
+// or a connection problem before the query reached the server. A call is
+// never retried once its outcome is unknown, such as a connection lost
+// while a commit was in flight.
+type RetryPolicy struct {
+	// MaxAttempts counts the first one; 1 or less disables retries.
+	MaxAttempts int
+	// Each retry waits a random time up to BaseDelay, doubling per retry up
+	// to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// BudgetRatio is the share of calls that may be retries, so a database
+	// that keeps failing isn't hit with every call several times over. A
+	// burst of retryBudgetMax retries is allowed after a quiet period.
+	BudgetRatio float64
+}
+
+// retryBudgetMax is the most retries the budget saves up.
+const retryBudgetMax = 10
+
+// Postgres SQLSTATEs worth retrying.
+const (
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+)
+
+// isTransient reports whether err is worth retrying.
+func isTransient(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == pgSerializationFailure || pgErr.Code == pgDeadlockDetected
+	}
+	return pgconn.SafeToRetry(err)
+}
+
+// retrier applies a RetryPolicy, keeping the budget it shares across every
+// call of a repository.
+type retrier struct {
+	policy RetryPolicy
+	mu     sync.Mutex
+	tokens float64
+}
+
+// Retry makes p retry transient failures by policy. Calls inside WithTx
+// run once, since WithTx retries the whole transaction.
+func (p *Postgres) Retry(policy RetryPolicy) *Postgres {
+	if policy.MaxAttempts > 1 {
+		p.retries = &retrier{policy: policy, tokens: retryBudgetMax}
+	}
+	return p
+}
+
+// retry runs op, again while it fails for a transient reason and the
+// policy and budget allow.
+func (p *Postgres) retry(ctx context.Context, op func() error) error {
+	r := p.retries
+	if r == nil || p.inTx {
+		return op()
+	}
+	delay := r.policy.BaseDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !isTransient(err) {
+			r.earn()
+			return err
+		}
+		if attempt >= r.policy.MaxAttempts || !r.spend() {
+			return err
+		}
+		wait := time.Duration(rand.Int64N(int64(delay) + 1))
+		slog.DebugContext(ctx, "retrying transient database error", "attempt", attempt, "retry_in", wait, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		delay = min(delay*2, r.policy.MaxDelay)
+	}
+}
+
+// call runs op as the call named query, timing it and retrying it by the
+// policy.
+func (p *Postgres) call(ctx context.Context, query string, op func() error) error {
+	start := time.Now()
+	err := p.retry(ctx, op)
+	p.metrics.observe(query, start)
+	return err
+}
+
+// earn credits the budget for a call that needed no more retries.
+func (r *retrier) earn() {
+	r.mu.Lock()
+	r.tokens = min(r.tokens+r.policy.BudgetRatio, retryBudgetMax)
+	r.mu.Unlock()
+}
+
+// spend takes a retry from the budget, reporting whether there was one.
+func (r *retrier) spend() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+func (p *Postgres) Create(ctx context.Context, u NewUser) (user *pb.User, err error) {
+	err = p.call(ctx, "create", func() error {
+		user, err = p.create(ctx, u)
+		return err
+	})
+	return user, err
+}
+
+func (p *Postgres) SetPassword(ctx context.Context, id int32, hash string) error {
+	return p.call(ctx, "set_password", func() error { return p.setPassword(ctx, id, hash) })
+}
+
+func (p *Postgres) Get(ctx context.Context, id int32) (user *pb.User, err error) {
+	err = p.call(ctx, "get", func() error {
+		user, err = p.get(ctx, id)
+		return err
+	})
+	return user, err
+}
+
+func (p *Postgres) Update(ctx context.Context, id int32, name, email string, expectedVersion int32) (user *pb.User, err error) {
+	err = p.call(ctx, "update", func() error {
+		user, err = p.update(ctx, id, name, email, expectedVersion)
+		return err
+	})
+	return user, err
+}
+
+func (p *Postgres) Delete(ctx context.Context, id int32) error {
+	return p.call(ctx, "delete", func() error { return p.delete(ctx, id) })
+}
+
+func (p *Postgres) List(ctx context.Context, opts ListOptions) (users []*pb.User, err error) {
+	err = p.call(ctx, "list", func() error {
+		users, err = p.list(ctx, opts)
+		return err
+	})
+	return users, err
+}
+
+func (p *Postgres) Audit(ctx context.Context, e AuditEntry) error {
+	return p.call(ctx, "audit", func() error { return p.audit(ctx, e) })
+}
@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLite is the UserRepository backed by the users table of
+// db.SQLiteSchema, so the account RPCs run from a single file without a
+// database server. Open its database with db.ConnectSQLite.
+type SQLite struct {
+	db      *sql.DB
+	q       querier // db, or the transaction inside WithTx
+	inTx    bool
+	observe QueryObserver
+}
+
+// NewSQLite returns a repository using db. observe may be nil.
+func NewSQLite(db *sql.DB, observe QueryObserver) *SQLite {
+	return &SQLite{db: db, q: db, observe: observe}
+}
+
+var _ UserRepository = (*SQLite)(nil)
+
+func isUniqueConstraint(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+func (s *SQLite) Create(ctx context.Context, u NewUser) (*pb.User, error) {
+	u.Email = normalizeEmail(u.Email)
+	var id int32
+	err := s.q.QueryRowContext(ctx,
+		"INSERT INTO users(name, email, role) VALUES(?, ?, ?) RETURNING id",
+		u.Name, u.Email, u.Role,
+	).Scan(&id)
+	if err != nil {
+		if isUniqueConstraint(err) {
+			return nil, ErrDuplicateEmail
+		}
+		return nil, err
+	}
+	return &pb.User{
+		Id:      id,
+		Name:    u.Name,
+		Email:   u.Email,
+		Role:    u.Role,
+		Status:  pb.UserStatus_USER_STATUS_ACTIVE,
+		Version: 1,
+	}, nil
+}
+
+func (s *SQLite) SetPassword(ctx context.Context, id int32, hash string) error {
+	return s.execOne(ctx, "UPDATE users SET password=? WHERE id=? AND deleted_at IS NULL", hash, id)
+}
+
+func (s *SQLite) Get(ctx context.Context, id int32) (*pb.User, error) {
+	user, err := scanUser(s.q.QueryRowContext(ctx,
+		`SELECT u.id, u.name, u.email, u.role, u.status, u.version
+		 FROM users src JOIN users u ON u.id = COALESCE(src.merged_into, src.id)
+		 WHERE src.id=? AND u.deleted_at IS NULL`,
+		id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return user, err
+}
+
+func (s *SQLite) Update(ctx context.Context, id int32, name, email string, expectedVersion int32) (*pb.User, error) {
+	email = normalizeEmail(email)
+	user, err := scanUser(s.q.QueryRowContext(ctx,
+		`UPDATE users SET name=?, email=?, version=version+1 WHERE id=? AND version=? AND deleted_at IS NULL
+		 RETURNING id, name, email, role, status, version`,
+		name, email, id, expectedVersion,
+	))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// Either the user is gone or someone else got there first
+		var exists bool
+		if err := s.q.QueryRowContext(ctx,
+			"SELECT EXISTS(SELECT 1 FROM users WHERE id=? AND deleted_at IS NULL)", id,
+		).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, ErrVersionMismatch
+		}
+		return nil, ErrNotFound
+	case isUniqueConstraint(err):
+		return nil, ErrDuplicateEmail
+	}
+	return user, err
+}
+
+func (s *SQLite) Delete(ctx context.Context, id int32) error {
+	return s.execOne(ctx, "DELETE FROM users WHERE id=?", id)
+}
+
+// execOne runs a statement that should touch exactly one user, returning
+// ErrNotFound if it touched none.
+func (s *SQLite) execOne(ctx context.Context, query string, args ...interface{}) error {
+	result, err := s.q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) List(ctx context.Context, opts ListOptions) ([]*pb.User, error) {
+	var rows *sql.Rows
+	var err error
+	if opts.Status == pb.UserStatus_USER_STATUS_UNSPECIFIED {
+		rows, err = s.query(ctx, "list", "",
+			"SELECT id, name, email, role, status, version FROM users WHERE deleted_at IS NULL ORDER BY id LIMIT ? OFFSET ?",
+			opts.Limit, opts.Offset,
+		)
+	} else {
+		rows, err = s.query(ctx, "list_by_status", "",
+			"SELECT id, name, email, role, status, version FROM users WHERE deleted_at IS NULL AND status=? ORDER BY id LIMIT ? OFFSET ?",
+			StatusToDB(opts.Status), opts.Limit, opts.Offset,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*pb.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("reading user: %w", err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s *SQLite) query(ctx context.Context, shape, hint, query string, args ...interface{}) (*sql.Rows, error) {
+	if s.observe != nil {
+		s.observe(ctx, shape, hint, query, args)
+	}
+	return s.q.QueryContext(ctx, query, args...)
+}
+
+func (s *SQLite) Audit(ctx context.Context, e AuditEntry) error {
+	_, err := s.q.ExecContext(ctx,
+		"INSERT INTO audit_log(actor, action, user_id, detail) VALUES(?, ?, ?, ?)",
+		e.Actor, e.Action, e.UserID, e.Detail,
+	)
+	return err
+}
+
+func (s *SQLite) WithTx(ctx context.Context, fn func(repo UserRepository) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Also rolls back when fn panics; after Commit it is a no-op
+	defer tx.Rollback()
+
+	if err := fn(&SQLite{db: s.db, q: tx, inTx: true, observe: s.observe}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,93 @@
+// Package repository is the storage behind the user service's handlers. The
+// handlers only see UserRepository; Postgres is the production backend.
+// MySQL and MongoDB are alternatives for the account data, and SQLite one
+// for demos and tests.
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+)
+
+var (
+	// ErrNotFound means no live user has the requested id.
+	ErrNotFound = errors.New("repository: user not found")
+	// ErrDuplicateEmail means another user already has the email.
+	ErrDuplicateEmail = errors.New("repository: email already in use")
+	// ErrVersionMismatch means the user changed after the expected version
+	// was read.
+	ErrVersionMismatch = errors.New("repository: user was modified concurrently")
+)
+
+// UserRepository stores user accounts. Implementations return ErrNotFound,
+// ErrDuplicateEmail and ErrVersionMismatch (possibly wrapped) for those
+// conditions, and other errors only for backend failures.
+type UserRepository interface {
+	// Create stores a new active user and returns it with its id. The user
+	// can't log in until SetPassword is called.
+	Create(ctx context.Context, u NewUser) (*pb.User, error)
+	// SetPassword stores the password hash a user logs in with.
+	SetPassword(ctx context.Context, id int32, hash string) error
+	// Get returns the user with id. An id that was merged into another
+	// account resolves to the surviving account, so the result's id can
+	// differ from the one asked for.
+	Get(ctx context.Context, id int32) (*pb.User, error)
+	// Update changes a user's name and email if the user is still at
+	// expectedVersion, and returns the stored row with its new version.
+	Update(ctx context.Context, id int32, name, email string, expectedVersion int32) (*pb.User, error)
+	// Delete removes a user permanently.
+	Delete(ctx context.Context, id int32) error
+	// List returns live users in id order.
+	List(ctx context.Context, opts ListOptions) ([]*pb.User, error)
+	// Audit appends an entry to the audit log.
+	Audit(ctx context.Context, e AuditEntry) error
+	// WithTx runs fn with a repository whose calls share one transaction. It
+	// commits if fn returns nil and rolls back otherwise, returning fn's
+	// error. Called on the repository fn was given, it joins the running
+	// transaction.
+	WithTx(ctx context.Context, fn func(repo UserRepository) error) error
+}
+
+// NewUser is the input to Create.
+type NewUser struct {
+	Name  string
+	Email string
+	Role  string
+}
+
+// AuditEntry is one row of the audit log. Actor is the email of whoever
+// made the change; UserID is the account it was made to.
+type AuditEntry struct {
+	Actor  string
+	Action string
+	UserID int32
+	Detail string
+}
+
+// ListOptions selects a page of users. Status USER_STATUS_UNSPECIFIED means
+// any status.
+type ListOptions struct {
+	Status pb.UserStatus
+	Limit  int32
+	Offset int32
+}
+
+// normalizeEmail lowercases and trims email before it is stored, like the
+// service does, so every backend's unique email index is case-insensitive
+// whoever calls it.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// The DB stores the bare status name ("ACTIVE", "SUSPENDED") rather than the
+// prefixed proto enum name.
+func StatusToDB(s pb.UserStatus) string {
+	return strings.TrimPrefix(s.String(), "USER_STATUS_")
+}
+
+func StatusFromDB(s string) pb.UserStatus {
+	return pb.UserStatus(pb.UserStatus_value["USER_STATUS_"+s])
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Users also have a UUID, the id of the v2 API; the serial id remains the
+// key of everything else. IDOf and UUIDsOf translate between the two, and
+// read from the primary so a user just created is found.
+
+// newUUID is the UUID of a new user, a v7 so they sort by creation time.
+func newUUID() (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", fmt.Errorf("generating user uuid: %w", err)
+	}
+	return id.String(), nil
+}
+
+// IDOf returns the serial id of the user with the UUID id, including
+// deleted and merged users, which Get then resolves.
+func (p *Postgres) IDOf(ctx context.Context, id string) (serial int32, err error) {
+	err = p.call(ctx, "id_of", func() error {
+		err := p.q.QueryRowContext(ctx, "SELECT id FROM users WHERE uuid=$1", id).Scan(&serial)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	})
+	return serial, err
+}
+
+// UUIDsOf returns the UUIDs of the users with ids. Ids of no user are
+// missing from the map.
+func (p *Postgres) UUIDsOf(ctx context.Context, ids []int32) (uuids map[int32]string, err error) {
+	err = p.call(ctx, "uuids_of", func() error {
+		rows, err := p.q.QueryContext(ctx, "SELECT id, uuid FROM users WHERE id = ANY($1)", ids)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		uuids = make(map[int32]string, len(ids))
+		for rows.Next() {
+			var id int32
+			var u string
+			if err := rows.Scan(&id, &u); err != nil {
+				return fmt.Errorf("reading user uuid: %w", err)
+			}
+			uuids[id] = u
+		}
+		return rows.Err()
+	})
+	return uuids, err
+}
@@ -0,0 +1,173 @@
+// Package sdk is the Go client for UserService.
+package sdk
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// Client is a UserService client bound to one connection.
+type Client struct {
+	pb.UserServiceClient
+	conn *grpc.ClientConn
+}
+
+type config struct {
+	insecure    bool
+	caFile      string
+	serverName  string
+	certFile    string
+	keyFile     string
+	noProxy     bool
+	balancer    string
+	region      string
+	credentials CredentialProvider
+	login       *loginProvider
+	metadata    metadata.MD
+	dialOptions []grpc.DialOption
+}
+
+// Option configures New.
+type Option func(*config)
+
+// WithInsecure disables TLS, e.g. for a local server on localhost.
+func WithInsecure() Option {
+	return func(c *config) { c.insecure = true }
+}
+
+// WithCAFile verifies the server against the PEM CA bundle at path instead of
+// the system roots.
+func WithCAFile(path string) Option {
+	return func(c *config) { c.caFile = path }
+}
+
+// WithServerName overrides the name checked against the server certificate.
+func WithServerName(name string) Option {
+	return func(c *config) { c.serverName = name }
+}
+
+// WithClientCertificate presents the PEM certificate and key to the server,
+// for servers that require mutual TLS.
+func WithClientCertificate(certFile, keyFile string) Option {
+	return func(c *config) { c.certFile, c.keyFile = certFile, keyFile }
+}
+
+// WithNoProxy ignores HTTPS_PROXY/NO_PROXY. By default the proxy settings
+// from the environment are honoured.
+func WithNoProxy() Option {
+	return func(c *config) { c.noProxy = true }
+}
+
+// WithLoadBalancing sets the load-balancing policy, e.g. "round_robin" to
+// spread calls over every address a dns:/// target resolves to. The default
+// is gRPC's pick_first.
+func WithLoadBalancing(policy string) Option {
+	return func(c *config) { c.balancer = policy }
+}
+
+// WithDialOptions passes extra options straight to grpc.NewClient.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(c *config) { c.dialOptions = append(c.dialOptions, opts...) }
+}
+
+// New creates a client for target, which can be "host:port", any gRPC
+// target URI such as "dns:///users.internal:50051", or a list of
+// region=host:port entries ("eu-west-1=users-eu:50051,us-east-1=users-us:50051")
+// used in WithRegion preference order. TLS with the system roots is used
+// unless WithInsecure is given.
+func New(target string, opts ...Option) (*Client, error) {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	transportCreds, err := transportCredentials(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(transportCreds)}
+	eps, isList, err := parseEndpoints(target)
+	if err != nil {
+		return nil, err
+	}
+	if isList {
+		var resolverOpt grpc.DialOption
+		target, resolverOpt = regionalResolver(eps, cfg.region)
+		dialOpts = append(dialOpts, resolverOpt)
+	}
+	if cfg.noProxy {
+		dialOpts = append(dialOpts, grpc.WithNoProxy())
+	}
+	if cfg.balancer != "" {
+		dialOpts = append(dialOpts, grpc.WithDefaultServiceConfig(
+			fmt.Sprintf(`{"loadBalancingConfig": [{%q: {}}]}`, cfg.balancer)))
+	}
+	if len(cfg.metadata) > 0 {
+		dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(metadataInterceptor(cfg.metadata)))
+	}
+	creds := cfg.credentials
+	if cfg.login != nil {
+		creds = cfg.login
+	}
+	if creds != nil {
+		dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(credentialsInterceptor(creds)))
+	}
+	dialOpts = append(dialOpts, cfg.dialOptions...)
+
+	conn, err := grpc.NewClient(target, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("sdk: create client for %s: %w", target, err)
+	}
+	client := pb.NewUserServiceClient(conn)
+	if cfg.login != nil {
+		cfg.login.client = client
+	}
+	return &Client{UserServiceClient: client, conn: conn}, nil
+}
+
+func transportCredentials(cfg config) (credentials.TransportCredentials, error) {
+	if cfg.insecure {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsCfg := &tls.Config{ServerName: cfg.serverName, MinVersion: tls.VersionTLS12}
+	if cfg.caFile != "" {
+		pem, err := os.ReadFile(cfg.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("sdk: read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("sdk: no certificates found in %s", cfg.caFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.certFile, cfg.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("sdk: load client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(tlsCfg), nil
+}
+
+// Conn exposes the underlying connection, e.g. for health checks.
+func (c *Client) Conn() *grpc.ClientConn {
+	return c.conn
+}
+
+// Close tears down the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,113 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// CredentialProvider supplies bearer tokens for outgoing calls.
+type CredentialProvider interface {
+	// Token returns the token to attach to the next call.
+	Token(ctx context.Context) (string, error)
+	// Refresh is called after the server answered UNAUTHENTICATED and must
+	// return a new token. Returning ErrCannotRefresh disables the retry.
+	Refresh(ctx context.Context) (string, error)
+}
+
+// ErrCannotRefresh is returned by providers that have no way to obtain a new token.
+var ErrCannotRefresh = errors.New("sdk: credentials cannot be refreshed")
+
+// WithCredentials attaches a token from p to every call. When a call fails
+// with UNAUTHENTICATED (e.g. the token expired) the token is refreshed and the
+// call retried once.
+func WithCredentials(p CredentialProvider) Option {
+	return func(c *config) { c.credentials = p }
+}
+
+// WithLogin logs in with email/password on first use and again whenever the
+// token is rejected, so long-running callers survive token expiry.
+func WithLogin(email, password string) Option {
+	return func(c *config) { c.login = &loginProvider{email: email, password: password} }
+}
+
+// StaticToken always returns the same token and cannot be refreshed.
+type StaticToken string
+
+func (t StaticToken) Token(context.Context) (string, error)   { return string(t), nil }
+func (t StaticToken) Refresh(context.Context) (string, error) { return "", ErrCannotRefresh }
+
+// loginProvider obtains tokens from the Login RPC over the client's own
+// connection. Login itself is never intercepted (see credentialsInterceptor).
+type loginProvider struct {
+	email    string
+	password string
+	client   pb.UserServiceClient
+
+	mu    sync.Mutex
+	token string
+}
+
+func (l *loginProvider) Token(ctx context.Context) (string, error) {
+	l.mu.Lock()
+	token := l.token
+	l.mu.Unlock()
+	if token != "" {
+		return token, nil
+	}
+	return l.Refresh(ctx)
+}
+
+func (l *loginProvider) Refresh(ctx context.Context) (string, error) {
+	res, err := l.client.Login(ctx, &pb.LoginRequest{Email: l.email, Password: l.password})
+	if err != nil {
+		return "", err
+	}
+	l.mu.Lock()
+	l.token = res.Token
+	l.mu.Unlock()
+	return res.Token, nil
+}
+
+const loginMethod = "/user.UserService/Login"
+
+func credentialsInterceptor(p CredentialProvider) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		// Login must not need a token, and callers may set their own
+		if method == loginMethod || hasAuthorization(ctx) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		token, err := p.Token(ctx)
+		if err != nil {
+			return status.Errorf(codes.Unauthenticated, "sdk: get credentials: %v", err)
+		}
+		err = invoker(withToken(ctx, token), method, req, reply, cc, opts...)
+		if status.Code(err) != codes.Unauthenticated {
+			return err
+		}
+
+		token, refreshErr := p.Refresh(ctx)
+		if refreshErr != nil {
+			// Surface the original UNAUTHENTICATED, not the refresh failure
+			return err
+		}
+		return invoker(withToken(ctx, token), method, req, reply, cc, opts...)
+	}
+}
+
+func withToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+func hasAuthorization(ctx context.Context) bool {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	return ok && len(md.Get("authorization")) > 0
+}
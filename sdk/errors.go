@@ -0,0 +1,51 @@
+package sdk
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+// Reasons the server reports in a google.rpc.ErrorInfo detail. They are
+// stable, unlike error messages.
+const (
+	ReasonInvalidArgument = "INVALID_ARGUMENT"
+	ReasonUserNotFound    = "USER_NOT_FOUND"
+	ReasonEmailTaken      = "EMAIL_TAKEN"
+	// ReasonAdminRequired is no longer sent; servers that check
+	// permissions send ReasonPermissionRequired instead.
+	ReasonAdminRequired = "ADMIN_REQUIRED"
+	// ReasonPermissionRequired carries the missing permission in the
+	// ErrorInfo's "permission" metadata.
+	ReasonPermissionRequired = "PERMISSION_REQUIRED"
+	ReasonVersionMismatch    = "VERSION_MISMATCH"
+	// ReasonEmailNotVerified is Login refusing an account whose email
+	// isn't verified yet; the address is in the "email" metadata.
+	ReasonEmailNotVerified = "EMAIL_NOT_VERIFIED"
+)
+
+// ErrorInfo returns the ErrorInfo detail of a call's error, or nil if it has
+// none:
+//
+//	if info := sdk.ErrorInfo(err); info != nil && info.Reason == sdk.ReasonEmailTaken {
+//		// offer to log in instead
+//	}
+func ErrorInfo(err error) *errdetails.ErrorInfo {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info
+		}
+	}
+	return nil
+}
+
+// FieldViolations returns the invalid fields of an INVALID_ARGUMENT error.
+// Each has the field name, a reason such as "REQUIRED" or "INVALID_EMAIL"
+// and a description fit for showing next to the field.
+func FieldViolations(err error) []*errdetails.BadRequest_FieldViolation {
+	for _, d := range status.Convert(err).Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			return br.FieldViolations
+		}
+	}
+	return nil
+}
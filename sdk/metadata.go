@@ -0,0 +1,69 @@
+package sdk
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Metadata keys understood by the server-side interceptors.
+const (
+	TenantKey        = "x-tenant-id"
+	RequestSourceKey = "x-request-source"
+	APIVersionKey    = "x-api-version"
+	// ClientRegionKey is set by WithRegion so servers can tell
+	// cross-region traffic apart.
+	ClientRegionKey = "x-client-region"
+)
+
+// WithMetadata sends the given key/value pairs on every call. Keys set for a
+// single call with CallMetadata take precedence. Like metadata.Pairs it panics
+// on an odd number of arguments.
+func WithMetadata(kv ...string) Option {
+	md := metadata.Pairs(kv...)
+	return func(c *config) { c.metadata = metadata.Join(c.metadata, md) }
+}
+
+// WithTenant tags every call with the tenant it is made on behalf of.
+func WithTenant(tenant string) Option {
+	return WithMetadata(TenantKey, tenant)
+}
+
+// WithRequestSource names the calling service, e.g. "billing-worker".
+func WithRequestSource(source string) Option {
+	return WithMetadata(RequestSourceKey, source)
+}
+
+// WithAPIVersion pins the API version the caller was written against.
+func WithAPIVersion(version string) Option {
+	return WithMetadata(APIVersionKey, version)
+}
+
+// CallMetadata returns a context whose calls carry the given key/value pairs,
+// replacing any client-wide default for the same key:
+//
+//	ctx = sdk.CallMetadata(ctx, sdk.TenantKey, "acme")
+//	client.GetUser(ctx, req)
+func CallMetadata(ctx context.Context, kv ...string) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	for k, v := range metadata.Pairs(kv...) {
+		md[k] = v
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// metadataInterceptor fills in the defaults for keys the call didn't set.
+func metadataInterceptor(defaults metadata.MD) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		md = md.Copy()
+		for k, v := range defaults {
+			if len(md[k]) == 0 {
+				md[k] = v
+			}
+		}
+		return invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,74 @@
+package sdk
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+// WithRegion sets the caller's region. With a region list target (see New)
+// servers in this region are tried first; the others are only used while no
+// same-region server is reachable. The region is also sent with every call
+// as ClientRegionKey.
+func WithRegion(region string) Option {
+	tag := WithMetadata(ClientRegionKey, region)
+	return func(c *config) {
+		c.region = region
+		tag(c)
+	}
+}
+
+// endpoint is one entry of a "region=host:port,..." target.
+type endpoint struct {
+	region string
+	addr   string
+}
+
+// parseEndpoints splits a region list target. ok is false for ordinary
+// targets, which contain no "=".
+func parseEndpoints(target string) (eps []endpoint, ok bool, err error) {
+	if !strings.Contains(target, "=") {
+		return nil, false, nil
+	}
+	for _, entry := range strings.Split(target, ",") {
+		region, addr, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || region == "" || addr == "" {
+			return nil, true, fmt.Errorf("sdk: bad target entry %q, want region=host:port", entry)
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, true, fmt.Errorf("sdk: bad address in target entry %q: %w", entry, err)
+		}
+		eps = append(eps, endpoint{region: region, addr: addr})
+	}
+	return eps, true, nil
+}
+
+// byRegion puts the endpoints in region first, keeping the listed order
+// otherwise.
+func byRegion(eps []endpoint, region string) []endpoint {
+	sorted := append([]endpoint(nil), eps...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].region == region && sorted[j].region != region
+	})
+	return sorted
+}
+
+// regionalResolver serves a fixed address list in preference order. With
+// gRPC's default pick_first policy the client connects to the first address
+// that answers, which gives same-region preference with cross-region
+// failover; a policy such as round_robin spreads calls over every region.
+func regionalResolver(eps []endpoint, region string) (target string, opt grpc.DialOption) {
+	var addrs []resolver.Address
+	for _, ep := range byRegion(eps, region) {
+		host, _, _ := net.SplitHostPort(ep.addr)
+		addrs = append(addrs, resolver.Address{Addr: ep.addr, ServerName: host})
+	}
+	r := manual.NewBuilderWithScheme("sdk-regions")
+	r.InitialState(resolver.State{Addresses: addrs})
+	return r.Scheme() + ":///users", grpc.WithResolvers(r)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"reflect"
+	"sort"
+
+	"grpc-crud-proj/middleware"
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// auditTrail records every call to a method not marked read_only in the
+// API policy in audit_events: who made it, what it targeted, how it ended and how the
+// target's users row changed. The row is read before and after the call,
+// so the diff covers whatever the handler touched, but a concurrent write
+// to the same user can show up in it too. Impersonate and every call made
+// with an impersonation token are recorded even when they only read, with
+// an empty diff.
+type auditTrail struct {
+	db       *sql.DB
+	readOnly *readOnlyMode
+	report   func(error)
+}
+
+// Requests naming the user they act on. MergeUsers is recorded against its
+// surviving account.
+type (
+	idRequest       interface{ GetId() int32 }
+	userIDRequest   interface{ GetUserId() int32 }
+	targetIDRequest interface{ GetTargetId() int32 }
+	userResponse    interface{ GetUser() *pb.User }
+)
+
+func (a *auditTrail) interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	claims, _ := middleware.ClaimsFromContext(ctx)
+	reads := a.readOnly.readOnlyMethod(info.FullMethod)
+	impersonating := claims != nil && claims.ActAs != "" || info.FullMethod == pb.UserService_Impersonate_FullMethodName
+	if reads && !impersonating || a.readOnly.on() {
+		return handler(ctx, req)
+	}
+	target := a.target(ctx, req, claims)
+	var before map[string]interface{}
+	var err error
+	if !reads {
+		if before, err = a.snapshot(ctx, target); err != nil {
+			slog.WarnContext(ctx, "audit: failed to read user before the call", "user_id", target, "error", err)
+		}
+	}
+
+	resp, callErr := handler(ctx, req)
+
+	// Register and CreateUser only know their target once it exists
+	if r, ok := resp.(userResponse); ok && r.GetUser() != nil && r.GetUser().GetId() != target {
+		target, before = r.GetUser().GetId(), nil
+	}
+	var diff map[string]interface{}
+	if callErr == nil && !reads {
+		after, err := a.snapshot(context.WithoutCancel(ctx), target)
+		if err != nil {
+			slog.WarnContext(ctx, "audit: failed to read user after the call", "user_id", target, "error", err)
+		}
+		diff = auditDiff(before, after)
+	}
+	err = a.record(context.WithoutCancel(ctx), info.FullMethod, claims, target, status.Code(callErr).String(), diff)
+	a.report(err)
+	if err != nil {
+		slog.ErrorContext(ctx, "audit: failed to record event", "error", err)
+	}
+	return resp, callErr
+}
+
+// target is the user req acts on, or the caller for self-service calls.
+func (a *auditTrail) target(ctx context.Context, req interface{}, claims *middleware.Claims) int32 {
+	switch r := req.(type) {
+	case targetIDRequest:
+		return r.GetTargetId()
+	case idRequest:
+		return r.GetId()
+	case userIDRequest:
+		return r.GetUserId()
+	}
+	if claims == nil {
+		return 0
+	}
+	var id int32
+	err := a.db.QueryRowContext(ctx,
+		"SELECT id FROM users WHERE email=$1 AND deleted_at IS NULL", claims.EffectiveEmail(),
+	).Scan(&id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.WarnContext(ctx, "audit: failed to look up caller", "error", err)
+	}
+	return id
+}
+
+// snapshot is the users row for id as a JSON object, nil if there is none.
+func (a *auditTrail) snapshot(ctx context.Context, id int32) (map[string]interface{}, error) {
+	if id == 0 {
+		return nil, nil
+	}
+	var raw []byte
+	err := a.db.QueryRowContext(ctx, "SELECT to_jsonb(u) FROM users u WHERE id=$1", id).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var row map[string]interface{}
+	return row, json.Unmarshal(raw, &row)
+}
+
+// auditDiff lists the columns that changed as {"before": ..., "after": ...}.
+// The password hash is never copied; a change only shows as "changed".
+func auditDiff(before, after map[string]interface{}) map[string]interface{} {
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	diff := map[string]interface{}{}
+	for _, k := range names {
+		b, inBefore := before[k]
+		c, inAfter := after[k]
+		if inBefore == inAfter && reflect.DeepEqual(b, c) {
+			continue
+		}
+		if k == "password" {
+			diff[k] = map[string]bool{"changed": true}
+			continue
+		}
+		change := map[string]interface{}{}
+		if inBefore {
+			change["before"] = b
+		}
+		if inAfter {
+			change["after"] = c
+		}
+		diff[k] = change
+	}
+	return diff
+}
+
+func (a *auditTrail) record(ctx context.Context, method string, claims *middleware.Claims, target int32, code string, diff map[string]interface{}) error {
+	var actor, actingAs string
+	if claims != nil {
+		actor, actingAs = claims.Email, claims.ActAs
+	}
+	if diff == nil {
+		diff = map[string]interface{}{}
+	}
+	raw, err := json.Marshal(diff)
+	if err != nil {
+		return err
+	}
+	requestID, _ := middleware.RequestIDFromContext(ctx)
+	_, err = a.db.ExecContext(ctx,
+		`INSERT INTO audit_events (actor, acting_as, method, user_id, code, request_id, client_ip, diff)
+		 VALUES ($1, $2, $3, NULLIF($4, 0), $5, $6, $7, $8)`,
+		actor, actingAs, method, target, code, requestID, middleware.ClientIP(ctx), raw)
+	return err
+}
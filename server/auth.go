@@ -2,8 +2,11 @@ package main // Changed to main
 
 import "golang.org/x/crypto/bcrypt"
 
+// bcryptCost is auth.bcrypt_cost, the work factor for new password hashes.
+var bcryptCost = 14
+
 func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
 	return string(bytes), err
 }
 
@@ -12,3 +15,10 @@ func checkPassword(password string, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// needsRehash reports whether hash was made with a cost other than
+// bcryptCost, so it should be replaced the next time the password is known.
+func needsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost != bcryptCost
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"grpc-crud-proj/internal/avatar"
+	"grpc-crud-proj/internal/config"
+	"grpc-crud-proj/middleware"
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func avatarPipeline(cfg config.AvatarsConfig) (*avatar.Pipeline, error) {
+	return avatar.New(cfg.Steps, avatar.Options{
+		MaxBytes:       cfg.MaxBytes,
+		MaxWidth:       cfg.MaxWidth,
+		MaxHeight:      cfg.MaxHeight,
+		ContentTypes:   cfg.ContentTypes,
+		ThumbnailSizes: cfg.ThumbnailSizes,
+	})
+}
+
+func (s *server) UploadAvatar(ctx context.Context, req *pb.UploadAvatarRequest) (*pb.Avatar, error) {
+	claims, ok := middleware.ClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "missing credentials")
+	}
+	img, err := s.avatars.Run(ctx, req.Data)
+	if errors.Is(err, avatar.ErrInvalid) {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to process avatar: %v", err)
+	}
+	var width, height int
+	if img.Decoded != nil {
+		width, height = img.Decoded.Bounds().Dx(), img.Decoded.Bounds().Dy()
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save avatar: %v", err)
+	}
+	defer tx.Rollback()
+
+	var userID int32
+	err = tx.QueryRowContext(ctx,
+		"SELECT id FROM users WHERE email=$1 AND deleted_at IS NULL",
+		claims.EffectiveEmail(),
+	).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to look up user: %v", err)
+	}
+
+	// Replace every rendition at once so sizes from an older config don't linger
+	if _, err := tx.ExecContext(ctx, "DELETE FROM avatars WHERE user_id=$1", userID); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save avatar: %v", err)
+	}
+	insert := "INSERT INTO avatars(user_id, size, content_type, data, width, height) VALUES($1, $2, $3, $4, $5, $6)"
+	if _, err := tx.ExecContext(ctx, insert, userID, 0, img.ContentType, img.Data, width, height); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save avatar: %v", err)
+	}
+	res := &pb.Avatar{UserId: userID, ContentType: img.ContentType, Width: int32(width), Height: int32(height)}
+	for _, thumb := range img.Thumbnails {
+		edge := min(thumb.Size, width, height)
+		if _, err := tx.ExecContext(ctx, insert, userID, thumb.Size, thumb.ContentType, thumb.Data, edge, edge); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to save avatar: %v", err)
+		}
+		res.ThumbnailSizes = append(res.ThumbnailSizes, int32(thumb.Size))
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save avatar: %v", err)
+	}
+	res.UpdatedAt = time.Now().Unix()
+	return res, nil
+}
+
+func (s *server) GetAvatar(ctx context.Context, req *pb.GetAvatarRequest) (*pb.AvatarImage, error) {
+	res := &pb.AvatarImage{}
+	err := s.db.QueryRowContext(ctx,
+		"SELECT content_type, data FROM avatars WHERE user_id=$1 AND size=$2",
+		req.Id, req.Size,
+	).Scan(&res.ContentType, &res.Data)
+	if errors.Is(err, sql.ErrNoRows) {
+		if req.Size != 0 {
+			return nil, status.Errorf(codes.NotFound, "user %d has no %dpx avatar", req.Id, req.Size)
+		}
+		return nil, status.Errorf(codes.NotFound, "user %d has no avatar", req.Id)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load avatar: %v", err)
+	}
+	return res, nil
+}
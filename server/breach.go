@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"grpc-crud-proj/internal/config"
+	"grpc-crud-proj/internal/httpclient"
+	"grpc-crud-proj/service"
+)
+
+// maxBreachResponse bounds a range API answer, a few hundred lines.
+const maxBreachResponse = 1 << 20
+
+// passwordPolicy is cfg as a service.PasswordPolicy, asking the breach
+// check API through client when one is set.
+func passwordPolicy(cfg config.PasswordConfig, client *httpclient.Client) service.PasswordPolicy {
+	p := service.PasswordPolicy{
+		MinLength:     cfg.MinLength,
+		RequireUpper:  cfg.RequireUpper,
+		RequireLower:  cfg.RequireLower,
+		RequireDigit:  cfg.RequireDigit,
+		RequireSymbol: cfg.RequireSymbol,
+	}
+	if cfg.BreachCheckURL != "" {
+		base := strings.TrimSuffix(cfg.BreachCheckURL, "/") + "/"
+		p.Breached = func(ctx context.Context, password string) bool {
+			breached, err := pwnedPassword(ctx, client, base, password)
+			if err != nil {
+				slog.WarnContext(ctx, "cannot check password against breaches; accepting it", "error", err)
+			}
+			return breached
+		}
+	}
+	return p
+}
+
+// pwnedPassword asks a Pwned Passwords range API whether password is known
+// from a breach. Only the first 5 hex digits of its SHA-1 leave the
+// process; the API answers with the suffixes of every hash sharing them.
+func pwnedPassword(ctx context.Context, client *httpclient.Client, base, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+prefix, nil)
+	if err != nil {
+		return false, err
+	}
+	// Padding hides which prefix matched from someone watching the size
+	req.Header.Set("Add-Padding", "true")
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("breach check answered HTTP %d", resp.StatusCode)
+	}
+
+	// Lines are "SUFFIX:COUNT"; padding entries have a count of 0
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxBreachResponse))
+	for scanner.Scan() {
+		s, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(s, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"grpc-crud-proj/internal/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Alert levels stored in capacity_alerts, per dimension.
+const (
+	capacityOK = iota
+	capacityWarn
+	capacityExceeded
+)
+
+var capacityLevelNames = []string{"ok", "warning", "exceeded"}
+
+// capacityMonitor compares the users table with the soft limits in
+// cfg.Capacity. Every instance exports the gauges; the capacity_alerts row
+// makes sure only one of them emails the admins when a level is crossed,
+// and only once per crossing, even across restarts.
+type capacityMonitor struct {
+	srv      *server
+	cfg      config.CapacityConfig
+	rows     prometheus.Gauge
+	bytes    prometheus.Gauge
+	usage    *prometheus.GaugeVec
+	readOnly *readOnlyMode
+}
+
+func newCapacityMonitor(srv *server, cfg config.CapacityConfig, reg prometheus.Registerer) *capacityMonitor {
+	m := &capacityMonitor{
+		srv: srv,
+		cfg: cfg,
+		rows: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "users_table_rows",
+			Help: "Planner estimate of the number of rows in the users table.",
+		}),
+		bytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "users_table_bytes",
+			Help: "Size of the users table including indexes and TOAST.",
+		}),
+		usage: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "users_table_limit_usage_ratio",
+			Help: "Users table size as a fraction of its soft limit (capacity.max_rows / capacity.max_bytes).",
+		}, []string{"dimension"}),
+		readOnly: srv.readOnly,
+	}
+	reg.MustRegister(m.rows, m.bytes, m.usage)
+	return m
+}
+
+func (m *capacityMonitor) run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.CheckInterval.Duration)
+	defer ticker.Stop()
+	for {
+		err := m.check(ctx)
+		m.srv.subsystems.report(subsystemCapacity, err)
+		if err != nil {
+			slog.Error("capacity check failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *capacityMonitor) check(ctx context.Context) error {
+	var rows, size int64
+	// reltuples is a cheap estimate kept up to date by autovacuum; it is -1
+	// before the table was first analyzed
+	err := m.srv.db.QueryRowContext(ctx,
+		"SELECT GREATEST(reltuples, 0)::bigint, pg_total_relation_size(oid) FROM pg_class WHERE oid = 'users'::regclass",
+	).Scan(&rows, &size)
+	if err != nil {
+		return err
+	}
+	m.rows.Set(float64(rows))
+	m.bytes.Set(float64(size))
+
+	for _, d := range []struct {
+		name  string
+		value int64
+		limit int
+		unit  string
+	}{
+		{"rows", rows, m.cfg.MaxRows, "rows"},
+		{"bytes", size, m.cfg.MaxBytes, "bytes"},
+	} {
+		if d.limit <= 0 {
+			continue
+		}
+		ratio := float64(d.value) / float64(d.limit)
+		m.usage.WithLabelValues(d.name).Set(ratio)
+
+		level := capacityOK
+		switch {
+		case ratio >= 1:
+			level = capacityExceeded
+		case ratio >= m.cfg.WarnRatio:
+			level = capacityWarn
+		}
+		if err := m.transition(ctx, "users_"+d.name, level, fmt.Sprintf("%d of %d %s (%.0f%%)", d.value, d.limit, d.unit, ratio*100)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transition records level for dimension and alerts if it went up. The
+// conditional UPDATE lets exactly one instance win each rise.
+func (m *capacityMonitor) transition(ctx context.Context, dimension string, level int, detail string) error {
+	if m.readOnly.on() {
+		return nil
+	}
+	_, err := m.srv.db.ExecContext(ctx,
+		"INSERT INTO capacity_alerts(dimension) VALUES($1) ON CONFLICT (dimension) DO NOTHING",
+		dimension)
+	if err != nil {
+		return err
+	}
+	res, err := m.srv.db.ExecContext(ctx,
+		"UPDATE capacity_alerts SET level = $2, changed_at = now() WHERE dimension = $1 AND level <> $2",
+		dimension, level)
+	if err != nil {
+		return err
+	}
+	changed, err := res.RowsAffected()
+	if err != nil || changed == 0 {
+		return err
+	}
+	slog.Warn("users table capacity level changed", "dimension", dimension, "level", capacityLevelNames[level], "usage", detail)
+	// Going down only resets the level, so the next rise alerts again
+	if level == capacityOK {
+		return nil
+	}
+	return m.alertAdmins(ctx, dimension, level, detail)
+}
+
+// alertAdmins emails every active admin who hasn't opted out of
+// capacity alerts.
+func (m *capacityMonitor) alertAdmins(ctx context.Context, dimension string, level int, detail string) error {
+	rows, err := m.srv.db.QueryContext(ctx,
+		"SELECT id, email FROM users WHERE lower(role) = 'admin' AND status = 'ACTIVE' AND deleted_at IS NULL")
+	if err != nil {
+		return err
+	}
+	type admin struct {
+		id    int32
+		email string
+	}
+	var admins []admin
+	for rows.Next() {
+		var a admin
+		if err := rows.Scan(&a.id, &a.email); err != nil {
+			rows.Close()
+			return err
+		}
+		admins = append(admins, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	subject := "Users table is approaching its capacity limit"
+	if level == capacityExceeded {
+		subject = "Users table has exceeded its capacity limit"
+	}
+	body := fmt.Sprintf("The users table (%s) is at %s of its soft limit. Plan storage and indexes before it becomes a problem.", dimension, detail)
+	for _, a := range admins {
+		if err := m.srv.notify(ctx, a.id, eventCapacityAlert, a.email, subject, body); err != nil {
+			slog.Warn("failed to send capacity alert", "user_id", a.id, "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net"
+	"os"
+
+	"grpc-crud-proj/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// serverFlags maps the server's command-line flags to config keys.
+var serverFlags = map[string]string{
+	"grpc-addr": "server.grpc_addr",
+	"http-addr": "server.http_addr",
+	"db-url":    "database.url",
+}
+
+// loadConfig resolves the defaults, -config (or CONFIG_FILE), environment
+// variables and serverFlags, in that order, sets up logging and exits if the
+// result is unusable.
+func loadConfig(path string, fs *flag.FlagSet) *config.Config {
+	cfg, problems, err := config.Resolve(path)
+	if err != nil {
+		fatal("failed to load config", "error", err)
+	}
+	problems = append(problems, cfg.ApplyFlags(fs, serverFlags)...)
+	// Set up logging first so config problems are logged in the requested format
+	setupLogging(cfg.Log)
+	problems = append(problems, cfg.Validate()...)
+	if len(problems) > 0 {
+		for _, p := range problems {
+			slog.Error("invalid config", "key", p.Key, "problem", p.Message)
+		}
+		os.Exit(1)
+	}
+	return cfg
+}
+
+// applyConfig sets the package-level settings used outside the server struct.
+func applyConfig(cfg *config.Config) {
+	jwtKey = []byte(cfg.Auth.JWTSecret)
+	jwtKeys = map[string][]byte{}
+	jwtSigningMethod, jwtSigningKey, jwtSigningKeyID = jwt.SigningMethodHS256, jwtKey, ""
+	for i, k := range cfg.Auth.Keys() {
+		if i == 0 {
+			jwtSigningKey, jwtSigningKeyID = []byte(k.Secret), k.ID
+		}
+		jwtKeys[k.ID] = []byte(k.Secret)
+	}
+	jwtIssuer, jwtAudience = cfg.Auth.JWTIssuer, cfg.Auth.JWTAudience
+	tokenTTL = cfg.Auth.TokenTTL.Duration
+	impersonationTTL = cfg.Auth.ImpersonationTTL.Duration
+	bcryptCost = cfg.Auth.BcryptCost
+	requireVerifiedEmail = cfg.Auth.RequireVerifiedEmail
+	refreshTokenTTL = cfg.Auth.RefreshTokenTTL.Duration
+	appBaseURL = cfg.Mail.AppBaseURL
+	currentConsentVersions = map[string]string{
+		"terms":   cfg.Consent.TermsVersion,
+		"privacy": cfg.Consent.PrivacyVersion,
+	}
+}
+
+// gatewayTarget is where the gateway dials the gRPC server listening on
+// addr: the same port on localhost unless addr names a specific host.
+func gatewayTarget(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" || net.ParseIP(host).IsUnspecified() {
+		return net.JoinHostPort("localhost", port)
+	}
+	return addr
+}
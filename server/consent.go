@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"grpc-crud-proj/middleware"
+	pb "grpc-crud-proj/proto/google/userpb"
+	"grpc-crud-proj/service"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// currentConsentVersions maps each consent document to the version users must
+// have accepted. Bump consent.terms_version / consent.privacy_version when a
+// new revision is published.
+var currentConsentVersions = map[string]string{
+	"terms":   "v1",
+	"privacy": "v1",
+}
+
+// consentRequiredMethods lists methods the caller may only use after
+// accepting the current version of the given documents.
+var consentRequiredMethods = map[string][]string{
+	"/user.UserService/UpdateUser":        {"terms"},
+	"/user.UserService/SetUserPreference": {"terms"},
+	"/user.v2.UserService/UpdateUser":     {"terms"},
+}
+
+// consentInterceptor rejects calls to consentRequiredMethods with
+// FailedPrecondition until the caller has accepted the current documents.
+// It must run after the auth middleware so the caller's claims are available.
+func consentInterceptor(db *sql.DB) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		kinds := consentRequiredMethods[info.FullMethod]
+		if len(kinds) == 0 {
+			return handler(ctx, req)
+		}
+
+		claims, ok := middleware.ClaimsFromContext(ctx)
+		if !ok {
+			return nil, status.Errorf(codes.Unauthenticated, "missing credentials")
+		}
+
+		for _, kind := range kinds {
+			version := currentConsentVersions[kind]
+			var accepted bool
+			err := db.QueryRowContext(ctx,
+				`SELECT EXISTS(
+				   SELECT 1 FROM consents c JOIN users u ON u.id = c.user_id
+				   WHERE u.email=$1 AND c.kind=$2 AND c.version=$3)`,
+				claims.EffectiveEmail(), kind, version,
+			).Scan(&accepted)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to check consent: %v", err)
+			}
+			if !accepted {
+				return nil, status.Errorf(codes.FailedPrecondition,
+					"you must accept %s version %s before calling this method", kind, version)
+			}
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func (s *server) RecordConsent(ctx context.Context, req *pb.RecordConsentRequest) (*pb.Consent, error) {
+	claims, ok := middleware.ClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "missing credentials")
+	}
+
+	current, known := currentConsentVersions[req.Kind]
+	if !known {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown consent kind %q", req.Kind)
+	}
+	version := req.Version
+	if version == "" {
+		version = current
+	}
+
+	consent := &pb.Consent{Kind: req.Kind, Version: version, Ip: middleware.ClientIP(ctx)}
+	var acceptedAt time.Time
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO consents(user_id, kind, version, ip)
+		 SELECT id, $2, $3, $4 FROM users WHERE email=$1 AND deleted_at IS NULL
+		 RETURNING accepted_at`,
+		claims.EffectiveEmail(), consent.Kind, consent.Version, consent.Ip,
+	).Scan(&acceptedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, status.Errorf(codes.NotFound, "user not found")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to record consent: %v", err)
+	}
+	consent.AcceptedAt = acceptedAt.Unix()
+
+	return consent, nil
+}
+
+func (s *server) GetConsents(ctx context.Context, req *pb.GetConsentsRequest) (*pb.GetConsentsResponse, error) {
+	if err := service.RequirePermission(ctx, service.PermUserRead); err != nil {
+		return nil, serviceStatus(err)
+	}
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT kind, version, accepted_at, ip FROM consents WHERE user_id=$1 ORDER BY accepted_at DESC",
+		req.Id,
+	)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load consents: %v", err)
+	}
+	defer rows.Close()
+
+	res := &pb.GetConsentsResponse{}
+	for rows.Next() {
+		var consent pb.Consent
+		var acceptedAt time.Time
+		if err := rows.Scan(&consent.Kind, &consent.Version, &acceptedAt, &consent.Ip); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to read consent: %v", err)
+		}
+		consent.AcceptedAt = acceptedAt.Unix()
+		res.Consents = append(res.Consents, &consent)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read consents: %v", err)
+	}
+
+	return res, nil
+}
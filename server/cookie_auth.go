@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	accessCookie  = "access_token"
+	refreshCookie = "refresh_token"
+	csrfCookie    = "csrf_token"
+
+	// authModeHeader set to "cookie" on a login or refresh asks for the
+	// tokens as cookies instead of in the body.
+	authModeHeader = "X-Auth-Mode"
+	csrfHeader     = "X-Csrf-Token"
+
+	refreshPath = "/v1/refresh"
+)
+
+// cookieAuth lets browsers hold their tokens in HttpOnly cookies on the
+// gateway, as an alternative to Authorization headers
+// (auth.cookie_auth). Logins and refreshes sent with "X-Auth-Mode: cookie"
+// answer with an access_token cookie, a refresh_token cookie only sent to
+// /v1/refresh, and a csrf_token cookie scripts can read; the tokens are
+// left out of the body. Requests without an Authorization header are then
+// authenticated by the access_token cookie, and every one but GET, HEAD and
+// OPTIONS must echo csrf_token in an X-Csrf-Token header.
+type cookieAuth struct {
+	secure bool
+}
+
+type cookieModeKey struct{}
+
+// cookieMode reports whether the gateway request behind ctx uses cookies.
+func cookieMode(ctx context.Context) bool {
+	on, _ := ctx.Value(cookieModeKey{}).(bool)
+	return on
+}
+
+// handler turns the cookies into what the gateway expects, an
+// Authorization header and, on /v1/refresh, the refresh token in the body.
+func (c cookieAuth) handler(gateway http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		useCookies := r.Header.Get(authModeHeader) == "cookie"
+		if r.Header.Get("Authorization") == "" {
+			access, _ := r.Cookie(accessCookie)
+			refresh, _ := r.Cookie(refreshCookie)
+			refreshing := r.Method == http.MethodPost && r.URL.Path == refreshPath && refresh != nil
+			if access != nil || refreshing {
+				if !safeMethod(r.Method) && !validCSRF(r) {
+					http.Error(w, "missing or wrong "+csrfHeader+" header", http.StatusForbidden)
+					return
+				}
+				useCookies = true
+				r = r.Clone(r.Context())
+				if access != nil {
+					r.Header.Set("Authorization", "Bearer "+access.Value)
+				}
+				if refreshing {
+					body, _ := json.Marshal(map[string]string{"refreshToken": refresh.Value})
+					r.Body, r.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+					r.Header.Set("Content-Type", "application/json")
+				}
+			}
+		}
+		if useCookies {
+			r = r.WithContext(context.WithValue(r.Context(), cookieModeKey{}, true))
+		}
+		gateway.ServeHTTP(w, r)
+	})
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// validCSRF checks the double-submitted CSRF token: another site can make
+// the browser send the cookie but can't read it to copy it into the header.
+func validCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookie)
+	header := r.Header.Get(csrfHeader)
+	return err == nil && cookie.Value != "" &&
+		subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) == 1
+}
+
+// forwardResponse is a gateway forward-response option moving the tokens
+// of a LoginResponse into cookies, and clearing them on Logout, for
+// requests in cookie mode.
+func (c cookieAuth) forwardResponse(ctx context.Context, w http.ResponseWriter, resp proto.Message) error {
+	if !cookieMode(ctx) {
+		return nil
+	}
+	switch resp := resp.(type) {
+	case *pb.LoginResponse:
+		http.SetCookie(w, c.cookie(accessCookie, resp.Token, "/", tokenTTL, true))
+		if resp.RefreshToken != "" {
+			http.SetCookie(w, c.cookie(refreshCookie, resp.RefreshToken, refreshPath, refreshTokenTTL, true))
+		}
+		http.SetCookie(w, c.cookie(csrfCookie, randomToken(), "/", refreshTokenTTL, false))
+		resp.Token, resp.RefreshToken = "", ""
+	case *pb.LogoutResponse:
+		http.SetCookie(w, c.cookie(accessCookie, "", "/", -1, true))
+		http.SetCookie(w, c.cookie(refreshCookie, "", refreshPath, -1, true))
+		http.SetCookie(w, c.cookie(csrfCookie, "", "/", -1, false))
+	}
+	return nil
+}
+
+// cookie is a SameSite=Strict cookie; a negative ttl deletes it.
+func (c cookieAuth) cookie(name, value, path string, ttl time.Duration, httpOnly bool) *http.Cookie {
+	maxAge := int(ttl / time.Second)
+	if ttl < 0 {
+		maxAge = -1
+	}
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
+		HttpOnly: httpOnly,
+		Secure:   c.secure,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// debugMux serves the runtime profiles and expvar counters. It is its own
+// mux rather than http.DefaultServeMux, so nothing else registered there
+// ends up on the debug port by accident.
+func debugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// publishPoolStats adds the database pool's statistics to /debug/vars as
+// db_pool.
+func publishPoolStats(pool *pgxpool.Pool) {
+	expvar.Publish("db_pool", expvar.Func(func() any {
+		s := pool.Stat()
+		return map[string]any{
+			"total_conns":          s.TotalConns(),
+			"idle_conns":           s.IdleConns(),
+			"acquired_conns":       s.AcquiredConns(),
+			"max_conns":            s.MaxConns(),
+			"acquire_count":        s.AcquireCount(),
+			"empty_acquire_count":  s.EmptyAcquireCount(),
+			"canceled_acquires":    s.CanceledAcquireCount(),
+			"acquire_duration_ms":  s.AcquireDuration().Milliseconds(),
+			"new_conns":            s.NewConnsCount(),
+			"max_lifetime_closed":  s.MaxLifetimeDestroyCount(),
+			"max_idle_time_closed": s.MaxIdleDestroyCount(),
+		}
+	}))
+}
+
+// serveDebug runs the debug endpoints on addr (server.debug_addr, which
+// config validation keeps on loopback). A failure is logged, not fatal: the
+// API works without them.
+func serveDebug(addr string) {
+	slog.Info("debug endpoints running", "addr", addr, "pprof", "/debug/pprof/", "expvar", "/debug/vars")
+	if err := http.ListenAndServe(addr, debugMux()); err != nil {
+		slog.Error("failed to serve debug endpoints", "addr", addr, "error", err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path"
+
+	"grpc-crud-proj/db"
+	"grpc-crud-proj/internal/config"
+	pb "grpc-crud-proj/proto/google/userpb"
+	"grpc-crud-proj/repository"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pinger is a database /readyz and the gRPC health service ping; *sql.DB
+// is one, and userStore is one for every driver.
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// userStore is the database users are kept in, picked by database.driver.
+// Only Postgres also has the tables behind logins, sessions, webhooks and
+// the other RPCs, so with any other driver postgres is nil and the server
+// serves repositoryMethods alone.
+type userStore struct {
+	driver   string
+	postgres *db.DB
+	// sql is the database/sql handle of postgres, mysql or sqlite, for
+	// the pool metrics; nil with mongo.
+	sql   *sql.DB
+	mongo *mongo.Database
+}
+
+// openUserStore connects to the database cfg names, retrying while it is
+// unreachable. opts only apply to Postgres.
+func openUserStore(cfg config.DatabaseConfig, opts []db.Option) (*userStore, error) {
+	retry := db.RetryConfig{
+		MaxWait:      cfg.ConnectMaxWait.Duration,
+		RetryBackoff: cfg.ConnectRetryBackoff.Duration,
+		MaxBackoff:   cfg.ConnectMaxBackoff.Duration,
+	}
+	s := &userStore{driver: cfg.Driver}
+	var err error
+	switch cfg.Driver {
+	case "postgres":
+		s.postgres, err = db.ConnectWithRetry(cfg.URL, retry, opts...)
+		if err == nil {
+			s.sql = s.postgres.DB
+		}
+	case "mysql":
+		s.sql, err = db.Retry(retry, "MySQL", func() (*sql.DB, error) { return db.ConnectMySQL(cfg.URL) })
+	case "sqlite":
+		// A file that can't be opened yet, on a volume still being
+		// mounted say, is retried the same way
+		s.sql, err = db.Retry(retry, "SQLite", func() (*sql.DB, error) { return db.ConnectSQLite(cfg.URL) })
+	case "mongo":
+		s.mongo, err = db.Retry(retry, "MongoDB", func() (*mongo.Database, error) {
+			return db.ConnectMongo(context.Background(), cfg.URL)
+		})
+	default:
+		err = fmt.Errorf("unknown database.driver %q", cfg.Driver)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate brings the schema up to date: the migrations in db/migrations on
+// Postgres, the idempotent schema of the driver anywhere else.
+func (s *userStore) migrate(ctx context.Context, url string) error {
+	switch s.driver {
+	case "postgres":
+		return db.Migrate(url)
+	case "mysql":
+		return db.ApplyMySQLSchema(ctx, s.sql)
+	case "sqlite":
+		return db.ApplySQLiteSchema(ctx, s.sql)
+	case "mongo":
+		return db.EnsureMongoIndexes(ctx, s.mongo)
+	}
+	return nil
+}
+
+// repository is a UserRepository on the database. On Postgres, main builds
+// its own with retries, metrics and replicas on top.
+func (s *userStore) repository(observe repository.QueryObserver) repository.UserRepository {
+	switch s.driver {
+	case "mysql":
+		return repository.NewMySQL(s.sql, observe)
+	case "sqlite":
+		return repository.NewSQLite(s.sql, observe)
+	case "mongo":
+		return repository.NewMongo(s.mongo)
+	}
+	return repository.NewPostgres(s.sql, observe)
+}
+
+func (s *userStore) PingContext(ctx context.Context) error {
+	if s.mongo != nil {
+		return s.mongo.Client().Ping(ctx, nil)
+	}
+	return s.sql.PingContext(ctx)
+}
+
+func (s *userStore) Close() error {
+	switch {
+	case s.postgres != nil:
+		return s.postgres.Close()
+	case s.mongo != nil:
+		return s.mongo.Client().Disconnect(context.Background())
+	}
+	return s.sql.Close()
+}
+
+// repositoryMethods are the UserService calls that only need the
+// UserRepository, or nothing stored at all, and so are served whatever
+// database.driver is. Other services, such as gRPC health, are never
+// refused.
+var repositoryMethods = map[string]bool{
+	"/user.UserService/CreateUser":      true,
+	"/user.UserService/GetUser":         true,
+	"/user.UserService/UpdateUser":      true,
+	"/user.UserService/DeleteUser":      true,
+	"/user.UserService/ListUsers":       true,
+	"/user.UserService/Register":        true,
+	"/user.UserService/WatchUsers":      true,
+	"/user.UserService/Introspect":      true,
+	"/user.UserService/GetReadOnlyMode": true,
+	"/user.UserService/SetReadOnlyMode": true,
+	"/user.UserService/ListSubsystems":  true,
+}
+
+// driverGuard refuses the calls that need Postgres tables when users are
+// kept elsewhere, instead of letting them fail on a missing database.
+type driverGuard struct {
+	driver string
+}
+
+func (g driverGuard) check(method string) error {
+	if path.Dir(method) != "/"+pb.UserService_ServiceDesc.ServiceName || repositoryMethods[method] {
+		return nil
+	}
+	return status.Errorf(codes.Unimplemented, "%s needs database.driver postgres; this server keeps users in %s",
+		path.Base(method), g.driver)
+}
+
+func (g driverGuard) interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := g.check(info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (g driverGuard) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := g.check(info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"grpc-crud-proj/middleware"
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	emailChangeTTL = 24 * time.Hour
+	// How long the old address can revert a confirmed change.
+	emailUndoWindow = 7 * 24 * time.Hour
+)
+
+// appBaseURL is where links in outgoing email point to (mail.app_base_url).
+var appBaseURL = "http://localhost:8080"
+
+// newEmailToken returns a random token for an email link and the hash that is
+// stored in the database. Only the hash is persisted.
+func newEmailToken() (token string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	return token, hashEmailToken(token), nil
+}
+
+func hashEmailToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *server) RequestEmailChange(ctx context.Context, req *pb.RequestEmailChangeRequest) (*pb.EmailChangeResponse, error) {
+	claims, ok := middleware.ClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "missing credentials")
+	}
+	newEmail, err := cleanEmail("new_email", req.NewEmail)
+	if err != nil {
+		return nil, err
+	}
+	req.NewEmail = newEmail
+
+	var userID int32
+	var oldEmail string
+	err = s.db.QueryRowContext(ctx,
+		"SELECT id, email FROM users WHERE email=$1 AND deleted_at IS NULL",
+		claims.EffectiveEmail(),
+	).Scan(&userID, &oldEmail)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to look up user: %v", err)
+	}
+	if req.NewEmail == oldEmail {
+		return nil, status.Errorf(codes.InvalidArgument, "new_email is the current address")
+	}
+
+	var taken bool
+	if err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE email=$1)", req.NewEmail).Scan(&taken); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to look up email: %v", err)
+	}
+	if taken {
+		return nil, status.Errorf(codes.AlreadyExists, "a user with email %q already exists", req.NewEmail)
+	}
+
+	token, hash, err := newEmailToken()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate token")
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if err := lockUserEmail(ctx, tx, userID, oldEmail); err != nil {
+		return nil, err
+	}
+	// Only the latest link of a user works
+	if err := cancelPendingEmailChanges(ctx, tx, userID); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save email change: %v", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO email_changes(user_id, old_email, new_email, confirm_token_hash, expires_at)
+		 VALUES($1, $2, $3, $4, $5)`,
+		userID, oldEmail, req.NewEmail, hash, time.Now().Add(emailChangeTTL),
+	)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save email change: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save email change: %v", err)
+	}
+
+	body := fmt.Sprintf("Confirm your new email address by opening:\n\n%s/confirm-email?token=%s\n\nThe link expires in 24 hours.",
+		appBaseURL, token)
+	if err := s.mailer.Send(ctx, req.NewEmail, "Confirm your new email address", body); err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to send confirmation email: %v", err)
+	}
+
+	return &pb.EmailChangeResponse{Message: "Confirmation sent to " + req.NewEmail}, nil
+}
+
+// lockUserEmail locks the user row by id and checks that its address is
+// still want, the one the change was made from.
+func lockUserEmail(ctx context.Context, tx *sql.Tx, userID int32, want string) error {
+	var current string
+	err := tx.QueryRowContext(ctx,
+		"SELECT email FROM users WHERE id=$1 AND deleted_at IS NULL FOR UPDATE", userID,
+	).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return status.Errorf(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to look up user: %v", err)
+	}
+	if current != want {
+		return status.Errorf(codes.FailedPrecondition, "email address changed since this request was made")
+	}
+	return nil
+}
+
+// lockEmailChange locks the email change by id, provided it is still in the
+// state where, a condition on its columns, that its token was found in.
+func lockEmailChange(ctx context.Context, tx *sql.Tx, id int32, where string) error {
+	var found int
+	err := tx.QueryRowContext(ctx, "SELECT 1 FROM email_changes WHERE id=$1 AND "+where+" FOR UPDATE", id).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return status.Errorf(codes.NotFound, "invalid or expired token")
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to look up email change: %v", err)
+	}
+	return nil
+}
+
+// cancelPendingEmailChanges drops the user's unconfirmed email changes, so
+// their links stop working.
+func cancelPendingEmailChanges(ctx context.Context, tx *sql.Tx, userID int32) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM email_changes WHERE user_id=$1 AND confirmed_at IS NULL", userID)
+	return err
+}
+
+func (s *server) ConfirmEmailChange(ctx context.Context, req *pb.ConfirmEmailChangeRequest) (*pb.EmailChangeResponse, error) {
+	if req.Token == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "token is required")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var changeID, userID int32
+	var oldEmail, newEmail string
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, user_id, old_email, new_email FROM email_changes
+		 WHERE confirm_token_hash=$1 AND confirmed_at IS NULL AND expires_at > now()`,
+		hashEmailToken(req.Token),
+	).Scan(&changeID, &userID, &oldEmail, &newEmail)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Errorf(codes.NotFound, "invalid or expired token")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to look up email change: %v", err)
+	}
+
+	// Only apply the change if the address hasn't been changed in the
+	// meantime. The user row is locked before the change, as everywhere
+	// else, and the change then checked again
+	if err := lockUserEmail(ctx, tx, userID, oldEmail); err != nil {
+		return nil, err
+	}
+	if err := lockEmailChange(ctx, tx, changeID, "confirmed_at IS NULL"); err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE users SET email=$1, email_verified_at=now(), version=version+1 WHERE id=$2",
+		newEmail, userID,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, status.Errorf(codes.AlreadyExists, "a user with email %q already exists", newEmail)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to update email: %v", err)
+	}
+
+	undoToken, undoHash, err := newEmailToken()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate token")
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE email_changes SET confirmed_at=now(), undo_token_hash=$1 WHERE id=$2",
+		undoHash, changeID,
+	)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to confirm email change: %v", err)
+	}
+	if err := cancelPendingEmailChanges(ctx, tx, userID); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to confirm email change: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to confirm email change: %v", err)
+	}
+	s.publishByID(ctx, pb.UserEventType_USER_EVENT_TYPE_UPDATED, userID)
+
+	// The change is already applied; a failed notice shouldn't fail the request
+	body := fmt.Sprintf("The email address on your account was changed to %s.\n\nIf you did not make this change, undo it within 7 days:\n\n%s/undo-email-change?token=%s",
+		newEmail, appBaseURL, undoToken)
+	if err := s.notify(ctx, userID, eventEmailChanged, oldEmail, "Your email address was changed", body); err != nil {
+		slog.WarnContext(ctx, "failed to notify old address of email change", "user_id", userID, "error", err)
+	}
+
+	return &pb.EmailChangeResponse{Message: "Email changed to " + newEmail}, nil
+}
+
+func (s *server) UndoEmailChange(ctx context.Context, req *pb.UndoEmailChangeRequest) (*pb.EmailChangeResponse, error) {
+	if req.Token == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "token is required")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var changeID, userID int32
+	var oldEmail, newEmail string
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, user_id, old_email, new_email FROM email_changes
+		 WHERE undo_token_hash=$1 AND undone_at IS NULL AND confirmed_at > $2`,
+		hashEmailToken(req.Token), time.Now().Add(-emailUndoWindow),
+	).Scan(&changeID, &userID, &oldEmail, &newEmail)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Errorf(codes.NotFound, "invalid or expired token")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to look up email change: %v", err)
+	}
+
+	if err := lockUserEmail(ctx, tx, userID, newEmail); err != nil {
+		return nil, err
+	}
+	if err := lockEmailChange(ctx, tx, changeID, "undone_at IS NULL"); err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE users SET email=$1, email_verified_at=now(), version=version+1 WHERE id=$2",
+		oldEmail, userID,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, status.Errorf(codes.AlreadyExists, "a user with email %q already exists", oldEmail)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to restore email: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE email_changes SET undone_at=now() WHERE id=$1", changeID); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to undo email change: %v", err)
+	}
+	// A link requested from the rolled-back address mustn't apply once the
+	// old one is back
+	if err := cancelPendingEmailChanges(ctx, tx, userID); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to undo email change: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to undo email change: %v", err)
+	}
+	s.publishByID(ctx, pb.UserEventType_USER_EVENT_TYPE_UPDATED, userID)
+
+	return &pb.EmailChangeResponse{Message: "Email restored to " + oldEmail}, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+	"grpc-crud-proj/testutil"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recordingMailer keeps the bodies it was asked to send.
+type recordingMailer struct{ bodies []string }
+
+func (m *recordingMailer) Send(_ context.Context, _, _, body string) error {
+	m.bodies = append(m.bodies, body)
+	return nil
+}
+
+var undoLink = regexp.MustCompile(`undo-email-change\?token=(\w+)`)
+
+func TestEmailChangeFollowsUser(t *testing.T) {
+	conn := testutil.DB(t)
+	user := testutil.CreateUser(t, conn)
+	mail := &recordingMailer{}
+	srv := &server{db: conn, mailer: mail, events: newUserEvents()}
+	ctx := context.Background()
+
+	pending := func(oldEmail, newEmail string) string {
+		t.Helper()
+		token, hash, err := newEmailToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Exec(
+			"INSERT INTO email_changes(user_id, old_email, new_email, confirm_token_hash, expires_at) VALUES($1, $2, $3, $4, $5)",
+			user.ID, oldEmail, newEmail, hash, time.Now().Add(time.Hour),
+		); err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	wantCode := func(err error, want codes.Code) {
+		t.Helper()
+		if status.Code(err) != want {
+			t.Errorf("got %v, want %s", err, want)
+		}
+	}
+
+	// Made from an address the user no longer has
+	stale := pending("previous-"+user.Email, "stale-"+user.Email)
+	_, err := srv.ConfirmEmailChange(ctx, &pb.ConfirmEmailChangeRequest{Token: stale})
+	wantCode(err, codes.FailedPrecondition)
+
+	first := pending(user.Email, "first-"+user.Email)
+	second := pending(user.Email, "second-"+user.Email)
+	if _, err := srv.ConfirmEmailChange(ctx, &pb.ConfirmEmailChangeRequest{Token: second}); err != nil {
+		t.Fatalf("ConfirmEmailChange: %v", err)
+	}
+	var email string
+	if err := conn.QueryRow("SELECT email FROM users WHERE id=$1", user.ID).Scan(&email); err != nil {
+		t.Fatal(err)
+	}
+	if email != "second-"+user.Email {
+		t.Errorf("email = %s after confirming, want second-%s", email, user.Email)
+	}
+	// The other pending change is cancelled with the confirmation
+	_, err = srv.ConfirmEmailChange(ctx, &pb.ConfirmEmailChangeRequest{Token: first})
+	wantCode(err, codes.NotFound)
+
+	if len(mail.bodies) != 1 || undoLink.FindStringSubmatch(mail.bodies[0]) == nil {
+		t.Fatalf("sent %q, want one notice with an undo link", mail.bodies)
+	}
+	undo := undoLink.FindStringSubmatch(mail.bodies[0])[1]
+	// A change requested from the new address doesn't survive the undo
+	fromNew := pending("second-"+user.Email, "third-"+user.Email)
+	if _, err := srv.UndoEmailChange(ctx, &pb.UndoEmailChangeRequest{Token: undo}); err != nil {
+		t.Fatalf("UndoEmailChange: %v", err)
+	}
+	if err := conn.QueryRow("SELECT email FROM users WHERE id=$1", user.ID).Scan(&email); err != nil {
+		t.Fatal(err)
+	}
+	if email != user.Email {
+		t.Errorf("email = %s after undoing, want %s", email, user.Email)
+	}
+	_, err = srv.ConfirmEmailChange(ctx, &pb.ConfirmEmailChangeRequest{Token: fromNew})
+	wantCode(err, codes.NotFound)
+	_, err = srv.UndoEmailChange(ctx, &pb.UndoEmailChangeRequest{Token: undo})
+	wantCode(err, codes.NotFound)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+
+	"grpc-crud-proj/service"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// errorDomain is the ErrorInfo domain of the reasons this service reports.
+const errorDomain = "user.UserService"
+
+// detailedStatus is a status with a google.rpc.ErrorInfo carrying reason and
+// metadata and, when there are any, a google.rpc.BadRequest listing fields.
+// Callers branch on those rather than on the message.
+func detailedStatus(code codes.Code, msg, reason string, metadata map[string]string, fields []service.FieldViolation) error {
+	st := status.New(code, msg)
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: reason, Domain: errorDomain, Metadata: metadata}}
+	if len(fields) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, f := range fields {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field: f.Field, Reason: f.Reason, Description: f.Description,
+			})
+		}
+		details = append(details, br)
+	}
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// pgUniqueViolation is the Postgres SQLSTATE for unique_violation.
+const pgUniqueViolation = "23505"
+
+// isUniqueViolation reports whether err is a Postgres unique constraint failure,
+// e.g. inserting an email that is already taken.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
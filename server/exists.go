@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UserExists answers a yes/no lookup by id or email without loading the row.
+func (s *server) UserExists(ctx context.Context, req *pb.UserExistsRequest) (*pb.UserExistsResponse, error) {
+	var row *sql.Row
+	switch lookup := req.Lookup.(type) {
+	case *pb.UserExistsRequest_Id:
+		row = s.db.QueryRowContext(ctx, "SELECT 1 FROM users WHERE id=$1 AND deleted_at IS NULL", lookup.Id)
+	case *pb.UserExistsRequest_Email:
+		row = s.db.QueryRowContext(ctx, "SELECT 1 FROM users WHERE email=$1 AND deleted_at IS NULL", normalizeEmail(lookup.Email))
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "id or email is required")
+	}
+
+	var one int
+	if err := row.Scan(&one); err != nil {
+		if err == sql.ErrNoRows {
+			return &pb.UserExistsResponse{Exists: false}, nil
+		}
+		return nil, status.Errorf(codes.Internal, "failed to look up user: %v", err)
+	}
+
+	return &pb.UserExistsResponse{Exists: true}, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+// gatewayPool spreads the gateway's calls over several connections to the
+// gRPC server, round-robin, so one connection's HTTP/2 stream limit doesn't
+// cap the gateway's concurrency. Each connection's open streams are counted
+// and exported.
+type gatewayPool struct {
+	conns   []*grpc.ClientConn
+	streams []atomic.Int64
+	next    atomic.Uint64
+	open    *prometheus.GaugeVec
+	calls   *prometheus.CounterVec
+}
+
+var _ grpc.ClientConnInterface = (*gatewayPool)(nil)
+
+// newGatewayPool opens size connections to target with opts.
+func newGatewayPool(size int, target string, reg prometheus.Registerer, opts ...grpc.DialOption) (*gatewayPool, error) {
+	p := &gatewayPool{
+		streams: make([]atomic.Int64, size),
+		open: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "grpc_gateway_pool_streams",
+			Help: "Calls in flight on each of the gateway's connections to the gRPC server.",
+		}, []string{"conn"}),
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_gateway_pool_calls_total",
+			Help: "Calls the gateway started on each of its connections to the gRPC server.",
+		}, []string{"conn"}),
+	}
+	for i := 0; i < size; i++ {
+		conn, err := grpc.NewClient(target, opts...)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.conns = append(p.conns, conn)
+	}
+	reg.MustRegister(p.open, p.calls)
+	return p, nil
+}
+
+// pick returns the next connection and a CallOption that releases its
+// stream count when the call finishes, however it ends.
+func (p *gatewayPool) pick() (*grpc.ClientConn, grpc.CallOption) {
+	i := int(p.next.Add(1) % uint64(len(p.conns)))
+	label := strconv.Itoa(i)
+	p.calls.WithLabelValues(label).Inc()
+	p.open.WithLabelValues(label).Set(float64(p.streams[i].Add(1)))
+	return p.conns[i], grpc.OnFinish(func(error) {
+		p.open.WithLabelValues(label).Set(float64(p.streams[i].Add(-1)))
+	})
+}
+
+func (p *gatewayPool) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	conn, done := p.pick()
+	return conn.Invoke(ctx, method, args, reply, append(opts, done)...)
+}
+
+func (p *gatewayPool) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	conn, done := p.pick()
+	return conn.NewStream(ctx, desc, method, append(opts, done)...)
+}
+
+func (p *gatewayPool) Close() error {
+	var errs []error
+	for _, conn := range p.conns {
+		errs = append(errs, conn.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"grpc-crud-proj/internal/config"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// grpcServerOptions turns the grpc section of the config into server options.
+func grpcServerOptions(cfg config.GRPCConfig) []grpc.ServerOption {
+	ka := cfg.Keepalive
+	return []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(cfg.MaxRecvMsgSize),
+		grpc.MaxSendMsgSize(cfg.MaxSendMsgSize),
+		grpc.ConnectionTimeout(cfg.ConnectionTimeout.Duration),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:                  ka.Time.Duration,
+			Timeout:               ka.Timeout.Duration,
+			MaxConnectionIdle:     ka.MaxConnectionIdle.Duration,
+			MaxConnectionAge:      ka.MaxConnectionAge.Duration,
+			MaxConnectionAgeGrace: ka.MaxConnectionAgeGrace.Duration,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             ka.MinTime.Duration,
+			PermitWithoutStream: ka.PermitWithoutStream,
+		}),
+	}
+}
+
+// gatewayCallOptions lets the gateway's client carry the same message sizes
+// as the server, so large REST requests and responses aren't cut off at the
+// client default.
+func gatewayCallOptions(cfg config.GRPCConfig) grpc.DialOption {
+	return grpc.WithDefaultCallOptions(
+		grpc.MaxCallRecvMsgSize(cfg.MaxSendMsgSize),
+		grpc.MaxCallSendMsgSize(cfg.MaxRecvMsgSize),
+	)
+}
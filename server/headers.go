@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/textproto"
+
+	"grpc-crud-proj/middleware"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/metadata"
+)
+
+// gatewayIncomingHeader forwards X-Request-Id and Traceparent to the gRPC
+// server as-is, on top of the headers grpc-gateway forwards by default.
+func gatewayIncomingHeader(key string) (string, bool) {
+	switch textproto.CanonicalMIMEHeaderKey(key) {
+	case "X-Request-Id":
+		return middleware.RequestIDHeader, true
+	case "Traceparent":
+		return middleware.TraceparentHeader, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// gatewayOutgoingHeader exposes the request id, region and deprecation flag
+// as plain X-Request-Id / X-Region / Deprecation response headers; other
+// metadata keeps the Grpc-Metadata- prefix.
+func gatewayOutgoingHeader(key string) (string, bool) {
+	switch key {
+	case middleware.RequestIDHeader:
+		return "X-Request-Id", true
+	case middleware.RegionHeader:
+		return "X-Region", true
+	case middleware.DeprecationHeader:
+		return "Deprecation", true
+	}
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
+// gatewayMetadata marks every call forwarded by the gateway, so the policy
+// can keep methods off it.
+func gatewayMetadata(ctx context.Context, r *http.Request) metadata.MD {
+	return metadata.Pairs(middleware.GatewayHeader, "1")
+}
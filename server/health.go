@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// readyTimeout bounds the database ping behind /readyz.
+const readyTimeout = 2 * time.Second
+
+// healthz is the HTTP liveness endpoint: it answers 200 whenever the
+// process is up, along with the instance's region,
+// whether it is refusing writes and the state of each optional subsystem.
+// A degraded subsystem turns the status to "degraded" but keeps the 200, so
+// load balancers don't pull an instance that can still serve users.
+func healthz(region string, readOnly *readOnlyMode, subsys *subsystems) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := map[string]string{}
+		for _, s := range subsys.list() {
+			state[s.Name] = subsystemStateName(s.State)
+		}
+		status := "ok"
+		if len(subsys.degraded()) > 0 {
+			status = "degraded"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Status     string            `json:"status"`
+			Region     string            `json:"region,omitempty"`
+			ReadOnly   bool              `json:"read_only"`
+			Subsystems map[string]string `json:"subsystems"`
+		}{status, region, readOnly.toProto().Enabled, state})
+	})
+}
+
+// dbHealth reports the database in the gRPC health service: services
+// backed by it turn NOT_SERVING after threshold failed pings in a row, so
+// health-checking clients stop sending them calls, and SERVING again on
+// the first ping that succeeds. A single slow ping doesn't flip them.
+type dbHealth struct {
+	db        pinger
+	srv       *health.Server
+	services  []string
+	interval  time.Duration
+	threshold int
+	failures  int
+}
+
+// run pings every interval until ctx ends. The services start SERVING,
+// since the database answered at startup.
+func (h *dbHealth) run(ctx context.Context) {
+	h.set(healthpb.HealthCheckResponse_SERVING)
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.check(ctx)
+		}
+	}
+}
+
+func (h *dbHealth) check(ctx context.Context) {
+	pingCtx, cancel := context.WithTimeout(ctx, min(h.interval, readyTimeout))
+	err := h.db.PingContext(pingCtx)
+	cancel()
+	if err == nil {
+		if h.failures >= h.threshold {
+			slog.Info("database answers again; serving", "services", h.services)
+			h.set(healthpb.HealthCheckResponse_SERVING)
+		}
+		h.failures = 0
+		return
+	}
+	if ctx.Err() != nil {
+		return // shutting down
+	}
+	h.failures++
+	if h.failures == h.threshold {
+		slog.Error("database unreachable; reporting not serving", "services", h.services,
+			"failed_pings", h.failures, "error", err)
+		h.set(healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
+
+func (h *dbHealth) set(status healthpb.HealthCheckResponse_ServingStatus) {
+	for _, service := range h.services {
+		h.srv.SetServingStatus(service, status)
+	}
+}
+
+// readiness tracks what /readyz checks besides the database.
+type readiness struct {
+	db pinger
+	// grpcServing is set once the gRPC listener is bound and serving.
+	grpcServing atomic.Bool
+}
+
+// readyz is the HTTP readiness endpoint: 200 when the database answers a
+// ping and the gRPC listener is serving, 503 with the failing checks
+// otherwise, so orchestrators only route traffic to instances that can
+// handle it.
+func (rd *readiness) readyz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks := map[string]string{"database": "ok", "grpc": "ok"}
+		ready := true
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		if err := rd.db.PingContext(ctx); err != nil {
+			checks["database"], ready = err.Error(), false
+		}
+		if !rd.grpcServing.Load() {
+			checks["grpc"], ready = "not serving", false
+		}
+		status, code := "ready", http.StatusOK
+		if !ready {
+			status, code = "not ready", http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(struct {
+			Status string            `json:"status"`
+			Checks map[string]string `json:"checks"`
+		}{status, checks})
+	})
+}
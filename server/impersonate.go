@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"grpc-crud-proj/middleware"
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Impersonate lets an admin obtain a short-lived token acting as another user,
+// e.g. so support staff can reproduce what that user sees.
+func (s *server) Impersonate(ctx context.Context, req *pb.ImpersonateRequest) (*pb.ImpersonateResponse, error) {
+	claims, ok := middleware.ClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "missing credentials")
+	}
+	if claims.ActAs != "" {
+		return nil, status.Errorf(codes.PermissionDenied, "cannot impersonate while impersonating")
+	}
+	if req.Reason == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "a reason is required to impersonate a user")
+	}
+
+	var email, role, userStatus string
+	err := s.db.QueryRowContext(ctx,
+		"SELECT email, role, status FROM users WHERE id=$1 AND deleted_at IS NULL",
+		req.Id,
+	).Scan(&email, &role, &userStatus)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, status.Errorf(codes.NotFound, "user not found")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to look up user: %v", err)
+	}
+	if statusFromDB(userStatus) == pb.UserStatus_USER_STATUS_SUSPENDED {
+		return nil, status.Errorf(codes.FailedPrecondition, "cannot impersonate a suspended user")
+	}
+
+	token, expiresAt, err := generateImpersonationToken(claims.Email, email, role)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate token")
+	}
+
+	slog.InfoContext(ctx, "AUDIT impersonation started", "admin", claims.Email, "user_id", req.Id, "target", email,
+		"reason", req.Reason, "expires_at", expiresAt.Format(time.RFC3339))
+
+	return &pb.ImpersonateResponse{Token: token, ExpiresAt: expiresAt.Unix()}, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxQueryShapes bounds the shapes kept by queryLog.
+const maxQueryShapes = 50
+
+// queryShape is one parameterized query plus the arguments of its latest run,
+// which AdviseIndexes uses to EXPLAIN it. hint is the index that would serve
+// the query if the planner falls back to a sequential scan.
+type queryShape struct {
+	name  string
+	sql   string
+	args  []interface{}
+	hint  string
+	calls int64
+}
+
+// queryLog remembers the user query shapes served by this instance.
+type queryLog struct {
+	mu     sync.Mutex
+	shapes map[string]*queryShape
+}
+
+func newQueryLog() *queryLog {
+	return &queryLog{shapes: map[string]*queryShape{}}
+}
+
+func (l *queryLog) record(name, hint, query string, args []interface{}) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	shape, ok := l.shapes[query]
+	if !ok {
+		if len(l.shapes) >= maxQueryShapes {
+			return
+		}
+		shape = &queryShape{name: name, sql: query, hint: hint}
+		l.shapes[query] = shape
+	}
+	shape.args = args
+	shape.calls++
+}
+
+// observe is the repository's QueryObserver: it records the shape and
+// adds the query to the calling request's list for the slow request log.
+func (l *queryLog) observe(ctx context.Context, name, hint, query string, args []interface{}) {
+	l.record(name, hint, query, args)
+	requestQueriesFrom(ctx).add(name, query, args)
+}
+
+func (l *queryLog) snapshot() []queryShape {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]queryShape, 0, len(l.shapes))
+	for _, shape := range l.shapes {
+		out = append(out, *shape)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].calls > out[j].calls })
+	return out
+}
+
+// queryUsers runs a list-style query on users and records its shape.
+func (s *server) queryUsers(ctx context.Context, shape, hint, query string, args ...interface{}) (*sql.Rows, error) {
+	s.queries.observe(ctx, shape, hint, query, args)
+	return s.db.QueryContext(ctx, query, args...)
+}
+
+// planNode is the part of EXPLAIN (FORMAT JSON) output we look at.
+type planNode struct {
+	NodeType  string     `json:"Node Type"`
+	Relation  string     `json:"Relation Name"`
+	IndexName string     `json:"Index Name"`
+	TotalCost float64    `json:"Total Cost"`
+	Plans     []planNode `json:"Plans"`
+}
+
+// usersAccess finds how the plan reads the users table.
+func (n planNode) usersAccess() (planNode, bool) {
+	if n.Relation == "users" {
+		return n, true
+	}
+	for _, child := range n.Plans {
+		if found, ok := child.usersAccess(); ok {
+			return found, true
+		}
+	}
+	return planNode{}, false
+}
+
+func (s *server) AdviseIndexes(ctx context.Context, req *pb.AdviseIndexesRequest) (*pb.AdviseIndexesResponse, error) {
+	res := &pb.AdviseIndexesResponse{}
+	err := s.db.QueryRowContext(ctx,
+		"SELECT reltuples::bigint FROM pg_class WHERE oid = 'users'::regclass",
+	).Scan(&res.TableRows)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read table statistics: %v", err)
+	}
+
+	for _, shape := range s.queries.snapshot() {
+		// Plain EXPLAIN only plans the query; nothing is executed
+		var raw []byte
+		if err := s.db.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+shape.sql, shape.args...).Scan(&raw); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to explain %s: %v", shape.name, err)
+		}
+		var plans []struct {
+			Plan planNode `json:"Plan"`
+		}
+		if err := json.Unmarshal(raw, &plans); err != nil || len(plans) == 0 {
+			return nil, status.Errorf(codes.Internal, "failed to parse plan for %s: %v", shape.name, err)
+		}
+
+		advice := &pb.QueryAdvice{Shape: shape.name, Sql: shape.sql, Calls: shape.calls, Cost: plans[0].Plan.TotalCost}
+		access, ok := plans[0].Plan.usersAccess()
+		switch {
+		case !ok:
+			advice.Plan = plans[0].Plan.NodeType
+		case access.IndexName != "":
+			advice.Plan = access.NodeType + " using " + access.IndexName
+		default:
+			advice.Plan = access.NodeType
+			if access.NodeType == "Seq Scan" {
+				advice.Suggestion = shape.hint
+			}
+		}
+		res.Queries = append(res.Queries, advice)
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT s.indexrelname, s.idx_scan, pg_size_pretty(pg_relation_size(s.indexrelid))
+		 FROM pg_stat_user_indexes s JOIN pg_index i ON i.indexrelid = s.indexrelid
+		 WHERE s.relname = 'users' AND s.idx_scan = 0 AND NOT i.indisunique AND NOT i.indisprimary
+		 ORDER BY pg_relation_size(s.indexrelid) DESC`,
+	)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read index usage: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var idx pb.IndexUsage
+		if err := rows.Scan(&idx.Name, &idx.Scans, &idx.Size); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to read index usage: %v", err)
+		}
+		res.UnusedIndexes = append(res.UnusedIndexes, &idx)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read index usage: %v", err)
+	}
+	return res, nil
+}
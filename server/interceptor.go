@@ -2,70 +2,74 @@ package main
 
 import (
 	"context"
-	"strings"
+	_ "embed"
+	"log/slog"
 
-	"github.com/golang-jwt/jwt/v5"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
+	"grpc-crud-proj/internal/config"
+	"grpc-crud-proj/middleware"
+
+	"golang.org/x/time/rate"
 )
 
-// 1. Define Public Methods (No Token Needed)
-var publicMethods = map[string]bool{
-	"/user.UserService/Login":    true,
-	"/user.UserService/Register": true,
-}
+// The API policy: who may call each method, its rate limit and timeout,
+// whether it works in read-only mode, and so on. It is the built-in
+// policy.yaml unless auth.policy_file points at another one.
+//
+//go:embed policy.yaml
+var defaultPolicy []byte
 
-// 2. Define Admin-Only Methods
-var adminMethods = map[string]bool{
-	"/user.UserService/CreateUser": true,
-	"/user.UserService/UpdateUser": true,
-	"/user.UserService/DeleteUser": true,
-	"/user.UserService/GetUser":    true, // <--- Add this
-}
+var builtinPolicy = mustParsePolicy(defaultPolicy)
 
-func AuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	// A. Allow Public Methods
-	if publicMethods[info.FullMethod] {
-		return handler(ctx, req)
+func mustParsePolicy(data []byte) *middleware.Policy {
+	p, err := middleware.ParsePolicy(data)
+	if err != nil {
+		panic("bad built-in policy: " + err.Error())
 	}
+	return p
+}
 
-	// B. Get Metadata
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return nil, status.Errorf(codes.Unauthenticated, "metadata missing")
-	}
+// deadlineConfig uses timeouts.default_rpc for every unary method without a
+// timeout in the policy.
+func deadlineConfig(cfg config.TimeoutsConfig) middleware.DeadlineConfig {
+	return middleware.DeadlineConfig{Default: cfg.DefaultRPC.Duration}
+}
 
-	// C. Get Token
-	values := md["authorization"]
-	if len(values) == 0 {
-		return nil, status.Errorf(codes.Unauthenticated, "token missing")
+// traceConfig maps the tracing section onto the tracing middleware.
+func traceConfig(cfg config.TracingConfig) middleware.TraceConfig {
+	tc := middleware.TraceConfig{
+		Logger:        slog.Default(),
+		Ratio:         cfg.Ratio,
+		Methods:       cfg.Methods,
+		KeepErrors:    cfg.KeepErrors,
+		SlowThreshold: cfg.SlowThreshold.Duration,
 	}
-
-	tokenString := values[0]
-	if len(tokenString) > 7 && strings.ToUpper(tokenString[0:7]) == "BEARER " {
-		tokenString = tokenString[7:]
+	if cfg.Sampler == "always" {
+		tc.Ratio, tc.Methods = 1, nil
 	}
+	return tc
+}
 
-	// D. Validate Token & Parse Claims
-	claims := &Claims{}
-	tkn, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-
-	if err != nil || !tkn.Valid {
-		return nil, status.Errorf(codes.Unauthenticated, "invalid token")
+// rateLimitConfig applies rate_limit.rps / rate_limit.burst to every method
+// without a rate_limit in the policy. An rps of 0 disables the default.
+func rateLimitConfig(cfg config.RateLimitConfig, store middleware.RateLimitStore) middleware.RateLimitConfig {
+	def := middleware.Limit{Rate: rate.Limit(cfg.RPS), Burst: cfg.Burst}
+	if cfg.RPS == 0 {
+		def = middleware.Limit{}
 	}
+	return middleware.RateLimitConfig{Default: def, IdleTTL: rateLimitIdleTTL, Store: store}
+}
 
-	// --- NEW: ROLE CHECK ---
-	// E. If method requires Admin, check the role
-	if adminMethods[info.FullMethod] {
-		if strings.ToLower(claims.Role) != "admin" {
-			return nil, status.Errorf(codes.PermissionDenied, "Access Denied: You are not an admin")
-		}
+// authConfig sets up token checks for the policy interceptor, including
+// the denylist, so revocations apply to the very next call.
+func authConfig(denylist tokenDenylist) middleware.AuthConfig {
+	return middleware.AuthConfig{
+		Key:        jwtKey,
+		Keys:       jwtKeys,
+		PublicKeys: jwtPublicKeys,
+		Issuer:     jwtIssuer,
+		Audience:   jwtAudience,
+		Revoked: func(ctx context.Context, claims *middleware.Claims) (bool, error) {
+			return denylist.revoked(ctx, claims)
+		},
 	}
-
-	// F. Success
-	return handler(ctx, req)
 }
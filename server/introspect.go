@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Introspect checks a token the way every call is checked, including the
+// denylist, so a logged-out or revoked token is reported inactive although
+// its signature still verifies.
+func (s *server) Introspect(ctx context.Context, req *pb.IntrospectRequest) (*pb.IntrospectResponse, error) {
+	claims, err := authConfig(s.state.denylist).Verify(ctx, req.Token)
+	if status.Code(err) == codes.Unauthenticated {
+		return &pb.IntrospectResponse{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.IntrospectResponse{
+		Active:    true,
+		Subject:   claims.Email,
+		ActingAs:  claims.ActAs,
+		Role:      claims.Role,
+		TokenId:   claims.ID,
+		SessionId: claims.SessionID,
+	}
+	if claims.IssuedAt != nil {
+		res.IssuedAt = claims.IssuedAt.Unix()
+	}
+	if claims.ExpiresAt != nil {
+		res.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+	return res, nil
+}
@@ -1,29 +1,107 @@
 package main
 
 import (
+	"crypto"
+	"crypto/rand"
+	"encoding/hex"
 	"time"
 
+	"grpc-crud-proj/middleware"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtKey = []byte("my_secret_key")
+// Set from the auth section of the config by applyConfig.
+var (
+	// jwtKey verifies tokens without a kid, and signs new ones when no
+	// jwtKeys are configured.
+	jwtKey []byte
+	// jwtKeys verify HMAC tokens by kid and jwtPublicKeys RS256 and EdDSA
+	// ones (see signingkeys.go).
+	jwtKeys       map[string][]byte
+	jwtPublicKeys map[string]crypto.PublicKey
+	// New tokens are signed with jwtSigningKey, named jwtSigningKeyID in
+	// their kid header unless that is empty.
+	jwtSigningMethod jwt.SigningMethod = jwt.SigningMethodHS256
+	jwtSigningKey    interface{}
+	jwtSigningKeyID  string
+	// jwtIssuer and jwtAudience go in every token when set, and are
+	// required of every token presented.
+	jwtIssuer   string
+	jwtAudience string
+	tokenTTL    = 24 * time.Hour
+	// Impersonation tokens are deliberately short-lived.
+	impersonationTTL = 15 * time.Minute
+	refreshTokenTTL  = 30 * 24 * time.Hour
+)
 
-type Claims struct {
-	Email string `json:"email"`
-	Role  string `json:"role"` // <--- Add this field
-	jwt.RegisteredClaims
+// newTokenID returns a random jti, the handle Logout revokes a token by.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
 
-// Update function signature to accept 'role'
-func generateToken(email string, role string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &Claims{
-		Email: email,
-		Role:  role, // <--- Store it here
+// Update function signature to accept 'role'. sessionID is the refresh
+// token family the token belongs to, empty for none.
+func generateToken(email, role, sessionID string) (string, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	expirationTime := now.Add(tokenTTL)
+	claims := &middleware.Claims{
+		Email:     email,
+		Role:      role, // <--- Store it here
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	return signToken(claims)
+}
+
+// generateImpersonationToken issues a token for adminEmail acting as
+// targetEmail. The token carries the target's role, so it never grants more
+// than the impersonated user could do themselves.
+func generateImpersonationToken(adminEmail, targetEmail, targetRole string) (string, time.Time, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expirationTime := now.Add(impersonationTTL)
+	claims := &middleware.Claims{
+		Email: adminEmail,
+		Role:  targetRole,
+		ActAs: targetEmail,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+		},
+	}
+	signed, err := signToken(claims)
+	return signed, expirationTime, err
+}
+
+// signToken signs claims with the current signing key, naming it in the kid
+// header so the token still verifies after the next rotation.
+func signToken(claims *middleware.Claims) (string, error) {
+	claims.Issuer = jwtIssuer
+	if jwtAudience != "" {
+		claims.Audience = jwt.ClaimStrings{jwtAudience}
+	}
+	token := jwt.NewWithClaims(jwtSigningMethod, claims)
+	if jwtSigningKeyID != "" {
+		token.Header["kid"] = jwtSigningKeyID
+	}
+	return token.SignedString(jwtSigningKey)
 }
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"grpc-crud-proj/internal/config"
+	"grpc-crud-proj/middleware"
+)
+
+// setupLogging makes slog's default logger follow the log section. Records
+// logged with a call's context get its method, request id and caller. An
+// invalid level or format (reported by Validate) falls back to info / text.
+func setupLogging(cfg config.LogConfig) {
+	var level slog.Level
+	_ = level.UnmarshalText([]byte(cfg.Level))
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if cfg.Format == "json" {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(middleware.ContextHandler(h)))
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"grpc-crud-proj/middleware"
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recordLoginAttempt stores the outcome of a login for email with method
+// ("password" or a provider) in login_attempts. Attempts without an email
+// say nothing about an account and aren't kept, and read-only mode keeps
+// none. Failing to store one is logged; the login itself goes ahead.
+func (s *server) recordLoginAttempt(ctx context.Context, method, email string, err error) {
+	if email == "" || s.readOnly.on() {
+		return
+	}
+	var message string
+	if err != nil {
+		message = status.Convert(err).Message()
+	}
+	if _, dbErr := s.db.ExecContext(context.WithoutCancel(ctx),
+		`INSERT INTO login_attempts (email, method, code, error, client_ip, user_agent)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		email, method, status.Code(err).String(), message, middleware.ClientIP(ctx), userAgent(ctx),
+	); dbErr != nil {
+		slog.WarnContext(ctx, "cannot record login attempt", "email", email, "error", dbErr)
+	}
+}
+
+func (s *server) ListLoginAttempts(ctx context.Context, req *pb.ListLoginAttemptsRequest) (*pb.ListLoginAttemptsResponse, error) {
+	email := normalizeEmail(req.Email)
+	if email == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "email is required")
+	}
+	pageSize := req.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, email, method, code, error, client_ip, user_agent, attempted_at
+		 FROM login_attempts WHERE email = $1 AND attempted_at >= to_timestamp($2)
+		 ORDER BY id DESC LIMIT $3 OFFSET $4`,
+		email, req.Since, pageSize, req.Offset,
+	)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list login attempts: %v", err)
+	}
+	defer rows.Close()
+
+	res := &pb.ListLoginAttemptsResponse{}
+	for rows.Next() {
+		var a pb.LoginAttempt
+		var at time.Time
+		if err := rows.Scan(&a.Id, &a.Email, &a.Method, &a.Code, &a.Error, &a.ClientIp, &a.UserAgent, &at); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to read login attempt: %v", err)
+		}
+		a.AttemptedAt = at.Unix()
+		res.Attempts = append(res.Attempts, &a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list login attempts: %v", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+
+	"grpc-crud-proj/internal/config"
+)
+
+// Mailer delivers transactional email (confirmations, security notices).
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// newMailer returns an SMTP mailer when mail.smtp_addr is set, otherwise a
+// mailer that only logs messages, which is enough for local development.
+func newMailer(cfg config.MailConfig) Mailer {
+	if cfg.SMTPAddr == "" {
+		return logMailer{}
+	}
+	return &smtpMailer{
+		addr:     cfg.SMTPAddr,
+		from:     cfg.SMTPFrom,
+		username: cfg.SMTPUser,
+		password: cfg.SMTPPassword,
+	}
+}
+
+type logMailer struct{}
+
+func (logMailer) Send(ctx context.Context, to, subject, body string) error {
+	slog.InfoContext(ctx, "mail not sent (no mail.smtp_addr)", "to", to, "subject", subject, "body", body)
+	return nil
+}
+
+type smtpMailer struct {
+	addr     string
+	from     string
+	username string
+	password string
+}
+
+func (m *smtpMailer) Send(ctx context.Context, to, subject, body string) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		host, _, err := net.SplitHostPort(m.addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.username, m.password, host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", m.from, to, subject, body)
+	return smtp.SendMail(m.addr, auth, m.from, []string{to}, []byte(msg))
+}
@@ -3,198 +3,581 @@ package main
 import (
 	"context"
 	"database/sql"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"grpc-crud-proj/db"
+	"grpc-crud-proj/internal/avatar"
+	"grpc-crud-proj/internal/httpclient"
+	"grpc-crud-proj/middleware"
 	gw "grpc-crud-proj/proto/google/userpb"
 	pb "grpc-crud-proj/proto/google/userpb"
+	pbv2 "grpc-crud-proj/proto/v2/userpb"
+	"grpc-crud-proj/repository"
+	"grpc-crud-proj/service"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
 type server struct {
 	pb.UnimplementedUserServiceServer
-	db *sql.DB
+	db         *sql.DB
+	repo       *repository.Postgres // nil unless database.driver is postgres
+	users      *service.Users
+	mailer     Mailer
+	events     *userEvents
+	queries    *queryLog
+	readOnly   *readOnlyMode
+	webhooks   *webhooks
+	avatars    *avatar.Pipeline
+	state      stateStores
+	subsystems *subsystems
+	oauth      *oauthLogin
 }
 
-// Add this inside server/main.go
-
 func (s *server) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.UserResponse, error) {
-	hashedPwd, _ := hashPassword(req.Password)
-
-	// Default to "user" if no role is sent
-	userRole := req.Role
-	if userRole == "" {
-		userRole = "user"
-	}
-
-	var id int
-	// INSERT the role into DB
-	err := s.db.QueryRow(
-		"INSERT INTO users(name, email, password, role) VALUES($1, $2, $3, $4) RETURNING id",
-		req.Name, req.Email, hashedPwd, userRole,
-	).Scan(&id)
-
+	user, err := s.users.Register(ctx, service.Registration{
+		Name:     req.Name,
+		Email:    req.Email,
+		Password: req.Password,
+		Role:     req.Role,
+	})
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "cannot create user: %v", err)
+		return nil, serviceStatus(err)
 	}
-
-	return &pb.UserResponse{
-		User: &pb.User{Id: int32(id), Name: req.Name, Email: req.Email, Role: userRole},
-	}, nil
+	// Verification links are stored in Postgres; with another
+	// database.driver there is nothing to verify with
+	if s.db == nil {
+		return &pb.UserResponse{User: user}, nil
+	}
+	// The account exists either way; it can ask for another link
+	if err := s.sendVerificationEmail(ctx, user.Id, user.Email); err != nil {
+		slog.WarnContext(ctx, "failed to send verification email", "user_id", user.Id, "error", err)
+	}
+	return &pb.UserResponse{User: user}, nil
 }
 
+// Login checks an email and password and starts a session. Every attempt is
+// recorded for ListLoginAttempts.
 func (s *server) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	resp, err := s.login(ctx, req)
+	s.recordLoginAttempt(ctx, "password", normalizeEmail(req.Email), err)
+	return resp, err
+}
+
+func (s *server) login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	var userID int32
 	var storedHash string
 	var role string // <--- 1. Variable to hold the role
+	var userStatus string
+	var verified bool
+
+	// Emails are stored normalized, so normalize before looking up
+	req.Email = normalizeEmail(req.Email)
+
+	// A store outage shouldn't lock everyone out, so only a known lockout refuses
+	if until, err := s.state.logins.lockedUntil(ctx, req.Email); err != nil {
+		slog.WarnContext(ctx, "cannot check login lockout", "error", err)
+	} else if !until.IsZero() {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many failed logins; try again after %s", until.UTC().Format(time.RFC3339))
+	}
 
 	// 2. CRITICAL: We must SELECT the 'role' column from the DB
-	err := s.db.QueryRow(
-		"SELECT password, role FROM users WHERE email=$1",
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, password, role, status, email_verified_at IS NOT NULL FROM users WHERE email=$1 AND deleted_at IS NULL",
 		req.Email,
-	).Scan(&storedHash, &role) // <--- 3. Scan it into the variable
+	).Scan(&userID, &storedHash, &role, &userStatus, &verified) // <--- 3. Scan it into the variable
 
 	if err != nil {
+		s.loginFailed(ctx, req.Email)
 		return nil, status.Errorf(codes.Unauthenticated, "user not found")
 	}
 
 	if !checkPassword(req.Password, storedHash) {
+		s.loginFailed(ctx, req.Email)
 		return nil, status.Errorf(codes.Unauthenticated, "incorrect password")
 	}
+	if err := s.state.logins.succeeded(ctx, req.Email); err != nil {
+		slog.WarnContext(ctx, "cannot reset failed logins", "error", err)
+	}
+	// Keep Login a read in read-only mode; the hash is upgraded next time
+	if needsRehash(storedHash) && !s.readOnly.on() {
+		s.rehashPassword(ctx, req.Email, req.Password, storedHash)
+	}
+
+	// Suspended accounts keep their data but cannot obtain new tokens
+	if statusFromDB(userStatus) == pb.UserStatus_USER_STATUS_SUSPENDED {
+		return nil, status.Errorf(codes.PermissionDenied, "account is suspended")
+	}
+	if requireVerifiedEmail && !verified {
+		return nil, emailNotVerified(req.Email)
+	}
 
 	// 4. Pass the fetched role to the token generator
-	token, err := generateToken(req.Email, role)
+	return s.startSession(ctx, userID, req.Email, role, req.Device)
+}
+
+// startSession issues the tokens for a successful login. Each login starts
+// a session: a refresh token family. Read-only mode can't store one, so
+// callers get only the access token then.
+func (s *server) startSession(ctx context.Context, userID int32, email, role, device string) (*pb.LoginResponse, error) {
+	var session, refresh string
+	if !s.readOnly.on() {
+		var err error
+		if session, err = newTokenID(); err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot generate token")
+		}
+		if refresh, err = issueRefreshToken(ctx, s.db, userID, session, device); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to issue refresh token: %v", err)
+		}
+	}
+	token, err := generateToken(email, role, session)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "cannot generate token")
 	}
+	return &pb.LoginResponse{Token: token, RefreshToken: refresh}, nil
+}
+
+// loginFailed counts a failed login for email, whether or not it has an
+// account, so guessing at unknown addresses is throttled the same way.
+func (s *server) loginFailed(ctx context.Context, email string) {
+	until, err := s.state.logins.failed(ctx, email)
+	if err != nil {
+		slog.WarnContext(ctx, "cannot record failed login", "error", err)
+		return
+	}
+	if !until.IsZero() {
+		slog.WarnContext(ctx, "login locked out after repeated failures", "email", email, "until", until)
+	}
+}
 
-	return &pb.LoginResponse{Token: token}, nil
+func (s *server) Logout(ctx context.Context, req *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	claims, ok := middleware.ClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "missing credentials")
+	}
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "this token predates logout and can't be revoked; it expires on its own")
+	}
+	if err := s.state.denylist.revoke(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke token: %v", err)
+	}
+	if claims.SessionID != "" {
+		if err := revokeSession(ctx, s.db, claims.SessionID); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to revoke session: %v", err)
+		}
+	}
+	return &pb.LogoutResponse{Message: "logged out"}, nil
 }
 
 func (s *server) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.UserResponse, error) {
-	var id int
-	// Include the role in the INSERT statement
-	err := s.db.QueryRow(
-		"INSERT INTO users(name, email, role) VALUES($1, $2, $3) RETURNING id",
-		req.Name, req.Email, req.Role,
-	).Scan(&id)
-
+	user, err := s.users.Create(ctx, req.Name, req.Email, req.Role)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to create user: %v", err)
+		return nil, serviceStatus(err)
 	}
-
-	return &pb.UserResponse{
-		User: &pb.User{
-			Id:    int32(id),
-			Name:  req.Name,
-			Email: req.Email,
-			Role:  req.Role,
-		},
-	}, nil
+	return &pb.UserResponse{User: user}, nil
 }
 
 func (s *server) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.UserResponse, error) {
-	var user pb.User
-	// Add 'role' to the SELECT and Scan
-	err := s.db.QueryRow(
-		"SELECT id, name, email, role FROM users WHERE id=$1",
-		req.Id,
-	).Scan(&user.Id, &user.Name, &user.Email, &user.Role)
-
+	user, err := s.users.Get(ctx, req.Id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, status.Errorf(codes.NotFound, "user not found")
-		}
-		return nil, err
+		return nil, serviceStatus(err)
 	}
 
-	return &pb.UserResponse{User: &user}, nil
+	// Tell callers the id they asked for is a tombstone so they can update
+	// their references; the gateway turns this into a 308 redirect.
+	if user.Id != req.Id {
+		grpc.SetHeader(ctx, metadata.Pairs(movedToHeader, strconv.Itoa(int(user.Id))))
+	}
+
+	return &pb.UserResponse{User: user}, nil
 }
 
 func (s *server) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.UserResponse, error) {
-	_, err := s.db.Exec(
-		"UPDATE users SET name=$1, email=$2 WHERE id=$3",
-		req.Name, req.Email, req.Id,
-	)
+	user, err := s.users.Update(ctx, req.Id, req.Name, req.Email, req.ExpectedVersion)
 	if err != nil {
-		return nil, err
+		return nil, serviceStatus(err)
 	}
-
-	return &pb.UserResponse{
-		User: &pb.User{
-			Id:    req.Id,
-			Name:  req.Name,
-			Email: req.Email,
-		},
-	}, nil
+	return &pb.UserResponse{User: user}, nil
 }
 
 func (s *server) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
-	_, err := s.db.Exec("DELETE FROM users WHERE id=$1", req.Id)
-	if err != nil {
-		return nil, err
+	if err := s.users.Delete(ctx, req.Id); err != nil {
+		return nil, serviceStatus(err)
 	}
-
 	return &pb.DeleteUserResponse{
 		Message: "User deleted",
 	}, nil
 }
 
 func main() {
-	dbConn := db.Connect()
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML config file (see usersctl config init)")
+	flag.String("grpc-addr", "", "gRPC listen address (overrides server.grpc_addr)")
+	flag.String("http-addr", "", "REST gateway listen address (overrides server.http_addr)")
+	flag.String("db-url", "", "database connection string (overrides database.url)")
+	migrateOnly := flag.Bool("migrate", false, "apply pending schema migrations and exit")
+	seedPath := flag.String("seed", "", "load the users in this JSON or CSV fixture and exit")
+	flag.Parse()
+	cfg := loadConfig(*configPath, flag.CommandLine)
+	applyConfig(cfg)
+	if err := loadSigningKeys(cfg.Auth); err != nil {
+		fatal("failed to load auth.jwt_private_keys", "error", err)
+	}
 
-	go func() {
-		lis, err := net.Listen("tcp", ":50051")
+	// Queries reuse the statements pgx prepares on each connection; the
+	// cache hit rate is on /metrics
+	var dbOpts []db.Option
+	if cfg.Database.Driver == "postgres" {
+		dbOpts = append(dbOpts, db.WithTracer(db.NewStatementMetrics(prometheus.DefaultRegisterer)))
+	}
+	if threshold := cfg.Database.SlowQueryThreshold.Duration; threshold > 0 {
+		dbOpts = append(dbOpts, db.WithTracer(db.NewSlowQueryLog(threshold)))
+	}
+	store, err := openUserStore(cfg.Database, dbOpts)
+	if err != nil {
+		fatal("failed to connect to the database", "driver", cfg.Database.Driver, "error", err)
+	}
+	if cfg.Database.AutoMigrate || *migrateOnly {
+		if err := store.migrate(context.Background(), cfg.Database.URL); err != nil {
+			fatal("failed to migrate the schema", "error", err)
+		}
+	}
+	if *migrateOnly {
+		store.Close()
+		return
+	}
+	if *seedPath != "" {
+		policy := builtinPolicy
+		if cfg.Auth.PolicyFile != "" {
+			policyFile, err := middleware.LoadPolicyFile(cfg.Auth.PolicyFile)
+			if err != nil {
+				fatal("failed to load auth.policy_file", "error", err)
+			}
+			policy = policyFile.Policy()
+		}
+		err := seedUsers(context.Background(), store.repository(nil), policy, *seedPath)
+		store.Close()
 		if err != nil {
-			log.Fatal("Failed to listen on gRPC port:", err)
+			fatal("failed to seed users", "error", err)
 		}
+		return
+	}
+	// dbConn is nil unless users are in Postgres, which every RPC outside
+	// repositoryMethods queries directly
+	var dbConn *sql.DB
+	if store.postgres != nil {
+		dbConn = store.postgres.DB
+		publishPoolStats(store.postgres.Pool)
+	} else {
+		slog.Warn("only the account RPCs are served with this database.driver; the rest answer UNIMPLEMENTED",
+			"driver", cfg.Database.Driver)
+	}
+	if store.sql != nil {
+		prometheus.MustRegister(collectors.NewDBStatsCollector(store.sql, "primary"))
+	}
 
-		//grpcServer := grpc.NewServer()
-		// We register the interceptor here!
-		grpcServer := grpc.NewServer(
-			grpc.UnaryInterceptor(AuthInterceptor),
-		)
-		pb.RegisterUserServiceServer(grpcServer, &server{db: dbConn})
+	// Wire-level stats (message sizes, compression, connection churn) are
+	// exported on /metrics; set server.log_payload_sizes to also log them.
+	wireMetrics := middleware.NewWireMetrics(prometheus.DefaultRegisterer)
+	logPayloadSizes := cfg.Server.LogPayloadSizes
+
+	//grpcServer := grpc.NewServer()
+	// We register the interceptor here!
+	policy := func() *middleware.Policy { return builtinPolicy }
+	var policyFile *middleware.PolicyFile
+	if cfg.Auth.PolicyFile != "" {
+		policyFile, err = middleware.LoadPolicyFile(cfg.Auth.PolicyFile)
+		if err != nil {
+			fatal("failed to load auth.policy_file", "error", err)
+		}
+		policy = policyFile.Policy
+	}
+	readOnly := newReadOnlyMode(cfg.Server.ReadOnly, policy)
+	subsys := newSubsystems()
+	registerSubsystems(subsys, cfg)
+	state := newStateStores(cfg, dbConn, readOnly, subsys.reporter(subsystemStorage))
+	proxies, err := middleware.ParseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		fatal("invalid server.trusted_proxies", "error", err)
+	}
+	mwOpts := []middleware.Option{
+		middleware.WithRequestIDs(),
+		middleware.WithTrustedProxies(proxies),
+		middleware.WithRegion(cfg.Server.Region),
+		middleware.WithLogging(slog.Default()),
+		middleware.WithMetrics(middleware.NewRPCMetrics(prometheus.DefaultRegisterer)),
+		middleware.WithDeadlines(deadlineConfig(cfg.Timeouts)),
+		middleware.WithRateLimit(rateLimitConfig(cfg.RateLimit, state.rateLimits)),
+		middleware.WithPolicy(policy),
+		middleware.WithStreamInterceptors(readOnly.streamInterceptor),
+	}
+	if cfg.Tracing.Enabled {
+		mwOpts = append(mwOpts, middleware.WithTracing(traceConfig(cfg.Tracing)))
+	}
+	if cfg.Auth.Enabled {
+		mwOpts = append(mwOpts, middleware.WithAuth(authConfig(state.denylist)))
+	} else {
+		slog.Warn("authentication is disabled (auth.enabled=false): every caller is treated as an admin; never run like this outside a demo")
+	}
+	var unary []grpc.UnaryServerInterceptor
+	if dbConn == nil {
+		guard := driverGuard{driver: cfg.Database.Driver}
+		unary = append(unary, guard.interceptor)
+		mwOpts = append(mwOpts, middleware.WithStreamInterceptors(guard.streamInterceptor))
+	}
+	if threshold := cfg.Database.SlowRequestThreshold.Duration; threshold > 0 {
+		unary = append(unary, newSlowRequests(dbConn, threshold, cfg.Database.ExplainSlowRequests).interceptor)
+	}
+	unary = append(unary,
+		readOnly.interceptor,
+		actorInterceptor(cfg.Auth.Enabled, policy),
+		ValidationInterceptor,
+	)
+	// Consents are recorded in Postgres; elsewhere none could be given
+	if dbConn != nil {
+		unary = append(unary, consentInterceptor(dbConn))
+	}
+	if cfg.Audit.Enabled {
+		unary = append(unary, (&auditTrail{db: dbConn, readOnly: readOnly, report: subsys.reporter(subsystemAudit)}).interceptor)
+	}
+	serverOpts := append(grpcServerOptions(cfg.GRPC),
+		grpc.StatsHandler(wireMetrics.StatsHandler("server", logPayloadSizes)),
+		middleware.ServerOption(append(mwOpts, middleware.WithUnaryInterceptors(unary...))...),
+		middleware.StreamServerOption(mwOpts...),
+	)
+	outbound, err := httpclient.New(outboundConfig(cfg.Outbound))
+	if err != nil {
+		fatal("failed to set up outbound HTTP client", "error", err)
+	}
+	avatars, err := avatarPipeline(cfg.Avatars)
+	if err != nil {
+		fatal("failed to set up avatar processing", "error", err)
+	}
+	queries := newQueryLog()
+	events := newUserEvents()
+	var repo repository.UserRepository
+	var pgRepo *repository.Postgres
+	if dbConn == nil {
+		repo = store.repository(queries.observe)
+	} else {
+		pgRepo = repository.NewPostgres(dbConn, queries.observe).Retry(repository.RetryPolicy{
+			MaxAttempts: cfg.Database.RetryMaxAttempts,
+			BaseDelay:   cfg.Database.RetryBaseDelay.Duration,
+			MaxDelay:    cfg.Database.RetryMaxDelay.Duration,
+			BudgetRatio: cfg.Database.RetryBudget,
+		}).Instrument(repository.NewQueryMetrics(prometheus.DefaultRegisterer))
+		repo = pgRepo
+	}
+	var replicas *db.Replicas
+	if len(cfg.Database.ReplicaURLs) > 0 {
+		replicas, err = db.OpenReplicas(dbConn, cfg.Database.ReplicaURLs, dbOpts...)
+		if err != nil {
+			fatal("failed to open database.replica_urls", "error", err)
+		}
+		defer replicas.Close()
+		for i, pool := range replicas.Pools() {
+			prometheus.MustRegister(collectors.NewDBStatsCollector(pool, fmt.Sprintf("replica%d", i)))
+		}
+		pgRepo.ReadFrom(replicas.Reader)
+	}
+	users := service.NewUsers(repo, events.publish, hashPassword)
+	users.SetPasswordPolicy(passwordPolicy(cfg.Password, outbound))
+	svc := &server{
+		db:         dbConn,
+		repo:       pgRepo,
+		users:      users,
+		mailer:     reportingMailer{newMailer(cfg.Mail), subsys.reporter(subsystemMail)},
+		events:     events,
+		queries:    queries,
+		readOnly:   readOnly,
+		webhooks:   newWebhooks(dbConn, outbound, cfg.Webhooks, cfg.Outbound, readOnly, subsys.reporter(subsystemWebhooks)),
+		avatars:    avatars,
+		state:      state,
+		subsystems: subsys,
+		oauth:      newOAuthLogin(cfg.OAuth, outbound, subsys.reporter(subsystemOAuth)),
+	}
 
-		log.Println("gRPC server running on :50051")
+	creds, err := serverCredentials(cfg.GRPC.TLS)
+	if err != nil {
+		fatal("failed to set up TLS", "error", err)
+	}
+	publicOpts := serverOpts
+	if creds != nil {
+		publicOpts = append(publicOpts[:len(publicOpts):len(publicOpts)], grpc.Creds(creds))
+	}
+	// With UUID ids, v2 is served next to v1 so clients can move over
+	var v2 *usersV2
+	if cfg.Database.UserIDs == "uuid" {
+		v2 = &usersV2{users: users, ids: pgRepo}
+	}
+	healthSrv := health.NewServer()
+	grpcServer := grpc.NewServer(publicOpts...)
+	pb.RegisterUserServiceServer(grpcServer, svc)
+	if v2 != nil {
+		pbv2.RegisterUserServiceServer(grpcServer, v2)
+	}
+	healthpb.RegisterHealthServer(grpcServer, healthSrv)
+
+	// Everything below runs in one group: the first server to fail, or
+	// SIGINT/SIGTERM, cancels ctx, which shuts the others down and stops
+	// the background workers.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	g, ctx := errgroup.WithContext(ctx)
+
+	ready := &readiness{db: store}
+	lis, err := net.Listen("tcp", cfg.Server.GRPCAddr)
+	if err != nil {
+		fatal("failed to listen on gRPC port", "error", err)
+	}
+	g.Go(func() error {
+		slog.Info("gRPC server running", "addr", cfg.Server.GRPCAddr,
+			"tls", creds != nil, "client_certs", cfg.GRPC.TLS.ClientCAFile != "")
+		ready.grpcServing.Store(true)
+		defer ready.grpcServing.Store(false)
 		if err := grpcServer.Serve(lis); err != nil {
-			log.Fatal("Failed to serve gRPC:", err)
+			return fmt.Errorf("serve gRPC: %w", err)
+		}
+		return nil
+	})
+	grpcServers := []*grpc.Server{grpcServer}
+
+	// The gateway dials the plaintext port directly; with TLS on it gets a
+	// private in-memory server with the same interceptors instead.
+	target := gatewayTarget(cfg.Server.GRPCAddr)
+	var gatewayDial []grpc.DialOption
+	if creds != nil {
+		pipe := newPipeListener()
+		internal := grpc.NewServer(serverOpts...)
+		pb.RegisterUserServiceServer(internal, svc)
+		if v2 != nil {
+			pbv2.RegisterUserServiceServer(internal, v2)
 		}
-	}()
+		g.Go(func() error {
+			if err := internal.Serve(pipe); err != nil {
+				return fmt.Errorf("serve gateway listener: %w", err)
+			}
+			return nil
+		})
+		grpcServers = append(grpcServers, internal)
+		target = "passthrough:///gateway"
+		gatewayDial = append(gatewayDial, pipe.dialOption())
+	}
 
-	ctx := context.Background()
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	svc.webhooks.start(ctx, svc.events)
+	if dbConn != nil {
+		go newCapacityMonitor(svc, cfg.Capacity, prometheus.DefaultRegisterer).run(ctx)
+	}
+	if replicas != nil {
+		go replicas.Watch(ctx, cfg.Database.ReplicaCheckInterval.Duration)
+	}
+	dbServices := []string{pb.UserService_ServiceDesc.ServiceName}
+	if v2 != nil {
+		dbServices = append(dbServices, pbv2.UserService_ServiceDesc.ServiceName)
+	}
+	go (&dbHealth{
+		db:        store,
+		srv:       healthSrv,
+		services:  dbServices,
+		interval:  cfg.Database.HealthCheckInterval.Duration,
+		threshold: cfg.Database.HealthFailureThreshold,
+	}).run(ctx)
+	if state.cleanup != nil {
+		go state.cleanup(ctx)
+	}
+	if cfg.Server.DebugAddr != "" {
+		go serveDebug(cfg.Server.DebugAddr)
+	}
+	if policyFile != nil {
+		go policyFile.Watch(ctx, cfg.Auth.PolicyReloadInterval.Duration, subsys.reporter(subsystemPolicy))
+	}
 
-	conn, err := grpc.NewClient(
-		"localhost:50051",
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	pool, err := newGatewayPool(cfg.GRPC.GatewayConnections, target, prometheus.DefaultRegisterer,
+		append(gatewayDial,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithStatsHandler(wireMetrics.StatsHandler("client", logPayloadSizes)),
+			gatewayCallOptions(cfg.GRPC),
+		)...,
 	)
 	if err != nil {
-		log.Fatal("Failed to dial gRPC server:", err)
+		fatal("failed to dial gRPC server", "error", err)
 	}
-	defer conn.Close()
+	defer pool.Close()
 
-	mux := runtime.NewServeMux()
+	muxOpts := []runtime.ServeMuxOption{
+		runtime.WithForwardResponseOption(redirectMovedUsers),
+		runtime.WithIncomingHeaderMatcher(gatewayIncomingHeader),
+		runtime.WithOutgoingHeaderMatcher(gatewayOutgoingHeader),
+		runtime.WithMetadata(gatewayMetadata),
+	}
+	cookies := cookieAuth{secure: cfg.Auth.CookieSecure}
+	if cfg.Auth.CookieAuth {
+		muxOpts = append(muxOpts, runtime.WithForwardResponseOption(cookies.forwardResponse))
+	}
+	mux := runtime.NewServeMux(muxOpts...)
 
-	err = gw.RegisterUserServiceHandler(ctx, mux, conn)
+	err = gw.RegisterUserServiceHandlerClient(ctx, mux, pb.NewUserServiceClient(pool))
 	if err != nil {
-		log.Fatal("Failed to register gateway:", err)
+		fatal("failed to register gateway", "error", err)
+	}
+	if v2 != nil {
+		if err := pbv2.RegisterUserServiceHandlerClient(ctx, mux, pbv2.NewUserServiceClient(pool)); err != nil {
+			fatal("failed to register v2 gateway", "error", err)
+		}
+	}
+	gateway := http.Handler(mux)
+	if cfg.Auth.CookieAuth {
+		gateway = cookies.handler(mux)
 	}
 
-	log.Println("HTTP/REST gateway running on :8080")
-	log.Println("POST   http://localhost:8080/v1/users")
-	log.Println("GET    http://localhost:8080/v1/users/{id}")
-	log.Println("PUT    http://localhost:8080/v1/users/{id}")
-	log.Println("DELETE http://localhost:8080/v1/users/{id}")
-
-	if err := http.ListenAndServe(":8080", mux); err != nil {
-		log.Fatal("Failed to serve HTTP:", err)
+	httpMux := http.NewServeMux()
+	httpMux.Handle("/metrics", promhttp.Handler())
+	httpMux.Handle("/healthz", healthz(cfg.Server.Region, readOnly, subsys))
+	httpMux.Handle("/readyz", ready.readyz())
+	httpMux.Handle("/.well-known/jwks.json", jwksHandler())
+	// The gateway resolves the client behind trusted proxies the same way
+	// the gRPC server does and forwards only that address
+	httpMux.Handle("/", proxies.Handler(gateway))
+	httpMux.Handle("/v1/oauth/", proxies.Handler(oauthGateway(svc.oauth, gateway)))
+
+	// See README.md for the full list of routes
+	slog.Info("HTTP/REST gateway running", "addr", cfg.Server.HTTPAddr, "metrics", "/metrics", "region", cfg.Server.Region)
+
+	httpServer := &http.Server{Addr: cfg.Server.HTTPAddr, Handler: httpMux}
+	g.Go(func() error {
+		if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("serve HTTP: %w", err)
+		}
+		return nil
+	})
+	g.Go(func() error {
+		<-ctx.Done()
+		shutdown(httpServer, grpcServers)
+		return nil
+	})
+
+	if err := g.Wait(); err != nil {
+		fatal("server stopped", "error", err)
 	}
+	slog.Info("server stopped")
 }
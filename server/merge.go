@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+	"grpc-crud-proj/repository"
+	"grpc-crud-proj/service"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mergeStep is one statement of a merge, run with the source id as $1 and
+// the target id as $2, and the table whose user_id rows it handles.
+type mergeStep struct {
+	table string
+	sql   string
+}
+
+// mergeSteps decides what happens to each table with a user_id referencing
+// users, rows of the source one by one: moved to the target, merged with
+// its own by strategy, revoked or deleted. A migration adding such a table
+// has to add it here too, or the source keeps rows attached to a deleted
+// account. audit_log, audit_events and webhook_events keep their history
+// under the source id on purpose.
+func mergeSteps(strategy pb.MergeStrategy) []mergeStep {
+	preferSource := strategy == pb.MergeStrategy_MERGE_STRATEGY_PREFER_SOURCE
+	// The strategy decides who wins on key conflicts
+	onConflict := "DO NOTHING"
+	// jsonb || keeps the right-hand value of keys both sides set
+	notifications := "EXCLUDED.settings || notification_preferences.settings"
+	if preferSource {
+		onConflict = "DO UPDATE SET value = EXCLUDED.value"
+		notifications = "notification_preferences.settings || EXCLUDED.settings"
+	}
+	steps := []mergeStep{
+		{"preferences", `INSERT INTO preferences(user_id, key, value)
+		 SELECT $2, key, value FROM preferences WHERE user_id=$1
+		 ON CONFLICT (user_id, key) ` + onConflict},
+		{"preferences", "DELETE FROM preferences WHERE user_id=$1"},
+		{"notification_preferences", `INSERT INTO notification_preferences(user_id, settings)
+		 SELECT $2, settings FROM notification_preferences WHERE user_id=$1
+		 ON CONFLICT (user_id) DO UPDATE SET settings = ` + notifications},
+		{"notification_preferences", "DELETE FROM notification_preferences WHERE user_id=$1"},
+	}
+	// Avatars go as a set: the source's replace the target's when preferred,
+	// and otherwise only if the target has none
+	if preferSource {
+		steps = append(steps, mergeStep{"avatars",
+			"DELETE FROM avatars WHERE user_id=$2 AND EXISTS (SELECT 1 FROM avatars WHERE user_id=$1)"})
+	}
+	return append(steps, []mergeStep{
+		{"avatars", "UPDATE avatars SET user_id=$2 WHERE user_id=$1 AND NOT EXISTS (SELECT 1 FROM avatars WHERE user_id=$2)"},
+		{"avatars", "DELETE FROM avatars WHERE user_id=$1"},
+		{"consents", "UPDATE consents SET user_id=$2 WHERE user_id=$1"},
+		{"user_identities", "UPDATE user_identities SET user_id=$2 WHERE user_id=$1"},
+		// The source can't log in any more, so neither can its sessions
+		{"refresh_tokens", "UPDATE refresh_tokens SET revoked_at=now() WHERE user_id=$1 AND revoked_at IS NULL"},
+		// Links mailed to the source's address would act on a deleted account
+		{"email_changes", "DELETE FROM email_changes WHERE user_id=$1"},
+		{"email_verifications", "DELETE FROM email_verifications WHERE user_id=$1"},
+		// Accounts previously merged into the source now redirect to the target
+		{"users", "UPDATE users SET merged_into=$2 WHERE merged_into=$1"},
+		// Soft-delete the source, leaving the redirect marker behind
+		{"users", "UPDATE users SET deleted_at=now(), merged_into=$2 WHERE id=$1"},
+	}...)
+}
+
+// MergeUsers folds a duplicate account (source) into the surviving one
+// (target). The source's child records are moved, merged or dropped as
+// mergeSteps says, its sessions and tokens are revoked, and it is
+// soft-deleted with merged_into set so that GetUser on the old id returns
+// the target. The target's version is bumped, and both accounts get an
+// audit entry.
+func (s *server) MergeUsers(ctx context.Context, req *pb.MergeUsersRequest) (*pb.UserResponse, error) {
+	if err := service.RequirePermission(ctx, service.PermUserDelete); err != nil {
+		return nil, serviceStatus(err)
+	}
+	if req.SourceId == req.TargetId {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot merge a user into itself")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to start merge: %v", err)
+	}
+	defer tx.Rollback()
+
+	// 1. Lock both rows, in id order so merges in opposite directions
+	// can't deadlock, and so concurrent merges/updates can't interleave
+	rows, err := tx.QueryContext(ctx,
+		"SELECT id, name, email, role, status, version FROM users WHERE id = ANY($1) AND deleted_at IS NULL ORDER BY id FOR UPDATE",
+		[]int32{req.SourceId, req.TargetId},
+	)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load users: %v", err)
+	}
+	locked := map[int32]*pb.User{}
+	for rows.Next() {
+		var u pb.User
+		var userStatus string
+		if err := rows.Scan(&u.Id, &u.Name, &u.Email, &u.Role, &userStatus, &u.Version); err != nil {
+			rows.Close()
+			return nil, status.Errorf(codes.Internal, "failed to load users: %v", err)
+		}
+		u.Status = statusFromDB(userStatus)
+		locked[u.Id] = &u
+	}
+	if err := rows.Err(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load users: %v", err)
+	}
+	target, source := locked[req.TargetId], locked[req.SourceId]
+	if target == nil {
+		return nil, status.Errorf(codes.NotFound, "target user %d not found", req.TargetId)
+	}
+	if source == nil {
+		return nil, status.Errorf(codes.NotFound, "source user %d not found", req.SourceId)
+	}
+
+	// 2. Move, merge or drop the source's rows table by table
+	for _, step := range mergeSteps(req.Strategy) {
+		if _, err := tx.ExecContext(ctx, step.sql, req.SourceId, req.TargetId); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to merge %s: %v", step.table, err)
+		}
+	}
+
+	// 3. The target changed under optimistic-concurrency clients
+	if err := tx.QueryRowContext(ctx,
+		"UPDATE users SET version=version+1 WHERE id=$1 RETURNING version", req.TargetId,
+	).Scan(&target.Version); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to update target user: %v", err)
+	}
+
+	// 4. Record it on both accounts in the same transaction
+	actor, _ := service.ActorFromContext(ctx)
+	repo := s.repo.InTx(tx)
+	detail := fmt.Sprintf("source=%d target=%d strategy=%s", req.SourceId, req.TargetId, req.Strategy)
+	for _, id := range []int32{req.SourceId, req.TargetId} {
+		if err := repo.Audit(ctx, repository.AuditEntry{Actor: actor.Email, Action: "merge", UserID: id, Detail: detail}); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to audit merge: %v", err)
+		}
+	}
+
+	// 5. Deny the source's access tokens. The denylist can't join the
+	// transaction, so this goes last before the commit: a merge that then
+	// fails only costs the source a login.
+	if err := s.state.denylist.revokeUser(ctx, source.Email, time.Now().Add(maxTokenTTL())); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke source tokens: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit merge: %v", err)
+	}
+
+	s.events.publish(pb.UserEventType_USER_EVENT_TYPE_DELETED, &pb.User{Id: req.SourceId})
+	s.events.publish(pb.UserEventType_USER_EVENT_TYPE_UPDATED, target)
+	slog.InfoContext(ctx, "merged users", "user_id", req.TargetId, "source_id", req.SourceId, "strategy", req.Strategy.String())
+	return &pb.UserResponse{User: target}, nil
+}
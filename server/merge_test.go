@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-crud-proj/db"
+	"grpc-crud-proj/internal/config"
+	"grpc-crud-proj/middleware"
+	pb "grpc-crud-proj/proto/google/userpb"
+	"grpc-crud-proj/repository"
+	"grpc-crud-proj/service"
+	"grpc-crud-proj/testutil"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var schemaTable = regexp.MustCompile(`(?i)^\s*(?:CREATE TABLE IF NOT EXISTS|CREATE TABLE|ALTER TABLE)\s+(\w+)`)
+
+// TestMergeStepsCoverUserTables fails when a migration adds a table
+// referencing users that mergeSteps doesn't handle.
+func TestMergeStepsCoverUserTables(t *testing.T) {
+	handled := map[string]bool{}
+	for _, strategy := range []pb.MergeStrategy{pb.MergeStrategy_MERGE_STRATEGY_KEEP_TARGET, pb.MergeStrategy_MERGE_STRATEGY_PREFER_SOURCE} {
+		for _, step := range mergeSteps(strategy) {
+			handled[step.table] = true
+		}
+	}
+
+	var table string
+	for _, line := range strings.Split(db.Schema, "\n") {
+		if m := schemaTable.FindStringSubmatch(line); m != nil {
+			table = m[1]
+		}
+		if strings.Contains(line, "REFERENCES users(") && !handled[table] {
+			t.Errorf("%s references users but mergeSteps doesn't handle it", table)
+			handled[table] = true
+		}
+	}
+}
+
+func TestMergeUsers(t *testing.T) {
+	conn := testutil.DB(t)
+	source := testutil.CreateUser(t, conn)
+	target := testutil.CreateUser(t, conn)
+	ctx := service.WithActor(context.Background(), service.Actor{Email: "admin@example.test", Role: service.AdminRole})
+
+	exec := func(query string, args ...interface{}) {
+		t.Helper()
+		if _, err := conn.Exec(query, args...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	exec("INSERT INTO preferences(user_id, key, value) VALUES($1, 'theme', 'dark'), ($1, 'lang', 'de'), ($2, 'theme', 'light')", source.ID, target.ID)
+	exec(`INSERT INTO notification_preferences(user_id, settings) VALUES($1, '{"email": false, "sms": true}'), ($2, '{"email": true}')`, source.ID, target.ID)
+	exec("INSERT INTO refresh_tokens(token_hash, family_id, user_id, expires_at) VALUES($1, 'family', $2, now() + interval '1 day')", strings.Repeat("a", 64), source.ID)
+	exec("INSERT INTO email_verifications(token_hash, user_id, email, expires_at) VALUES($1, $2, $3, now() + interval '1 day')", strings.Repeat("b", 64), source.ID, source.Email)
+
+	srv := &server{
+		db:     conn,
+		repo:   repository.NewPostgres(conn, nil),
+		events: newUserEvents(),
+		state:  newStateStores(config.Default(), conn, nil, nil),
+	}
+	resp, err := srv.MergeUsers(ctx, &pb.MergeUsersRequest{SourceId: source.ID, TargetId: target.ID})
+	if err != nil {
+		t.Fatalf("MergeUsers: %v", err)
+	}
+	if resp.User.Id != target.ID || resp.User.Version != 2 {
+		t.Errorf("merged user = id %d version %d, want id %d version 2", resp.User.Id, resp.User.Version, target.ID)
+	}
+
+	count := func(query string, args ...interface{}) int {
+		t.Helper()
+		var n int
+		if err := conn.QueryRow(query, args...).Scan(&n); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return n
+	}
+	var theme, settings string
+	if err := conn.QueryRow("SELECT value FROM preferences WHERE user_id=$1 AND key='theme'", target.ID).Scan(&theme); err != nil {
+		t.Fatal(err)
+	}
+	if theme != "light" {
+		t.Errorf("target theme = %q, want the target's own light", theme)
+	}
+	if n := count("SELECT count(*) FROM preferences WHERE user_id=$1", target.ID); n != 2 {
+		t.Errorf("target has %d preferences, want 2", n)
+	}
+	if err := conn.QueryRow("SELECT settings::text FROM notification_preferences WHERE user_id=$1", target.ID).Scan(&settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings != `{"sms": true, "email": true}` {
+		t.Errorf("target notification settings = %s, want the source's sms with the target's email", settings)
+	}
+	for query, want := range map[string]int{
+		"SELECT count(*) FROM preferences WHERE user_id=$1":                                                 0,
+		"SELECT count(*) FROM notification_preferences WHERE user_id=$1":                                    0,
+		"SELECT count(*) FROM email_verifications WHERE user_id=$1":                                         0,
+		"SELECT count(*) FROM refresh_tokens WHERE user_id=$1 AND revoked_at IS NULL":                       0,
+		"SELECT count(*) FROM users WHERE id=$1 AND deleted_at IS NOT NULL AND merged_into IS NOT NULL":     1,
+		"SELECT count(*) FROM audit_log WHERE action='merge' AND detail LIKE 'source=' || $1::text || ' %'": 2,
+	} {
+		if n := count(query, source.ID); n != want {
+			t.Errorf("%s = %d, want %d", query, n, want)
+		}
+	}
+
+	issued := &middleware.Claims{Email: source.Email, RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))}}
+	if revoked, err := srv.state.denylist.revoked(ctx, issued); err != nil || !revoked {
+		t.Errorf("source token revoked = %v, %v; want true", revoked, err)
+	}
+}
+
+func TestMergeUsersOppositeDirections(t *testing.T) {
+	conn := testutil.DB(t)
+	a := testutil.CreateUser(t, conn)
+	b := testutil.CreateUser(t, conn)
+	ctx := service.WithActor(context.Background(), service.Actor{Email: "admin@example.test", Role: service.AdminRole})
+	srv := &server{
+		db:     conn,
+		repo:   repository.NewPostgres(conn, nil),
+		events: newUserEvents(),
+		state:  newStateStores(config.Default(), conn, nil, nil),
+	}
+
+	// Both lock the two rows in id order, so one waits for the other and
+	// then finds its source or target gone instead of deadlocking
+	errs := make(chan error, 2)
+	for _, req := range []*pb.MergeUsersRequest{{SourceId: a.ID, TargetId: b.ID}, {SourceId: b.ID, TargetId: a.ID}} {
+		go func() {
+			_, err := srv.MergeUsers(ctx, req)
+			errs <- err
+		}()
+	}
+	var merged int
+	for range 2 {
+		if err := <-errs; err == nil {
+			merged++
+		} else if strings.Contains(err.Error(), "deadlock") {
+			t.Errorf("MergeUsers deadlocked: %v", err)
+		}
+	}
+	if merged != 1 {
+		t.Errorf("%d merges succeeded, want 1", merged)
+	}
+}
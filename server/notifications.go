@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"time"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+	"grpc-crud-proj/service"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Notification event types a user can opt out of.
+const (
+	eventEmailChanged  = "email_changed"
+	eventAccountStatus = "account_status"
+	eventCapacityAlert = "capacity_alert"
+)
+
+var notificationEvents = map[string]bool{
+	eventEmailChanged:  true,
+	eventAccountStatus: true,
+	eventCapacityAlert: true,
+}
+
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+
+// notificationSettings is the JSONB document stored in notification_preferences.
+type notificationSettings struct {
+	EmailEvents map[string]bool `json:"email_events,omitempty"`
+	Locale      string          `json:"locale,omitempty"`
+	Timezone    string          `json:"timezone,omitempty"`
+}
+
+func defaultNotificationSettings() notificationSettings {
+	return notificationSettings{Locale: "en", Timezone: "UTC"}
+}
+
+// wants reports whether the user should be emailed about event.
+func (n notificationSettings) wants(event string) bool {
+	enabled, ok := n.EmailEvents[event]
+	return !ok || enabled
+}
+
+func (n notificationSettings) toProto() *pb.NotificationPreferences {
+	events := make(map[string]bool, len(notificationEvents))
+	for event := range notificationEvents {
+		events[event] = n.wants(event)
+	}
+	return &pb.NotificationPreferences{EmailEvents: events, Locale: n.Locale, Timezone: n.Timezone}
+}
+
+// validateNotificationPreferences is the schema for the stored document.
+func validateNotificationPreferences(v *violations, prefs *pb.NotificationPreferences) {
+	if prefs == nil {
+		v.add("preferences", service.FieldRequired, "must be set")
+		return
+	}
+	for event := range prefs.EmailEvents {
+		if !notificationEvents[event] {
+			v.add("preferences.email_events", service.FieldInvalidValue, "unknown event type %q", event)
+		}
+	}
+	if prefs.Locale != "" && !localePattern.MatchString(prefs.Locale) {
+		v.add("preferences.locale", service.FieldInvalidValue, "must look like \"en\" or \"pt-BR\"")
+	}
+	if prefs.Timezone != "" {
+		if _, err := time.LoadLocation(prefs.Timezone); err != nil {
+			v.add("preferences.timezone", service.FieldInvalidValue, "unknown time zone %q", prefs.Timezone)
+		}
+	}
+}
+
+func (s *server) loadNotificationSettings(ctx context.Context, userID int32) (notificationSettings, error) {
+	settings := defaultNotificationSettings()
+	var raw []byte
+	err := s.db.QueryRowContext(ctx,
+		"SELECT settings FROM notification_preferences WHERE user_id=$1",
+		userID,
+	).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	if err := json.Unmarshal(raw, &settings); err != nil {
+		return settings, fmt.Errorf("corrupt notification preferences for user %d: %w", userID, err)
+	}
+	return settings, nil
+}
+
+// notify emails a user about event unless they opted out of it. to is passed
+// explicitly because some notices go to an address the user no longer has.
+func (s *server) notify(ctx context.Context, userID int32, event, to, subject, body string) error {
+	settings, err := s.loadNotificationSettings(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !settings.wants(event) {
+		slog.DebugContext(ctx, "skipping notification: opted out", "user_id", userID, "event", event)
+		return nil
+	}
+
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	body += "\n\nSent " + time.Now().In(loc).Format("Mon, 02 Jan 2006 15:04 MST")
+	return s.mailer.Send(ctx, to, subject, body)
+}
+
+func (s *server) GetNotificationPreferences(ctx context.Context, req *pb.GetNotificationPreferencesRequest) (*pb.NotificationPreferences, error) {
+	if err := service.RequirePermission(ctx, service.PermUserRead); err != nil {
+		return nil, serviceStatus(err)
+	}
+	settings, err := s.loadNotificationSettings(ctx, req.Id)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load notification preferences: %v", err)
+	}
+	return settings.toProto(), nil
+}
+
+func (s *server) UpdateNotificationPreferences(ctx context.Context, req *pb.UpdateNotificationPreferencesRequest) (*pb.NotificationPreferences, error) {
+	if err := service.RequirePermission(ctx, service.PermUserWrite); err != nil {
+		return nil, serviceStatus(err)
+	}
+	if req.Preferences == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "preferences must be set")
+	}
+
+	settings := defaultNotificationSettings()
+	settings.EmailEvents = req.Preferences.EmailEvents
+	if req.Preferences.Locale != "" {
+		settings.Locale = req.Preferences.Locale
+	}
+	if req.Preferences.Timezone != "" {
+		settings.Timezone = req.Preferences.Timezone
+	}
+
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode notification preferences: %v", err)
+	}
+
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO notification_preferences(user_id, settings)
+		 SELECT id, $2 FROM users WHERE id=$1 AND deleted_at IS NULL
+		 ON CONFLICT (user_id) DO UPDATE SET settings = EXCLUDED.settings`,
+		req.Id, raw,
+	)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save notification preferences: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+
+	return settings.toProto(), nil
+}
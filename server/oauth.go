@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"grpc-crud-proj/internal/config"
+	"grpc-crud-proj/internal/httpclient"
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxProviderResponse bounds what is read from a provider's token and user
+// endpoints.
+const maxProviderResponse = 1 << 20
+
+// oauthProvider is an OAuth2 identity provider users can sign in with.
+type oauthProvider struct {
+	name         string
+	clientID     string
+	clientSecret string
+	authURL      string
+	tokenURL     string
+	scopes       string
+	// identity fetches the signed-in user with an access token.
+	identity func(ctx context.Context, o *oauthLogin, accessToken string) (oauthIdentity, error)
+}
+
+// oauthIdentity is who the provider says signed in. Subject is the
+// provider's stable id for the account; emails can change.
+type oauthIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// oauthLogin holds the configured providers for LoginWithProvider and the
+// gateway's redirect handlers.
+type oauthLogin struct {
+	client       *httpclient.Client
+	providers    map[string]*oauthProvider
+	redirectBase string
+	report       func(error)
+}
+
+func newOAuthLogin(cfg config.OAuthConfig, client *httpclient.Client, report func(error)) *oauthLogin {
+	o := &oauthLogin{
+		client:       client,
+		providers:    map[string]*oauthProvider{},
+		redirectBase: strings.TrimSuffix(cfg.RedirectBaseURL, "/"),
+		report:       report,
+	}
+	if cfg.GoogleClientID != "" {
+		o.providers["google"] = &oauthProvider{
+			name:         "google",
+			clientID:     cfg.GoogleClientID,
+			clientSecret: cfg.GoogleClientSecret,
+			authURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+			tokenURL:     "https://oauth2.googleapis.com/token",
+			scopes:       "openid email profile",
+			identity:     googleIdentity,
+		}
+	}
+	if cfg.GitHubClientID != "" {
+		o.providers["github"] = &oauthProvider{
+			name:         "github",
+			clientID:     cfg.GitHubClientID,
+			clientSecret: cfg.GitHubClientSecret,
+			authURL:      "https://github.com/login/oauth/authorize",
+			tokenURL:     "https://github.com/login/oauth/access_token",
+			scopes:       "read:user user:email",
+			identity:     githubIdentity,
+		}
+	}
+	return o
+}
+
+// redirectURI is where provider sends the browser back to with the code.
+func (o *oauthLogin) redirectURI(provider string) string {
+	return o.redirectBase + "/v1/oauth/" + provider + "/callback"
+}
+
+// LoginWithProvider exchanges an authorization code for the provider's view
+// of the user and logs them in. The account is found by the provider's
+// subject id, or on first sign-in by a verified email, in which case the
+// identity is linked to it or a passwordless account is created. Attempts
+// are recorded for ListLoginAttempts once the provider has named an email.
+func (s *server) LoginWithProvider(ctx context.Context, req *pb.LoginWithProviderRequest) (resp *pb.LoginResponse, err error) {
+	var email string
+	defer func() { s.recordLoginAttempt(ctx, req.Provider, email, err) }()
+
+	p, ok := s.oauth.providers[req.Provider]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "provider %q is not configured", req.Provider)
+	}
+	accessToken, err := s.oauth.exchange(ctx, p, req.Code, req.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	id, err := p.identity(ctx, s.oauth, accessToken)
+	s.oauth.report(providerUnavailable(err))
+	if err != nil {
+		return nil, providerStatus(p, err)
+	}
+	email = normalizeEmail(id.Email)
+
+	userID, err := s.identityUser(ctx, p.name, id)
+	if err != nil {
+		return nil, err
+	}
+	var role, userStatus string
+	err = s.db.QueryRowContext(ctx,
+		"SELECT email, role, status FROM users WHERE id=$1 AND deleted_at IS NULL", userID,
+	).Scan(&email, &role, &userStatus)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load user: %v", err)
+	}
+	if statusFromDB(userStatus) == pb.UserStatus_USER_STATUS_SUSPENDED {
+		return nil, status.Errorf(codes.PermissionDenied, "account is suspended")
+	}
+	return s.startSession(ctx, userID, email, role, req.Device)
+}
+
+// identityUser returns the account id is linked to, linking or creating one
+// on first sign-in.
+func (s *server) identityUser(ctx context.Context, provider string, id oauthIdentity) (int32, error) {
+	var userID int32
+	err := s.db.QueryRowContext(ctx,
+		`SELECT i.user_id FROM user_identities i JOIN users u ON u.id = i.user_id
+		 WHERE i.provider=$1 AND i.subject=$2 AND u.deleted_at IS NULL`,
+		provider, id.Subject,
+	).Scan(&userID)
+	if err == nil {
+		return userID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, status.Errorf(codes.Internal, "failed to look up identity: %v", err)
+	}
+
+	// Only an address the provider checked may claim an account
+	if !id.EmailVerified || id.Email == "" {
+		return 0, status.Errorf(codes.PermissionDenied, "%s account has no verified email address", provider)
+	}
+	email := normalizeEmail(id.Email)
+	err = s.db.QueryRowContext(ctx,
+		"SELECT id FROM users WHERE email=$1 AND deleted_at IS NULL", email,
+	).Scan(&userID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		user, err := s.users.Provision(ctx, id.Name, email)
+		if err != nil {
+			return 0, serviceStatus(err)
+		}
+		userID = user.Id
+	case err != nil:
+		return 0, status.Errorf(codes.Internal, "failed to look up user: %v", err)
+	}
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT INTO user_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (provider, subject) DO UPDATE SET user_id=EXCLUDED.user_id, email=EXCLUDED.email`,
+		provider, id.Subject, userID, email,
+	); err != nil {
+		return 0, status.Errorf(codes.Internal, "failed to link identity: %v", err)
+	}
+	// The provider checked the address, which is the account's
+	if _, err := s.db.ExecContext(ctx,
+		"UPDATE users SET email_verified_at=COALESCE(email_verified_at, now()) WHERE id=$1", userID,
+	); err != nil {
+		return 0, status.Errorf(codes.Internal, "failed to mark email verified: %v", err)
+	}
+	slog.InfoContext(ctx, "AUDIT identity linked", "provider", provider, "subject", id.Subject, "user_id", userID, "email", email)
+	return userID, nil
+}
+
+// exchange trades an authorization code for an access token.
+func (o *oauthLogin) exchange(ctx context.Context, p *oauthProvider, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.redirectURI(p.name)},
+		"client_id":     {p.clientID},
+		"client_secret": {p.clientSecret},
+	}
+	if verifier != "" {
+		form.Set("code_verifier", verifier)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "cannot build token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = o.do(req, &token)
+	o.report(providerUnavailable(err))
+	if err != nil {
+		return "", providerStatus(p, err)
+	}
+	// GitHub answers 200 with an error field for a bad code
+	if token.Error != "" || token.AccessToken == "" {
+		return "", status.Errorf(codes.Unauthenticated, "%s rejected the code: %s", p.name, strings.TrimSpace(token.Error+" "+token.ErrorDescription))
+	}
+	return token.AccessToken, nil
+}
+
+// getJSON fetches a provider API endpoint with accessToken into out.
+func (o *oauthLogin) getJSON(ctx context.Context, endpoint, accessToken string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	return o.do(req, out)
+}
+
+// providerError is a response the provider refused the request with.
+type providerError struct {
+	code int
+	body string
+}
+
+func (e *providerError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.code, e.body)
+}
+
+func (o *oauthLogin) do(req *http.Request, out interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxProviderResponse))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &providerError{code: resp.StatusCode, body: strings.TrimSpace(string(body))}
+	}
+	return json.Unmarshal(body, out)
+}
+
+// providerUnavailable is err if it means the provider can't be reached,
+// for the subsystem registry; a refused code is the user's problem.
+func providerUnavailable(err error) error {
+	var pe *providerError
+	if errors.As(err, &pe) && pe.code < http.StatusInternalServerError {
+		return nil
+	}
+	return err
+}
+
+func providerStatus(p *oauthProvider, err error) error {
+	if providerUnavailable(err) == nil {
+		return status.Errorf(codes.Unauthenticated, "%s refused the sign-in: %v", p.name, err)
+	}
+	return status.Errorf(codes.Unavailable, "cannot reach %s: %v", p.name, err)
+}
+
+// googleIdentity reads the OpenID Connect userinfo endpoint.
+func googleIdentity(ctx context.Context, o *oauthLogin, accessToken string) (oauthIdentity, error) {
+	var info struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := o.getJSON(ctx, "https://openidconnect.googleapis.com/v1/userinfo", accessToken, &info); err != nil {
+		return oauthIdentity{}, err
+	}
+	if info.Sub == "" {
+		return oauthIdentity{}, errors.New("userinfo has no subject")
+	}
+	return oauthIdentity{Subject: info.Sub, Email: info.Email, EmailVerified: info.EmailVerified, Name: info.Name}, nil
+}
+
+// githubIdentity reads the user and, since the profile email is optional
+// and unverified, the primary verified address.
+func githubIdentity(ctx context.Context, o *oauthLogin, accessToken string) (oauthIdentity, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := o.getJSON(ctx, "https://api.github.com/user", accessToken, &user); err != nil {
+		return oauthIdentity{}, err
+	}
+	if user.ID == 0 {
+		return oauthIdentity{}, errors.New("user has no id")
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := o.getJSON(ctx, "https://api.github.com/user/emails", accessToken, &emails); err != nil {
+		return oauthIdentity{}, err
+	}
+	id := oauthIdentity{Subject: strconv.FormatInt(user.ID, 10), Name: user.Name}
+	if id.Name == "" {
+		id.Name = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			id.Email, id.EmailVerified = e.Email, true
+		}
+	}
+	return id, nil
+}
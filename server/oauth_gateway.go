@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	oauthCookie       = "oauth_state"
+	oauthCookieMaxAge = 600 // seconds the user has to finish at the provider
+)
+
+// oauthGateway runs the browser side of LoginWithProvider on the REST
+// gateway:
+//
+//	GET /v1/oauth/{provider}/start     redirects to the provider
+//	GET /v1/oauth/{provider}/callback  the provider redirects back here
+//
+// start remembers a random state and PKCE verifier in a short-lived cookie;
+// callback checks the state against it and hands the code and verifier to
+// LoginWithProvider through gateway, answering with its LoginResponse, or
+// with cookies when auth.cookie_auth is on.
+func oauthGateway(o *oauthLogin, gateway http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/oauth/{provider}/start", func(w http.ResponseWriter, r *http.Request) {
+		p, ok := o.providers[r.PathValue("provider")]
+		if !ok {
+			http.Error(w, "unknown provider", http.StatusNotFound)
+			return
+		}
+		state, verifier := randomToken(), randomToken()
+		http.SetCookie(w, &http.Cookie{
+			Name:     oauthCookie,
+			Value:    state + "." + verifier,
+			Path:     "/v1/oauth/",
+			MaxAge:   oauthCookieMaxAge,
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(o.redirectBase, "https:"),
+			SameSite: http.SameSiteLaxMode,
+		})
+		challenge := sha256.Sum256([]byte(verifier))
+		q := url.Values{
+			"client_id":             {p.clientID},
+			"redirect_uri":          {o.redirectURI(p.name)},
+			"response_type":         {"code"},
+			"scope":                 {p.scopes},
+			"state":                 {state},
+			"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+			"code_challenge_method": {"S256"},
+		}
+		http.Redirect(w, r, p.authURL+"?"+q.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("GET /v1/oauth/{provider}/callback", func(w http.ResponseWriter, r *http.Request) {
+		provider := r.PathValue("provider")
+		if _, ok := o.providers[provider]; !ok {
+			http.Error(w, "unknown provider", http.StatusNotFound)
+			return
+		}
+		// The cookie is single-use whatever happens next
+		http.SetCookie(w, &http.Cookie{Name: oauthCookie, Path: "/v1/oauth/", MaxAge: -1})
+		q := r.URL.Query()
+		if e := q.Get("error"); e != "" {
+			http.Error(w, "sign-in was cancelled or refused: "+e, http.StatusUnauthorized)
+			return
+		}
+		cookie, err := r.Cookie(oauthCookie)
+		if err != nil {
+			http.Error(w, "sign-in expired; start again", http.StatusBadRequest)
+			return
+		}
+		state, verifier, _ := strings.Cut(cookie.Value, ".")
+		if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(q.Get("state"))) != 1 {
+			http.Error(w, "state mismatch; start again", http.StatusBadRequest)
+			return
+		}
+		body, _ := json.Marshal(map[string]string{"code": q.Get("code"), "codeVerifier": verifier})
+		login := r.Clone(r.Context())
+		login.Method = http.MethodPost
+		login.URL = &url.URL{Path: "/v1/login/" + provider}
+		login.RequestURI = ""
+		login.Body, login.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+		login.Header.Set("Content-Type", "application/json")
+		login.Header.Set(authModeHeader, "cookie")
+		// A fresh login needs none of the cookies a CSRF check would apply to
+		login.Header.Del("Cookie")
+		gateway.ServeHTTP(w, login)
+	})
+	return mux
+}
+
+// randomToken is 32 random bytes, base64url-encoded.
+func randomToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"grpc-crud-proj/middleware"
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ChangePassword replaces the caller's password. A wrong current password
+// counts as a failed login, so it can't be used to guess around the login
+// lockout. Other tokens stay valid.
+func (s *server) ChangePassword(ctx context.Context, req *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
+	claims, ok := middleware.ClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "missing credentials")
+	}
+	if claims.ActAs != "" {
+		return nil, status.Errorf(codes.PermissionDenied, "impersonation tokens can't change passwords")
+	}
+	email := claims.Email
+
+	if until, err := s.state.logins.lockedUntil(ctx, email); err != nil {
+		slog.WarnContext(ctx, "cannot check login lockout", "error", err)
+	} else if !until.IsZero() {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many failed logins; try again after %s", until.UTC().Format(time.RFC3339))
+	}
+
+	var storedHash string
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COALESCE(password, '') FROM users WHERE email=$1 AND deleted_at IS NULL", email,
+	).Scan(&storedHash)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+	if !checkPassword(req.CurrentPassword, storedHash) {
+		s.loginFailed(ctx, email)
+		return nil, status.Errorf(codes.Unauthenticated, "incorrect password")
+	}
+	if err := s.users.CheckPassword(ctx, "new_password", req.NewPassword); err != nil {
+		return nil, serviceStatus(err)
+	}
+
+	hash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot hash password: %v", err)
+	}
+	// Matching the old hash too keeps a concurrent change from being lost
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE users SET password=$1 WHERE email=$2 AND password=$3 AND deleted_at IS NULL",
+		hash, email, storedHash)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to change password: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, status.Errorf(codes.Aborted, "the password was changed concurrently; try again")
+	}
+
+	slog.InfoContext(ctx, "AUDIT password changed", "email", email)
+	return &pb.ChangePasswordResponse{Message: "password changed"}, nil
+}
+
+// rehashPassword moves a user who just logged in with password onto the
+// current bcryptCost. It is best effort: the old hash keeps working.
+func (s *server) rehashPassword(ctx context.Context, email, password, oldHash string) {
+	hash, err := hashPassword(password)
+	if err == nil {
+		_, err = s.db.ExecContext(ctx,
+			"UPDATE users SET password=$1 WHERE email=$2 AND password=$3 AND deleted_at IS NULL",
+			hash, email, oldHash)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "cannot rehash password", "error", err)
+	}
+}
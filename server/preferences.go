@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+	"grpc-crud-proj/service"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SetUserPreference stores a single key/value setting for a user.
+// Setting an existing key overwrites its value.
+func (s *server) SetUserPreference(ctx context.Context, req *pb.SetUserPreferenceRequest) (*pb.UserPreference, error) {
+	if err := service.RequirePermission(ctx, service.PermUserWrite); err != nil {
+		return nil, serviceStatus(err)
+	}
+	if req.Key == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "preference key is required")
+	}
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id=$1 AND deleted_at IS NULL)", req.Id).Scan(&exists)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to look up user: %v", err)
+	}
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO preferences(user_id, key, value) VALUES($1, $2, $3)
+		 ON CONFLICT (user_id, key) DO UPDATE SET value = EXCLUDED.value`,
+		req.Id, req.Key, req.Value,
+	)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save preference: %v", err)
+	}
+
+	return &pb.UserPreference{Key: req.Key, Value: req.Value}, nil
+}
+
+// GetUserPreferences returns every stored preference for a user, ordered by key.
+func (s *server) GetUserPreferences(ctx context.Context, req *pb.GetUserPreferencesRequest) (*pb.GetUserPreferencesResponse, error) {
+	if err := service.RequirePermission(ctx, service.PermUserRead); err != nil {
+		return nil, serviceStatus(err)
+	}
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT key, value FROM preferences WHERE user_id=$1 ORDER BY key",
+		req.Id,
+	)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load preferences: %v", err)
+	}
+	defer rows.Close()
+
+	res := &pb.GetUserPreferencesResponse{}
+	for rows.Next() {
+		var pref pb.UserPreference
+		if err := rows.Scan(&pref.Key, &pref.Value); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to read preference: %v", err)
+		}
+		res.Preferences = append(res.Preferences, &pref)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read preferences: %v", err)
+	}
+
+	return res, nil
+}
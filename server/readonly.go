@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"grpc-crud-proj/middleware"
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// readOnlyMode is this instance's write switch, used during failovers and
+// when the database is a read replica. It starts from server.read_only and
+// is flipped at runtime with SetReadOnlyMode; it is not shared between
+// instances. Methods marked read_only in policy keep working while it is on.
+type readOnlyMode struct {
+	policy  func() *middleware.Policy
+	mu      sync.RWMutex
+	enabled bool
+	reason  string
+	since   time.Time
+}
+
+func newReadOnlyMode(enabled bool, policy func() *middleware.Policy) *readOnlyMode {
+	m := &readOnlyMode{policy: policy}
+	if enabled {
+		m.set(true, "enabled in config")
+	}
+	return m
+}
+
+func (m *readOnlyMode) set(enabled bool, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if enabled && !m.enabled {
+		m.since = time.Now()
+	}
+	if !enabled {
+		m.since = time.Time{}
+		reason = ""
+	}
+	m.enabled, m.reason = enabled, reason
+}
+
+func (m *readOnlyMode) on() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.enabled
+}
+
+func (m *readOnlyMode) toProto() *pb.ReadOnlyMode {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := &pb.ReadOnlyMode{Enabled: m.enabled, Reason: m.reason}
+	if m.enabled {
+		res.Since = m.since.Unix()
+	}
+	return res
+}
+
+// readOnlyMethod reports whether method is marked read_only in the policy.
+func (m *readOnlyMode) readOnlyMethod(method string) bool {
+	return m.policy().ReadOnly(method)
+}
+
+// check refuses methods not marked read_only while the mode is on.
+func (m *readOnlyMode) check(method string) error {
+	if m.readOnlyMethod(method) {
+		return nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.enabled {
+		return nil
+	}
+	msg := "the service is in read-only mode; writes are disabled until it is turned off"
+	if m.reason != "" {
+		msg = "the service is in read-only mode (" + m.reason + "); writes are disabled until it is turned off"
+	}
+	return status.Error(codes.FailedPrecondition, msg)
+}
+
+func (m *readOnlyMode) interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := m.check(info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (m *readOnlyMode) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := m.check(info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+func (s *server) GetReadOnlyMode(ctx context.Context, req *pb.GetReadOnlyModeRequest) (*pb.ReadOnlyMode, error) {
+	return s.readOnly.toProto(), nil
+}
+
+// SetReadOnlyMode flips read-only mode on this instance. Turning it on
+// without a reason is allowed; callers then see a generic message.
+func (s *server) SetReadOnlyMode(ctx context.Context, req *pb.SetReadOnlyModeRequest) (*pb.ReadOnlyMode, error) {
+	s.readOnly.set(req.Enabled, req.Reason)
+
+	slog.InfoContext(ctx, "AUDIT read-only mode set", "enabled", req.Enabled, "reason", req.Reason)
+	return s.readOnly.toProto(), nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/protobuf/proto"
+)
+
+// movedToHeader is set by GetUser when the requested id was merged into
+// another account. Its value is the canonical user id.
+const movedToHeader = "x-moved-to"
+
+// redirectMovedUsers is a gateway forward-response option that answers
+// requests for a tombstoned id with 308 Permanent Redirect pointing at the
+// canonical resource. The body still carries the canonical user.
+func redirectMovedUsers(ctx context.Context, w http.ResponseWriter, resp proto.Message) error {
+	if _, ok := resp.(*pb.UserResponse); !ok {
+		return nil
+	}
+	md, ok := runtime.ServerMetadataFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	values := md.HeaderMD.Get(movedToHeader)
+	if len(values) == 0 {
+		return nil
+	}
+
+	w.Header().Set("Location", "/v1/users/"+values[0])
+	w.WriteHeader(http.StatusPermanentRedirect)
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"grpc-crud-proj/middleware"
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// newRefreshToken returns a random refresh token and the hash that is
+// stored in the database. Only the hash is persisted.
+func newRefreshToken() (token string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	return token, hashRefreshToken(token), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// execer is a *sql.DB or *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// issueRefreshToken stores a new refresh token for userID in family, with
+// the device label and the caller's user agent and address, and drops the
+// user's expired ones.
+func issueRefreshToken(ctx context.Context, db execer, userID int32, family, device string) (string, error) {
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = db.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (token_hash, family_id, user_id, device, user_agent, client_ip, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		hash, family, userID, device, userAgent(ctx), middleware.ClientIP(ctx), time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		return "", err
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE user_id=$1 AND expires_at < now()", userID); err != nil {
+		slog.WarnContext(ctx, "cannot delete expired refresh tokens", "error", err)
+	}
+	return token, nil
+}
+
+// userAgent is the caller's User-Agent, as sent to the gateway or by a gRPC
+// client.
+func userAgent(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, key := range []string{"grpcgateway-user-agent", "user-agent"} {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+// revokeSession revokes every refresh token of family.
+func revokeSession(ctx context.Context, db execer, family string) error {
+	_, err := db.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at=now() WHERE family_id=$1 AND revoked_at IS NULL", family)
+	return err
+}
+
+// RefreshToken rotates a refresh token: the presented one is spent and a
+// new one from the same session comes back with a fresh access token. A
+// spent token showing up again means it was copied, so the whole session
+// is revoked, for the thief and the owner alike.
+func (s *server) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest) (*pb.LoginResponse, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	var userID int32
+	var family, device string
+	var expiresAt time.Time
+	var rotatedAt, revokedAt sql.NullTime
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, user_id, family_id, device, expires_at, rotated_at, revoked_at
+		 FROM refresh_tokens WHERE token_hash=$1 FOR UPDATE`,
+		hashRefreshToken(req.RefreshToken),
+	).Scan(&id, &userID, &family, &device, &expiresAt, &rotatedAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid refresh token")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to look up refresh token: %v", err)
+	}
+	switch {
+	case revokedAt.Valid:
+		return nil, status.Errorf(codes.Unauthenticated, "the session has been revoked; log in again")
+	case rotatedAt.Valid:
+		if err := revokeSession(ctx, tx, family); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to revoke session: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to revoke session: %v", err)
+		}
+		slog.WarnContext(ctx, "AUDIT refresh token reused; session revoked",
+			"user_id", userID, "session", family, "rotated_at", rotatedAt.Time, "device", device)
+		return nil, status.Errorf(codes.Unauthenticated, "refresh token was already used; the session has been revoked, log in again")
+	case time.Now().After(expiresAt):
+		return nil, status.Errorf(codes.Unauthenticated, "refresh token has expired; log in again")
+	}
+
+	var email, role, userStatus string
+	err = tx.QueryRowContext(ctx,
+		"SELECT email, role, status FROM users WHERE id=$1 AND deleted_at IS NULL", userID,
+	).Scan(&email, &role, &userStatus)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Errorf(codes.Unauthenticated, "user not found")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to look up user: %v", err)
+	}
+	if statusFromDB(userStatus) == pb.UserStatus_USER_STATUS_SUSPENDED {
+		return nil, status.Errorf(codes.PermissionDenied, "account is suspended")
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET rotated_at=now() WHERE id=$1", id); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to rotate refresh token: %v", err)
+	}
+	refresh, err := issueRefreshToken(ctx, tx, userID, family, device)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to issue refresh token: %v", err)
+	}
+	token, err := generateToken(email, role, family)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate token")
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to rotate refresh token: %v", err)
+	}
+	return &pb.LoginResponse{Token: token, RefreshToken: refresh}, nil
+}
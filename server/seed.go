@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"grpc-crud-proj/middleware"
+	"grpc-crud-proj/repository"
+	"grpc-crud-proj/service"
+)
+
+// seedUser is one user of a -seed fixture. Password is optional; users
+// without one can't log in until they set it.
+type seedUser struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+	Password string `json:"password"`
+}
+
+// seedUsers loads the fixture at path, a JSON array of users or a CSV file
+// with a name,email,role,password header, through repo. Roles other than
+// "user" must be defined in policy. Users whose email already exists are
+// skipped, so seeding twice is harmless. Each user is created in a
+// transaction of its own with its password and an audit entry.
+func seedUsers(ctx context.Context, repo repository.UserRepository, policy *middleware.Policy, path string) error {
+	users, err := readSeed(path)
+	if err != nil {
+		return err
+	}
+	// Check everything first so a bad row doesn't leave a fixture half loaded
+	for i, u := range users {
+		var v violations
+		v.requireName("name", u.Name)
+		v.requireEmail("email", u.Email)
+		if u.Role != "" && u.Role != "user" && !policy.HasRole(u.Role) {
+			v.add("role", service.FieldInvalidValue, "must be a role of the API policy, got %q", u.Role)
+		}
+		if len(v) > 0 {
+			return fmt.Errorf("%s: user %d: %s", path, i+1, v.message())
+		}
+	}
+
+	// Fixtures tend to share a few passwords, and bcrypt is slow on purpose
+	hashes := map[string]string{}
+	var created, skipped int
+	for i, u := range users {
+		if u.Role == "" {
+			u.Role = "user"
+		}
+		hash, ok := hashes[u.Password]
+		if u.Password != "" && !ok {
+			if hash, err = hashPassword(u.Password); err != nil {
+				return fmt.Errorf("%s: user %d: %w", path, i+1, err)
+			}
+			hashes[u.Password] = hash
+		}
+
+		err := repo.WithTx(ctx, func(repo repository.UserRepository) error {
+			user, err := repo.Create(ctx, repository.NewUser{Name: u.Name, Email: normalizeEmail(u.Email), Role: u.Role})
+			if err != nil {
+				return err
+			}
+			if hash != "" {
+				if err := repo.SetPassword(ctx, user.Id, hash); err != nil {
+					return err
+				}
+			}
+			return repo.Audit(ctx, repository.AuditEntry{Actor: "seed", Action: "seed", UserID: user.Id, Detail: "role=" + u.Role})
+		})
+		switch {
+		case errors.Is(err, repository.ErrDuplicateEmail):
+			skipped++
+		case err != nil:
+			return fmt.Errorf("%s: user %d (%s): %w", path, i+1, u.Email, err)
+		default:
+			created++
+		}
+	}
+	slog.Info("seeded users", "fixture", path, "created", created, "skipped", skipped)
+	return nil
+}
+
+func readSeed(path string) ([]seedUser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return readSeedCSV(path, f)
+	}
+	var users []seedUser
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&users); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return users, nil
+}
+
+// readSeedCSV reads a CSV fixture; columns may be in any order and only
+// name and email are required.
+func readSeedCSV(path string, r io.Reader) ([]seedUser, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: read header: %w", path, err)
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"name", "email"} {
+		if _, ok := col[required]; !ok {
+			return nil, fmt.Errorf("%s: missing %q column", path, required)
+		}
+	}
+	get := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	var users []seedUser
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return users, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		users = append(users, seedUser{
+			Name:     get(row, "name"),
+			Email:    get(row, "email"),
+			Role:     get(row, "role"),
+			Password: get(row, "password"),
+		})
+	}
+}
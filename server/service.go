@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"grpc-crud-proj/middleware"
+	"grpc-crud-proj/service"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// actorInterceptor hands the authenticated caller to the service layer,
+// with the permissions the API policy's role table grants their role. An
+// impersonation token acts as the impersonated user. With auth disabled
+// nobody has claims, so every call acts as an anonymous admin.
+func actorInterceptor(authEnabled bool, policy func() *middleware.Policy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if claims, ok := middleware.ClaimsFromContext(ctx); ok {
+			p, role := policy(), claims.Role
+			ctx = service.WithActor(ctx, service.Actor{
+				Email:  claims.EffectiveEmail(),
+				Role:   role,
+				Grants: func(permission string) bool { return p.Grants(role, permission) },
+			})
+		} else if !authEnabled {
+			ctx = service.WithActor(ctx, service.Actor{Role: service.AdminRole})
+		}
+		return handler(ctx, req)
+	}
+}
+
+var serviceCodes = map[service.Kind]codes.Code{
+	service.Internal:         codes.Internal,
+	service.Invalid:          codes.InvalidArgument,
+	service.NotFound:         codes.NotFound,
+	service.Conflict:         codes.AlreadyExists,
+	service.PermissionDenied: codes.PermissionDenied,
+	service.VersionMismatch:  codes.Aborted,
+}
+
+// serviceStatus turns a service error into the gRPC status for it, with its
+// reason and invalid fields as details.
+func serviceStatus(err error) error {
+	var se *service.Error
+	if errors.As(err, &se) {
+		return detailedStatus(serviceCodes[se.Kind], se.Message, se.Reason, se.Metadata, se.Violations)
+	}
+	return status.Errorf(codes.Internal, "%v", err)
+}
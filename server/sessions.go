@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"grpc-crud-proj/middleware"
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A session is a refresh token family. Its live token is the latest one,
+// the only one not yet rotated, and says where it was last used from.
+
+// ListSessions lists the caller's sessions that can still be refreshed.
+func (s *server) ListSessions(ctx context.Context, req *pb.ListSessionsRequest) (*pb.ListSessionsResponse, error) {
+	claims, userID, err := s.sessionOwner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT family_id, device, user_agent, client_ip, started_at, created_at, expires_at FROM (
+		     SELECT DISTINCT ON (family_id) family_id, device, user_agent, client_ip,
+		            MIN(created_at) OVER (PARTITION BY family_id) AS started_at,
+		            created_at, expires_at, rotated_at, revoked_at
+		     FROM refresh_tokens WHERE user_id=$1
+		     ORDER BY family_id, created_at DESC
+		 ) latest
+		 WHERE rotated_at IS NULL AND revoked_at IS NULL AND expires_at > now()
+		 ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list sessions: %v", err)
+	}
+	defer rows.Close()
+
+	var sessions []*pb.Session
+	for rows.Next() {
+		var sess pb.Session
+		var started, lastSeen, expires time.Time
+		if err := rows.Scan(&sess.Id, &sess.Device, &sess.UserAgent, &sess.ClientIp, &started, &lastSeen, &expires); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to read session: %v", err)
+		}
+		sess.CreatedAt, sess.LastSeenAt, sess.ExpiresAt = started.Unix(), lastSeen.Unix(), expires.Unix()
+		sess.Current = sess.Id == claims.SessionID
+		sessions = append(sessions, &sess)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list sessions: %v", err)
+	}
+	return &pb.ListSessionsResponse{Sessions: sessions}, nil
+}
+
+// RevokeSession revokes the refresh tokens of one of the caller's sessions
+// and, through the denylist, the access tokens carrying its id, for as
+// long as any of them could still be valid.
+func (s *server) RevokeSession(ctx context.Context, req *pb.RevokeSessionRequest) (*pb.RevokeSessionResponse, error) {
+	claims, userID, err := s.sessionOwner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.Id == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "session id is required")
+	}
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at=now() WHERE family_id=$1 AND user_id=$2 AND revoked_at IS NULL",
+		req.Id, userID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke session: %v", err)
+	}
+	// Someone else's session looks the same as one that doesn't exist
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, status.Errorf(codes.NotFound, "session not found")
+	}
+	if err := s.state.denylist.revoke(ctx, req.Id, time.Now().Add(tokenTTL)); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke session tokens: %v", err)
+	}
+	slog.InfoContext(ctx, "AUDIT session revoked", "email", claims.EffectiveEmail(), "session", req.Id, "current", req.Id == claims.SessionID)
+	return &pb.RevokeSessionResponse{Message: "session revoked"}, nil
+}
+
+// sessionOwner is the caller and their user id. Impersonation tokens see
+// and manage the sessions of the user they act as.
+func (s *server) sessionOwner(ctx context.Context) (*middleware.Claims, int32, error) {
+	claims, ok := middleware.ClaimsFromContext(ctx)
+	if !ok {
+		return nil, 0, status.Errorf(codes.Unauthenticated, "missing credentials")
+	}
+	var userID int32
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id FROM users WHERE email=$1 AND deleted_at IS NULL", claims.EffectiveEmail(),
+	).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, status.Errorf(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, 0, status.Errorf(codes.Internal, "failed to look up user: %v", err)
+	}
+	return claims, userID, nil
+}
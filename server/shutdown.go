@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// shutdownTimeout bounds how long shutdown waits for in-flight calls.
+// Long-lived streams such as WatchUsers never finish on their own, so once
+// it passes the gRPC servers are stopped hard.
+const shutdownTimeout = 15 * time.Second
+
+// shutdown stops accepting calls on every server and waits, up to
+// shutdownTimeout, for the ones in flight.
+func shutdown(httpServer *http.Server, grpcServers []*grpc.Server) {
+	slog.Info("shutting down", "timeout", shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			slog.Warn("HTTP server did not shut down cleanly", "error", err)
+		}
+	}()
+	for _, s := range grpcServers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done := make(chan struct{})
+			go func() {
+				s.GracefulStop()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-ctx.Done():
+				slog.Warn("gRPC calls still running after the shutdown timeout; closing them")
+				s.Stop()
+			}
+		}()
+	}
+	wg.Wait()
+}
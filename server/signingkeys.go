@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+
+	"grpc-crud-proj/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSABits is the smallest RSA key accepted for signing.
+const minRSABits = 2048
+
+// loadSigningKeys reads auth.jwt_private_keys. With jwt_algorithm RS256 or
+// EdDSA the first key signs new tokens; every listed key verifies them and
+// is published by jwksHandler, so a key added ahead of a rotation is known
+// to other services before any token carries it.
+func loadSigningKeys(cfg config.AuthConfig) error {
+	jwtPublicKeys = map[string]crypto.PublicKey{}
+	if cfg.JWTAlgorithm == "HS256" {
+		return nil
+	}
+	for i, f := range cfg.PrivateKeyFiles() {
+		pem, err := os.ReadFile(f.Path)
+		if err != nil {
+			return err
+		}
+		var private crypto.Signer
+		switch cfg.JWTAlgorithm {
+		case "RS256":
+			key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.Path, err)
+			}
+			if key.N.BitLen() < minRSABits {
+				return fmt.Errorf("%s: RSA key has %d bits, need at least %d", f.Path, key.N.BitLen(), minRSABits)
+			}
+			private = key
+		case "EdDSA":
+			key, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.Path, err)
+			}
+			private = key.(crypto.Signer)
+		}
+		if i == 0 {
+			jwtSigningMethod = jwt.GetSigningMethod(cfg.JWTAlgorithm)
+			jwtSigningKey, jwtSigningKeyID = private, f.ID
+		}
+		jwtPublicKeys[f.ID] = private.Public()
+	}
+	return nil
+}
+
+// jwk is a public key in JSON Web Key form (RFC 7517, 8037).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// jwksHandler serves the public keys tokens may be signed with at
+// /.well-known/jwks.json. The set is empty when tokens are signed with
+// HMAC secrets, which must never be published.
+func jwksHandler() http.Handler {
+	keys := make([]jwk, 0, len(jwtPublicKeys))
+	for kid, key := range jwtPublicKeys {
+		b64 := base64.RawURLEncoding.EncodeToString
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, jwk{Kty: "RSA", Kid: kid, Use: "sig", Alg: "RS256",
+				N: b64(k.N.Bytes()), E: b64(big.NewInt(int64(k.E)).Bytes())})
+		case ed25519.PublicKey:
+			keys = append(keys, jwk{Kty: "OKP", Kid: kid, Use: "sig", Alg: "EdDSA", Crv: "Ed25519", X: b64(k)})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Kid < keys[j].Kid })
+	body, _ := json.Marshal(map[string][]jwk{"keys": keys})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		_, _ = w.Write(body)
+	})
+}
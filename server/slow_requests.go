@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// maxRequestQueries bounds the queries remembered per request.
+const maxRequestQueries = 20
+
+// explainTimeout bounds the EXPLAINs for one slow request.
+const explainTimeout = 5 * time.Second
+
+// requestQueries lists the user queries a request ran, in order.
+type requestQueries struct {
+	mu      sync.Mutex
+	queries []queryShape
+}
+
+type requestQueriesKey struct{}
+
+func requestQueriesFrom(ctx context.Context) *requestQueries {
+	q, _ := ctx.Value(requestQueriesKey{}).(*requestQueries)
+	return q
+}
+
+func (r *requestQueries) add(name, query string, args []interface{}) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queries) < maxRequestQueries {
+		r.queries = append(r.queries, queryShape{name: name, sql: query, args: args})
+	}
+}
+
+func (r *requestQueries) list() []queryShape {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]queryShape(nil), r.queries...)
+}
+
+// slowRequests logs unary calls that take at least threshold, with the
+// queries they ran. With explain on, each query's plan is looked up in the
+// background (plain EXPLAIN: planned, never executed) and included, so an
+// index regression shows up in the log line itself. At most explainWorkers
+// requests are explained at a time; the rest are logged without plans.
+type slowRequests struct {
+	db        *sql.DB
+	threshold time.Duration
+	explain   bool
+	workers   chan struct{}
+}
+
+const explainWorkers = 2
+
+func newSlowRequests(db *sql.DB, threshold time.Duration, explain bool) *slowRequests {
+	return &slowRequests{db: db, threshold: threshold, explain: explain, workers: make(chan struct{}, explainWorkers)}
+}
+
+func (s *slowRequests) interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	queries := &requestQueries{}
+	start := time.Now()
+	resp, err := handler(context.WithValue(ctx, requestQueriesKey{}, queries), req)
+	if elapsed := time.Since(start); elapsed >= s.threshold {
+		s.report(context.WithoutCancel(ctx), info.FullMethod, elapsed, queries.list())
+	}
+	return resp, err
+}
+
+func (s *slowRequests) report(ctx context.Context, method string, elapsed time.Duration, queries []queryShape) {
+	if !s.explain || len(queries) == 0 {
+		s.log(ctx, method, elapsed, queries, nil)
+		return
+	}
+	select {
+	case s.workers <- struct{}{}:
+	default:
+		slog.DebugContext(ctx, "too many slow requests being explained, logging without plans")
+		s.log(ctx, method, elapsed, queries, nil)
+		return
+	}
+	go func() {
+		defer func() { <-s.workers }()
+		ctx, cancel := context.WithTimeout(ctx, explainTimeout)
+		defer cancel()
+		plans := make([]string, len(queries))
+		for i, q := range queries {
+			plans[i] = s.plan(ctx, q)
+		}
+		s.log(ctx, method, elapsed, queries, plans)
+	}()
+}
+
+// plan returns the text EXPLAIN output for q, or why there is none.
+func (s *slowRequests) plan(ctx context.Context, q queryShape) string {
+	rows, err := s.db.QueryContext(ctx, "EXPLAIN "+q.sql, q.args...)
+	if err != nil {
+		return fmt.Sprintf("explain failed: %v", err)
+	}
+	defer rows.Close()
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return fmt.Sprintf("explain failed: %v", err)
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Sprintf("explain failed: %v", err)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (s *slowRequests) log(ctx context.Context, method string, elapsed time.Duration, queries []queryShape, plans []string) {
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.Duration("duration", elapsed),
+		slog.Int("queries", len(queries)),
+	}
+	for i, q := range queries {
+		group := []interface{}{slog.String("shape", q.name), slog.String("sql", q.sql)}
+		if plans != nil {
+			group = append(group, slog.String("plan", plans[i]))
+		}
+		attrs = append(attrs, slog.Group(fmt.Sprintf("query_%d", i+1), group...))
+	}
+	slog.LogAttrs(ctx, slog.LevelWarn, "slow request", attrs...)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"grpc-crud-proj/internal/config"
+	"grpc-crud-proj/middleware"
+)
+
+// loginThrottle counts failed logins per email and locks an email out after
+// too many of them.
+type loginThrottle interface {
+	// lockedUntil is when email's lockout ends; zero if it isn't locked.
+	lockedUntil(ctx context.Context, email string) (time.Time, error)
+	// failed records a failed login and returns the lockout end if this
+	// failure started one.
+	failed(ctx context.Context, email string) (time.Time, error)
+	// succeeded forgets email's failures.
+	succeeded(ctx context.Context, email string) error
+}
+
+// tokenDenylist holds revoked tokens until they would have expired: single
+// tokens by their jti (Logout), the tokens of a session by its id
+// (RevokeSession) and everything issued to a user before a cutoff
+// (RevokeTokens).
+type tokenDenylist interface {
+	// revoke revokes the token with jti id, or every token with sid id.
+	revoke(ctx context.Context, id string, expires time.Time) error
+	// revokeUser revokes every token issued to or acting as email until
+	// now. The cutoff is kept until expires, once the last of them is gone.
+	revokeUser(ctx context.Context, email string, expires time.Time) error
+	revoked(ctx context.Context, claims *middleware.Claims) (bool, error)
+}
+
+// issuedAt is when claims were issued; tokens from before iat was set
+// count as infinitely old, so a user cutoff always covers them.
+func issuedAt(claims *middleware.Claims) time.Time {
+	if claims.IssuedAt == nil {
+		return time.Time{}
+	}
+	return claims.IssuedAt.Time
+}
+
+// stateStores is the state behind rate limiting, login lockouts and logout,
+// kept where storage.backend says.
+type stateStores struct {
+	rateLimits middleware.RateLimitStore
+	logins     loginThrottle
+	denylist   tokenDenylist
+	// cleanup deletes expired rows until ctx ends; nil for memory.
+	cleanup func(ctx context.Context)
+}
+
+func newStateStores(cfg *config.Config, db *sql.DB, readOnly *readOnlyMode, report func(error)) stateStores {
+	max, lockout := cfg.Auth.LoginMaxFailures, cfg.Auth.LoginLockout.Duration
+	if cfg.Storage.Backend == "postgres" {
+		return stateStores{
+			rateLimits: &pgRateLimits{db: db, readOnly: readOnly, memory: middleware.NewMemoryRateLimitStore(rateLimitIdleTTL)},
+			logins:     &pgLogins{db: db, readOnly: readOnly, max: max, lockout: lockout},
+			denylist:   &pgDenylist{db: db},
+			cleanup: func(ctx context.Context) {
+				cleanupState(ctx, db, cfg.Storage.CleanupInterval.Duration, lockout, readOnly, report)
+			},
+		}
+	}
+	return stateStores{
+		rateLimits: middleware.NewMemoryRateLimitStore(rateLimitIdleTTL),
+		logins:     &memoryLogins{max: max, lockout: lockout, entries: map[string]*loginFailures{}},
+		denylist:   &memoryDenylist{tokens: map[string]time.Time{}, users: map[string]userCutoff{}},
+	}
+}
+
+// rateLimitIdleTTL is how long an unused bucket is kept. Every configured
+// limit refills well within it, so a dropped bucket would have been full.
+const rateLimitIdleTTL = 10 * time.Minute
+
+type loginFailures struct {
+	count       int
+	windowStart time.Time
+	lockedUntil time.Time
+}
+
+type memoryLogins struct {
+	max     int
+	lockout time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*loginFailures
+	lastSweep time.Time
+}
+
+func (m *memoryLogins) lockedUntil(ctx context.Context, email string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[email]; ok && time.Now().Before(e.lockedUntil) {
+		return e.lockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+func (m *memoryLogins) failed(ctx context.Context, email string) (time.Time, error) {
+	if m.max == 0 {
+		return time.Time{}, nil
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.lastSweep) > m.lockout {
+		for k, e := range m.entries {
+			if now.Sub(e.windowStart) > m.lockout && now.After(e.lockedUntil) {
+				delete(m.entries, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	e, ok := m.entries[email]
+	if !ok || now.Sub(e.windowStart) > m.lockout {
+		e = &loginFailures{windowStart: now}
+		m.entries[email] = e
+	}
+	e.count++
+	if e.count < m.max {
+		return time.Time{}, nil
+	}
+	e.count, e.windowStart, e.lockedUntil = 0, now, now.Add(m.lockout)
+	return e.lockedUntil, nil
+}
+
+func (m *memoryLogins) succeeded(ctx context.Context, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, email)
+	return nil
+}
+
+type memoryDenylist struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+	users  map[string]userCutoff
+}
+
+type userCutoff struct {
+	before, expires time.Time
+}
+
+func (m *memoryDenylist) revoke(ctx context.Context, id string, expires time.Time) error {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, exp := range m.tokens {
+		if now.After(exp) {
+			delete(m.tokens, k)
+		}
+	}
+	m.tokens[id] = expires
+	return nil
+}
+
+func (m *memoryDenylist) revokeUser(ctx context.Context, email string, expires time.Time) error {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, c := range m.users {
+		if now.After(c.expires) {
+			delete(m.users, k)
+		}
+	}
+	if c, ok := m.users[email]; ok && c.expires.After(expires) {
+		expires = c.expires
+	}
+	m.users[email] = userCutoff{before: now, expires: expires}
+	return nil
+}
+
+func (m *memoryDenylist) revoked(ctx context.Context, claims *middleware.Claims) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range []string{claims.ID, claims.SessionID} {
+		if _, ok := m.tokens[id]; ok && id != "" {
+			return true, nil
+		}
+	}
+	for _, email := range []string{claims.Email, claims.ActAs} {
+		if c, ok := m.users[email]; ok && email != "" && issuedAt(claims).Before(c.before) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"grpc-crud-proj/middleware"
+)
+
+// The postgres backend (storage.backend: postgres). Every instance sees the
+// same buckets, lockouts and revoked tokens, and they survive restarts.
+//
+// In read-only mode the database may be a replica, so nothing is written:
+// rate limiting falls back to this instance's memory and failed logins are
+// not counted, while existing lockouts and revocations are still honoured.
+
+type pgRateLimits struct {
+	db       *sql.DB
+	readOnly *readOnlyMode
+	memory   middleware.RateLimitStore
+}
+
+// Allow refills the bucket for the time since it was last touched and takes
+// a token, in one statement. The WHERE on the conflict branch makes an empty
+// bucket return no row.
+func (p *pgRateLimits) Allow(ctx context.Context, key string, limit middleware.Limit) (bool, error) {
+	if p.readOnly.on() {
+		return p.memory.Allow(ctx, key, limit)
+	}
+	if limit.Burst < 1 {
+		return false, nil
+	}
+	var tokens float64
+	err := p.db.QueryRowContext(ctx,
+		`INSERT INTO rate_limit_buckets AS b (key, tokens, updated_at) VALUES ($1, $3::float8 - 1, now())
+		 ON CONFLICT (key) DO UPDATE SET
+		     tokens = LEAST($3::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $2::float8) - 1,
+		     updated_at = now()
+		 WHERE LEAST($3::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $2::float8) >= 1
+		 RETURNING tokens`,
+		key, float64(limit.Rate), limit.Burst,
+	).Scan(&tokens)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type pgLogins struct {
+	db       *sql.DB
+	readOnly *readOnlyMode
+	max      int
+	lockout  time.Duration
+}
+
+func (p *pgLogins) lockedUntil(ctx context.Context, email string) (time.Time, error) {
+	var until time.Time
+	err := p.db.QueryRowContext(ctx,
+		"SELECT locked_until FROM login_failures WHERE email=$1 AND locked_until > now()",
+		email,
+	).Scan(&until)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return until, err
+}
+
+func (p *pgLogins) failed(ctx context.Context, email string) (time.Time, error) {
+	if p.max == 0 || p.readOnly.on() {
+		return time.Time{}, nil
+	}
+	// A failure outside the window starts a new one
+	var count int
+	err := p.db.QueryRowContext(ctx,
+		`INSERT INTO login_failures AS f (email, failures, window_start) VALUES ($1, 1, now())
+		 ON CONFLICT (email) DO UPDATE SET
+		     failures = CASE WHEN f.window_start > now() - make_interval(secs => $2) THEN f.failures + 1 ELSE 1 END,
+		     window_start = CASE WHEN f.window_start > now() - make_interval(secs => $2) THEN f.window_start ELSE now() END
+		 RETURNING failures`,
+		email, p.lockout.Seconds(),
+	).Scan(&count)
+	if err != nil || count < p.max {
+		return time.Time{}, err
+	}
+	var until time.Time
+	err = p.db.QueryRowContext(ctx,
+		`UPDATE login_failures SET failures = 0, window_start = now(), locked_until = now() + make_interval(secs => $2)
+		 WHERE email=$1 RETURNING locked_until`,
+		email, p.lockout.Seconds(),
+	).Scan(&until)
+	return until, err
+}
+
+func (p *pgLogins) succeeded(ctx context.Context, email string) error {
+	if p.readOnly.on() {
+		return nil
+	}
+	_, err := p.db.ExecContext(ctx, "DELETE FROM login_failures WHERE email=$1", email)
+	return err
+}
+
+type pgDenylist struct {
+	db *sql.DB
+}
+
+func (p *pgDenylist) revoke(ctx context.Context, id string, expires time.Time) error {
+	_, err := p.db.ExecContext(ctx,
+		"INSERT INTO revoked_tokens(id, expires_at) VALUES($1, $2) ON CONFLICT (id) DO NOTHING",
+		id, expires)
+	return err
+}
+
+func (p *pgDenylist) revokeUser(ctx context.Context, email string, expires time.Time) error {
+	_, err := p.db.ExecContext(ctx,
+		`INSERT INTO revoked_users(email, revoked_before, expires_at) VALUES($1, now(), $2)
+		 ON CONFLICT (email) DO UPDATE SET revoked_before=now(), expires_at=GREATEST(revoked_users.expires_at, EXCLUDED.expires_at)`,
+		email, expires)
+	return err
+}
+
+func (p *pgDenylist) revoked(ctx context.Context, claims *middleware.Claims) (bool, error) {
+	var revoked bool
+	err := p.db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE id IN ($1, $5) AND id <> '')
+		     OR EXISTS(SELECT 1 FROM revoked_users WHERE email IN ($2, $3) AND email <> '' AND revoked_before > $4)`,
+		claims.ID, claims.Email, claims.ActAs, issuedAt(claims), claims.SessionID,
+	).Scan(&revoked)
+	return revoked, err
+}
+
+// cleanupState deletes idle buckets, stale login failures and revoked tokens
+// and user cutoffs past their expiry every interval until ctx ends.
+func cleanupState(ctx context.Context, db *sql.DB, interval, lockout time.Duration, readOnly *readOnlyMode, report func(error)) {
+	statements := []struct {
+		table string
+		query string
+		args  []interface{}
+	}{
+		{"rate_limit_buckets", "DELETE FROM rate_limit_buckets WHERE updated_at < now() - make_interval(secs => $1)",
+			[]interface{}{rateLimitIdleTTL.Seconds()}},
+		{"login_failures", "DELETE FROM login_failures WHERE window_start < now() - make_interval(secs => $1) AND (locked_until IS NULL OR locked_until < now())",
+			[]interface{}{lockout.Seconds()}},
+		{"revoked_tokens", "DELETE FROM revoked_tokens WHERE expires_at < now()", nil},
+		{"revoked_users", "DELETE FROM revoked_users WHERE expires_at < now()", nil},
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if readOnly.on() {
+			continue
+		}
+		var failed error
+		for _, st := range statements {
+			res, err := db.ExecContext(ctx, st.query, st.args...)
+			if err != nil {
+				slog.Error("state cleanup failed", "table", st.table, "error", err)
+				failed = err
+				continue
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				slog.Debug("state cleanup", "table", st.table, "deleted", n)
+			}
+		}
+		report(failed)
+	}
+}
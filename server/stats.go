@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultStatsDays = 30
+	maxStatsDays     = 366
+)
+
+func (s *server) GetUserStats(ctx context.Context, req *pb.GetUserStatsRequest) (*pb.UserStats, error) {
+	days := req.Days
+	if days == 0 {
+		days = defaultStatsDays
+	}
+
+	res := &pb.UserStats{}
+	err := s.db.QueryRowContext(ctx,
+		`SELECT count(*) FILTER (WHERE deleted_at IS NULL),
+		        count(*) FILTER (WHERE deleted_at IS NULL AND status = 'ACTIVE'),
+		        count(*) FILTER (WHERE deleted_at IS NULL AND status = 'SUSPENDED'),
+		        count(*) FILTER (WHERE deleted_at IS NOT NULL)
+		 FROM users`,
+	).Scan(&res.Total, &res.Active, &res.Suspended, &res.Deleted)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to count users: %v", err)
+	}
+
+	// Days are UTC dates; days without signups or deletions still get a row.
+	// start is midnight UTC of the first day, so both counts use the indexes
+	rows, err := s.db.QueryContext(ctx,
+		`WITH bounds AS (
+		   SELECT ((now() AT TIME ZONE 'UTC')::date - ($1::int - 1))::timestamp AT TIME ZONE 'UTC' AS start
+		 ), days AS (
+		   SELECT (now() AT TIME ZONE 'UTC')::date - i AS day FROM generate_series(0, $1::int - 1) AS i
+		 ), created AS (
+		   SELECT (created_at AT TIME ZONE 'UTC')::date AS day, count(*) AS n
+		   FROM users, bounds WHERE created_at >= bounds.start GROUP BY 1
+		 ), deleted AS (
+		   SELECT (deleted_at AT TIME ZONE 'UTC')::date AS day, count(*) AS n
+		   FROM users, bounds WHERE deleted_at >= bounds.start GROUP BY 1
+		 )
+		 SELECT to_char(days.day, 'YYYY-MM-DD'), COALESCE(created.n, 0), COALESCE(deleted.n, 0)
+		 FROM days LEFT JOIN created USING (day) LEFT JOIN deleted USING (day)
+		 ORDER BY days.day`,
+		days,
+	)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to count users per day: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		d := &pb.DailyUserCounts{}
+		if err := rows.Scan(&d.Date, &d.Created, &d.Deleted); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to read user counts: %v", err)
+		}
+		res.Days = append(res.Days, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read user counts: %v", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+	"grpc-crud-proj/repository"
+	"grpc-crud-proj/service"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultPageSize = service.DefaultPageSize
+	maxPageSize     = service.MaxPageSize
+)
+
+var (
+	statusToDB   = repository.StatusToDB
+	statusFromDB = repository.StatusFromDB
+)
+
+func (s *server) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	users, err := s.users.List(ctx, repository.ListOptions{Status: req.Status, Limit: req.PageSize, Offset: req.Offset})
+	if err != nil {
+		return nil, serviceStatus(err)
+	}
+	return &pb.ListUsersResponse{Users: users}, nil
+}
+
+func (s *server) DeactivateUser(ctx context.Context, req *pb.DeactivateUserRequest) (*pb.UserResponse, error) {
+	return s.setUserStatus(ctx, req.Id, pb.UserStatus_USER_STATUS_SUSPENDED)
+}
+
+func (s *server) ActivateUser(ctx context.Context, req *pb.ActivateUserRequest) (*pb.UserResponse, error) {
+	return s.setUserStatus(ctx, req.Id, pb.UserStatus_USER_STATUS_ACTIVE)
+}
+
+func (s *server) setUserStatus(ctx context.Context, id int32, newStatus pb.UserStatus) (*pb.UserResponse, error) {
+	if err := service.RequirePermission(ctx, service.PermUserWrite); err != nil {
+		return nil, serviceStatus(err)
+	}
+	var user pb.User
+	var userStatus string
+	err := s.db.QueryRowContext(ctx,
+		"UPDATE users SET status=$1, version=version+1 WHERE id=$2 AND deleted_at IS NULL RETURNING id, name, email, role, status, version",
+		statusToDB(newStatus), id,
+	).Scan(&user.Id, &user.Name, &user.Email, &user.Role, &userStatus, &user.Version)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, status.Errorf(codes.NotFound, "user not found")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to update user status: %v", err)
+	}
+	user.Status = statusFromDB(userStatus)
+	s.events.publish(pb.UserEventType_USER_EVENT_TYPE_UPDATED, &user)
+
+	subject := "Your account has been reactivated"
+	if newStatus == pb.UserStatus_USER_STATUS_SUSPENDED {
+		subject = "Your account has been suspended"
+	}
+	if err := s.notify(ctx, user.Id, eventAccountStatus, user.Email, subject, subject+"."); err != nil {
+		slog.WarnContext(ctx, "failed to notify user of status change", "user_id", user.Id, "error", err)
+	}
+
+	return &pb.UserResponse{User: &user}, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"grpc-crud-proj/internal/config"
+	pb "grpc-crud-proj/proto/google/userpb"
+)
+
+// Optional subsystems tracked in the registry.
+const (
+	subsystemMail     = "mail"
+	subsystemWebhooks = "webhooks"
+	subsystemStorage  = "state_storage"
+	subsystemCapacity = "capacity_monitor"
+	subsystemPolicy   = "api_policy"
+	subsystemTracing  = "tracing"
+	subsystemAudit    = "audit"
+	subsystemOAuth    = "oauth"
+)
+
+// subsystems is the registry of optional components. Each one is registered
+// at startup as configured or not, and configured ones report the outcome
+// of their work so they show as degraded while failing. /healthz and
+// ListSubsystems read it; handlers that need a subsystem ask enabled
+// rather than checking its config themselves.
+type subsystems struct {
+	mu      sync.Mutex
+	entries map[string]*subsystemEntry
+}
+
+type subsystemEntry struct {
+	state  pb.SubsystemState
+	detail string
+	since  time.Time
+	// configDetail is what detail returns to once a failure clears
+	configDetail string
+}
+
+func newSubsystems() *subsystems {
+	return &subsystems{entries: map[string]*subsystemEntry{}}
+}
+
+// register adds name as healthy when configured and disabled otherwise.
+// detail says how it is (or isn't) configured.
+func (r *subsystems) register(name string, configured bool, detail string) {
+	state := pb.SubsystemState_SUBSYSTEM_STATE_DISABLED
+	if configured {
+		state = pb.SubsystemState_SUBSYSTEM_STATE_HEALTHY
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[name] = &subsystemEntry{state: state, detail: detail, since: time.Now(), configDetail: detail}
+}
+
+// report records the outcome of a configured subsystem's latest attempt:
+// nil makes it healthy, an error degraded. Reports for disabled or unknown
+// subsystems are ignored.
+func (r *subsystems) report(name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[name]
+	if !ok || e.state == pb.SubsystemState_SUBSYSTEM_STATE_DISABLED {
+		return
+	}
+	state, detail := pb.SubsystemState_SUBSYSTEM_STATE_HEALTHY, e.configDetail
+	if err != nil {
+		state, detail = pb.SubsystemState_SUBSYSTEM_STATE_DEGRADED, err.Error()
+	}
+	e.detail = detail
+	if state == e.state {
+		return
+	}
+	e.state, e.since = state, time.Now()
+	if err != nil {
+		slog.Warn("subsystem degraded", "subsystem", name, "error", err)
+	} else {
+		slog.Info("subsystem recovered", "subsystem", name)
+	}
+}
+
+// reporter is report bound to name, for components that shouldn't see the
+// whole registry.
+func (r *subsystems) reporter(name string) func(error) {
+	return func(err error) { r.report(name, err) }
+}
+
+// enabled reports whether name is configured, working or not.
+func (r *subsystems) enabled(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[name]
+	return ok && e.state != pb.SubsystemState_SUBSYSTEM_STATE_DISABLED
+}
+
+// degraded lists the names of failing subsystems.
+func (r *subsystems) degraded() []string {
+	var names []string
+	for _, s := range r.list() {
+		if s.State == pb.SubsystemState_SUBSYSTEM_STATE_DEGRADED {
+			names = append(names, s.Name)
+		}
+	}
+	return names
+}
+
+func (r *subsystems) list() []*pb.Subsystem {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]*pb.Subsystem, 0, len(r.entries))
+	for name, e := range r.entries {
+		out = append(out, &pb.Subsystem{Name: name, State: e.state, Detail: e.detail, Since: e.since.Unix()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// subsystemStateName is "healthy" for SUBSYSTEM_STATE_HEALTHY and so on.
+func subsystemStateName(s pb.SubsystemState) string {
+	return strings.ToLower(strings.TrimPrefix(s.String(), "SUBSYSTEM_STATE_"))
+}
+
+// registerSubsystems records how cfg sets up each optional subsystem.
+func registerSubsystems(r *subsystems, cfg *config.Config) {
+	if cfg.Mail.SMTPAddr != "" {
+		r.register(subsystemMail, true, "smtp "+cfg.Mail.SMTPAddr)
+	} else {
+		r.register(subsystemMail, false, "no mail.smtp_addr; mail is written to the log")
+	}
+	r.register(subsystemWebhooks, len(cfg.Webhooks.URLs) > 0, fmt.Sprintf("%d receivers in webhooks.urls", len(cfg.Webhooks.URLs)))
+	r.register(subsystemStorage, true, cfg.Storage.Backend+" backend")
+	if cfg.Database.Driver != "postgres" {
+		r.register(subsystemCapacity, false, "needs database.driver postgres")
+	} else if cfg.Capacity.MaxRows > 0 || cfg.Capacity.MaxBytes > 0 {
+		r.register(subsystemCapacity, true, "alerting on capacity limits")
+	} else {
+		r.register(subsystemCapacity, true, "no capacity limits; exporting gauges only")
+	}
+	if cfg.Auth.PolicyFile != "" {
+		r.register(subsystemPolicy, true, cfg.Auth.PolicyFile)
+	} else {
+		r.register(subsystemPolicy, true, "built-in policy")
+	}
+	r.register(subsystemTracing, cfg.Tracing.Enabled, "sampler "+cfg.Tracing.Sampler)
+	r.register(subsystemAudit, cfg.Audit.Enabled, "audit_events")
+	var providers []string
+	if cfg.OAuth.GoogleClientID != "" {
+		providers = append(providers, "google")
+	}
+	if cfg.OAuth.GitHubClientID != "" {
+		providers = append(providers, "github")
+	}
+	r.register(subsystemOAuth, len(providers) > 0, "providers: "+strings.Join(providers, ", "))
+}
+
+func (s *server) ListSubsystems(ctx context.Context, req *pb.ListSubsystemsRequest) (*pb.ListSubsystemsResponse, error) {
+	return &pb.ListSubsystemsResponse{Subsystems: s.subsystems.list()}, nil
+}
+
+// reportingMailer tells the registry whether mail is going out.
+type reportingMailer struct {
+	Mailer
+	report func(error)
+}
+
+func (m reportingMailer) Send(ctx context.Context, to, subject, body string) error {
+	err := m.Mailer.Send(ctx, to, subject, body)
+	m.report(err)
+	return err
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+	"grpc-crud-proj/service"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	exportPageSize = 500
+	// maxImportFailures bounds the failure list returned by ImportUsers.
+	maxImportFailures = 100
+)
+
+// ExportUsers pages through users by id (keyset pagination), so a long export
+// doesn't hold one query open and resumes cheaply from after_id.
+func (s *server) ExportUsers(req *pb.ExportUsersRequest, stream pb.UserService_ExportUsersServer) error {
+	ctx := stream.Context()
+	if err := service.RequirePermission(ctx, service.PermUserRead); err != nil {
+		return serviceStatus(err)
+	}
+	afterID := req.AfterId
+	for {
+		var (
+			rows *sql.Rows
+			err  error
+		)
+		if req.Status == pb.UserStatus_USER_STATUS_UNSPECIFIED {
+			rows, err = s.queryUsers(ctx, "export", "",
+				"SELECT id, name, email, role, status, version FROM users WHERE id > $1 AND deleted_at IS NULL ORDER BY id LIMIT $2",
+				afterID, exportPageSize,
+			)
+		} else {
+			rows, err = s.queryUsers(ctx, "export_by_status",
+				"CREATE INDEX CONCURRENTLY users_status_id_idx ON users (status, id) WHERE deleted_at IS NULL",
+				"SELECT id, name, email, role, status, version FROM users WHERE id > $1 AND deleted_at IS NULL AND status=$2 ORDER BY id LIMIT $3",
+				afterID, statusToDB(req.Status), exportPageSize,
+			)
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to export users: %v", err)
+		}
+
+		var page []*pb.User
+		for rows.Next() {
+			var user pb.User
+			var userStatus string
+			if err := rows.Scan(&user.Id, &user.Name, &user.Email, &user.Role, &userStatus, &user.Version); err != nil {
+				rows.Close()
+				return status.Errorf(codes.Internal, "failed to read user: %v", err)
+			}
+			user.Status = statusFromDB(userStatus)
+			page = append(page, &user)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return status.Errorf(codes.Internal, "failed to export users: %v", err)
+		}
+
+		for _, user := range page {
+			if err := stream.Send(user); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPageSize {
+			return nil
+		}
+		afterID = page[len(page)-1].Id
+	}
+}
+
+// ImportUsers inserts each streamed user on its own, so one bad record is
+// reported in failures instead of aborting the rest.
+func (s *server) ImportUsers(stream pb.UserService_ImportUsersServer) error {
+	ctx := stream.Context()
+	if err := service.RequirePermission(ctx, service.PermUserWrite); err != nil {
+		return serviceStatus(err)
+	}
+	res := &pb.ImportUsersResponse{}
+	fail := func(index int32, email string, msg string) {
+		if len(res.Failures) < maxImportFailures {
+			res.Failures = append(res.Failures, &pb.ImportFailure{Index: index, Email: email, Error: msg})
+		}
+	}
+
+	for index := int32(0); ; index++ {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(res)
+		}
+		if err != nil {
+			return err
+		}
+
+		user := req.User
+		if user == nil {
+			fail(index, "", "user must be set")
+			continue
+		}
+		var v violations
+		v.requireName("name", user.Name)
+		v.requireEmail("email", user.Email)
+		if len(v) > 0 {
+			fail(index, user.Email, v.message())
+			continue
+		}
+
+		role := user.Role
+		if role == "" {
+			role = "user"
+		}
+		userStatus := user.Status
+		if userStatus == pb.UserStatus_USER_STATUS_UNSPECIFIED {
+			userStatus = pb.UserStatus_USER_STATUS_ACTIVE
+		}
+		created := &pb.User{Name: user.Name, Email: normalizeEmail(user.Email), Role: role, Status: userStatus}
+
+		err = s.db.QueryRowContext(ctx,
+			`INSERT INTO users(name, email, role, status) VALUES($1, $2, $3, $4)
+			 ON CONFLICT (email) DO NOTHING RETURNING id`,
+			created.Name, created.Email, created.Role, statusToDB(created.Status),
+		).Scan(&created.Id)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			res.Skipped++
+		case err != nil:
+			if ctx.Err() != nil {
+				return status.FromContextError(ctx.Err()).Err()
+			}
+			fail(index, user.Email, err.Error())
+		default:
+			res.Created++
+			s.events.publish(pb.UserEventType_USER_EVENT_TYPE_CREATED, created)
+		}
+	}
+}
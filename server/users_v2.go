@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+	pbv2 "grpc-crud-proj/proto/v2/userpb"
+	"grpc-crud-proj/repository"
+	"grpc-crud-proj/service"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// userUUIDs translates between the v2 API's UUIDs and the serial ids the
+// service works with; repository.Postgres is one.
+type userUUIDs interface {
+	IDOf(ctx context.Context, id string) (int32, error)
+	UUIDsOf(ctx context.Context, ids []int32) (map[int32]string, error)
+}
+
+// usersV2 serves user.v2.UserService, served with database.user_ids: uuid.
+// Each call resolves the UUID to the serial id and goes through the same
+// service methods as v1, so authorization, validation and events are
+// shared; only the ids in requests and responses differ.
+type usersV2 struct {
+	pbv2.UnimplementedUserServiceServer
+	users *service.Users
+	ids   userUUIDs
+}
+
+func (s *usersV2) CreateUser(ctx context.Context, req *pbv2.CreateUserRequest) (*pbv2.UserResponse, error) {
+	user, err := s.users.Create(ctx, req.Name, req.Email, req.Role)
+	if err != nil {
+		return nil, serviceStatus(err)
+	}
+	return s.response(ctx, user)
+}
+
+func (s *usersV2) GetUser(ctx context.Context, req *pbv2.GetUserRequest) (*pbv2.UserResponse, error) {
+	id, err := s.resolve(ctx, req.Id, service.PermUserRead)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.users.Get(ctx, id)
+	if err != nil {
+		return nil, s.status(req.Id, err)
+	}
+	return s.response(ctx, user)
+}
+
+func (s *usersV2) UpdateUser(ctx context.Context, req *pbv2.UpdateUserRequest) (*pbv2.UserResponse, error) {
+	id, err := s.resolve(ctx, req.Id, service.PermUserWrite)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.users.Update(ctx, id, req.Name, req.Email, req.ExpectedVersion)
+	if err != nil {
+		return nil, s.status(req.Id, err)
+	}
+	return s.response(ctx, user)
+}
+
+func (s *usersV2) DeleteUser(ctx context.Context, req *pbv2.DeleteUserRequest) (*pbv2.DeleteUserResponse, error) {
+	id, err := s.resolve(ctx, req.Id, service.PermUserDelete)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.Delete(ctx, id); err != nil {
+		return nil, s.status(req.Id, err)
+	}
+	return &pbv2.DeleteUserResponse{Message: "User deleted"}, nil
+}
+
+func (s *usersV2) ListUsers(ctx context.Context, req *pbv2.ListUsersRequest) (*pbv2.ListUsersResponse, error) {
+	users, err := s.users.List(ctx, repository.ListOptions{
+		Status: pbStatus(req.Status),
+		Limit:  req.PageSize,
+		Offset: req.Offset,
+	})
+	if err != nil {
+		return nil, serviceStatus(err)
+	}
+	converted, err := s.convert(ctx, users)
+	if err != nil {
+		return nil, err
+	}
+	return &pbv2.ListUsersResponse{Users: converted}, nil
+}
+
+// resolve returns the serial id of the user with the UUID id. A UUID
+// matching no user is NotFound only to callers with perm, the permission
+// the call needs; everyone else gets the permission error the service
+// gives them for an account that isn't theirs, so they can't probe which
+// UUIDs exist.
+func (s *usersV2) resolve(ctx context.Context, id, perm string) (int32, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		var v violations
+		v.add("id", service.FieldInvalidID, "must be a UUID")
+		return 0, v.status()
+	}
+	serial, err := s.ids.IDOf(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		if err := service.RequirePermission(ctx, perm); err != nil {
+			return 0, serviceStatus(err)
+		}
+		return 0, userNotFoundV2(id)
+	}
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "failed to look up user: %v", err)
+	}
+	return serial, nil
+}
+
+// status is serviceStatus, naming a missing user by its UUID rather than
+// its serial id.
+func (s *usersV2) status(id string, err error) error {
+	var se *service.Error
+	if errors.As(err, &se) && se.Reason == service.ReasonUserNotFound {
+		return userNotFoundV2(id)
+	}
+	return serviceStatus(err)
+}
+
+func userNotFoundV2(id string) error {
+	return detailedStatus(codes.NotFound, "user "+id+" not found", service.ReasonUserNotFound,
+		map[string]string{"id": id}, nil)
+}
+
+func (s *usersV2) response(ctx context.Context, user *pb.User) (*pbv2.UserResponse, error) {
+	converted, err := s.convert(ctx, []*pb.User{user})
+	if err != nil {
+		return nil, err
+	}
+	return &pbv2.UserResponse{User: converted[0]}, nil
+}
+
+// convert turns v1 users into v2 ones, looking their UUIDs up at once.
+func (s *usersV2) convert(ctx context.Context, users []*pb.User) ([]*pbv2.User, error) {
+	ids := make([]int32, len(users))
+	for i, u := range users {
+		ids[i] = u.Id
+	}
+	uuids, err := s.ids.UUIDsOf(ctx, ids)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to look up user ids: %v", err)
+	}
+	out := make([]*pbv2.User, len(users))
+	for i, u := range users {
+		out[i] = &pbv2.User{
+			Id:      uuids[u.Id],
+			Name:    u.Name,
+			Email:   u.Email,
+			Role:    u.Role,
+			Status:  pbv2.UserStatus(u.Status),
+			Version: u.Version,
+		}
+	}
+	return out, nil
+}
+
+// pbStatus is the v1 status with the same number as s.
+func pbStatus(s pbv2.UserStatus) pb.UserStatus {
+	return pb.UserStatus(s)
+}
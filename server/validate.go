@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+	"grpc-crud-proj/service"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	maxNameLength = 255
+	maxPrefKeyLen = 255
+	maxDeviceLen  = 100
+)
+
+// violations collects the invalid fields of a request. Reasons are the
+// service.Field* constants.
+type violations []service.FieldViolation
+
+func (v *violations) add(field, reason, format string, args ...interface{}) {
+	*v = append(*v, service.FieldViolation{Field: field, Reason: reason, Description: fmt.Sprintf(format, args...)})
+}
+
+// status is the InvalidArgument error for v, with a BadRequest detail.
+func (v violations) status() error {
+	return detailedStatus(codes.InvalidArgument, "invalid request: "+v.message(), service.ReasonInvalidArgument, nil, v)
+}
+
+// message joins the violations into one human-readable string.
+func (v violations) message() string {
+	msgs := make([]string, len(v))
+	for i, fv := range v {
+		msgs[i] = fv.Field + " " + fv.Description
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (v *violations) requireName(field, name string) {
+	switch {
+	case strings.TrimSpace(name) == "":
+		v.add(field, service.FieldRequired, "must not be empty")
+	case len(name) > maxNameLength:
+		v.add(field, service.FieldTooLong, "must be at most %d characters", maxNameLength)
+	}
+}
+
+func (v *violations) requireEmail(field, email string) {
+	if email == "" {
+		v.add(field, service.FieldRequired, "must not be empty")
+		return
+	}
+	if err := validateEmail(normalizeEmail(email)); err != nil {
+		v.add(field, service.FieldInvalidEmail, "%v", err)
+	}
+}
+
+func (v *violations) requireID(field string, id int32) {
+	if id <= 0 {
+		v.add(field, service.FieldInvalidID, "must be a positive id")
+	}
+}
+
+func (v *violations) requireNonEmpty(field, value string) {
+	if value == "" {
+		v.add(field, service.FieldRequired, "must not be empty")
+	}
+}
+
+var (
+	normalizeEmail = service.NormalizeEmail
+	validateEmail  = service.ValidateEmail
+)
+
+// cleanEmail normalizes and validates an email from a request.
+func cleanEmail(field, email string) (string, error) {
+	email = normalizeEmail(email)
+	if err := validateEmail(email); err != nil {
+		var v violations
+		v.add(field, service.FieldInvalidEmail, "%v", err)
+		return "", v.status()
+	}
+	return email, nil
+}
+
+// validateRequest checks the fields of UserService requests. The account CRUD
+// requests are validated by the service layer instead. It returns nil when the
+// request is valid.
+func validateRequest(req interface{}) violations {
+	var v violations
+	switch r := req.(type) {
+	case *pb.LoginRequest:
+		v.requireNonEmpty("email", r.Email)
+		v.requireNonEmpty("password", r.Password)
+		if len(r.Device) > maxDeviceLen {
+			v.add("device", service.FieldTooLong, "must be at most %d characters", maxDeviceLen)
+		}
+	case *pb.LoginWithProviderRequest:
+		v.requireNonEmpty("provider", r.Provider)
+		v.requireNonEmpty("code", r.Code)
+		if len(r.Device) > maxDeviceLen {
+			v.add("device", service.FieldTooLong, "must be at most %d characters", maxDeviceLen)
+		}
+	case *pb.RefreshTokenRequest:
+		v.requireNonEmpty("refresh_token", r.RefreshToken)
+	case *pb.VerifyEmailRequest:
+		v.requireNonEmpty("token", r.Token)
+	case *pb.IntrospectRequest:
+		v.requireNonEmpty("token", r.Token)
+	case *pb.ChangePasswordRequest:
+		v.requireNonEmpty("current_password", r.CurrentPassword)
+		v.requireNonEmpty("new_password", r.NewPassword)
+	case *pb.DeactivateUserRequest:
+		v.requireID("id", r.Id)
+	case *pb.ActivateUserRequest:
+		v.requireID("id", r.Id)
+	case *pb.SetUserPreferenceRequest:
+		v.requireID("id", r.Id)
+		v.requireNonEmpty("key", r.Key)
+		if len(r.Key) > maxPrefKeyLen {
+			v.add("key", service.FieldTooLong, "must be at most %d characters", maxPrefKeyLen)
+		}
+	case *pb.GetUserPreferencesRequest:
+		v.requireID("id", r.Id)
+	case *pb.RevokeTokensRequest:
+		v.requireID("id", r.Id)
+	case *pb.ImpersonateRequest:
+		v.requireID("id", r.Id)
+		v.requireNonEmpty("reason", r.Reason)
+	case *pb.RecordConsentRequest:
+		v.requireNonEmpty("kind", r.Kind)
+	case *pb.GetConsentsRequest:
+		v.requireID("id", r.Id)
+	case *pb.UserExistsRequest:
+		switch lookup := r.Lookup.(type) {
+		case *pb.UserExistsRequest_Id:
+			v.requireID("id", lookup.Id)
+		case *pb.UserExistsRequest_Email:
+			v.requireEmail("email", lookup.Email)
+		default:
+			v.add("lookup", service.FieldRequired, "id or email is required")
+		}
+	case *pb.MergeUsersRequest:
+		v.requireID("source_id", r.SourceId)
+		v.requireID("target_id", r.TargetId)
+		if r.SourceId == r.TargetId {
+			v.add("source_id", service.FieldInvalidValue, "must differ from target_id")
+		}
+	case *pb.RequestEmailChangeRequest:
+		v.requireEmail("new_email", r.NewEmail)
+	case *pb.ConfirmEmailChangeRequest:
+		v.requireNonEmpty("token", r.Token)
+	case *pb.UndoEmailChangeRequest:
+		v.requireNonEmpty("token", r.Token)
+	case *pb.GetAvatarRequest:
+		v.requireID("id", r.Id)
+		if r.Size < 0 {
+			v.add("size", service.FieldOutOfRange, "must not be negative")
+		}
+	case *pb.GetNotificationPreferencesRequest:
+		v.requireID("id", r.Id)
+	case *pb.UpdateNotificationPreferencesRequest:
+		v.requireID("id", r.Id)
+		validateNotificationPreferences(&v, r.Preferences)
+	case *pb.GetUserStatsRequest:
+		if r.Days < 0 || r.Days > maxStatsDays {
+			v.add("days", service.FieldOutOfRange, "must be between 0 and %d", maxStatsDays)
+		}
+	}
+	return v
+}
+
+// ValidationInterceptor rejects malformed requests with InvalidArgument
+// before they reach a handler (and therefore before any SQL runs).
+func ValidationInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if v := validateRequest(req); len(v) > 0 {
+		return nil, v.status()
+	}
+	return handler(ctx, req)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+	"grpc-crud-proj/service"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// watcherBuffer is how many events a slow watcher may fall behind before it
+// is disconnected.
+const watcherBuffer = 64
+
+// userEvents fans user changes out to WatchUsers streams. It is in-process
+// only: watchers see changes made through this server instance.
+type userEvents struct {
+	mu       sync.Mutex
+	watchers map[chan *pb.UserEvent]struct{}
+}
+
+func newUserEvents() *userEvents {
+	return &userEvents{watchers: map[chan *pb.UserEvent]struct{}{}}
+}
+
+func (e *userEvents) subscribe() chan *pb.UserEvent {
+	ch := make(chan *pb.UserEvent, watcherBuffer)
+	e.mu.Lock()
+	e.watchers[ch] = struct{}{}
+	e.mu.Unlock()
+	return ch
+}
+
+func (e *userEvents) unsubscribe(ch chan *pb.UserEvent) {
+	e.mu.Lock()
+	if _, ok := e.watchers[ch]; ok {
+		delete(e.watchers, ch)
+		close(ch)
+	}
+	e.mu.Unlock()
+}
+
+// publish never blocks: a watcher whose buffer is full is dropped, and its
+// stream ends with ResourceExhausted.
+func (e *userEvents) publish(t pb.UserEventType, user *pb.User) {
+	if e == nil || user == nil {
+		return
+	}
+	ev := &pb.UserEvent{Type: t, User: proto.Clone(user).(*pb.User), OccurredAt: time.Now().Unix()}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.watchers {
+		select {
+		case ch <- ev:
+		default:
+			delete(e.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// publishByID is for writes that don't return the full row.
+func (s *server) publishByID(ctx context.Context, t pb.UserEventType, id int32) {
+	var user pb.User
+	var userStatus string
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, name, email, role, status, version FROM users WHERE id=$1",
+		id,
+	).Scan(&user.Id, &user.Name, &user.Email, &user.Role, &userStatus, &user.Version)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load user for watchers", "user_id", id, "error", err)
+		return
+	}
+	user.Status = statusFromDB(userStatus)
+	s.events.publish(t, &user)
+}
+
+func (s *server) WatchUsers(req *pb.WatchUsersRequest, stream pb.UserService_WatchUsersServer) error {
+	if err := service.RequirePermission(stream.Context(), service.PermUserRead); err != nil {
+		return serviceStatus(err)
+	}
+	wanted := map[pb.UserEventType]bool{}
+	for _, t := range req.Types {
+		if t == pb.UserEventType_USER_EVENT_TYPE_UNSPECIFIED {
+			return status.Errorf(codes.InvalidArgument, "invalid request: types must not contain USER_EVENT_TYPE_UNSPECIFIED")
+		}
+		wanted[t] = true
+	}
+
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-ch:
+			if !ok {
+				return status.Errorf(codes.ResourceExhausted, "watcher fell too far behind; reconnect")
+			}
+			if len(wanted) > 0 && !wanted[ev.Type] {
+				continue
+			}
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"grpc-crud-proj/internal/config"
+	"grpc-crud-proj/internal/httpclient"
+	"grpc-crud-proj/pkg/webhookverify"
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// webhookQueue is how many events may wait to be recorded before new
+	// ones are dropped.
+	webhookQueue = 1024
+	// webhookBatch is how many due deliveries one poll claims and sends
+	// concurrently.
+	webhookBatch = 20
+	webhookPoll  = 2 * time.Second
+)
+
+// Delivery works at least once. Every event is recorded in webhook_events
+// with one PENDING row per receiver in webhook_deliveries, in a single
+// statement. A worker claims due rows by pushing next_attempt_at out by a
+// lease and bumping attempts, then sends. From there a delivery moves:
+//
+//	PENDING --2xx--> DELIVERED
+//	PENDING --error, attempts left--> PENDING (next_attempt_at = now + backoff)
+//	PENDING --error, no attempts left, or receiver removed--> FAILED
+//
+// If the worker dies mid-attempt nothing is written, and the row becomes due
+// again when the lease runs out. That, and a receiver that processed a
+// delivery but answered too late, is why receivers can see an event twice and
+// should dedupe on event_id. RedeliverWebhook adds a new PENDING row for the
+// same event.
+type webhooks struct {
+	db          *sql.DB
+	client      *httpclient.Client
+	secret      []byte
+	urls        []string
+	maxAttempts int
+	lease       time.Duration
+	readOnly    *readOnlyMode
+	wake        chan struct{}
+	// report tells the subsystem registry whether the event log and
+	// delivery queue in the database are usable
+	report func(error)
+}
+
+func newWebhooks(db *sql.DB, client *httpclient.Client, cfg config.WebhooksConfig, outbound config.OutboundConfig, readOnly *readOnlyMode, report func(error)) *webhooks {
+	// The lease has to outlast one attempt, including the client's own retries
+	retries := time.Duration(outbound.MaxRetries)
+	lease := outbound.Timeout.Duration*(retries+1) + outbound.MaxBackoff.Duration*retries + time.Minute
+	return &webhooks{
+		db:          db,
+		client:      client,
+		secret:      []byte(cfg.Secret),
+		urls:        cfg.URLs,
+		maxAttempts: cfg.MaxAttempts,
+		lease:       lease,
+		readOnly:    readOnly,
+		wake:        make(chan struct{}, 1),
+		report:      report,
+	}
+}
+
+func outboundConfig(cfg config.OutboundConfig) httpclient.Config {
+	return httpclient.Config{
+		Timeout:             cfg.Timeout.Duration,
+		DialTimeout:         cfg.DialTimeout.Duration,
+		MaxRetries:          cfg.MaxRetries,
+		RetryBackoff:        cfg.RetryBackoff.Duration,
+		MaxBackoff:          cfg.MaxBackoff.Duration,
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     cfg.MaxConnsPerHost,
+		IdleConnTimeout:     cfg.IdleConnTimeout.Duration,
+		ProxyURL:            cfg.ProxyURL,
+		AllowedNetworks:     cfg.AllowedNetworks,
+	}
+}
+
+// start records every user event for delivery and runs the delivery worker.
+// Nothing runs when no receivers are configured.
+func (w *webhooks) start(ctx context.Context, events *userEvents) {
+	if len(w.urls) == 0 {
+		return
+	}
+	queue := make(chan *pb.UserEvent, webhookQueue)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-queue:
+				err := w.record(ctx, ev)
+				w.report(err)
+				if err != nil {
+					slog.Error("failed to record webhook event", "type", ev.Type.String(), "user_id", ev.User.GetId(), "error", err)
+				}
+			}
+		}
+	}()
+
+	go func() {
+		ch := events.subscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				events.unsubscribe(ch)
+				return
+			case ev, ok := <-ch:
+				if !ok {
+					// Only happens if this loop itself stalls; carry on with a fresh subscription
+					slog.Warn("webhook dispatcher fell behind; events were lost")
+					ch = events.subscribe()
+					continue
+				}
+				select {
+				case queue <- ev:
+				default:
+					slog.Warn("webhook queue full; event dropped", "type", ev.Type.String(), "user_id", ev.User.GetId())
+				}
+			}
+		}
+	}()
+
+	go w.run(ctx)
+}
+
+func (w *webhooks) record(ctx context.Context, ev *pb.UserEvent) error {
+	payload, err := protojson.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = w.db.ExecContext(ctx,
+		`WITH ev AS (
+		   INSERT INTO webhook_events(type, user_id, payload, occurred_at) VALUES($1, $2, $3, to_timestamp($4)) RETURNING id
+		 )
+		 INSERT INTO webhook_deliveries(event_id, url) SELECT ev.id, u FROM ev, unnest($5::text[]) AS u`,
+		strings.TrimPrefix(ev.Type.String(), "USER_EVENT_TYPE_"), ev.User.GetId(), payload, ev.OccurredAt, w.urls,
+	)
+	if err == nil {
+		w.nudge()
+	}
+	return err
+}
+
+// nudge makes the worker poll now instead of at its next tick.
+func (w *webhooks) nudge() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *webhooks) run(ctx context.Context) {
+	ticker := time.NewTicker(webhookPoll)
+	defer ticker.Stop()
+	for {
+		// A read-only instance may be on a replica, where claiming would fail
+		if !w.readOnly.on() {
+			w.deliverDue(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// claimedDelivery is a delivery the worker holds the lease on.
+type claimedDelivery struct {
+	id       int64
+	eventID  int64
+	url      string
+	attempts int
+	event    []byte
+}
+
+func (w *webhooks) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		batch, err := w.claim(ctx)
+		w.report(err)
+		if err != nil {
+			slog.Error("failed to claim webhook deliveries", "error", err)
+			return
+		}
+		var wg sync.WaitGroup
+		for _, d := range batch {
+			wg.Add(1)
+			go func(d claimedDelivery) {
+				defer wg.Done()
+				w.attempt(ctx, d)
+			}(d)
+		}
+		wg.Wait()
+		if len(batch) < webhookBatch {
+			return
+		}
+	}
+}
+
+func (w *webhooks) claim(ctx context.Context) ([]claimedDelivery, error) {
+	rows, err := w.db.QueryContext(ctx,
+		`UPDATE webhook_deliveries d SET attempts = d.attempts + 1, next_attempt_at = now() + make_interval(secs => $1)
+		 FROM webhook_events e
+		 WHERE e.id = d.event_id AND d.id IN (
+		   SELECT id FROM webhook_deliveries WHERE state = 'PENDING' AND next_attempt_at <= now()
+		   ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING d.id, d.event_id, d.url, d.attempts, e.payload`,
+		w.lease.Seconds(), webhookBatch,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []claimedDelivery
+	for rows.Next() {
+		var d claimedDelivery
+		if err := rows.Scan(&d.id, &d.eventID, &d.url, &d.attempts, &d.event); err != nil {
+			return nil, err
+		}
+		batch = append(batch, d)
+	}
+	return batch, rows.Err()
+}
+
+func (w *webhooks) attempt(ctx context.Context, d claimedDelivery) {
+	var code int
+	var err error
+	permanent := false
+	if !w.configured(d.url) {
+		err, permanent = errors.New("receiver is no longer in webhooks.urls"), true
+	} else {
+		code, err = w.post(ctx, d)
+		permanent = errors.Is(err, httpclient.ErrBlockedAddress)
+	}
+	if ctx.Err() != nil {
+		// Shutting down: leave the row to be retried when its lease runs out
+		return
+	}
+
+	switch {
+	case err == nil:
+		_, err = w.db.ExecContext(ctx,
+			"UPDATE webhook_deliveries SET state = 'DELIVERED', delivered_at = now(), last_status_code = $2, last_error = '' WHERE id = $1",
+			d.id, code)
+	case permanent || d.attempts >= w.maxAttempts:
+		slog.Warn("webhook delivery failed for good", "delivery_id", d.id, "event_id", d.eventID, "url", d.url, "attempts", d.attempts, "error", err)
+		_, err = w.db.ExecContext(ctx,
+			"UPDATE webhook_deliveries SET state = 'FAILED', last_status_code = $2, last_error = $3 WHERE id = $1",
+			d.id, code, err.Error())
+	default:
+		slog.Debug("webhook delivery will be retried", "delivery_id", d.id, "url", d.url, "attempts", d.attempts, "error", err)
+		_, err = w.db.ExecContext(ctx,
+			"UPDATE webhook_deliveries SET next_attempt_at = now() + make_interval(secs => $4), last_status_code = $2, last_error = $3 WHERE id = $1",
+			d.id, code, err.Error(), webhookRetryDelay(d.attempts).Seconds())
+	}
+	if err != nil {
+		slog.Error("failed to update webhook delivery", "delivery_id", d.id, "error", err)
+	}
+}
+
+// webhookRetryDelay is the wait after a failed attempt: a minute, doubling,
+// at most an hour.
+func webhookRetryDelay(attempts int) time.Duration {
+	if attempts > 6 {
+		return time.Hour
+	}
+	return time.Minute << (attempts - 1)
+}
+
+func (w *webhooks) configured(url string) bool {
+	for _, u := range w.urls {
+		if u == url {
+			return true
+		}
+	}
+	return false
+}
+
+// post sends one attempt, signed as pkg/webhookverify expects. The client's
+// own retries resend the same timestamp and signature, well within the
+// receiver's tolerance. The status code is 0 when there was no response.
+func (w *webhooks) post(ctx context.Context, d claimedDelivery) (int, error) {
+	var ev pb.UserEvent
+	if err := protojson.Unmarshal(d.event, &ev); err != nil {
+		return 0, fmt.Errorf("stored event %d is unreadable: %w", d.eventID, err)
+	}
+	body, err := protojson.Marshal(&pb.WebhookPayload{DeliveryId: d.id, EventId: d.eventID, Event: &ev})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "user-service-webhooks")
+	now := time.Now()
+	req.Header.Set(webhookverify.TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(webhookverify.SignatureHeader, webhookverify.Sign(w.secret, now, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+const deliveryColumns = `d.id, d.event_id, e.type, e.user_id, d.url, d.state, d.attempts, d.last_status_code,
+	d.last_error, d.created_at, d.next_attempt_at, d.delivered_at, COALESCE(d.redelivery_of, 0)`
+
+func scanDelivery(row interface{ Scan(...interface{}) error }) (*pb.WebhookDelivery, error) {
+	var d pb.WebhookDelivery
+	var eventType, state string
+	var createdAt, nextAttemptAt time.Time
+	var deliveredAt sql.NullTime
+	err := row.Scan(&d.Id, &d.EventId, &eventType, &d.UserId, &d.Url, &state, &d.Attempts, &d.LastStatusCode,
+		&d.LastError, &createdAt, &nextAttemptAt, &deliveredAt, &d.RedeliveryOf)
+	if err != nil {
+		return nil, err
+	}
+	d.EventType = pb.UserEventType(pb.UserEventType_value["USER_EVENT_TYPE_"+eventType])
+	d.State = pb.WebhookDeliveryState(pb.WebhookDeliveryState_value["WEBHOOK_DELIVERY_STATE_"+state])
+	d.CreatedAt = createdAt.Unix()
+	if d.State == pb.WebhookDeliveryState_WEBHOOK_DELIVERY_STATE_PENDING {
+		d.NextAttemptAt = nextAttemptAt.Unix()
+	}
+	if deliveredAt.Valid {
+		d.DeliveredAt = deliveredAt.Time.Unix()
+	}
+	return &d, nil
+}
+
+func (s *server) ListDeliveries(ctx context.Context, req *pb.ListDeliveriesRequest) (*pb.ListDeliveriesResponse, error) {
+	pageSize := req.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	state := ""
+	if req.State != pb.WebhookDeliveryState_WEBHOOK_DELIVERY_STATE_UNSPECIFIED {
+		state = strings.TrimPrefix(req.State.String(), "WEBHOOK_DELIVERY_STATE_")
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+deliveryColumns+`
+		 FROM webhook_deliveries d JOIN webhook_events e ON e.id = d.event_id
+		 WHERE ($1::text = '' OR d.state = $1) AND ($2::bigint = 0 OR d.event_id = $2)
+		 ORDER BY d.id DESC LIMIT $3 OFFSET $4`,
+		state, req.EventId, pageSize, req.Offset,
+	)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	res := &pb.ListDeliveriesResponse{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to read delivery: %v", err)
+		}
+		res.Deliveries = append(res.Deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list deliveries: %v", err)
+	}
+	return res, nil
+}
+
+// RedeliverWebhook queues the event again for the same receiver. The original
+// delivery is left as it was.
+func (s *server) RedeliverWebhook(ctx context.Context, req *pb.RedeliverWebhookRequest) (*pb.WebhookDelivery, error) {
+	var url string
+	err := s.db.QueryRowContext(ctx, "SELECT url FROM webhook_deliveries WHERE id = $1", req.Id).Scan(&url)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Errorf(codes.NotFound, "delivery %d not found", req.Id)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load delivery: %v", err)
+	}
+	if !s.subsystems.enabled(subsystemWebhooks) || !s.webhooks.configured(url) {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is no longer a configured webhook receiver", url)
+	}
+
+	d, err := scanDelivery(s.db.QueryRowContext(ctx,
+		`WITH d AS (
+		   INSERT INTO webhook_deliveries(event_id, url, redelivery_of)
+		   SELECT event_id, url, id FROM webhook_deliveries WHERE id = $1
+		   RETURNING *
+		 )
+		 SELECT `+deliveryColumns+` FROM d JOIN webhook_events e ON e.id = d.event_id`,
+		req.Id,
+	))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to redeliver: %v", err)
+	}
+	s.webhooks.nudge()
+
+	slog.InfoContext(ctx, "AUDIT webhook redelivered", "delivery_id", req.Id, "new_delivery_id", d.Id, "event_id", d.EventId)
+	return d, nil
+}
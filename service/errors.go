@@ -0,0 +1,161 @@
+package service
+
+import (
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+)
+
+// Kind classifies an Error so each transport can map it onto its own status
+// codes.
+type Kind int
+
+const (
+	Internal Kind = iota
+	Invalid
+	NotFound
+	Conflict
+	PermissionDenied
+	// VersionMismatch means the caller's copy is stale; re-read and retry.
+	VersionMismatch
+)
+
+// Reasons are stable, machine-readable causes for an Error. Unlike messages
+// they never change wording, so callers can branch on them.
+const (
+	ReasonInternal        = "INTERNAL"
+	ReasonInvalidArgument = "INVALID_ARGUMENT"
+	ReasonUserNotFound    = "USER_NOT_FOUND"
+	ReasonEmailTaken      = "EMAIL_TAKEN"
+	// ReasonPermissionRequired has the missing permission in its
+	// "permission" metadata.
+	ReasonPermissionRequired = "PERMISSION_REQUIRED"
+	ReasonVersionMismatch    = "VERSION_MISMATCH"
+)
+
+// Field violation reasons, per invalid field of an Invalid error.
+const (
+	FieldRequired     = "REQUIRED"
+	FieldTooLong      = "TOO_LONG"
+	FieldInvalidEmail = "INVALID_EMAIL"
+	FieldInvalidID    = "INVALID_ID"
+	FieldOutOfRange   = "OUT_OF_RANGE"
+	FieldInvalidValue = "INVALID_VALUE"
+)
+
+// Error is returned by every service method. Message is safe to show the
+// caller; Err, when set, is the underlying cause. Reason and Metadata (e.g.
+// the email that is taken) identify the error for programs, and Violations
+// lists the invalid fields of an Invalid error.
+type Error struct {
+	Kind       Kind
+	Reason     string
+	Message    string
+	Metadata   map[string]string
+	Violations []FieldViolation
+	Err        error
+}
+
+// FieldViolation is one invalid field of a request.
+type FieldViolation struct {
+	Field       string
+	Reason      string
+	Description string
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func errorf(kind Kind, reason, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// internal wraps a backend failure as "<what>: <err>".
+func internal(what string, err error) *Error {
+	return &Error{Kind: Internal, Reason: ReasonInternal, Message: what + ": " + err.Error(), Err: err}
+}
+
+func userNotFound(id int32) *Error {
+	e := errorf(NotFound, ReasonUserNotFound, "user %d not found", id)
+	e.Metadata = map[string]string{"id": strconv.Itoa(int(id))}
+	return e
+}
+
+func emailTaken(email string) *Error {
+	e := errorf(Conflict, ReasonEmailTaken, "a user with email %q already exists", email)
+	e.Metadata = map[string]string{"email": email}
+	return e
+}
+
+const (
+	maxNameLength  = 255
+	maxEmailLength = 254 // RFC 5321 path limit
+)
+
+// violations collects invalid fields so a caller hears about all of them at
+// once.
+type violations []FieldViolation
+
+func (v *violations) add(field, reason, format string, args ...interface{}) {
+	*v = append(*v, FieldViolation{Field: field, Reason: reason, Description: fmt.Sprintf(format, args...)})
+}
+
+func (v *violations) requireName(field, name string) {
+	switch {
+	case strings.TrimSpace(name) == "":
+		v.add(field, FieldRequired, "must not be empty")
+	case len(name) > maxNameLength:
+		v.add(field, FieldTooLong, "must be at most %d characters", maxNameLength)
+	}
+}
+
+func (v *violations) requireEmail(field, email string) {
+	if email == "" {
+		v.add(field, FieldRequired, "must not be empty")
+		return
+	}
+	if err := ValidateEmail(NormalizeEmail(email)); err != nil {
+		v.add(field, FieldInvalidEmail, "%v", err)
+	}
+}
+
+func (v *violations) requireID(field string, id int32) {
+	if id <= 0 {
+		v.add(field, FieldInvalidID, "must be a positive id")
+	}
+}
+
+// err is nil when nothing was added.
+func (v violations) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(v))
+	for i, fv := range v {
+		msgs[i] = fv.Field + " " + fv.Description
+	}
+	e := errorf(Invalid, ReasonInvalidArgument, "invalid request: %s", strings.Join(msgs, "; "))
+	e.Violations = v
+	return e
+}
+
+// NormalizeEmail is applied to every email before it is stored or compared,
+// so lookups and the unique constraint behave case-insensitively.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// ValidateEmail accepts a bare RFC 5322 address ("jane@example.com"). Display
+// names ("Jane <jane@example.com>") are rejected.
+func ValidateEmail(email string) error {
+	if len(email) > maxEmailLength {
+		return fmt.Errorf("must be at most %d characters", maxEmailLength)
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return fmt.Errorf("must be a valid email address")
+	}
+	return nil
+}
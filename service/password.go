@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"unicode"
+)
+
+// maxPasswordBytes is bcrypt's limit; it refuses longer passwords.
+const maxPasswordBytes = 72
+
+// Password violation reasons, one per rule of PasswordPolicy.
+const (
+	FieldTooShort      = "TOO_SHORT"
+	FieldNeedsUpper    = "NEEDS_UPPERCASE"
+	FieldNeedsLower    = "NEEDS_LOWERCASE"
+	FieldNeedsDigit    = "NEEDS_DIGIT"
+	FieldNeedsSymbol   = "NEEDS_SYMBOL"
+	FieldBreachedValue = "BREACHED"
+)
+
+// PasswordPolicy is what new passwords must satisfy, in Register and
+// CheckPassword. The zero policy only requires a password that bcrypt can
+// hash.
+type PasswordPolicy struct {
+	// MinLength counts characters, not bytes.
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// Breached, if set, reports whether password is known from a breach.
+	// It is asked last, only about passwords that pass every other rule,
+	// and handles its own errors.
+	Breached func(ctx context.Context, password string) bool
+}
+
+// SetPasswordPolicy replaces the policy new passwords are checked against.
+// Call it before serving.
+func (u *Users) SetPasswordPolicy(p PasswordPolicy) { u.passwords = p }
+
+// CheckPassword reports a new password that breaks the policy as an Invalid
+// error naming field, with a violation per broken rule. Transports setting
+// passwords outside Register call it first.
+func (u *Users) CheckPassword(ctx context.Context, field, password string) error {
+	var v violations
+	u.passwords.check(ctx, &v, field, password)
+	return v.err()
+}
+
+func (p PasswordPolicy) check(ctx context.Context, v *violations, field, password string) {
+	if password == "" {
+		v.add(field, FieldRequired, "must not be empty")
+		return
+	}
+	before := len(*v)
+	if len(password) > maxPasswordBytes {
+		v.add(field, FieldTooLong, "must be at most %d bytes", maxPasswordBytes)
+	}
+	var length int
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		length++
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if length < p.MinLength {
+		v.add(field, FieldTooShort, "must be at least %d characters", p.MinLength)
+	}
+	if p.RequireUpper && !upper {
+		v.add(field, FieldNeedsUpper, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		v.add(field, FieldNeedsLower, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		v.add(field, FieldNeedsDigit, "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		v.add(field, FieldNeedsSymbol, "must contain a symbol or space")
+	}
+	if len(*v) == before && p.Breached != nil && p.Breached(ctx, password) {
+		v.add(field, FieldBreachedValue, "appears in a known data breach; choose another")
+	}
+}
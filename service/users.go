@@ -0,0 +1,342 @@
+// Package service is the user service's business logic: validation,
+// authorization and event emission around the repository. Transports (the
+// gRPC handlers today) translate requests into calls here and Errors back
+// into their own status codes.
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+	"grpc-crud-proj/repository"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 100
+)
+
+// AdminRole is the role that may manage other users' accounts when the
+// transport has no permission model of its own.
+const AdminRole = "admin"
+
+// Permissions needed to manage other users' accounts.
+const (
+	PermUserRead   = "user.read"
+	PermUserWrite  = "user.write"
+	PermUserDelete = "user.delete"
+)
+
+// Actor is the authenticated caller a transport acts for.
+type Actor struct {
+	Email string
+	Role  string
+	// Grants reports whether the actor holds a permission. Nil grants
+	// every permission to AdminRole and none to other roles.
+	Grants func(permission string) bool
+}
+
+func (a Actor) IsAdmin() bool { return strings.EqualFold(a.Role, AdminRole) }
+
+// Can reports whether the actor holds permission.
+func (a Actor) Can(permission string) bool {
+	if a.Grants != nil {
+		return a.Grants(permission)
+	}
+	return a.IsAdmin()
+}
+
+type actorKey struct{}
+
+// WithActor attaches the caller to ctx. Transports call it once they have
+// authenticated the request; calls without an actor are anonymous.
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, a)
+}
+
+// ActorFromContext returns the caller set by WithActor.
+func ActorFromContext(ctx context.Context) (Actor, bool) {
+	a, ok := ctx.Value(actorKey{}).(Actor)
+	return a, ok
+}
+
+// EventPublisher is told about every change to a user after it is stored.
+type EventPublisher func(t pb.UserEventType, user *pb.User)
+
+// Users manages user accounts.
+type Users struct {
+	repo         repository.UserRepository
+	publish      EventPublisher
+	hashPassword func(string) (string, error)
+	passwords    PasswordPolicy
+}
+
+// NewUsers returns the service over repo. publish may be nil.
+func NewUsers(repo repository.UserRepository, publish EventPublisher, hashPassword func(string) (string, error)) *Users {
+	if publish == nil {
+		publish = func(pb.UserEventType, *pb.User) {}
+	}
+	return &Users{repo: repo, publish: publish, hashPassword: hashPassword}
+}
+
+// RequirePermission is the check for operations on other users' accounts:
+// nil if the caller in ctx has permission, the PermissionDenied error the
+// methods here return otherwise. Transports use it to refuse a call before
+// looking anything up for it.
+func RequirePermission(ctx context.Context, permission string) error {
+	if a, ok := ActorFromContext(ctx); !ok || !a.Can(permission) {
+		return permissionRequired(permission, "requires permission %s", permission)
+	}
+	return nil
+}
+
+func permissionRequired(permission, format string, args ...interface{}) *Error {
+	e := errorf(PermissionDenied, ReasonPermissionRequired, format, args...)
+	e.Metadata = map[string]string{"permission": permission}
+	return e
+}
+
+// ownAccount returns user id if it is the actor's own account, for callers
+// acting on themselves without the permission. Anything else, including
+// an id that doesn't exist, is false, so the caller answers with the
+// permission error and learns nothing about other accounts.
+func (u *Users) ownAccount(ctx context.Context, id int32) (*pb.User, bool) {
+	a, ok := ActorFromContext(ctx)
+	if !ok || a.Email == "" || id < 1 {
+		return nil, false
+	}
+	user, err := u.repo.Get(ctx, id)
+	if err != nil || user.Id != id || user.Email != NormalizeEmail(a.Email) {
+		return nil, false
+	}
+	return user, true
+}
+
+// Registration is a sign-up. Role defaults to "user"; any other role needs
+// a caller with user.write, since Register is open to anonymous callers.
+type Registration struct {
+	Name     string
+	Email    string
+	Password string
+	Role     string
+}
+
+// Register creates an account for an anonymous caller.
+func (u *Users) Register(ctx context.Context, r Registration) (*pb.User, error) {
+	if r.Role != "" && r.Role != "user" {
+		if err := RequirePermission(ctx, PermUserWrite); err != nil {
+			return nil, err
+		}
+	}
+	var v violations
+	v.requireName("name", r.Name)
+	v.requireEmail("email", r.Email)
+	u.passwords.check(ctx, &v, "password", r.Password)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	hash, err := u.hashPassword(r.Password)
+	if err != nil {
+		return nil, internal("cannot hash password", err)
+	}
+	role := r.Role
+	if role == "" {
+		role = "user"
+	}
+	nu := repository.NewUser{Name: r.Name, Email: NormalizeEmail(r.Email), Role: role}
+
+	// The account, its password and the audit row land together or not at all
+	var user *pb.User
+	err = u.repo.WithTx(ctx, func(repo repository.UserRepository) error {
+		var err error
+		if user, err = repo.Create(ctx, nu); err != nil {
+			return err
+		}
+		if err := repo.SetPassword(ctx, user.Id, hash); err != nil {
+			return err
+		}
+		return repo.Audit(ctx, repository.AuditEntry{Actor: nu.Email, Action: "register", UserID: user.Id, Detail: "role=" + role})
+	})
+	if err := createError(nu, err, "cannot create user"); err != nil {
+		return nil, err
+	}
+	u.publish(pb.UserEventType_USER_EVENT_TYPE_CREATED, user)
+	return user, nil
+}
+
+// Create adds an account without a password. Needs user.write.
+func (u *Users) Create(ctx context.Context, name, email, role string) (*pb.User, error) {
+	if err := RequirePermission(ctx, PermUserWrite); err != nil {
+		return nil, err
+	}
+	var v violations
+	v.requireName("name", name)
+	v.requireEmail("email", email)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	nu := repository.NewUser{Name: name, Email: NormalizeEmail(email), Role: role}
+	user, err := u.repo.Create(ctx, nu)
+	if err := createError(nu, err, "failed to create user"); err != nil {
+		return nil, err
+	}
+	u.publish(pb.UserEventType_USER_EVENT_TYPE_CREATED, user)
+	return user, nil
+}
+
+// Provision creates a passwordless account, with the "user" role, for
+// someone who signed in with an identity provider that vouched for email.
+func (u *Users) Provision(ctx context.Context, name, email string) (*pb.User, error) {
+	var v violations
+	v.requireEmail("email", email)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	// The provider's display name is a nicety; fall back rather than refuse
+	if strings.TrimSpace(name) == "" || len(name) > maxNameLength {
+		name = email
+	}
+	nu := repository.NewUser{Name: name, Email: NormalizeEmail(email), Role: "user"}
+	var user *pb.User
+	err := u.repo.WithTx(ctx, func(repo repository.UserRepository) error {
+		var err error
+		if user, err = repo.Create(ctx, nu); err != nil {
+			return err
+		}
+		return repo.Audit(ctx, repository.AuditEntry{Actor: nu.Email, Action: "provision", UserID: user.Id})
+	})
+	if err := createError(nu, err, "cannot create user"); err != nil {
+		return nil, err
+	}
+	u.publish(pb.UserEventType_USER_EVENT_TYPE_CREATED, user)
+	return user, nil
+}
+
+// createError maps a repository error from creating nu; it is nil if err is.
+func createError(nu repository.NewUser, err error, what string) error {
+	switch {
+	case errors.Is(err, repository.ErrDuplicateEmail):
+		return emailTaken(nu.Email)
+	case err != nil:
+		return internal(what, err)
+	}
+	return nil
+}
+
+// Get returns a user; see UserRepository.Get for merged ids. Needs
+// user.read, except for the caller's own account.
+func (u *Users) Get(ctx context.Context, id int32) (*pb.User, error) {
+	if err := RequirePermission(ctx, PermUserRead); err != nil {
+		if own, ok := u.ownAccount(ctx, id); ok {
+			return own, nil
+		}
+		return nil, err
+	}
+	var v violations
+	v.requireID("id", id)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	user, err := u.repo.Get(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, userNotFound(id)
+	}
+	if err != nil {
+		return nil, internal("failed to get user", err)
+	}
+	return user, nil
+}
+
+// Update changes a user's name and email, provided nobody changed the user
+// since expectedVersion was read. Needs user.write, except that callers may
+// rename their own account; they change its email with RequestEmailChange,
+// which checks the new address.
+func (u *Users) Update(ctx context.Context, id int32, name, email string, expectedVersion int32) (*pb.User, error) {
+	if err := RequirePermission(ctx, PermUserWrite); err != nil {
+		own, ok := u.ownAccount(ctx, id)
+		if !ok {
+			return nil, err
+		}
+		if NormalizeEmail(email) != own.Email {
+			return nil, permissionRequired(PermUserWrite, "change your own email with RequestEmailChange")
+		}
+	}
+	var v violations
+	v.requireID("id", id)
+	v.requireName("name", name)
+	v.requireEmail("email", email)
+	if expectedVersion < 1 {
+		v.add("expected_version", FieldRequired, "must be set to the version last read")
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	email = NormalizeEmail(email)
+	user, err := u.repo.Update(ctx, id, name, email, expectedVersion)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return nil, userNotFound(id)
+	case errors.Is(err, repository.ErrVersionMismatch):
+		return nil, errorf(VersionMismatch, ReasonVersionMismatch, "user %d has changed since version %d; fetch it again and retry", id, expectedVersion)
+	case errors.Is(err, repository.ErrDuplicateEmail):
+		return nil, emailTaken(email)
+	case err != nil:
+		return nil, internal("failed to update user", err)
+	}
+	u.publish(pb.UserEventType_USER_EVENT_TYPE_UPDATED, user)
+	return user, nil
+}
+
+// Delete removes a user permanently. Needs user.delete.
+func (u *Users) Delete(ctx context.Context, id int32) error {
+	if err := RequirePermission(ctx, PermUserDelete); err != nil {
+		return err
+	}
+	var v violations
+	v.requireID("id", id)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	err := u.repo.Delete(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return userNotFound(id)
+	}
+	if err != nil {
+		return internal("failed to delete user", err)
+	}
+	u.publish(pb.UserEventType_USER_EVENT_TYPE_DELETED, &pb.User{Id: id})
+	return nil
+}
+
+// List returns a page of live users. A limit of 0 means DefaultPageSize.
+// Needs user.read.
+func (u *Users) List(ctx context.Context, opts repository.ListOptions) ([]*pb.User, error) {
+	if err := RequirePermission(ctx, PermUserRead); err != nil {
+		return nil, err
+	}
+	var v violations
+	if opts.Limit < 0 || opts.Limit > MaxPageSize {
+		v.add("page_size", FieldOutOfRange, "must be between 0 and %d", MaxPageSize)
+	}
+	if opts.Offset < 0 {
+		v.add("offset", FieldOutOfRange, "must not be negative")
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	if opts.Limit == 0 {
+		opts.Limit = DefaultPageSize
+	}
+
+	users, err := u.repo.List(ctx, opts)
+	if err != nil {
+		return nil, internal("failed to list users", err)
+	}
+	return users, nil
+}
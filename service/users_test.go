@@ -0,0 +1,59 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"grpc-crud-proj/db"
+	"grpc-crud-proj/repository"
+	"grpc-crud-proj/service"
+)
+
+func newUsers(t *testing.T) *service.Users {
+	t.Helper()
+	conn, err := db.ConnectSQLite(t.TempDir() + "/users.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := db.ApplySQLiteSchema(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+	hash := func(password string) (string, error) { return "hash:" + password, nil }
+	return service.NewUsers(repository.NewSQLite(conn, nil), nil, hash)
+}
+
+func TestRegisterRole(t *testing.T) {
+	users := newUsers(t)
+	ctx := context.Background()
+
+	_, err := users.Register(ctx, service.Registration{
+		Name: "Mallory", Email: "mallory@example.com", Password: "correct-horse", Role: "admin",
+	})
+	var se *service.Error
+	if !errors.As(err, &se) || se.Kind != service.PermissionDenied {
+		t.Fatalf("anonymous Register as admin: got %v, want PermissionDenied", err)
+	}
+
+	user, err := users.Register(ctx, service.Registration{
+		Name: "Alice", Email: "alice@example.com", Password: "correct-horse",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != "user" {
+		t.Errorf("anonymous Register: role %q, want user", user.Role)
+	}
+
+	admin := service.WithActor(ctx, service.Actor{Email: "root@example.com", Role: service.AdminRole})
+	user, err = users.Register(admin, service.Registration{
+		Name: "Bob", Email: "bob@example.com", Password: "correct-horse", Role: "manager",
+	})
+	if err != nil {
+		t.Fatalf("Register by an admin: %v", err)
+	}
+	if user.Role != "manager" {
+		t.Errorf("Register by an admin: role %q, want manager", user.Role)
+	}
+}
@@ -34,7 +34,17 @@ protoc \
   --go-grpc_opt=paths=source_relative \
   --grpc-gateway_out=proto/userpb \
   --grpc-gateway_opt=paths=source_relative \
-  proto/user.proto
+  proto/user.proto &&
+protoc \
+  --proto_path=proto \
+  --proto_path=. \
+  --go_out=. \
+  --go_opt=module=grpc-crud-proj \
+  --go-grpc_out=. \
+  --go-grpc_opt=module=grpc-crud-proj \
+  --grpc-gateway_out=. \
+  --grpc-gateway_opt=module=grpc-crud-proj \
+  proto/v2/user.proto
 
 if [ $? -eq 0 ]; then
     echo "✅ Code generation successful!"
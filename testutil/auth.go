@@ -0,0 +1,46 @@
+package testutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"grpc-crud-proj/middleware"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/metadata"
+)
+
+// Claims returns claims for email/role that expire in an hour. Modify the
+// result to build expired, impersonation or otherwise unusual tokens.
+func Claims(email, role string) middleware.Claims {
+	return middleware.Claims{
+		Email: email,
+		Role:  role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+// Token signs claims with key using the same algorithm as the server.
+func Token(t testing.TB, key []byte, claims middleware.Claims) string {
+	t.Helper()
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+// OutgoingContext is for calls made through a gRPC client.
+func OutgoingContext(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+// IncomingContext is for calling interceptors or handlers directly, as if the
+// request had arrived over the wire.
+func IncomingContext(ctx context.Context, token string) context.Context {
+	return metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+token))
+}
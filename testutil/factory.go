@@ -0,0 +1,67 @@
+package testutil
+
+import (
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User is a row created by CreateUser. Password is the plain-text password,
+// so tests can log in with it.
+type User struct {
+	ID       int32
+	Name     string
+	Email    string
+	Password string
+	Role     string
+	Status   string
+}
+
+// UserOption overrides a default of CreateUser.
+type UserOption func(*User)
+
+func WithName(name string) UserOption     { return func(u *User) { u.Name = name } }
+func WithEmail(email string) UserOption   { return func(u *User) { u.Email = email } }
+func WithPassword(pw string) UserOption   { return func(u *User) { u.Password = pw } }
+func WithRole(role string) UserOption     { return func(u *User) { u.Role = role } }
+func WithStatus(status string) UserOption { return func(u *User) { u.Status = status } }
+
+// Admin is shorthand for WithRole("admin").
+func Admin() UserOption { return WithRole("admin") }
+
+var userSeq atomic.Int64
+
+// CreateUser inserts a user with unique defaults and returns it.
+func CreateUser(t testing.TB, db *sql.DB, opts ...UserOption) User {
+	t.Helper()
+
+	n := userSeq.Add(1)
+	u := User{
+		Name:     fmt.Sprintf("Test User %d", n),
+		Email:    fmt.Sprintf("user%d-%s@example.test", n, randomHex(t, 4)),
+		Password: "password123",
+		Role:     "user",
+		Status:   "ACTIVE",
+	}
+	for _, opt := range opts {
+		opt(&u)
+	}
+
+	// MinCost keeps factories fast; the cost doesn't matter for tests
+	hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+
+	err = db.QueryRow(
+		"INSERT INTO users(name, email, password, role, status) VALUES($1, $2, $3, $4, $5) RETURNING id",
+		u.Name, u.Email, string(hash), u.Role, u.Status,
+	).Scan(&u.ID)
+	if err != nil {
+		t.Fatalf("create user %s: %v", u.Email, err)
+	}
+	return u
+}
@@ -0,0 +1,78 @@
+package testutil_test
+
+import (
+	"testing"
+
+	"grpc-crud-proj/middleware"
+	"grpc-crud-proj/testutil"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestSchemasAreIsolated runs parallel tests against schemas of their own
+// and checks none sees the others' users.
+func TestSchemasAreIsolated(t *testing.T) {
+	for _, name := range []string{"first", "second"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			conn := testutil.DB(t)
+
+			mine := map[string]bool{}
+			for i := 0; i < 3; i++ {
+				mine[testutil.CreateUser(t, conn).Email] = true
+			}
+			admin := testutil.CreateUser(t, conn, testutil.Admin(), testutil.WithPassword("s3cret-pass"))
+			mine[admin.Email] = true
+
+			rows, err := conn.Query("SELECT email FROM users")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			var seen int
+			for rows.Next() {
+				var email string
+				if err := rows.Scan(&email); err != nil {
+					t.Fatal(err)
+				}
+				if !mine[email] {
+					t.Errorf("sees %s, created by another test", email)
+				}
+				seen++
+			}
+			if err := rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if seen != len(mine) {
+				t.Errorf("sees %d users, want %d", seen, len(mine))
+			}
+
+			var role, hash string
+			if err := conn.QueryRow("SELECT role, password FROM users WHERE id=$1", admin.ID).Scan(&role, &hash); err != nil {
+				t.Fatal(err)
+			}
+			if role != "admin" {
+				t.Errorf("admin has role %q", role)
+			}
+			if bcrypt.CompareHashAndPassword([]byte(hash), []byte(admin.Password)) != nil {
+				t.Error("stored hash doesn't match the factory's password")
+			}
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+	key := []byte("test-key-test-key-test-key-test-key")
+	signed := testutil.Token(t, key, testutil.Claims("jane@example.test", "support"))
+
+	var claims middleware.Claims
+	_, err := jwt.ParseWithClaims(signed, &claims, func(*jwt.Token) (interface{}, error) { return key, nil },
+		jwt.WithValidMethods([]string{"HS256"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Email != "jane@example.test" || claims.Role != "support" {
+		t.Errorf("claims = %q/%q, want jane@example.test/support", claims.Email, claims.Role)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+)
+
+// runAdviseIndexes prints the server's index advice for the users table.
+func runAdviseIndexes(ctx context.Context, g *globals, args []string) error {
+	if err := parseFlags(newFlagSet("advise-indexes"), args); err != nil {
+		return err
+	}
+	return withClient(g, func(c pb.UserServiceClient) error {
+		res, err := c.AdviseIndexes(ctx, &pb.AdviseIndexesRequest{})
+		if err != nil {
+			return err
+		}
+		if g.output == "json" {
+			return printJSON(res)
+		}
+
+		fmt.Printf("users: ~%d rows\n\n", res.TableRows)
+		if len(res.Queries) == 0 {
+			fmt.Println("No queries recorded yet; the server only sees shapes it has served since it started.")
+		}
+		for _, q := range res.Queries {
+			fmt.Printf("%s (%d calls): %s, cost %.1f\n", q.Shape, q.Calls, q.Plan, q.Cost)
+			if q.Suggestion != "" {
+				fmt.Printf("  suggest: %s;\n", q.Suggestion)
+			}
+		}
+		if len(res.UnusedIndexes) > 0 {
+			fmt.Println("\nIndexes never scanned since statistics were reset:")
+			for _, idx := range res.UnusedIndexes {
+				fmt.Printf("  %s (%s)\n", idx.Name, idx.Size)
+			}
+		}
+		return nil
+	})
+}
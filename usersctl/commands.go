@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func runGet(ctx context.Context, g *globals, args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	return withClient(g, func(c pb.UserServiceClient) error {
+		res, err := c.GetUser(ctx, &pb.GetUserRequest{Id: id})
+		if err != nil {
+			return err
+		}
+		return printUsers(g, res, res.User)
+	})
+}
+
+func runList(ctx context.Context, g *globals, args []string) error {
+	fs := newFlagSet("list")
+	statusFlag := fs.String("status", "", `only list "active" or "suspended" users`)
+	pageSize := fs.Int("page-size", 0, "maximum number of users to return (server default when 0)")
+	offset := fs.Int("offset", 0, "number of users to skip")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	req := &pb.ListUsersRequest{PageSize: int32(*pageSize), Offset: int32(*offset)}
+	if *statusFlag != "" {
+		v, ok := pb.UserStatus_value["USER_STATUS_"+strings.ToUpper(*statusFlag)]
+		if !ok {
+			return usagef("unknown -status %q", *statusFlag)
+		}
+		req.Status = pb.UserStatus(v)
+	}
+	return withClient(g, func(c pb.UserServiceClient) error {
+		res, err := c.ListUsers(ctx, req)
+		if err != nil {
+			return err
+		}
+		return printUsers(g, res, res.Users...)
+	})
+}
+
+func runCreate(ctx context.Context, g *globals, args []string) error {
+	fs := newFlagSet("create")
+	name := fs.String("name", "", "full name")
+	email := fs.String("email", "", "email address")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return withClient(g, func(c pb.UserServiceClient) error {
+		res, err := c.CreateUser(ctx, &pb.CreateUserRequest{Name: *name, Email: *email})
+		if err != nil {
+			return err
+		}
+		return printUsers(g, res, res.User)
+	})
+}
+
+func runDelete(ctx context.Context, g *globals, args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	return withClient(g, func(c pb.UserServiceClient) error {
+		res, err := c.DeleteUser(ctx, &pb.DeleteUserRequest{Id: id})
+		if err != nil {
+			return err
+		}
+		if g.output == "json" {
+			return printJSON(res)
+		}
+		fmt.Println(res.Message)
+		return nil
+	})
+}
+
+func withClient(g *globals, fn func(pb.UserServiceClient) error) error {
+	client, err := g.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return fn(client)
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("usersctl "+name, flag.ContinueOnError)
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{err.Error()}
+	}
+	if fs.NArg() > 0 {
+		return usagef("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	return nil
+}
+
+func idArg(args []string) (int32, error) {
+	if len(args) != 1 {
+		return 0, usagef("expected exactly one user id")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 32)
+	if err != nil {
+		return 0, usagef("invalid user id %q", args[0])
+	}
+	return int32(id), nil
+}
+
+// printUsers prints the whole response as JSON, or the users as a table.
+func printUsers(g *globals, res proto.Message, users ...*pb.User) error {
+	if g.output == "json" {
+		return printJSON(res)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tEMAIL\tROLE\tSTATUS")
+	for _, u := range users {
+		status := strings.TrimPrefix(u.Status.String(), "USER_STATUS_")
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", u.Id, u.Name, u.Email, u.Role, status)
+	}
+	return w.Flush()
+}
+
+func printJSON(m proto.Message) error {
+	out, err := protojson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
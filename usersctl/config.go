@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"grpc-crud-proj/internal/config"
+)
+
+// invalidConfigError exits with exitInvalidArgument.
+type invalidConfigError struct{ problems int }
+
+func (e invalidConfigError) Error() string {
+	return fmt.Sprintf("config has %d problem(s)", e.problems)
+}
+
+func runConfig(ctx context.Context, g *globals, args []string) error {
+	if len(args) == 0 {
+		return usagef("usage: config init [-out file] [-force] | config validate <file>")
+	}
+	switch args[0] {
+	case "init":
+		return runConfigInit(g, args[1:])
+	case "validate":
+		return runConfigValidate(g, args[1:])
+	}
+	return usagef("unknown config command %q", args[0])
+}
+
+// runConfigInit writes the annotated default config.
+func runConfigInit(g *globals, args []string) error {
+	fs := newFlagSet("config init")
+	out := fs.String("out", "", "file to write (default: stdout)")
+	force := fs.Bool("force", false, "overwrite an existing file")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *out == "" {
+		fmt.Print(config.Template)
+		return nil
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_EXCL
+	if *force {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	}
+	// 0600: the file is meant to hold secrets
+	f, err := os.OpenFile(*out, flags, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return usagef("%s already exists (use -force to overwrite)", *out)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(config.Template); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s; set auth.jwt_secret or auth.jwt_keys before starting the server\n", *out)
+	return nil
+}
+
+// runConfigValidate reports unknown keys, bad values and missing secrets.
+func runConfigValidate(g *globals, args []string) error {
+	if len(args) != 1 {
+		return usagef("expected exactly one config file")
+	}
+	path := args[0]
+
+	problems, err := config.Check(path)
+	if err != nil {
+		return err
+	}
+
+	if g.output == "json" {
+		out, _ := json.Marshal(struct {
+			Valid    bool             `json:"valid"`
+			Problems []config.Problem `json:"problems"`
+		}{len(problems) == 0, problems})
+		fmt.Println(string(out))
+	} else {
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) == 0 {
+			fmt.Printf("%s is valid\n", path)
+		}
+	}
+	if len(problems) > 0 {
+		return invalidConfigError{len(problems)}
+	}
+	return nil
+}
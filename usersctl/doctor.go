@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"grpc-crud-proj/internal/doctor"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// runDoctor connects to Postgres directly (not through the server), so it
+// also works when the server won't start.
+func runDoctor(ctx context.Context, g *globals, args []string) error {
+	fs := newFlagSet("doctor")
+	dbURL := fs.String("db-url", g.cfg.Database.URL, "Postgres connection string (default: database.url or $DB_URL)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *dbURL == "" {
+		return usagef("-db-url is required")
+	}
+
+	conn, err := sql.Open("pgx", *dbURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	findings := doctor.Run(ctx, conn)
+	errs := 0
+	for _, f := range findings {
+		if f.Severity == doctor.Error {
+			errs++
+		}
+	}
+
+	if g.output == "json" {
+		out, _ := json.Marshal(map[string][]doctor.Finding{"findings": findings})
+		fmt.Println(string(out))
+	} else {
+		for _, f := range findings {
+			fmt.Printf("[%-5s] %-12s %s\n", strings.ToUpper(string(f.Severity)), f.Check, f.Message)
+			if f.Fix != "" {
+				fmt.Printf("%20s %s\n", "fix:", f.Fix)
+			}
+		}
+	}
+	if errs > 0 {
+		return fmt.Errorf("%d check(s) failed", errs)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Exit codes. Scripts can branch on these instead of parsing messages.
+const (
+	exitOK                 = 0
+	exitFailure            = 1 // anything not listed below
+	exitUsage              = 2 // bad flags or arguments
+	exitInvalidArgument    = 3
+	exitNotFound           = 4
+	exitAlreadyExists      = 5
+	exitUnauthenticated    = 6
+	exitPermissionDenied   = 7
+	exitFailedPrecondition = 8
+	exitResourceExhausted  = 9
+	exitUnavailable        = 10 // server unreachable or deadline exceeded
+)
+
+var exitCodes = map[codes.Code]int{
+	codes.InvalidArgument:    exitInvalidArgument,
+	codes.OutOfRange:         exitInvalidArgument,
+	codes.NotFound:           exitNotFound,
+	codes.AlreadyExists:      exitAlreadyExists,
+	codes.Unauthenticated:    exitUnauthenticated,
+	codes.PermissionDenied:   exitPermissionDenied,
+	codes.FailedPrecondition: exitFailedPrecondition,
+	codes.ResourceExhausted:  exitResourceExhausted,
+	codes.Unavailable:        exitUnavailable,
+	codes.DeadlineExceeded:   exitUnavailable,
+}
+
+// usageError marks mistakes in the command line itself.
+type usageError struct{ msg string }
+
+func (e usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...interface{}) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
+
+func exitCode(err error) int {
+	var ue usageError
+	if errors.As(err, &ue) {
+		return exitUsage
+	}
+	var ce invalidConfigError
+	if errors.As(err, &ce) {
+		return exitInvalidArgument
+	}
+	if s, ok := status.FromError(err); ok {
+		if code, ok := exitCodes[s.Code()]; ok {
+			return code
+		}
+	}
+	return exitFailure
+}
+
+// reportError prints err to stderr, as JSON with -output json:
+//
+//	{"error": {"code": "NOT_FOUND", "message": "user not found", "exit_code": 4}}
+func reportError(g *globals, err error) int {
+	code := exitCode(err)
+	grpcCode, msg := "", err.Error()
+	if s, ok := status.FromError(err); ok && s.Code() != codes.Unknown {
+		grpcCode, msg = codeName(s.Code()), s.Message()
+	}
+
+	if g.output != "json" {
+		fmt.Fprintln(os.Stderr, "usersctl:", msg)
+		return code
+	}
+	type jsonError struct {
+		Code     string `json:"code,omitempty"`
+		Message  string `json:"message"`
+		ExitCode int    `json:"exit_code"`
+	}
+	out, _ := json.Marshal(map[string]jsonError{"error": {Code: grpcCode, Message: msg, ExitCode: code}})
+	fmt.Fprintln(os.Stderr, string(out))
+	return code
+}
+
+// codeName is the canonical upper-case name, e.g. "NOT_FOUND".
+func codeName(c codes.Code) string {
+	names := map[codes.Code]string{
+		codes.OK: "OK", codes.Canceled: "CANCELLED", codes.Unknown: "UNKNOWN",
+		codes.InvalidArgument: "INVALID_ARGUMENT", codes.DeadlineExceeded: "DEADLINE_EXCEEDED",
+		codes.NotFound: "NOT_FOUND", codes.AlreadyExists: "ALREADY_EXISTS",
+		codes.PermissionDenied: "PERMISSION_DENIED", codes.ResourceExhausted: "RESOURCE_EXHAUSTED",
+		codes.FailedPrecondition: "FAILED_PRECONDITION", codes.Aborted: "ABORTED",
+		codes.OutOfRange: "OUT_OF_RANGE", codes.Unimplemented: "UNIMPLEMENTED",
+		codes.Internal: "INTERNAL", codes.Unavailable: "UNAVAILABLE", codes.DataLoss: "DATA_LOSS",
+		codes.Unauthenticated: "UNAUTHENTICATED",
+	}
+	if n, ok := names[c]; ok {
+		return n
+	}
+	return c.String()
+}
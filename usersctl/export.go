@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var csvHeader = []string{"id", "name", "email", "role", "status"}
+
+func runExport(ctx context.Context, g *globals, args []string) error {
+	fs := newFlagSet("export")
+	format := fs.String("format", "ndjson", `"csv" or "ndjson"`)
+	out := fs.String("out", "", "output file (default: stdout)")
+	statusFlag := fs.String("status", "", `only export "active" or "suspended" users`)
+	resume := fs.Bool("resume", false, "append to -out, continuing after the last user it contains")
+	noProgress := fs.Bool("no-progress", false, "don't draw progress on stderr")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *format != "csv" && *format != "ndjson" {
+		return usagef("unknown -format %q", *format)
+	}
+	if *resume && *out == "" {
+		return usagef("-resume needs -out")
+	}
+
+	req := &pb.ExportUsersRequest{}
+	if *statusFlag != "" {
+		v, ok := pb.UserStatus_value["USER_STATUS_"+strings.ToUpper(*statusFlag)]
+		if !ok {
+			return usagef("unknown -status %q", *statusFlag)
+		}
+		req.Status = pb.UserStatus(v)
+	}
+
+	w := io.Writer(os.Stdout)
+	writeHeader := true
+	if *out != "" {
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if *resume {
+			lastID, err := lastExportedID(*out, *format)
+			if err != nil {
+				return err
+			}
+			req.AfterId = lastID
+			writeHeader = lastID == 0
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		f, err := os.OpenFile(*out, flags, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	enc := newUserEncoder(w, *format)
+	if writeHeader {
+		if err := enc.header(); err != nil {
+			return err
+		}
+	}
+
+	bar := newProgress(os.Stderr, "exported", 0, *out != "" && !*noProgress && g.output != "json")
+	defer bar.finish()
+	return withClient(g, func(c pb.UserServiceClient) error {
+		stream, err := c.ExportUsers(ctx, req)
+		if err != nil {
+			return err
+		}
+		for {
+			user, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return enc.flush()
+			}
+			if err != nil {
+				// Keep what we have so -resume can pick up from here
+				enc.flush()
+				return err
+			}
+			if err := enc.encode(user); err != nil {
+				return err
+			}
+			bar.add(1)
+		}
+	})
+}
+
+type userEncoder struct {
+	format string
+	buf    *bufio.Writer
+	csv    *csv.Writer
+}
+
+func newUserEncoder(w io.Writer, format string) *userEncoder {
+	buf := bufio.NewWriter(w)
+	return &userEncoder{format: format, buf: buf, csv: csv.NewWriter(buf)}
+}
+
+func (e *userEncoder) header() error {
+	if e.format != "csv" {
+		return nil
+	}
+	return e.csv.Write(csvHeader)
+}
+
+func (e *userEncoder) encode(u *pb.User) error {
+	if e.format == "csv" {
+		return e.csv.Write([]string{
+			strconv.Itoa(int(u.Id)), u.Name, u.Email, u.Role,
+			strings.TrimPrefix(u.Status.String(), "USER_STATUS_"),
+		})
+	}
+	line, err := protojson.Marshal(u)
+	if err != nil {
+		return err
+	}
+	e.buf.Write(line)
+	return e.buf.WriteByte('\n')
+}
+
+func (e *userEncoder) flush() error {
+	e.csv.Flush()
+	if err := e.csv.Error(); err != nil {
+		return err
+	}
+	return e.buf.Flush()
+}
+
+// lastExportedID returns the id of the last complete record in an earlier
+// export, dropping a half-written trailing line first. 0 means start over.
+func lastExportedID(path, format string) (int32, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	end := bytes.LastIndexByte(data, '\n') + 1
+	if end != len(data) {
+		if err := os.Truncate(path, int64(end)); err != nil {
+			return 0, err
+		}
+		data = data[:end]
+	}
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	last := lines[len(lines)-1]
+	if len(last) == 0 {
+		return 0, nil
+	}
+
+	if format == "csv" {
+		rec, err := csv.NewReader(bytes.NewReader(last)).Read()
+		if err != nil {
+			return 0, fmt.Errorf("cannot resume %s: %w", path, err)
+		}
+		if rec[0] == csvHeader[0] {
+			return 0, nil
+		}
+		id, err := strconv.ParseInt(rec[0], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("cannot resume %s: bad id %q", path, rec[0])
+		}
+		return int32(id), nil
+	}
+
+	var u pb.User
+	if err := protojson.Unmarshal(last, &u); err != nil {
+		return 0, fmt.Errorf("cannot resume %s: %w", path, err)
+	}
+	return u.Id, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// record is one user read from an import file, with the line it came from.
+type record struct {
+	line int
+	user *pb.User
+}
+
+type batch struct {
+	index   int
+	records []record
+}
+
+type batchResult struct {
+	index int
+	res   *pb.ImportUsersResponse
+	err   error
+}
+
+type importFailure struct {
+	Line  int    `json:"line"`
+	Email string `json:"email,omitempty"`
+	Error string `json:"error"`
+}
+
+type importSummary struct {
+	Created  int             `json:"created"`
+	Skipped  int             `json:"skipped"`
+	Failures []importFailure `json:"failures,omitempty"`
+}
+
+// runImport sends the file in batches, each on its own ImportUsers stream.
+// The number of records covered by finished batches is checkpointed to
+// <file>.progress so -resume can skip them after an interruption.
+func runImport(ctx context.Context, g *globals, args []string) error {
+	fs := newFlagSet("import")
+	format := fs.String("format", "", `"csv" or "ndjson" (default: from the file extension)`)
+	concurrency := fs.Int("concurrency", 4, "number of batches in flight")
+	batchSize := fs.Int("batch-size", 500, "users per ImportUsers stream")
+	resume := fs.Bool("resume", false, "skip the records a previous run already imported")
+	noProgress := fs.Bool("no-progress", false, "don't draw progress on stderr")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{err.Error()}
+	}
+	if fs.NArg() != 1 {
+		return usagef("expected exactly one file to import")
+	}
+	if *concurrency < 1 || *batchSize < 1 {
+		return usagef("-concurrency and -batch-size must be at least 1")
+	}
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = "ndjson"
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			*format = "csv"
+		}
+	}
+
+	records, err := readRecords(path, *format)
+	if err != nil {
+		return err
+	}
+	checkpoint := path + ".progress"
+	skip := 0
+	if *resume {
+		if skip, err = readCheckpoint(checkpoint); err != nil {
+			return err
+		}
+		skip = min(skip, len(records))
+	}
+
+	var batches []batch
+	for start := skip; start < len(records); start += *batchSize {
+		end := min(start+*batchSize, len(records))
+		batches = append(batches, batch{index: len(batches), records: records[start:end]})
+	}
+
+	// Ctrl-C stops sending new batches; the checkpoint stays usable
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	bar := newProgress(os.Stderr, "imported", len(records), !*noProgress && g.output != "json")
+	bar.resumed(skip)
+
+	var summary importSummary
+	err = withClient(g, func(c pb.UserServiceClient) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		jobs := make(chan batch)
+		results := make(chan batchResult)
+		for i := 0; i < *concurrency; i++ {
+			go func() {
+				for b := range jobs {
+					if ctx.Err() != nil {
+						results <- batchResult{index: b.index, err: ctx.Err()}
+						continue
+					}
+					res, err := sendBatch(ctx, c, b)
+					results <- batchResult{index: b.index, res: res, err: err}
+				}
+			}()
+		}
+		go func() {
+			for _, b := range batches {
+				jobs <- b
+			}
+			close(jobs)
+		}()
+
+		// Batches finish out of order; only the finished prefix is checkpointed
+		done := make([]bool, len(batches))
+		next, covered := 0, skip
+		var firstErr error
+		for range batches {
+			r := <-results
+			if r.err != nil {
+				if firstErr == nil {
+					firstErr = r.err
+					cancel()
+				}
+				continue
+			}
+			done[r.index] = true
+			b := batches[r.index]
+			summary.Created += int(r.res.Created)
+			summary.Skipped += int(r.res.Skipped)
+			for _, f := range r.res.Failures {
+				line := 0
+				if int(f.Index) < len(b.records) {
+					line = b.records[f.Index].line
+				}
+				summary.Failures = append(summary.Failures, importFailure{Line: line, Email: f.Email, Error: f.Error})
+			}
+			bar.add(len(b.records))
+
+			for next < len(batches) && done[next] {
+				covered += len(batches[next].records)
+				next++
+			}
+			if err := os.WriteFile(checkpoint, []byte(strconv.Itoa(covered)+"\n"), 0o644); err != nil && firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+		}
+		return firstErr
+	})
+	bar.finish()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "usersctl: import interrupted; rerun with -resume to continue\n")
+		return err
+	}
+	os.Remove(checkpoint)
+
+	if g.output == "json" {
+		out, _ := json.Marshal(summary)
+		fmt.Println(string(out))
+	} else {
+		fmt.Printf("created %d, skipped %d (already exist), failed %d\n", summary.Created, summary.Skipped, len(summary.Failures))
+		for _, f := range summary.Failures {
+			fmt.Printf("  line %d (%s): %s\n", f.Line, f.Email, f.Error)
+		}
+	}
+	if len(summary.Failures) > 0 {
+		return fmt.Errorf("%d records failed to import", len(summary.Failures))
+	}
+	return nil
+}
+
+func sendBatch(ctx context.Context, c pb.UserServiceClient, b batch) (*pb.ImportUsersResponse, error) {
+	stream, err := c.ImportUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range b.records {
+		if err := stream.Send(&pb.ImportUsersRequest{User: r.user}); err != nil {
+			// The real error is reported by CloseAndRecv
+			break
+		}
+	}
+	return stream.CloseAndRecv()
+}
+
+// readRecords parses the whole file up front so syntax errors are reported
+// before anything is imported, and so the progress bar knows the total.
+func readRecords(path, format string) ([]record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if format == "csv" {
+		return readCSV(path, f)
+	}
+	if format != "ndjson" {
+		return nil, usagef("unknown -format %q", format)
+	}
+
+	var records []record
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		var u pb.User
+		if err := protojson.Unmarshal([]byte(text), &u); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		records = append(records, record{line: line, user: &u})
+	}
+	return records, sc.Err()
+}
+
+// readCSV expects the header written by export; columns may be in any order
+// and only name and email are required.
+func readCSV(path string, r io.Reader) ([]record, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: read header: %w", path, err)
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"name", "email"} {
+		if _, ok := col[required]; !ok {
+			return nil, fmt.Errorf("%s: missing %q column", path, required)
+		}
+	}
+	get := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	var records []record
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		line, _ := cr.FieldPos(0)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		u := &pb.User{Name: get(row, "name"), Email: get(row, "email"), Role: get(row, "role")}
+		if s := get(row, "status"); s != "" {
+			v, ok := pb.UserStatus_value["USER_STATUS_"+strings.ToUpper(s)]
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: unknown status %q", path, line, s)
+			}
+			u.Status = pb.UserStatus(v)
+		}
+		records = append(records, record{line: line, user: u})
+	}
+}
+
+func readCheckpoint(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("corrupt checkpoint %s: %w", path, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,156 @@
+// Command usersctl is a command-line client for UserService.
+//
+//	usersctl [global flags] <command> [flags] [args]
+//
+// Run "usersctl -h" for the list of commands.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"grpc-crud-proj/internal/config"
+	"grpc-crud-proj/sdk"
+)
+
+// globals are the connection and output settings shared by every command.
+type globals struct {
+	cfg      *config.Config // connection settings are in cfg.Client
+	email    string
+	password string
+	output   string
+	timeout  time.Duration
+}
+
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(ctx context.Context, g *globals, args []string) error
+	// streaming commands run until done or interrupted and ignore -timeout
+	streaming bool
+}
+
+var commands = []command{
+	{name: "get", usage: "get <id>", summary: "show one user", run: runGet},
+	{name: "list", usage: "list [-status active|suspended] [-page-size n] [-offset n]", summary: "list users", run: runList},
+	{name: "create", usage: "create -name <name> -email <email>", summary: "create a user", run: runCreate},
+	{name: "delete", usage: "delete <id>", summary: "delete a user", run: runDelete},
+	{name: "export", usage: "export [-format csv|ndjson] [-out file] [-resume]", summary: "stream every user to a file", run: runExport, streaming: true},
+	{name: "import", usage: "import [-format csv|ndjson] [-concurrency n] [-resume] <file>", summary: "create users from a file", run: runImport, streaming: true},
+	{name: "config", usage: "config init [-out file] | config validate <file>", summary: "write or check a server config file", run: runConfig},
+	{name: "doctor", usage: "doctor [-db-url url]", summary: "check the database for common problems", run: runDoctor},
+	{name: "advise-indexes", usage: "advise-indexes", summary: "EXPLAIN recent list queries and report missing or unused indexes", run: runAdviseIndexes},
+	{name: "read-only", usage: "read-only [[-reason text] on | off]", summary: "show or switch the server's read-only mode", run: runReadOnly},
+	{name: "subsystems", usage: "subsystems", summary: "show which optional subsystems are configured and working", run: runSubsystems},
+	{name: "webhooks", usage: "webhooks list [-state s] [-event id] | webhooks redeliver <delivery-id>", summary: "inspect and retry webhook deliveries", run: runWebhooks},
+	{name: "watch", usage: "watch [-filter created,updated,deleted]", summary: "print user changes as they happen", run: runWatch, streaming: true},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	var g globals
+	fs := flag.NewFlagSet("usersctl", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML config file with client (and, for doctor, database) settings")
+	fs.String("target", "", `server address, e.g. "dns:///users.internal:50051" (default client.target)`)
+	fs.String("region", "", "prefer servers in this region when -target lists region=host:port entries")
+	fs.Bool("tls", false, "connect with TLS")
+	fs.String("ca-file", "", "PEM CA bundle used to verify the server (default: system roots)")
+	fs.String("server-name", "", "override the TLS server name")
+	fs.String("cert", "", "PEM client certificate for servers that require mutual TLS (implies -tls)")
+	fs.String("key", "", "PEM key for -cert")
+	fs.StringVar(&g.email, "email", os.Getenv("USER_SERVICE_EMAIL"), "log in as this user")
+	fs.StringVar(&g.password, "password", os.Getenv("USER_SERVICE_PASSWORD"), "password for -email")
+	fs.StringVar(&g.output, "output", "text", `output format: "text" or "json"`)
+	fs.DurationVar(&g.timeout, "timeout", 10*time.Second, "per-command deadline")
+	fs.Usage = func() { usage(fs) }
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if g.output != "text" && g.output != "json" {
+		fmt.Fprintf(os.Stderr, "usersctl: unknown -output %q\n", g.output)
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		usage(fs)
+		return exitUsage
+	}
+	cfg, problems, err := config.Resolve(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "usersctl: %v\n", err)
+		return exitUsage
+	}
+	problems = append(problems, cfg.ApplyFlags(fs, config.ClientFlags)...)
+	problems = append(problems, cfg.Client.Validate()...)
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "usersctl: %s\n", p)
+		}
+		return exitUsage
+	}
+	g.cfg = cfg
+
+	name := fs.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+		if cmd.streaming {
+			ctx, cancel = context.WithCancel(context.Background())
+		}
+		defer cancel()
+		if err := cmd.run(ctx, &g, fs.Args()[1:]); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return exitOK
+			}
+			return reportError(&g, err)
+		}
+		return exitOK
+	}
+	fmt.Fprintf(os.Stderr, "usersctl: unknown command %q\n", name)
+	usage(fs)
+	return exitUsage
+}
+
+func usage(fs *flag.FlagSet) {
+	out := fs.Output()
+	fmt.Fprintf(out, "Usage: usersctl [global flags] <command> [flags] [args]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-60s %s\n", cmd.usage, cmd.summary)
+	}
+	fmt.Fprintf(out, "\nGlobal flags:\n")
+	fs.PrintDefaults()
+}
+
+// dial connects with the client section of the config.
+func (g *globals) dial() (*sdk.Client, error) {
+	cc := g.cfg.Client
+	var opts []sdk.Option
+	if g.email != "" {
+		opts = append(opts, sdk.WithLogin(g.email, g.password))
+	}
+	if cc.TLS || cc.CertFile != "" {
+		opts = append(opts, sdk.WithCAFile(cc.CAFile), sdk.WithServerName(cc.ServerName))
+		if cc.CertFile != "" {
+			opts = append(opts, sdk.WithClientCertificate(cc.CertFile, cc.KeyFile))
+		}
+	} else {
+		opts = append(opts, sdk.WithInsecure())
+	}
+	if cc.Region != "" {
+		opts = append(opts, sdk.WithRegion(cc.Region))
+	}
+	opts = append(opts, sdk.WithRequestSource("usersctl"))
+	return sdk.New(cc.Target, opts...)
+}
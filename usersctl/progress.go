@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// progress draws a single self-overwriting status line on stderr. total is
+// 0 when it isn't known up front (exports), which drops the bar.
+type progress struct {
+	w       io.Writer
+	label   string
+	total   int
+	done    int
+	base    int // done before this run, excluded from the rate
+	start   time.Time
+	drawn   time.Time
+	enabled bool
+}
+
+const barWidth = 30
+
+func newProgress(w io.Writer, label string, total int, enabled bool) *progress {
+	return &progress{w: w, label: label, total: total, start: time.Now(), enabled: enabled}
+}
+
+// set records that n items are done, redrawing at most ten times a second.
+func (p *progress) set(n int) {
+	p.done = n
+	if p.enabled && time.Since(p.drawn) >= 100*time.Millisecond {
+		p.draw()
+	}
+}
+
+// resumed marks n items as already done by an earlier run.
+func (p *progress) resumed(n int) {
+	p.done, p.base = n, n
+}
+
+func (p *progress) add(n int) {
+	p.set(p.done + n)
+}
+
+// finish draws the final state and ends the line.
+func (p *progress) finish() {
+	if !p.enabled {
+		return
+	}
+	p.draw()
+	fmt.Fprintln(p.w)
+}
+
+func (p *progress) draw() {
+	p.drawn = time.Now()
+	rate := float64(p.done-p.base) / time.Since(p.start).Seconds()
+	if p.total <= 0 {
+		fmt.Fprintf(p.w, "\r%s %d (%.0f/s)", p.label, p.done, rate)
+		return
+	}
+	filled := barWidth * p.done / p.total
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+	fmt.Fprintf(p.w, "\r%s [%s] %d/%d %3d%% (%.0f/s)", p.label, bar, p.done, p.total, 100*p.done/p.total, rate)
+}
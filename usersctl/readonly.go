@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+)
+
+// runReadOnly shows read-only mode, or turns it on or off.
+func runReadOnly(ctx context.Context, g *globals, args []string) error {
+	fs := newFlagSet("read-only")
+	reason := fs.String("reason", "", `shown to callers whose writes are refused, e.g. "failover in progress"`)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{err.Error()}
+	}
+	var set *pb.SetReadOnlyModeRequest
+	switch fs.Arg(0) {
+	case "":
+	case "on":
+		set = &pb.SetReadOnlyModeRequest{Enabled: true, Reason: *reason}
+	case "off":
+		set = &pb.SetReadOnlyModeRequest{Enabled: false}
+	default:
+		return usagef(`expected "on" or "off", got %q`, fs.Arg(0))
+	}
+	if fs.NArg() > 1 {
+		return usagef("unexpected arguments after %s", fs.Arg(0))
+	}
+
+	return withClient(g, func(c pb.UserServiceClient) error {
+		var res *pb.ReadOnlyMode
+		var err error
+		if set != nil {
+			res, err = c.SetReadOnlyMode(ctx, set)
+		} else {
+			res, err = c.GetReadOnlyMode(ctx, &pb.GetReadOnlyModeRequest{})
+		}
+		if err != nil {
+			return err
+		}
+		if g.output == "json" {
+			return printJSON(res)
+		}
+		if !res.Enabled {
+			fmt.Println("read-only mode is off")
+			return nil
+		}
+		fmt.Printf("read-only mode is on since %s", time.Unix(res.Since, 0).Format(time.RFC3339))
+		if res.Reason != "" {
+			fmt.Printf(" (%s)", res.Reason)
+		}
+		fmt.Println()
+		return nil
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+)
+
+// runSubsystems lists the server's optional subsystems and their state.
+func runSubsystems(ctx context.Context, g *globals, args []string) error {
+	if len(args) > 0 {
+		return usagef("subsystems takes no arguments")
+	}
+	return withClient(g, func(c pb.UserServiceClient) error {
+		res, err := c.ListSubsystems(ctx, &pb.ListSubsystemsRequest{})
+		if err != nil {
+			return err
+		}
+		if g.output == "json" {
+			return printJSON(res)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSTATE\tSINCE\tDETAIL")
+		for _, s := range res.Subsystems {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Name, strings.TrimPrefix(s.State.String(), "SUBSYSTEM_STATE_"),
+				time.Unix(s.Since, 0).Format(time.RFC3339), s.Detail)
+		}
+		return w.Flush()
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// runWatch prints user events until the stream ends or the user hits Ctrl-C.
+// It ignores -timeout.
+func runWatch(ctx context.Context, g *globals, args []string) error {
+	fs := newFlagSet("watch")
+	filter := fs.String("filter", "", `comma-separated event types to show, e.g. "created,deleted" (default: all)`)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	req := &pb.WatchUsersRequest{}
+	for _, name := range strings.Split(*filter, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		v, ok := pb.UserEventType_value["USER_EVENT_TYPE_"+strings.ToUpper(name)]
+		if !ok || v == 0 {
+			return usagef("unknown event type %q in -filter", name)
+		}
+		req.Types = append(req.Types, pb.UserEventType(v))
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	return withClient(g, func(c pb.UserServiceClient) error {
+		stream, err := c.WatchUsers(ctx, req)
+		if err != nil {
+			return err
+		}
+		for {
+			ev, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				if status.Code(err) == codes.Canceled && ctx.Err() != nil {
+					return nil // Ctrl-C
+				}
+				return err
+			}
+			if err := printEvent(g, ev); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// printEvent writes one event per line, so -output json is NDJSON.
+func printEvent(g *globals, ev *pb.UserEvent) error {
+	if g.output == "json" {
+		return printJSON(ev)
+	}
+	u := ev.User
+	fmt.Printf("%s  %-7s  %d  %s  %s  %s\n",
+		time.Unix(ev.OccurredAt, 0).UTC().Format(time.RFC3339),
+		strings.TrimPrefix(ev.Type.String(), "USER_EVENT_TYPE_"),
+		u.GetId(), u.GetEmail(), u.GetName(),
+		strings.TrimPrefix(u.GetStatus().String(), "USER_STATUS_"))
+	return nil
+}
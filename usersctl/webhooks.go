@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	pb "grpc-crud-proj/proto/google/userpb"
+)
+
+func runWebhooks(ctx context.Context, g *globals, args []string) error {
+	if len(args) == 0 {
+		return usagef("usage: webhooks list [-state pending|delivered|failed] [-event id] | webhooks redeliver <delivery-id>")
+	}
+	switch args[0] {
+	case "list":
+		return runWebhooksList(ctx, g, args[1:])
+	case "redeliver":
+		return runWebhooksRedeliver(ctx, g, args[1:])
+	}
+	return usagef("unknown webhooks command %q", args[0])
+}
+
+func runWebhooksList(ctx context.Context, g *globals, args []string) error {
+	fs := newFlagSet("webhooks list")
+	state := fs.String("state", "", `only list "pending", "delivered" or "failed" deliveries`)
+	eventID := fs.Int64("event", 0, "only list deliveries of this event id")
+	pageSize := fs.Int("page-size", 0, "maximum number of deliveries to return (server default when 0)")
+	offset := fs.Int("offset", 0, "number of deliveries to skip")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	req := &pb.ListDeliveriesRequest{EventId: *eventID, PageSize: int32(*pageSize), Offset: int32(*offset)}
+	if *state != "" {
+		v, ok := pb.WebhookDeliveryState_value["WEBHOOK_DELIVERY_STATE_"+strings.ToUpper(*state)]
+		if !ok {
+			return usagef("unknown -state %q", *state)
+		}
+		req.State = pb.WebhookDeliveryState(v)
+	}
+	return withClient(g, func(c pb.UserServiceClient) error {
+		res, err := c.ListDeliveries(ctx, req)
+		if err != nil {
+			return err
+		}
+		if g.output == "json" {
+			return printJSON(res)
+		}
+		return printDeliveries(res.Deliveries...)
+	})
+}
+
+func runWebhooksRedeliver(ctx context.Context, g *globals, args []string) error {
+	if len(args) != 1 {
+		return usagef("expected exactly one delivery id")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return usagef("invalid delivery id %q", args[0])
+	}
+	return withClient(g, func(c pb.UserServiceClient) error {
+		res, err := c.RedeliverWebhook(ctx, &pb.RedeliverWebhookRequest{Id: id})
+		if err != nil {
+			return err
+		}
+		if g.output == "json" {
+			return printJSON(res)
+		}
+		return printDeliveries(res)
+	})
+}
+
+func printDeliveries(deliveries ...*pb.WebhookDelivery) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tEVENT\tTYPE\tUSER\tURL\tSTATE\tATTEMPTS\tCREATED\tLAST ERROR")
+	for _, d := range deliveries {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%s\t%s\t%d\t%s\t%s\n", d.Id, d.EventId,
+			strings.TrimPrefix(d.EventType.String(), "USER_EVENT_TYPE_"), d.UserId, d.Url,
+			strings.TrimPrefix(d.State.String(), "WEBHOOK_DELIVERY_STATE_"), d.Attempts,
+			time.Unix(d.CreatedAt, 0).Format(time.RFC3339), d.LastError)
+	}
+	return w.Flush()
+}